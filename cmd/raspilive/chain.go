@@ -0,0 +1,45 @@
+package main
+
+import (
+	"crypto/ed25519"
+
+	"github.com/jaredpetersen/raspilive/internal/chain"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+// addSignKeyFlag adds the flag for signing a tamper-evident manifest of the video to the command.
+func addSignKeyFlag(cmd *cobra.Command, signKey *string) {
+	cmd.Flags().StringVar(signKey, "sign-key", "", "Ed25519 private key to sign a tamper-evident hash chain manifest of the video with, generated along with its public key (.pub) if it does not exist")
+}
+
+// loadSignKey loads the key to sign the hash chain manifests with, if one was asked for.
+func loadSignKey(name string) ed25519.PrivateKey {
+	if name == "" {
+		return nil
+	}
+
+	key, err := chain.LoadKey(name)
+	if err != nil {
+		log.Debug().Err(err).Msg("Encountered an error loading signing key")
+		log.Fatal().Str("key", name).Msg("Encountered an error loading signing key")
+	}
+
+	return key
+}
+
+// newChain sets up the hash chain manifest of the video in the directory, carrying on from an earlier run. There is no
+// chain if there is no key to sign it with.
+func newChain(key ed25519.PrivateKey, directory string) *chain.Chain {
+	if key == nil {
+		return nil
+	}
+
+	hashChain := &chain.Chain{Directory: directory, Key: key}
+	if err := hashChain.Load(); err != nil {
+		log.Debug().Err(err).Msg("Encountered an error loading hash chain manifest")
+		log.Fatal().Str("directory", directory).Msg("Encountered an error loading hash chain manifest")
+	}
+
+	return hashChain
+}
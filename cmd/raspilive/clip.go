@@ -0,0 +1,315 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"filippo.io/age"
+	"github.com/jaredpetersen/raspilive/internal/raspivid"
+	"github.com/jaredpetersen/raspilive/internal/record"
+	"github.com/jaredpetersen/raspilive/internal/server"
+	"github.com/jaredpetersen/raspilive/internal/upload"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+// ClipCfg represents the clip configuration options
+type ClipCfg struct {
+	Video     *VideoCfg
+	Port      int
+	Directory string        // Directory to write the clips to, disabled if not provided alongside a stream
+	PreRoll   time.Duration // Video to keep from before the trigger
+	PostRoll  time.Duration // Video to keep from after the trigger
+	MaxAge    time.Duration // Remove clips older than this, keeping them regardless of age if zero
+	MaxSize   int           // Maximum disk space in megabytes for the clips, unlimited if zero
+	Auth      string        // Credentials required to trigger clips, formatted as username:password
+}
+
+func newClipCmd(video *VideoCfg) *cobra.Command {
+	cfg := ClipCfg{
+		Video: video,
+	}
+	uploadCfg := UploadCfg{}
+	diskCfg := DiskCfg{}
+	var signKey string
+	var encryptTo string
+
+	cmd := &cobra.Command{
+		Use:   "clip",
+		Short: "Save clips of video when triggered",
+		Long:  "Save clips of video when triggered",
+	}
+
+	cmd.Flags().IntVar(&cfg.Port, "port", 0, "static file server port")
+	cmd.MarkFlagRequired("port")
+
+	cmd.Flags().StringVar(&cfg.Directory, "directory", "", "directory to write the clips to, served by the static file server")
+	cmd.MarkFlagRequired("directory")
+
+	cmd.Flags().DurationVar(&cfg.PreRoll, "pre-roll", 10*time.Second, "video to keep in memory from before each trigger")
+
+	cmd.Flags().DurationVar(&cfg.PostRoll, "post-roll", 20*time.Second, "video to keep from after each trigger, extended by any triggers that come in meanwhile")
+
+	cmd.Flags().DurationVar(&cfg.MaxAge, "max-age", 0, "remove clips older than this, such as 168h (0 keeps them regardless of age)")
+
+	cmd.Flags().IntVar(&cfg.MaxSize, "max-size", 0, "maximum disk space in megabytes for the clips, removing the oldest past it (0 is unlimited)")
+
+	cmd.Flags().StringVar(&cfg.Auth, "auth", "", "credentials required to trigger clips, formatted as username:password")
+
+	addUploadFlags(cmd, &uploadCfg)
+
+	addDiskFlags(cmd, &diskCfg)
+
+	addSignKeyFlag(cmd, &signKey)
+
+	addEncryptToFlag(cmd, &encryptTo)
+
+	cmd.Flags().SortFlags = false
+
+	cmd.Run = func(cmd *cobra.Command, args []string) {
+		clipVideo(cfg, uploadCfg, diskCfg, signKey, encryptTo)
+	}
+
+	cmd.PreRun = func(cmd *cobra.Command, args []string) {
+		isValidCfg := isValidClipCfg(cfg, "")
+		if !isValidUploadCfg(uploadCfg) {
+			isValidCfg = false
+		}
+		if !isValidDiskCfg(diskCfg) {
+			isValidCfg = false
+		}
+		if !isValidEncryptTo(encryptTo) {
+			isValidCfg = false
+		}
+		if !isValidCfg {
+			cmd.Usage()
+			os.Exit(1)
+		}
+	}
+
+	return cmd
+}
+
+// addClipFlags adds the flags for saving clips alongside the stream to the command.
+func addClipFlags(cmd *cobra.Command, cfg *ClipCfg) {
+	cmd.Flags().StringVar(&cfg.Directory, "clips", "", "directory to save clips of the video to when triggered via HTTP POST to /camera/clip or SIGUSR1")
+
+	cmd.Flags().DurationVar(&cfg.PreRoll, "clip-pre-roll", 10*time.Second, "video to keep in memory from before each trigger")
+
+	cmd.Flags().DurationVar(&cfg.PostRoll, "clip-post-roll", 20*time.Second, "video to keep from after each trigger, extended by any triggers that come in meanwhile")
+
+	cmd.Flags().DurationVar(&cfg.MaxAge, "clip-max-age", 0, "remove clips older than this, such as 168h (0 keeps them regardless of age)")
+
+	cmd.Flags().IntVar(&cfg.MaxSize, "clip-max-size", 0, "maximum disk space in megabytes for the clips, removing the oldest past it (0 is unlimited)")
+
+	cmd.Flags().StringVar(&cfg.Auth, "clip-auth", "", "credentials required to trigger clips, formatted as username:password")
+}
+
+// isValidClipCfg checks the clip options, whose flags are named with the given prefix.
+func isValidClipCfg(cfg ClipCfg, prefix string) bool {
+	isValidCfg := true
+
+	if cfg.PreRoll < 0 {
+		fmt.Printf("Error: invalid value \"%s\" for flag \"%spre-roll\"\n", cfg.PreRoll, prefix)
+		isValidCfg = false
+	}
+
+	if cfg.PostRoll <= 0 {
+		fmt.Printf("Error: invalid value \"%s\" for flag \"%spost-roll\"\n", cfg.PostRoll, prefix)
+		isValidCfg = false
+	}
+
+	if cfg.MaxAge < 0 {
+		fmt.Printf("Error: invalid value \"%s\" for flag \"%smax-age\"\n", cfg.MaxAge, prefix)
+		isValidCfg = false
+	}
+
+	if cfg.MaxSize < 0 {
+		fmt.Printf("Error: invalid value \"%d\" for flag \"%smax-size\"\n", cfg.MaxSize, prefix)
+		isValidCfg = false
+	}
+
+	if cfg.Auth != "" && !strings.Contains(cfg.Auth, ":") {
+		fmt.Printf("Error: invalid value \"%s\" for flag \"%sauth\"\n", cfg.Auth, prefix)
+		isValidCfg = false
+	}
+
+	return isValidCfg
+}
+
+// newClipper sets up the clipper for the clip options, chaining the clips if there is a key to sign them with and
+// encrypting them if there is a recipient to encrypt them to.
+func newClipper(cfg ClipCfg, fps int, signKey ed25519.PrivateKey, recipient age.Recipient) *record.Clipper {
+	clipper := &record.Clipper{
+		Directory: cfg.Directory,
+		Options: record.ClipOptions{
+			Fps:      fps,
+			PreRoll:  cfg.PreRoll,
+			PostRoll: cfg.PostRoll,
+			MaxAge:   cfg.MaxAge,
+			MaxSize:  int64(cfg.MaxSize) * 1024 * 1024,
+			Chain:    newChain(signKey, cfg.Directory),
+		},
+	}
+	if recipient != nil {
+		clipper.Options.Recipient = recipient
+	}
+
+	return clipper
+}
+
+// clipTrigger is the handler that triggers clips via HTTP POST to `/camera/clip`.
+func clipTrigger(clipper *record.Clipper, cfg ClipCfg) *server.Trigger {
+	return &server.Trigger{Action: clipper.Trigger, Auth: cfg.Auth}
+}
+
+// triggerOnSignal triggers clips whenever the process receives SIGUSR1, such as from a motion detection script.
+func triggerOnSignal(clipper *record.Clipper) {
+	triggers := make(chan os.Signal, 1)
+	signal.Notify(triggers, syscall.SIGUSR1)
+
+	go func() {
+		for range triggers {
+			log.Info().Msg("Saving clip")
+			clipper.Trigger()
+		}
+	}()
+}
+
+// startClipping taps the camera's video to save clips of it alongside the stream.
+//
+// The stream carries on if clipping fails, such as when the disk fills up.
+func startClipping(raspiStream *raspivid.Stream, cfg ClipCfg, fps int, signKey ed25519.PrivateKey, recipient age.Recipient) *record.Clipper {
+	tapped, tap := io.Pipe()
+
+	clipper := newClipper(cfg, fps, signKey, recipient)
+	if err := clipper.Mux(tapped); err != nil {
+		log.Debug().Err(err).Msg("Encountered an error starting clipper")
+		log.Fatal().Msg("Encountered an error clipping video")
+	}
+	log.Debug().Str("cmd", clipper.String()).Msg("Started clipper")
+
+	raspiStream.Video = &teeReadCloser{ReadCloser: raspiStream.Video, w: tap}
+	triggerOnSignal(clipper)
+
+	go func() {
+		if err := clipper.Wait(); err != nil {
+			log.Debug().Err(err).Msg("Encountered an error waiting for clipper")
+			log.Warn().Msg("Encountered an error clipping video, clipping stopped")
+		}
+
+		// Stop taking in video so that the tap never holds it up
+		tapped.Close()
+	}()
+
+	return clipper
+}
+
+func clipVideo(cfg ClipCfg, uploadCfg UploadCfg, diskCfg DiskCfg, signKey string, encryptTo string) {
+	// Set up raspivid stream
+	raspiOptions := raspivid.Options{
+		Width:          cfg.Video.Width,
+		Height:         cfg.Video.Height,
+		Fps:            cfg.Video.Fps,
+		HorizontalFlip: cfg.Video.HorizontalFlip,
+		VerticalFlip:   cfg.Video.VerticalFlip,
+	}
+	raspiStream, err := raspivid.NewStream(raspiOptions)
+	if err != nil {
+		log.Fatal().Msg("Encountered an error streaming video from the Raspberry Pi Camera Module")
+	}
+	verifyVideo(raspiStream, cfg.Video)
+
+	// Set up clipper
+	clipper := newClipper(cfg, cfg.Video.Fps, loadSignKey(signKey), loadRecipient(encryptTo))
+	triggerOnSignal(clipper)
+
+	// Keep the disk from filling up, which would bring the clipper down
+	guards := startDiskGuards(diskCfg, guardedDirectory{Directory: cfg.Directory, Pruner: clipper})
+
+	// Set up static file server for triggering and downloading clips
+	srv := server.Static{
+		Port:      cfg.Port,
+		Directory: cfg.Directory,
+	}
+	srv.Handle("/clip", clipTrigger(clipper, cfg))
+	if len(guards) > 0 {
+		srv.Handle("/disk", &server.Disk{Guards: guards})
+	}
+
+	// Upload the clips if asked to
+	var uploaders []*upload.Uploader
+	if uploadCfg.Bucket != "" {
+		uploaders = append(uploaders, startUploading(uploadCfg, cfg.Directory, ""))
+	}
+
+	// Set up a channel for exiting
+	stop := make(chan struct{})
+	osStopper(stop)
+
+	// Serve the clips
+	go func() {
+		err := srv.ListenAndServe()
+		if errors.Is(err, server.ErrInvalidDirectory) {
+			log.Fatal().Msg("Directory does not exist")
+		}
+		if err != nil {
+			log.Debug().Err(err).Msg("Encountered an error serving clips")
+			log.Fatal().Msg("Encountered an error serving clips")
+		}
+		stop <- struct{}{}
+	}()
+
+	// Clip video
+	go func() {
+		if err := muxClips(raspiStream, clipper); err != nil {
+			log.Fatal().Msg("Encountered an error clipping video")
+		}
+		stop <- struct{}{}
+	}()
+
+	// Wait for a stop signal
+	<-stop
+
+	log.Info().Msg("Shutting down")
+
+	// Let the clip in progress finish up so that it does not need to be recovered
+	raspiStream.Video.Close()
+	srv.Shutdown(serverShutdownDeadline)
+	clipper.Wait()
+	stopUploads(uploaders)
+	stopDiskGuards(guards)
+}
+
+func muxClips(raspiStream *raspivid.Stream, clipper *record.Clipper) error {
+	if err := clipper.Mux(raspiStream.Video); err != nil {
+		log.Debug().Err(err).Msg("Encountered an error starting clipper")
+		return err
+	}
+	log.Debug().Str("cmd", clipper.String()).Msg("Started clipper")
+
+	if err := raspiStream.Start(); err != nil {
+		log.Debug().Err(err).Msg("Encountered an error starting video stream")
+		return err
+	}
+	log.Debug().Str("cmd", raspiStream.String()).Msg("Started raspivid")
+
+	if err := clipper.Wait(); err != nil {
+		log.Debug().Err(err).Msg("Encountered an error waiting for clipper")
+		return err
+	}
+
+	if err := raspiStream.Wait(); err != nil {
+		log.Debug().Err(err).Msg("Encountered an error waiting for video stream")
+		return err
+	}
+
+	return nil
+}
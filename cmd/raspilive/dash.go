@@ -2,14 +2,30 @@ package main
 
 import (
 	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+	"time"
 
-	"github.com/jaredpetersen/raspilive/internal/ffmpeg/dash"
+	"github.com/jaredpetersen/raspilive/internal/dash"
+	"github.com/jaredpetersen/raspilive/internal/disk"
+	"github.com/jaredpetersen/raspilive/internal/export"
+	ffmpegdash "github.com/jaredpetersen/raspilive/internal/ffmpeg/dash"
+	"github.com/jaredpetersen/raspilive/internal/memfs"
+	"github.com/jaredpetersen/raspilive/internal/metadata"
 	"github.com/jaredpetersen/raspilive/internal/raspivid"
+	"github.com/jaredpetersen/raspilive/internal/record"
+	"github.com/jaredpetersen/raspilive/internal/segment"
 	"github.com/jaredpetersen/raspilive/internal/server"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
 )
 
+// dashClock is where players may fetch the time of the camera from to sync up with the manifest.
+const dashClock = "/camera/time"
+
 // DashCfg represents the DASH configuration options
 type DashCfg struct {
 	Video        *VideoCfg
@@ -17,9 +33,29 @@ type DashCfg struct {
 	Directory    string
 	TLSCert      string
 	TLSKey       string
-	SegmentTime  int // Segment length target duration in seconds
-	PlaylistSize int // Maximum number of playlist entries
-	StorageSize  int // Maximum number of unreferenced segments to keep on disk before removal
+	SegmentTime  int           // Segment length target duration in seconds
+	PlaylistSize int           // Maximum number of playlist entries
+	StorageSize  int           // Maximum number of unreferenced segments to keep on disk before removal
+	Muxer        string        // Implementation used to mux the video
+	Memory       bool          // Keep the video in memory rather than writing it to the directory
+	Renditions   []string      // Lower quality renditions to transcode for adaptive bitrate streaming
+	DVRWindow    time.Duration // Duration of video to keep for viewers to rewind through, in place of PlaylistSize
+	DVRBudget    int           // Maximum disk space in megabytes for the video kept for rewinding
+	HLSPlaylist  bool          // Also write an HLS playlist that references the same segments
+	EpochNumbers bool          // Number the segments from the current time so that they keep increasing across restarts
+	Thumbnails   int           // Seconds between thumbnails in the scrubbing preview track, disabled if zero
+	Metadata     bool          // Accept timed metadata via HTTP POST to carry alongside the video
+	MetadataAuth string        // Credentials required to post metadata, formatted as username:password
+	Export       bool          // Serve downloads of the retained video between two wall clock times as MP4
+	ExportAuth   string        // Credentials required to export video, formatted as username:password
+	Sessions     SessionCfg    // Sessions to keep each run of the stream in
+	SignKey      string        // Ed25519 private key to sign a hash chain manifest of the video with
+	EncryptTo    string        // age public key to encrypt the recordings and clips to as they are written
+	Audio        AudioCfg      // Audio to capture alongside the video
+	Record       RecordCfg     // Rolling recordings to write alongside the stream
+	Clips        ClipCfg       // Clips to save alongside the stream when triggered
+	Upload       UploadCfg     // Object storage to upload the video to
+	Disk         DiskCfg       // Free space to keep on the disk
 }
 
 func newDashCmd(video *VideoCfg) *cobra.Command {
@@ -47,40 +83,352 @@ func newDashCmd(video *VideoCfg) *cobra.Command {
 
 	cmd.Flags().IntVar(&cfg.StorageSize, "storage-size", 1, "maximum number of unreferenced segments to keep on disk before removal")
 
+	cmd.Flags().StringVar(&cfg.Muxer, "muxer", "", "implementation used to mux the video (valid [\"ffmpeg\", \"native\"], default \"ffmpeg\")")
+
+	cmd.Flags().BoolVar(&cfg.Memory, "memory", false, "keep video in memory instead of writing it to the directory")
+
+	cmd.Flags().StringSliceVar(&cfg.Renditions, "renditions", nil, "lower quality renditions to transcode for adaptive bitrate streaming, formatted as WIDTHxHEIGHT:BITRATE (e.g. 640x360:800k)")
+
+	cmd.Flags().DurationVar(&cfg.DVRWindow, "dvr-window", 0, "duration of video to keep for viewers to rewind through, such as 30m or 2h (replaces playlist-size)")
+
+	cmd.Flags().IntVar(&cfg.DVRBudget, "dvr-budget", 1024, "maximum disk space in megabytes for the video kept by dvr-window")
+
+	cmd.Flags().BoolVar(&cfg.HLSPlaylist, "hls-playlist", false, "also write an HLS playlist referencing the same segments (native muxer only)")
+
+	cmd.Flags().BoolVar(&cfg.EpochNumbers, "epoch-numbers", false, "number segments from the current time so that they keep increasing across restarts")
+
+	cmd.Flags().IntVar(&cfg.Thumbnails, "thumbnails", 0, "seconds between thumbnails in a sprite sheet track for scrubbing previews, served as thumbnails.vtt (0 disables)")
+
+	cmd.Flags().BoolVar(&cfg.Metadata, "metadata", false, "accept timed metadata as JSON via HTTP POST to /camera/metadata, carried in the segments as ID3 event messages (native muxer only)")
+
+	cmd.Flags().StringVar(&cfg.MetadataAuth, "metadata-auth", "", "credentials required to post metadata, formatted as username:password")
+
+	cmd.Flags().BoolVar(&cfg.Export, "export", false, "serve the retained video between two times as MP4 from /camera/export?start=...&end=...")
+
+	cmd.Flags().StringVar(&cfg.ExportAuth, "export-auth", "", "credentials required to export video, formatted as username:password")
+
+	addSessionFlags(cmd, &cfg.Sessions)
+
+	addSignKeyFlag(cmd, &cfg.SignKey)
+
+	addEncryptToFlag(cmd, &cfg.EncryptTo)
+
+	addAudioFlags(cmd, &cfg.Audio)
+
+	addRecordFlags(cmd, &cfg.Record)
+
+	addClipFlags(cmd, &cfg.Clips)
+
+	addUploadFlags(cmd, &cfg.Upload)
+
+	addDiskFlags(cmd, &cfg.Disk)
+
 	cmd.Flags().SortFlags = false
 
 	cmd.Run = func(cmd *cobra.Command, args []string) {
 		streamDash(cfg)
 	}
 
+	cmd.PreRun = func(cmd *cobra.Command, args []string) {
+		isValidCfg := isValidDashCfg(cfg)
+		if !isValidCfg {
+			cmd.Usage()
+			os.Exit(1)
+		}
+	}
+
 	return cmd
 }
 
+func isValidDashCfg(cfg DashCfg) bool {
+	isValidCfg := true
+
+	muxer := strings.ToLower(cfg.Muxer)
+	validMuxer := muxer == "" || muxer == "ffmpeg" || muxer == "native"
+
+	if !validMuxer {
+		fmt.Printf("Error: invalid value \"%s\" for flag \"muxer\"\n", cfg.Muxer)
+		isValidCfg = false
+	}
+
+	if cfg.HLSPlaylist && muxer != "native" {
+		fmt.Printf("Error: flag \"hls-playlist\" requires the native muxer\n")
+		isValidCfg = false
+	}
+
+	if _, invalid := parseRenditions(cfg.Renditions); invalid != "" {
+		fmt.Printf("Error: invalid value \"%s\" for flag \"renditions\"\n", invalid)
+		isValidCfg = false
+	}
+
+	if len(cfg.Renditions) > 0 && muxer == "native" {
+		fmt.Printf("Error: flag \"renditions\" requires the ffmpeg muxer\n")
+		isValidCfg = false
+	}
+
+	if cfg.DVRWindow < 0 {
+		fmt.Printf("Error: invalid value \"%s\" for flag \"dvr-window\"\n", cfg.DVRWindow)
+		isValidCfg = false
+	}
+
+	if cfg.DVRBudget < 0 {
+		fmt.Printf("Error: invalid value \"%d\" for flag \"dvr-budget\"\n", cfg.DVRBudget)
+		isValidCfg = false
+	}
+
+	if cfg.Thumbnails < 0 {
+		fmt.Printf("Error: invalid value \"%d\" for flag \"thumbnails\"\n", cfg.Thumbnails)
+		isValidCfg = false
+	}
+
+	if cfg.Metadata && muxer != "native" {
+		fmt.Printf("Error: flag \"metadata\" requires the native muxer\n")
+		isValidCfg = false
+	}
+
+	if cfg.MetadataAuth != "" && !strings.Contains(cfg.MetadataAuth, ":") {
+		fmt.Printf("Error: invalid value \"%s\" for flag \"metadata-auth\"\n", cfg.MetadataAuth)
+		isValidCfg = false
+	}
+
+	if cfg.ExportAuth != "" && !strings.Contains(cfg.ExportAuth, ":") {
+		fmt.Printf("Error: invalid value \"%s\" for flag \"export-auth\"\n", cfg.ExportAuth)
+		isValidCfg = false
+	}
+
+	if !isValidSessionCfg(cfg.Sessions, cfg.Directory) {
+		isValidCfg = false
+	}
+
+	if cfg.SignKey != "" && (muxer != "native" || cfg.Directory == "") {
+		fmt.Printf("Error: flag \"sign-key\" requires the native muxer and flag \"directory\"\n")
+		isValidCfg = false
+	}
+
+	if !isValidEncryptTo(cfg.EncryptTo) {
+		isValidCfg = false
+	}
+
+	if cfg.EncryptTo != "" && cfg.Record.Directory == "" && cfg.Clips.Directory == "" {
+		fmt.Printf("Error: flag \"encrypt-to\" requires flag \"record\" or \"clips\"\n")
+		isValidCfg = false
+	}
+
+	if !isValidAudioCfg(cfg.Audio, muxer) {
+		isValidCfg = false
+	}
+
+	if !isValidRecordCfg(cfg.Record, "record-") {
+		isValidCfg = false
+	}
+
+	if !isValidClipCfg(cfg.Clips, "clip-") {
+		isValidCfg = false
+	}
+
+	if !isValidUploadCfg(cfg.Upload) {
+		isValidCfg = false
+	}
+
+	if !isValidDiskCfg(cfg.Disk) {
+		isValidCfg = false
+	}
+
+	if cfg.Upload.Bucket != "" && cfg.Directory == "" {
+		fmt.Printf("Error: flag \"upload-bucket\" requires flag \"directory\"\n")
+		isValidCfg = false
+	}
+
+	if cfg.Memory && cfg.Directory != "" {
+		fmt.Printf("Error: flags \"memory\" and \"directory\" cannot be used together\n")
+		isValidCfg = false
+	}
+
+	return isValidCfg
+}
+
 func streamDash(cfg DashCfg) {
 	// Set up raspivid stream
+	// Keyframes are lined up with the renditions' segments for players to switch between them
+	renditions, _ := parseRenditions(cfg.Renditions)
 	raspiOptions := raspivid.Options{
 		Width:          cfg.Video.Width,
 		Height:         cfg.Video.Height,
 		Fps:            cfg.Video.Fps,
 		HorizontalFlip: cfg.Video.HorizontalFlip,
 		VerticalFlip:   cfg.Video.VerticalFlip,
+		IntraPeriod:    renditionIntraPeriod(cfg.Video.Fps, cfg.SegmentTime, renditions),
 	}
 	raspiStream, err := raspivid.NewStream(raspiOptions)
 	if err != nil {
 		log.Fatal().Msg("Encountered an error streaming video from the Raspberry Pi Camera Module")
 	}
+	verifyVideo(raspiStream, cfg.Video)
+
+	// Ffmpeg counts the DVR window in segments rather than time
+	playlistSize := cfg.PlaylistSize
+	if cfg.DVRWindow > 0 {
+		playlistSize = dvrPlaylistSize(cfg.DVRWindow, cfg.DVRBudget, cfg.SegmentTime, cfg.StorageSize)
+	}
+
+	// Keep the video in memory if asked to, sparing the SD card from wear
+	var store *memfs.Store
+	var uploads *memfs.UploadServer
+	if cfg.Memory {
+		store = newMemoryStore(cfg.SegmentTime, playlistSize, cfg.StorageSize, renditions)
+	}
+
+	// Accept timed metadata if asked to, carried alongside the video captured as it arrives
+	var queue *metadata.Queue
+	if cfg.Metadata {
+		queue = &metadata.Queue{}
+	}
+
+	// Keep track of the segments if asked to, so that footage may be exported from them for as long as they are kept
+	var catalog *export.Catalog
+	if cfg.Export {
+		catalog = &export.Catalog{}
+	}
+
+	// Keep each run in a session of its own if asked to, writing the video to the session's directory
+	library, tracker := startSession(cfg.Sessions, cfg.Directory)
+	videoDirectory := cfg.Directory
+	if tracker != nil {
+		videoDirectory = tracker.Directory()
+	}
+
+	// Sign a tamper-evident manifest of the video if asked to, for footage that may be used as evidence
+	signKey := loadSignKey(cfg.SignKey)
+
+	// Encrypt the recordings and clips if asked to, so that they are of no use to whoever takes the device
+	recipient := loadRecipient(cfg.EncryptTo)
 
 	// Set up DASH muxer
-	muxer := dash.Muxer{
-		Directory: cfg.Directory,
-		Options: dash.Options{
-			Fps:          cfg.Video.Fps,
-			SegmentTime:  cfg.SegmentTime,
-			PlaylistSize: cfg.PlaylistSize,
-			StorageSize:  cfg.StorageSize,
-		},
+	var muxer videoMuxer
+	var follower *sessionFollower
+	if strings.ToLower(cfg.Muxer) == "native" {
+		nativeMuxer := &dash.Muxer{
+			Directory: videoDirectory,
+			Options: dash.Options{
+				Fps:          cfg.Video.Fps,
+				SegmentTime:  cfg.SegmentTime,
+				PlaylistSize: cfg.PlaylistSize,
+				StorageSize:  cfg.StorageSize,
+				Window:       int(cfg.DVRWindow.Seconds()),
+				Budget:       dvrBudget(cfg.DVRWindow, cfg.DVRBudget),
+				HLSPlaylist:  cfg.HLSPlaylist,
+				UTCTiming:    dashClock,
+				EpochNumbers: cfg.EpochNumbers,
+				Metadata:     queue,
+				Catalog:      catalog,
+				Chain:        newChain(signKey, videoDirectory),
+				Session:      tracker,
+			},
+		}
+		if cfg.Thumbnails > 0 {
+			nativeMuxer.Options.Thumbnails = dash.Thumbnails{
+				Media:    "thumbnails-$Number$.jpg",
+				Interval: cfg.Thumbnails,
+				Columns:  thumbnailColumns,
+				Rows:     thumbnailRows,
+				Width:    thumbnailWidth,
+				Height:   thumbnailHeight(cfg.Video),
+			}
+		}
+		if store != nil {
+			nativeMuxer.Storage = store
+		}
+		muxer = nativeMuxer
+	} else {
+		ffmpegMuxer := &ffmpegdash.Muxer{
+			Directory: videoDirectory,
+			Options: ffmpegdash.Options{
+				Fps:          cfg.Video.Fps,
+				SegmentTime:  cfg.SegmentTime,
+				PlaylistSize: playlistSize,
+				StorageSize:  cfg.StorageSize,
+				Renditions:   renditions,
+				UTCTimingURL: dashClock,
+				EpochNumbers: cfg.EpochNumbers,
+			},
+		}
+		if cfg.Audio.Device != "" {
+			ffmpegMuxer.Options.Audio = audioInput(cfg.Audio)
+		}
+		if store != nil {
+			uploads, ffmpegMuxer.URL = serveUploads(store)
+		}
+		muxer = ffmpegMuxer
+
+		// The manifest may be read back while Ffmpeg is partway through writing it, leaving nothing new
+		listSegments := func(data []byte) ([]segment.Segment, string) {
+			segments, init, _ := ffmpegdash.Segments(data)
+			return segments, init
+		}
+
+		// Ffmpeg only lists the segments in its manifest, so the catalog is kept up to date from that
+		if catalog != nil {
+			var files http.FileSystem = http.Dir(videoDirectory)
+			if store != nil {
+				files = store
+			}
+			catalogSegments(catalog, files, "livestream.mpd", listSegments)
+		}
+
+		if tracker != nil {
+			// The whole session is played back from disk, but Ffmpeg always removes the segments past the extra window,
+			// so it is made too large to ever be reached
+			ffmpegMuxer.Options.StorageSize = math.MaxInt32
+			follower = followSession(tracker, "livestream.mpd", listSegments, false)
+		}
 	}
 
+	if cfg.Thumbnails > 0 {
+		var storage segment.Storage = segment.Dir(videoDirectory)
+		if store != nil {
+			storage = store
+		}
+		retention := thumbnailRetention(cfg.SegmentTime, playlistSize, cfg.StorageSize, store != nil)
+		generateThumbnails(raspiStream, storage, cfg.Video, cfg.Thumbnails, retention)
+	}
+
+	var recorder *record.Recorder
+	if cfg.Record.Directory != "" {
+		recorder = startRecording(raspiStream, cfg.Record, cfg.Video.Fps, signKey, recipient)
+	}
+
+	var clipper *record.Clipper
+	if cfg.Clips.Directory != "" {
+		clipper = startClipping(raspiStream, cfg.Clips, cfg.Video.Fps, signKey, recipient)
+	}
+
+	// Keep the disk from filling up, which would bring the stream down
+	var sessionPruner, segmentPruner, recordPruner, clipPruner disk.Pruner
+	if library != nil {
+		sessionPruner = library
+	}
+	if ffmpegMuxer, ok := muxer.(*ffmpegdash.Muxer); ok && store == nil {
+		segmentPruner = ffmpegMuxer
+	}
+	if recorder != nil {
+		recordPruner = recorder
+	}
+	if clipper != nil {
+		clipPruner = clipper
+	}
+	guards := startDiskGuards(
+		cfg.Disk,
+		guardedDirectory{Directory: streamDirectory(cfg.Directory, store != nil), Pruner: sessionPruner},
+		guardedDirectory{Directory: streamDirectory(cfg.Directory, store != nil), Pruner: segmentPruner},
+		guardedDirectory{Directory: cfg.Record.Directory, Pruner: recordPruner},
+		guardedDirectory{Directory: cfg.Clips.Directory, Pruner: clipPruner},
+	)
+	if nativeMuxer, ok := muxer.(*dash.Muxer); ok {
+		nativeMuxer.Options.Shortfall = diskShortfall(guards, streamDirectory(cfg.Directory, store != nil))
+	}
+
+	uploaders := startStreamUploads(cfg.Upload, cfg.Directory, cfg.Record, cfg.Clips)
+
 	// Set up static file server
 	srv := server.Static{
 		Port:      cfg.Port,
@@ -88,6 +436,26 @@ func streamDash(cfg DashCfg) {
 		Cert:      cfg.TLSCert,
 		Key:       cfg.TLSKey,
 	}
+	if store != nil {
+		srv.FileSystem = store
+	}
+	srv.Handle("/time", http.HandlerFunc(server.Clock))
+	if queue != nil {
+		srv.Handle("/metadata", &server.Metadata{Queue: queue, Auth: cfg.MetadataAuth})
+	}
+	if clipper != nil {
+		srv.Handle("/clip", clipTrigger(clipper, cfg.Clips))
+	}
+	if catalog != nil {
+		srv.Handle("/export", exportHandler(catalog, videoDirectory, store, cfg.ExportAuth))
+	}
+	if len(guards) > 0 {
+		srv.Handle("/disk", &server.Disk{Guards: guards})
+	}
+	if library != nil {
+		srv.Handle("/sessions/", &server.Sessions{Library: library})
+		redirectToSession(&srv, tracker, "livestream.mpd", "livestream.m3u8")
+	}
 
 	// Set up a channel for exiting
 	stop := make(chan struct{})
@@ -107,28 +475,49 @@ func streamDash(cfg DashCfg) {
 	}()
 
 	// Stream video
+	muxed := make(chan error, 1)
 	go func() {
-		if err := muxDash(raspiStream, &muxer); err != nil {
-			log.Fatal().Msg("Encountered an error muxing video")
-		}
-		stop <- struct{}{}
+		muxed <- muxDash(raspiStream, muxer)
 	}()
 
-	// Wait for a stop signal
-	<-stop
+	// Wait for a stop signal, or for the video to come to an end
+	select {
+	case <-stop:
+		log.Info().Msg("Shutting down")
 
-	log.Info().Msg("Shutting down")
+		// Let the muxer finish off the video it has before anything that it writes to goes away
+		raspiStream.Video.Close()
+		waitForMuxer(muxed)
+	case err := <-muxed:
+		if err != nil {
+			log.Fatal().Msg("Encountered an error muxing video")
+		}
+		log.Info().Msg("Shutting down")
+	}
 
-	raspiStream.Video.Close()
+	if follower != nil {
+		follower.Finish()
+	}
 	srv.Shutdown(serverShutdownDeadline)
+	if recorder != nil {
+		recorder.Wait()
+	}
+	if clipper != nil {
+		clipper.Wait()
+	}
+	stopUploads(uploaders)
+	stopDiskGuards(guards)
+	if uploads != nil {
+		uploads.Close()
+	}
 }
 
-func muxDash(raspiStream *raspivid.Stream, muxer *dash.Muxer) error {
+func muxDash(raspiStream *raspivid.Stream, muxer videoMuxer) error {
 	if err := muxer.Mux(raspiStream.Video); err != nil {
 		log.Debug().Err(err).Msg("Encountered an error starting video mux")
 		return err
 	}
-	log.Debug().Str("cmd", muxer.String()).Msg("Started ffmpeg muxer")
+	log.Debug().Str("cmd", muxer.String()).Msg("Started muxer")
 
 	if err := raspiStream.Start(); err != nil {
 		log.Debug().Err(err).Msg("Encountered an error starting video stream")
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"github.com/jaredpetersen/raspilive/internal/record"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+// DecryptCfg represents the decryption configuration options
+type DecryptCfg struct {
+	Identity string // File with the private key that the recordings and clips were encrypted to
+	Output   string // Directory to write the decrypted files to, alongside the encrypted ones if not provided
+	Generate bool   // Generate a new private key in the identity file instead of decrypting
+}
+
+func newDecryptCmd() *cobra.Command {
+	cfg := DecryptCfg{}
+
+	cmd := &cobra.Command{
+		Use:   "decrypt [file or directory]...",
+		Short: "Decrypt encrypted recordings and clips",
+		Long:  "Decrypt recordings and clips that were encrypted with --encrypt-to, restoring them to playable MP4 files",
+	}
+
+	cmd.Flags().StringVar(&cfg.Identity, "identity", "", "file with the age private key that the video was encrypted to")
+	cmd.MarkFlagRequired("identity")
+
+	cmd.Flags().StringVar(&cfg.Output, "output", "", "directory to write the decrypted files to, keeping their paths (default alongside the encrypted files)")
+
+	cmd.Flags().BoolVar(&cfg.Generate, "generate", false, "generate a new private key in the identity file and print its public key to encrypt to, instead of decrypting")
+
+	cmd.Flags().SortFlags = false
+
+	cmd.Run = func(cmd *cobra.Command, args []string) {
+		if cfg.Generate {
+			generateIdentity(cfg.Identity)
+			return
+		}
+		decryptVideo(cfg, args)
+	}
+
+	cmd.PreRun = func(cmd *cobra.Command, args []string) {
+		isValidCfg := true
+
+		if cfg.Generate && (len(args) > 0 || cfg.Output != "") {
+			fmt.Printf("Error: flag \"generate\" cannot be used with files to decrypt or flag \"output\"\n")
+			isValidCfg = false
+		}
+
+		if !cfg.Generate && len(args) == 0 {
+			fmt.Printf("Error: requires at least one file or directory to decrypt\n")
+			isValidCfg = false
+		}
+
+		if !isValidCfg {
+			cmd.Usage()
+			os.Exit(1)
+		}
+	}
+
+	return cmd
+}
+
+// generateIdentity writes a new private key to the file in the format used by age-keygen, keeping it from being
+// overwritten so that nothing already encrypted is lost.
+func generateIdentity(name string) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		log.Debug().Err(err).Msg("Encountered an error generating private key")
+		log.Fatal().Msg("Encountered an error generating private key")
+	}
+
+	file, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if os.IsExist(err) {
+		log.Fatal().Str("identity", name).Msg("Identity file already exists")
+	}
+	if err != nil {
+		log.Debug().Err(err).Msg("Encountered an error writing private key")
+		log.Fatal().Str("identity", name).Msg("Encountered an error writing private key")
+	}
+
+	_, err = fmt.Fprintf(file, "# created: %s\n# public key: %s\n%s\n",
+		time.Now().Format(time.RFC3339), identity.Recipient(), identity)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		log.Debug().Err(err).Msg("Encountered an error writing private key")
+		log.Fatal().Str("identity", name).Msg("Encountered an error writing private key")
+	}
+
+	fmt.Println(identity.Recipient())
+}
+
+// decryptVideo decrypts the encrypted files given, along with those in the directories given, carrying on past any
+// that fail.
+func decryptVideo(cfg DecryptCfg, paths []string) {
+	identities := loadIdentities(cfg.Identity)
+
+	decrypted, failed := 0, 0
+	for _, root := range paths {
+		err := filepath.Walk(root, func(name string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			// Hidden files are still being written
+			if name != root && strings.HasPrefix(info.Name(), ".") {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if info.IsDir() || filepath.Ext(name) != record.EncryptedExt {
+				return nil
+			}
+
+			dst := decryptedName(cfg.Output, root, name)
+
+			err = record.Decrypt(name, dst, identities...)
+			switch {
+			case errors.Is(err, record.ErrTruncated):
+				log.Warn().Str("file", name).Msg("Encrypted file was cut short or damaged, decrypted up to the last complete fragment")
+			case err != nil:
+				log.Debug().Err(err).Msg("Encountered an error decrypting file")
+				log.Error().Str("file", name).Msg("Encountered an error decrypting file")
+				failed++
+				return nil
+			}
+
+			log.Info().Str("file", dst).Msg("Decrypted file")
+			decrypted++
+
+			return nil
+		})
+		if err != nil {
+			log.Debug().Err(err).Msg("Encountered an error finding files to decrypt")
+			log.Error().Str("path", root).Msg("Encountered an error finding files to decrypt")
+			failed++
+		}
+	}
+
+	fmt.Printf("%d decrypted, %d failed\n", decrypted, failed)
+
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// loadIdentities reads the private keys from the identity file.
+func loadIdentities(name string) []age.Identity {
+	file, err := os.Open(name)
+	if err != nil {
+		log.Debug().Err(err).Msg("Encountered an error loading private key")
+		log.Fatal().Str("identity", name).Msg("Encountered an error loading private key")
+	}
+	defer file.Close()
+
+	identities, err := age.ParseIdentities(file)
+	if err != nil {
+		log.Debug().Err(err).Msg("Encountered an error loading private key")
+		log.Fatal().Str("identity", name).Msg("Encountered an error loading private key")
+	}
+
+	return identities
+}
+
+// decryptedName returns where to write the encrypted file found under the root once decrypted, which is alongside it
+// unless there is an output directory, keeping its path relative to the root there.
+func decryptedName(output string, root string, name string) string {
+	if output == "" {
+		return strings.TrimSuffix(name, record.EncryptedExt)
+	}
+
+	// Files given directly have no path under a root to keep
+	relative, err := filepath.Rel(root, name)
+	if err != nil || relative == "." {
+		relative = filepath.Base(name)
+	}
+
+	return filepath.Join(output, strings.TrimSuffix(relative, record.EncryptedExt))
+}
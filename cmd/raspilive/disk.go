@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/jaredpetersen/raspilive/internal/disk"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+// DiskCfg represents the options for guarding against running out of disk space
+type DiskCfg struct {
+	MinFree  int // Free space in megabytes to maintain by removing the oldest video, disabled if zero
+	Critical int // Free space in megabytes below which raspilive refuses to start
+}
+
+// guardedDirectory is a directory that video is written to, along with what removes the oldest of it.
+type guardedDirectory struct {
+	Directory string
+	Pruner    disk.Pruner // Remover of the oldest video in the directory, if it is not left to the muxer
+}
+
+// addDiskFlags adds the flags for guarding against running out of disk space to the command.
+func addDiskFlags(cmd *cobra.Command, cfg *DiskCfg) {
+	cmd.Flags().IntVar(&cfg.MinFree, "disk-min-free", 0, "free space in megabytes to keep on the filesystem, removing the oldest video to maintain it (0 disables)")
+
+	cmd.Flags().IntVar(&cfg.Critical, "disk-critical", 64, "free space in megabytes below which raspilive refuses to start")
+}
+
+// isValidDiskCfg checks the disk space options.
+func isValidDiskCfg(cfg DiskCfg) bool {
+	isValidCfg := true
+
+	if cfg.MinFree < 0 {
+		fmt.Printf("Error: invalid value \"%d\" for flag \"disk-min-free\"\n", cfg.MinFree)
+		isValidCfg = false
+	}
+
+	if cfg.Critical < 0 || (cfg.MinFree > 0 && cfg.Critical > cfg.MinFree) {
+		fmt.Printf("Error: invalid value \"%d\" for flag \"disk-critical\"\n", cfg.Critical)
+		isValidCfg = false
+	}
+
+	return isValidCfg
+}
+
+// streamDirectory is the directory on disk that the stream is written to, which is the working directory that the
+// static file server serves if none is given, or empty if the stream is kept in memory and there is nothing to guard.
+func streamDirectory(directory string, memory bool) string {
+	if directory == "" && !memory {
+		return "."
+	}
+
+	return directory
+}
+
+// startDiskGuards guards each filesystem that the directories are on, refusing to start if any of them is critically
+// low on space. Video is pruned from every directory on a filesystem that runs low, since they all share its space.
+func startDiskGuards(cfg DiskCfg, directories ...guardedDirectory) []*disk.Guard {
+	var guards []*disk.Guard
+
+	for _, directory := range directories {
+		// Directories are only left empty when the video they would hold is turned off or kept in memory
+		if directory.Directory == "" {
+			continue
+		}
+
+		var guard *disk.Guard
+		for _, existing := range guards {
+			if disk.SameFilesystem(existing.Directory, directory.Directory) {
+				guard = existing
+				break
+			}
+		}
+
+		if guard == nil {
+			guard = &disk.Guard{
+				Directory: directory.Directory,
+				Options: disk.Options{
+					MinFree:  int64(cfg.MinFree) * 1024 * 1024,
+					Critical: int64(cfg.Critical) * 1024 * 1024,
+				},
+			}
+
+			err := guard.Check()
+			if err == disk.ErrCriticallyLow {
+				log.Fatal().Str("directory", directory.Directory).Msg("Not enough disk space to start")
+			}
+			if err != nil {
+				// The directory is reported on properly once the video starts
+				log.Debug().Err(err).Msg("Encountered an error checking disk space")
+				continue
+			}
+
+			guards = append(guards, guard)
+		}
+
+		if directory.Pruner != nil {
+			guard.Pruners = append(guard.Pruners, directory.Pruner)
+		}
+	}
+
+	if cfg.MinFree == 0 {
+		return guards
+	}
+
+	for _, guard := range guards {
+		if err := guard.Start(); err != nil {
+			log.Debug().Err(err).Msg("Encountered an error starting disk space guard")
+			log.Fatal().Msg("Encountered an error guarding disk space")
+		}
+		log.Debug().Str("directory", guard.Directory).Msg("Started disk space guard")
+	}
+
+	return guards
+}
+
+// diskShortfall is how many bytes need freeing up on the filesystem holding the directory, for the native segmenter to
+// cut back on the segments it keeps.
+func diskShortfall(guards []*disk.Guard, directory string) func() int64 {
+	for _, guard := range guards {
+		if disk.SameFilesystem(guard.Directory, directory) && guard.Options.MinFree > 0 {
+			return guard.Shortfall
+		}
+	}
+
+	return nil
+}
+
+// stopDiskGuards stops checking the disk space.
+func stopDiskGuards(guards []*disk.Guard) {
+	for _, guard := range guards {
+		guard.Stop()
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+
+	"filippo.io/age"
+	"github.com/spf13/cobra"
+)
+
+// addEncryptToFlag adds the flag for encrypting the recordings and clips at rest to the command.
+func addEncryptToFlag(cmd *cobra.Command, encryptTo *string) {
+	cmd.Flags().StringVar(encryptTo, "encrypt-to", "", "age public key (age1...) to encrypt the recordings and clips to as they are written, so that the device itself cannot play them back")
+}
+
+// isValidEncryptTo checks the public key to encrypt to, if one was given.
+func isValidEncryptTo(encryptTo string) bool {
+	if encryptTo == "" {
+		return true
+	}
+
+	if _, err := age.ParseX25519Recipient(encryptTo); err != nil {
+		fmt.Printf("Error: invalid value \"%s\" for flag \"encrypt-to\"\n", encryptTo)
+		return false
+	}
+
+	return true
+}
+
+// loadRecipient parses the public key to encrypt the recordings and clips to. There is no recipient if there is no key,
+// leaving them in the clear.
+func loadRecipient(encryptTo string) age.Recipient {
+	if encryptTo == "" {
+		return nil
+	}
+
+	// Already checked along with the rest of the flags
+	recipient, _ := age.ParseX25519Recipient(encryptTo)
+
+	return recipient
+}
@@ -1,13 +1,27 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"net/http"
 	"os"
+	"path/filepath"
 	"strings"
-
-	"github.com/jaredpetersen/raspilive/internal/ffmpeg/hls"
+	"time"
+
+	"github.com/jaredpetersen/raspilive/internal/disk"
+	"github.com/jaredpetersen/raspilive/internal/export"
+	"github.com/jaredpetersen/raspilive/internal/ffmpeg/abr"
+	ffmpeghls "github.com/jaredpetersen/raspilive/internal/ffmpeg/hls"
+	"github.com/jaredpetersen/raspilive/internal/h264"
+	"github.com/jaredpetersen/raspilive/internal/hls"
+	"github.com/jaredpetersen/raspilive/internal/memfs"
+	"github.com/jaredpetersen/raspilive/internal/metadata"
 	"github.com/jaredpetersen/raspilive/internal/raspivid"
+	"github.com/jaredpetersen/raspilive/internal/record"
+	"github.com/jaredpetersen/raspilive/internal/segment"
 	"github.com/jaredpetersen/raspilive/internal/server"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
@@ -20,10 +34,44 @@ type HlsCfg struct {
 	Directory    string
 	TLSCert      string
 	TLSKey       string
-	SegmentType  string // Format of the video segment
-	SegmentTime  int    // Segment length target duration in seconds
-	PlaylistSize int    // Maximum number of playlist entries
-	StorageSize  int    // Maximum number of unreferenced segments to keep on disk before removal
+	SegmentType  string        // Format of the video segment
+	SegmentTime  int           // Segment length target duration in seconds
+	PlaylistSize int           // Maximum number of playlist entries
+	StorageSize  int           // Maximum number of unreferenced segments to keep on disk before removal
+	Muxer        string        // Implementation used to mux the video
+	Memory       bool          // Keep the video in memory rather than writing it to the directory
+	Renditions   []string      // Lower quality renditions to transcode for adaptive bitrate streaming
+	DVRWindow    time.Duration // Duration of video to keep for viewers to rewind through, in place of PlaylistSize
+	DVRBudget    int           // Maximum disk space in megabytes for the video kept for rewinding
+	Encrypt      bool          // Encrypt the segments with AES-128
+	KeyRotation  int           // Number of segments to encrypt with each key
+	KeyDirectory string        // Private directory to keep the keys in across restarts, away from the served directory
+	KeyAuth      string        // Credentials required to fetch keys, formatted as username:password
+
+	ProgramDateTime bool // Tag the segments in the playlist with the wall clock time they were captured
+	TimestampNames  bool // Name the segments with the time they were captured instead of the sequence number
+	EpochNumbers    bool // Number the segments from the current time so that they keep increasing across restarts
+	IFrames         bool // Write an I-frame playlist for scrubbing previews
+	Thumbnails      int  // Seconds between thumbnails in the scrubbing preview track, disabled if zero
+
+	Metadata     bool     // Accept timed metadata via HTTP POST to carry alongside the video
+	MetadataAuth string   // Credentials required to post metadata, formatted as username:password
+	Subtitles    []string // Fields of the timed metadata to render in a WebVTT subtitles rendition
+
+	Export     bool   // Serve downloads of the retained video between two wall clock times as MP4
+	ExportAuth string // Credentials required to export video, formatted as username:password
+
+	Sessions SessionCfg // Sessions to keep each run of the stream in
+
+	SignKey   string // Ed25519 private key to sign a hash chain manifest of the video with
+	EncryptTo string // age public key to encrypt the recordings and clips to as they are written
+
+	Audio AudioCfg // Audio to capture alongside the video
+
+	Record RecordCfg // Rolling recordings to write alongside the stream
+	Clips  ClipCfg   // Clips to save alongside the stream when triggered
+	Upload UploadCfg // Object storage to upload the video to
+	Disk   DiskCfg   // Free space to keep on the disk
 }
 
 func newHlsCmd(video *VideoCfg) *cobra.Command {
@@ -54,6 +102,60 @@ func newHlsCmd(video *VideoCfg) *cobra.Command {
 
 	cmd.Flags().IntVar(&cfg.StorageSize, "storage-size", 1, "maximum number of unreferenced segments to keep on disk before removal")
 
+	cmd.Flags().StringVar(&cfg.Muxer, "muxer", "", "implementation used to mux the video (valid [\"ffmpeg\", \"native\"], default \"ffmpeg\")")
+
+	cmd.Flags().BoolVar(&cfg.Memory, "memory", false, "keep video in memory instead of writing it to the directory")
+
+	cmd.Flags().StringSliceVar(&cfg.Renditions, "renditions", nil, "lower quality renditions to transcode for adaptive bitrate streaming, formatted as WIDTHxHEIGHT:BITRATE (e.g. 640x360:800k)")
+
+	cmd.Flags().DurationVar(&cfg.DVRWindow, "dvr-window", 0, "duration of video to keep for viewers to rewind through, such as 30m or 2h (replaces playlist-size)")
+
+	cmd.Flags().IntVar(&cfg.DVRBudget, "dvr-budget", 1024, "maximum disk space in megabytes for the video kept by dvr-window")
+
+	cmd.Flags().BoolVar(&cfg.Encrypt, "encrypt", false, "encrypt segments with AES-128, serving the keys from /keys")
+
+	cmd.Flags().IntVar(&cfg.KeyRotation, "key-rotation", 10, "number of segments to encrypt with each key")
+
+	cmd.Flags().StringVar(&cfg.KeyDirectory, "key-directory", "", "private directory to keep the keys in across restarts (default a directory in the user cache)")
+
+	cmd.Flags().StringVar(&cfg.KeyAuth, "key-auth", "", "credentials required to fetch keys, formatted as username:password")
+
+	cmd.Flags().BoolVar(&cfg.ProgramDateTime, "program-date-time", false, "tag segments in the playlist with the wall clock time they were captured")
+
+	cmd.Flags().BoolVar(&cfg.TimestampNames, "timestamp-names", false, "name segments with the time they were captured so that they sort in order")
+
+	cmd.Flags().BoolVar(&cfg.EpochNumbers, "epoch-numbers", false, "number segments from the current time so that they keep increasing across restarts")
+
+	cmd.Flags().BoolVar(&cfg.IFrames, "iframes", false, "write an I-frame playlist for scrubbing previews, listed in a master playlist (native muxer and mpegts segments only)")
+
+	cmd.Flags().IntVar(&cfg.Thumbnails, "thumbnails", 0, "seconds between thumbnails in a sprite sheet track for scrubbing previews, served as thumbnails.vtt (0 disables)")
+
+	cmd.Flags().BoolVar(&cfg.Metadata, "metadata", false, "accept timed metadata as JSON via HTTP POST to /camera/metadata, carried in the segments as ID3 tags (native muxer only)")
+
+	cmd.Flags().StringVar(&cfg.MetadataAuth, "metadata-auth", "", "credentials required to post metadata, formatted as username:password")
+
+	cmd.Flags().StringSliceVar(&cfg.Subtitles, "subtitles", nil, "fields of the timed metadata to render in a WebVTT subtitles rendition, listed in a master playlist")
+
+	cmd.Flags().BoolVar(&cfg.Export, "export", false, "serve the retained video between two times as MP4 from /camera/export?start=...&end=...")
+
+	cmd.Flags().StringVar(&cfg.ExportAuth, "export-auth", "", "credentials required to export video, formatted as username:password")
+
+	addSessionFlags(cmd, &cfg.Sessions)
+
+	addSignKeyFlag(cmd, &cfg.SignKey)
+
+	addEncryptToFlag(cmd, &cfg.EncryptTo)
+
+	addAudioFlags(cmd, &cfg.Audio)
+
+	addRecordFlags(cmd, &cfg.Record)
+
+	addClipFlags(cmd, &cfg.Clips)
+
+	addUploadFlags(cmd, &cfg.Upload)
+
+	addDiskFlags(cmd, &cfg.Disk)
+
 	cmd.Flags().SortFlags = false
 
 	cmd.Run = func(cmd *cobra.Command, args []string) {
@@ -82,35 +184,350 @@ func isValidHlsCfg(cfg HlsCfg) bool {
 		isValidCfg = false
 	}
 
+	muxer := strings.ToLower(cfg.Muxer)
+	validMuxer := muxer == "" || muxer == "ffmpeg" || muxer == "native"
+
+	if !validMuxer {
+		fmt.Printf("Error: invalid value \"%s\" for flag \"muxer\"\n", cfg.Muxer)
+		isValidCfg = false
+	}
+
+	if _, invalid := parseRenditions(cfg.Renditions); invalid != "" {
+		fmt.Printf("Error: invalid value \"%s\" for flag \"renditions\"\n", invalid)
+		isValidCfg = false
+	}
+
+	if len(cfg.Renditions) > 0 && muxer == "native" {
+		fmt.Printf("Error: flag \"renditions\" requires the ffmpeg muxer\n")
+		isValidCfg = false
+	}
+
+	if cfg.Encrypt && muxer == "native" {
+		fmt.Printf("Error: flag \"encrypt\" requires the ffmpeg muxer\n")
+		isValidCfg = false
+	}
+
+	if cfg.KeyRotation < 2 {
+		fmt.Printf("Error: invalid value \"%d\" for flag \"key-rotation\"\n", cfg.KeyRotation)
+		isValidCfg = false
+	}
+
+	if cfg.KeyDirectory != "" && sameDirectory(cfg.KeyDirectory, cfg.Directory) {
+		fmt.Printf("Error: flag \"key-directory\" cannot be the served directory\n")
+		isValidCfg = false
+	}
+
+	if cfg.KeyAuth != "" && !strings.Contains(cfg.KeyAuth, ":") {
+		fmt.Printf("Error: invalid value \"%s\" for flag \"key-auth\"\n", cfg.KeyAuth)
+		isValidCfg = false
+	}
+
+	if cfg.DVRWindow < 0 {
+		fmt.Printf("Error: invalid value \"%s\" for flag \"dvr-window\"\n", cfg.DVRWindow)
+		isValidCfg = false
+	}
+
+	if cfg.DVRBudget < 0 {
+		fmt.Printf("Error: invalid value \"%d\" for flag \"dvr-budget\"\n", cfg.DVRBudget)
+		isValidCfg = false
+	}
+
+	if cfg.TimestampNames && len(cfg.Renditions) > 0 {
+		fmt.Printf("Error: flags \"timestamp-names\" and \"renditions\" cannot be used together\n")
+		isValidCfg = false
+	}
+
+	if cfg.IFrames && (muxer != "native" || segmentType == "fmp4") {
+		fmt.Printf("Error: flag \"iframes\" requires the native muxer and mpegts segments\n")
+		isValidCfg = false
+	}
+
+	if cfg.Thumbnails < 0 {
+		fmt.Printf("Error: invalid value \"%d\" for flag \"thumbnails\"\n", cfg.Thumbnails)
+		isValidCfg = false
+	}
+
+	if cfg.Metadata && muxer != "native" {
+		fmt.Printf("Error: flag \"metadata\" requires the native muxer\n")
+		isValidCfg = false
+	}
+
+	if cfg.MetadataAuth != "" && !strings.Contains(cfg.MetadataAuth, ":") {
+		fmt.Printf("Error: invalid value \"%s\" for flag \"metadata-auth\"\n", cfg.MetadataAuth)
+		isValidCfg = false
+	}
+
+	if len(cfg.Subtitles) > 0 && !cfg.Metadata {
+		fmt.Printf("Error: flag \"subtitles\" requires flag \"metadata\"\n")
+		isValidCfg = false
+	}
+
+	if cfg.ExportAuth != "" && !strings.Contains(cfg.ExportAuth, ":") {
+		fmt.Printf("Error: invalid value \"%s\" for flag \"export-auth\"\n", cfg.ExportAuth)
+		isValidCfg = false
+	}
+
+	if !isValidSessionCfg(cfg.Sessions, cfg.Directory) {
+		isValidCfg = false
+	}
+
+	// Keys are discarded along with the segments of the run that they encrypted, leaving earlier sessions unplayable
+	if cfg.Encrypt && cfg.Sessions.Enabled {
+		fmt.Printf("Error: flags \"encrypt\" and \"sessions\" cannot be used together\n")
+		isValidCfg = false
+	}
+
+	if cfg.SignKey != "" && (muxer != "native" || cfg.Directory == "") {
+		fmt.Printf("Error: flag \"sign-key\" requires the native muxer and flag \"directory\"\n")
+		isValidCfg = false
+	}
+
+	if !isValidEncryptTo(cfg.EncryptTo) {
+		isValidCfg = false
+	}
+
+	if cfg.EncryptTo != "" && cfg.Record.Directory == "" && cfg.Clips.Directory == "" {
+		fmt.Printf("Error: flag \"encrypt-to\" requires flag \"record\" or \"clips\"\n")
+		isValidCfg = false
+	}
+
+	if !isValidAudioCfg(cfg.Audio, muxer) {
+		isValidCfg = false
+	}
+
+	if !isValidRecordCfg(cfg.Record, "record-") {
+		isValidCfg = false
+	}
+
+	if !isValidClipCfg(cfg.Clips, "clip-") {
+		isValidCfg = false
+	}
+
+	if !isValidUploadCfg(cfg.Upload) {
+		isValidCfg = false
+	}
+
+	if !isValidDiskCfg(cfg.Disk) {
+		isValidCfg = false
+	}
+
+	if cfg.Upload.Bucket != "" && cfg.Directory == "" {
+		fmt.Printf("Error: flag \"upload-bucket\" requires flag \"directory\"\n")
+		isValidCfg = false
+	}
+
+	if cfg.Audio.Device != "" && len(cfg.Renditions) > 0 {
+		fmt.Printf("Error: flags \"audio-device\" and \"renditions\" cannot be used together\n")
+		isValidCfg = false
+	}
+
+	if cfg.Audio.Device != "" && strings.ToLower(cfg.Audio.Codec) == "opus" && segmentType != "fmp4" {
+		fmt.Printf("Error: flag \"audio-codec\" requires fmp4 segments for opus\n")
+		isValidCfg = false
+	}
+
+	if cfg.Memory && cfg.Directory != "" {
+		fmt.Printf("Error: flags \"memory\" and \"directory\" cannot be used together\n")
+		isValidCfg = false
+	}
+
 	return isValidCfg
 }
 
 func streamHls(cfg HlsCfg) {
 	// Set up raspivid stream
+	// Keyframes are lined up with the renditions' segments for players to switch between them
+	renditions, _ := parseRenditions(cfg.Renditions)
 	raspiOptions := raspivid.Options{
 		Width:          cfg.Video.Width,
 		Height:         cfg.Video.Height,
 		Fps:            cfg.Video.Fps,
 		HorizontalFlip: cfg.Video.HorizontalFlip,
 		VerticalFlip:   cfg.Video.VerticalFlip,
+		IntraPeriod:    renditionIntraPeriod(cfg.Video.Fps, cfg.SegmentTime, renditions),
 	}
 	raspiStream, err := raspivid.NewStream(raspiOptions)
 	if err != nil {
 		log.Fatal().Msg("Encountered an error streaming video from the Raspberry Pi Camera Module")
 	}
+	verifyVideo(raspiStream, cfg.Video)
+
+	// Ffmpeg counts the DVR window in segments rather than time
+	playlistSize := cfg.PlaylistSize
+	if cfg.DVRWindow > 0 {
+		playlistSize = dvrPlaylistSize(cfg.DVRWindow, cfg.DVRBudget, cfg.SegmentTime, cfg.StorageSize)
+	}
+
+	// Keep the video in memory if asked to, sparing the SD card from wear
+	var store *memfs.Store
+	var uploads *memfs.UploadServer
+	if cfg.Memory {
+		store = newMemoryStore(cfg.SegmentTime, playlistSize, cfg.StorageSize, renditions)
+	}
+
+	// Encrypt the segments if asked to, with keys kept away from the served files
+	var keyring *ffmpeghls.Keyring
+	if cfg.Encrypt {
+		var segments http.FileSystem = http.Dir(cfg.Directory)
+		if store != nil {
+			segments = store
+		}
+		keyring = newKeyring(cfg.KeyRotation, keyDirectory(cfg.KeyDirectory, cfg.Directory, store != nil), segments)
+	}
+
+	// Accept timed metadata if asked to, carried alongside the video captured as it arrives
+	var queue *metadata.Queue
+	if cfg.Metadata {
+		queue = &metadata.Queue{}
+	}
+
+	// Keep track of the segments if asked to, so that footage may be exported from them for as long as they are kept
+	var catalog *export.Catalog
+	if cfg.Export {
+		catalog = &export.Catalog{}
+	}
+
+	// Keep each run in a session of its own if asked to, writing the video to the session's directory
+	library, tracker := startSession(cfg.Sessions, cfg.Directory)
+	videoDirectory := cfg.Directory
+	if tracker != nil {
+		videoDirectory = tracker.Directory()
+	}
+
+	// Sign a tamper-evident manifest of the video if asked to, for footage that may be used as evidence
+	signKey := loadSignKey(cfg.SignKey)
+
+	// Encrypt the recordings and clips if asked to, so that they are of no use to whoever takes the device
+	recipient := loadRecipient(cfg.EncryptTo)
 
 	// Set up HLS muxer
-	muxer := hls.Muxer{
-		Directory: cfg.Directory,
-		Options: hls.Options{
-			Fps:          cfg.Video.Fps,
-			SegmentTime:  cfg.SegmentTime,
-			SegmentType:  cfg.SegmentType,
-			PlaylistSize: cfg.PlaylistSize,
-			StorageSize:  cfg.StorageSize,
-		},
+	var muxer videoMuxer
+	var playlists []string
+	var follower *sessionFollower
+	if strings.ToLower(cfg.Muxer) == "native" {
+		nativeMuxer := &hls.Muxer{
+			Directory: videoDirectory,
+			Options: hls.Options{
+				Fps:          cfg.Video.Fps,
+				SegmentTime:  cfg.SegmentTime,
+				SegmentType:  cfg.SegmentType,
+				PlaylistSize: cfg.PlaylistSize,
+				StorageSize:  cfg.StorageSize,
+				Window:       int(cfg.DVRWindow.Seconds()),
+				Budget:       dvrBudget(cfg.DVRWindow, cfg.DVRBudget),
+
+				ProgramDateTime: cfg.ProgramDateTime,
+				TimestampNames:  cfg.TimestampNames,
+				EpochNumbers:    cfg.EpochNumbers,
+				IFrames:         cfg.IFrames,
+				Metadata:        queue,
+				Subtitles:       cfg.Subtitles,
+				Catalog:         catalog,
+				Chain:           newChain(signKey, videoDirectory),
+				Session:         tracker,
+			},
+		}
+		if store != nil {
+			nativeMuxer.Storage = store
+		}
+		muxer = nativeMuxer
+	} else {
+		ffmpegMuxer := &ffmpeghls.Muxer{
+			Directory: videoDirectory,
+			Options: ffmpeghls.Options{
+				Fps:          cfg.Video.Fps,
+				SegmentTime:  cfg.SegmentTime,
+				SegmentType:  cfg.SegmentType,
+				PlaylistSize: playlistSize,
+				StorageSize:  cfg.StorageSize,
+				Renditions:   renditions,
+
+				// Exports go by the wall clock time that the segments were captured, which only the playlist tells
+				ProgramDateTime: cfg.ProgramDateTime || cfg.Export,
+				TimestampNames:  cfg.TimestampNames,
+				EpochNumbers:    cfg.EpochNumbers,
+			},
+		}
+		if cfg.Audio.Device != "" {
+			ffmpegMuxer.Options.Audio = audioInput(cfg.Audio)
+		}
+		if keyring != nil {
+			ffmpegMuxer.Options.KeyInfoFile = keyring.InfoFile()
+		}
+		if store != nil {
+			uploads, ffmpegMuxer.URL = serveUploads(store)
+		}
+		if tracker != nil {
+			// The whole session is played back from disk, so Ffmpeg is left to keep every segment
+			ffmpegMuxer.Options.StorageSize = 0
+			follower = followSession(tracker, ffmpegMuxer.Playlists()[0], ffmpeghls.Segments, cfg.ProgramDateTime)
+		}
+		playlists = ffmpegMuxer.Playlists()
+		muxer = ffmpegMuxer
+	}
+
+	var storage segment.Storage = segment.Dir(videoDirectory)
+	if store != nil {
+		storage = store
+	}
+
+	var files http.FileSystem = http.Dir(videoDirectory)
+	if store != nil {
+		files = store
+	}
+
+	// List the renditions in a master playlist once the camera reveals what it's delivering
+	if len(renditions) > 0 {
+		writeMasterPlaylist(raspiStream, storage, files, renditions)
+	}
+
+	// Ffmpeg only lists the segments in its playlist, so the catalog is kept up to date from that
+	if catalog != nil && len(playlists) > 0 {
+		catalogSegments(catalog, files, playlists[0], ffmpeghls.Segments)
 	}
 
+	if cfg.Thumbnails > 0 {
+		retention := thumbnailRetention(cfg.SegmentTime, playlistSize, cfg.StorageSize, store != nil)
+		generateThumbnails(raspiStream, storage, cfg.Video, cfg.Thumbnails, retention)
+	}
+
+	var recorder *record.Recorder
+	if cfg.Record.Directory != "" {
+		recorder = startRecording(raspiStream, cfg.Record, cfg.Video.Fps, signKey, recipient)
+	}
+
+	var clipper *record.Clipper
+	if cfg.Clips.Directory != "" {
+		clipper = startClipping(raspiStream, cfg.Clips, cfg.Video.Fps, signKey, recipient)
+	}
+
+	// Keep the disk from filling up, which would bring the stream down
+	var sessionPruner, segmentPruner, recordPruner, clipPruner disk.Pruner
+	if library != nil {
+		sessionPruner = library
+	}
+	if ffmpegMuxer, ok := muxer.(*ffmpeghls.Muxer); ok && store == nil {
+		segmentPruner = ffmpegMuxer
+	}
+	if recorder != nil {
+		recordPruner = recorder
+	}
+	if clipper != nil {
+		clipPruner = clipper
+	}
+	guards := startDiskGuards(
+		cfg.Disk,
+		guardedDirectory{Directory: streamDirectory(cfg.Directory, store != nil), Pruner: sessionPruner},
+		guardedDirectory{Directory: streamDirectory(cfg.Directory, store != nil), Pruner: segmentPruner},
+		guardedDirectory{Directory: cfg.Record.Directory, Pruner: recordPruner},
+		guardedDirectory{Directory: cfg.Clips.Directory, Pruner: clipPruner},
+	)
+	if nativeMuxer, ok := muxer.(*hls.Muxer); ok {
+		nativeMuxer.Options.Shortfall = diskShortfall(guards, streamDirectory(cfg.Directory, store != nil))
+	}
+
+	uploaders := startStreamUploads(cfg.Upload, cfg.Directory, cfg.Record, cfg.Clips)
+
 	// Set up static file server
 	srv := server.Static{
 		Port:      cfg.Port,
@@ -118,6 +535,30 @@ func streamHls(cfg HlsCfg) {
 		Cert:      cfg.TLSCert,
 		Key:       cfg.TLSKey,
 	}
+	if store != nil {
+		srv.FileSystem = store
+	}
+	if queue != nil {
+		srv.Handle("/metadata", &server.Metadata{Queue: queue, Auth: cfg.MetadataAuth})
+	}
+	if clipper != nil {
+		srv.Handle("/clip", clipTrigger(clipper, cfg.Clips))
+	}
+	if catalog != nil {
+		srv.Handle("/export", exportHandler(catalog, videoDirectory, store, cfg.ExportAuth))
+	}
+	if len(guards) > 0 {
+		srv.Handle("/disk", &server.Disk{Guards: guards})
+	}
+	if library != nil {
+		srv.Handle("/sessions/", &server.Sessions{Library: library})
+		redirectToSession(&srv, tracker, "livestream.m3u8", "thumbnails.vtt")
+	}
+	if keyring != nil {
+		srv.KeyAuth = cfg.KeyAuth
+		srv.HandleKeys(keyring)
+		go rotateKeys(keyring, keyring.Segments, playlists)
+	}
 
 	// Set up a channel for exiting
 	stop := make(chan struct{})
@@ -137,28 +578,184 @@ func streamHls(cfg HlsCfg) {
 	}()
 
 	// Stream video
+	muxed := make(chan error, 1)
 	go func() {
-		if err := muxHls(raspiStream, &muxer); err != nil {
-			log.Fatal().Msg("Encountered an error streaming/muxing video")
-		}
-		stop <- struct{}{}
+		muxed <- muxHls(raspiStream, muxer)
 	}()
 
-	// Wait for a stop signal
-	<-stop
+	// Wait for a stop signal, or for the video to come to an end
+	select {
+	case <-stop:
+		log.Info().Msg("Shutting down")
 
-	log.Info().Msg("Shutting down")
+		// Let the muxer finish off the video it has before anything that it writes to goes away
+		raspiStream.Video.Close()
+		waitForMuxer(muxed)
+	case err := <-muxed:
+		if err != nil {
+			log.Fatal().Msg("Encountered an error streaming/muxing video")
+		}
+		log.Info().Msg("Shutting down")
+	}
 
-	raspiStream.Video.Close()
+	if follower != nil {
+		follower.Finish()
+	}
 	srv.Shutdown(serverShutdownDeadline)
+	if recorder != nil {
+		recorder.Wait()
+	}
+	if clipper != nil {
+		clipper.Wait()
+	}
+	stopUploads(uploaders)
+	stopDiskGuards(guards)
+	if uploads != nil {
+		uploads.Close()
+	}
+}
+
+// newKeyring sets up the keys for encrypting segments in the directory, picking up the keys of earlier runs and keeping
+// each of them around for as long as the segments encrypted with it are.
+func newKeyring(rotation int, dir string, segments http.FileSystem) *ffmpeghls.Keyring {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		log.Debug().Err(err).Msg("Encountered an error setting up encryption keys")
+		log.Fatal().Msg("Encountered an error setting up encryption keys")
+	}
+
+	keyring := &ffmpeghls.Keyring{
+		Directory: dir,
+		URI:       "/keys/",
+		Rotation:  rotation,
+		Segments:  segments,
+	}
+
+	if err := keyring.Load(); err != nil {
+		log.Debug().Err(err).Msg("Encountered an error loading encryption keys")
+		log.Fatal().Msg("Encountered an error loading encryption keys")
+	}
+
+	if err := keyring.Rotate(); err != nil {
+		log.Debug().Err(err).Msg("Encountered an error generating encryption key")
+		log.Fatal().Msg("Encountered an error generating encryption key")
+	}
+
+	return keyring
+}
+
+// keyDirectory returns the directory to keep the keys in, defaulting to one in the user cache for each directory of
+// video so that the keys of different streams are kept apart.
+func keyDirectory(dir string, videoDirectory string, memory bool) string {
+	if dir != "" {
+		return dir
+	}
+
+	stream := "memory"
+	if !memory {
+		absolute, err := filepath.Abs(videoDirectory)
+		if err != nil {
+			absolute = videoDirectory
+		}
+		sum := sha256.Sum256([]byte(absolute))
+		stream = hex.EncodeToString(sum[:8])
+	}
+
+	cache, err := os.UserCacheDir()
+	if err != nil {
+		cache = os.TempDir()
+	}
+
+	return filepath.Join(cache, "raspilive", "keys", stream)
+}
+
+// sameDirectory reports whether the two paths name the same directory.
+func sameDirectory(a string, b string) bool {
+	absoluteA, errA := filepath.Abs(a)
+	absoluteB, errB := filepath.Abs(b)
+
+	return errA == nil && errB == nil && absoluteA == absoluteB
+}
+
+// rotateKeys keeps the keyring in step with the playlists as Ffmpeg writes them, which it picks up at the start of
+// the next segment.
+func rotateKeys(keyring *ffmpeghls.Keyring, files http.FileSystem, playlists []string) {
+	for range time.Tick(playlistPollInterval) {
+		var contents [][]byte
+		for _, name := range playlists {
+			// Ffmpeg has yet to write the playlist if it can't be read
+			if playlist, err := readFile(files, name); err == nil {
+				contents = append(contents, playlist)
+			}
+		}
+
+		if err := keyring.Update(contents...); err != nil {
+			log.Debug().Err(err).Msg("Encountered an error generating encryption key")
+			log.Fatal().Msg("Encountered an error generating encryption key")
+		}
+	}
+}
+
+// writeMasterPlaylist lists the camera's own video first in the master playlist, followed by the renditions. The camera's
+// bitrate varies with the scene, so its video is listed with the peak bitrate of its segments so far, rewriting the
+// master playlist whenever it goes up.
+func writeMasterPlaylist(raspiStream *raspivid.Stream, storage segment.Storage, files http.FileSystem, renditions []abr.Rendition) {
+	found := make(chan h264.SPS, 1)
+	raspiStream.Video = h264.Watch(raspiStream.Video, func(sps h264.SPS) {
+		found <- sps
+	})
+
+	go func() {
+		sps := <-found
+
+		peak := 0
+		for range time.Tick(playlistPollInterval) {
+			// Ffmpeg has yet to write the playlist if it can't be read
+			playlist, err := readFile(files, ffmpeghls.VariantName(0))
+			if err != nil {
+				continue
+			}
+
+			bandwidth := ffmpeghls.PeakBandwidth(playlist, files)
+			if bandwidth <= peak {
+				continue
+			}
+			peak = bandwidth
+
+			variants := []hls.Variant{
+				{
+					URI:       ffmpeghls.VariantName(0),
+					Bandwidth: peak,
+					Width:     sps.Width,
+					Height:    sps.Height,
+					Codec:     sps.Codec(),
+				},
+			}
+
+			for i, rendition := range renditions {
+				variants = append(variants, hls.Variant{
+					URI:       ffmpeghls.VariantName(i + 1),
+					Bandwidth: rendition.Peak(),
+					Width:     rendition.Width,
+					Height:    rendition.Height,
+					Codec:     abr.Codec,
+				})
+			}
+
+			masterPlaylist := hls.MasterPlaylist{Storage: storage, Name: "livestream.m3u8", Variants: variants}
+			if err := masterPlaylist.Write(); err != nil {
+				log.Debug().Err(err).Msg("Encountered an error writing master playlist")
+				log.Fatal().Msg("Encountered an error writing master playlist")
+			}
+		}
+	}()
 }
 
-func muxHls(raspiStream *raspivid.Stream, muxer *hls.Muxer) error {
+func muxHls(raspiStream *raspivid.Stream, muxer videoMuxer) error {
 	if err := muxer.Mux(raspiStream.Video); err != nil {
 		log.Debug().Err(err).Msg("Encountered an error starting video mux")
 		return err
 	}
-	log.Debug().Str("cmd", muxer.String()).Msg("Started ffmpeg muxer")
+	log.Debug().Str("cmd", muxer.String()).Msg("Started muxer")
 
 	if err := raspiStream.Start(); err != nil {
 		log.Debug().Err(err).Msg("Encountered an error starting video stream")
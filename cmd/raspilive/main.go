@@ -11,6 +11,8 @@ import (
 
 const serverShutdownDeadline = 10 * time.Second
 
+const muxerShutdownDeadline = 10 * time.Second
+
 // VideoCfg represents the video configuration options
 type VideoCfg struct {
 	Width          int
@@ -41,6 +43,15 @@ func main() {
 
 	rootCmd.AddCommand(newHlsCmd(&video))
 	rootCmd.AddCommand(newDashCmd(&video))
+	rootCmd.AddCommand(newMjpegCmd(&video))
+	rootCmd.AddCommand(newWebSocketCmd(&video))
+	rootCmd.AddCommand(newSrtCmd(&video))
+	rootCmd.AddCommand(newRtpCmd(&video))
+	rootCmd.AddCommand(newTCPCmd(&video))
+	rootCmd.AddCommand(newRecordCmd(&video))
+	rootCmd.AddCommand(newClipCmd(&video))
+	rootCmd.AddCommand(newVerifyCmd())
+	rootCmd.AddCommand(newDecryptCmd())
 
 	rootCmd.PersistentFlags().IntVar(&video.Width, "width", 1280, "video width")
 	rootCmd.PersistentFlags().IntVar(&video.Height, "height", 720, "video height")
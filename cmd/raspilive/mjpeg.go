@@ -0,0 +1,164 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/jaredpetersen/raspilive/internal/ffmpeg/mjpeg"
+	"github.com/jaredpetersen/raspilive/internal/raspivid"
+	"github.com/jaredpetersen/raspilive/internal/server"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+// MjpegCfg represents the MJPEG configuration options
+type MjpegCfg struct {
+	Video     *VideoCfg
+	Port      int
+	Directory string
+	TLSCert   string
+	TLSKey    string
+	OutputFps int // Framerate of the MJPEG stream
+	Quality   int // JPEG quality scale, from 2 (best) to 31 (worst)
+}
+
+func newMjpegCmd(video *VideoCfg) *cobra.Command {
+	cfg := MjpegCfg{
+		Video: video,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "mjpeg",
+		Short: "Stream video using MJPEG",
+		Long:  "Stream video using MJPEG",
+	}
+
+	cmd.Flags().IntVar(&cfg.Port, "port", 0, "static file server port")
+
+	cmd.Flags().StringVar(&cfg.Directory, "directory", "", "static file server directory")
+
+	cmd.Flags().StringVar(&cfg.TLSCert, "tls-cert", "", "static file server TLS certificate")
+
+	cmd.Flags().StringVar(&cfg.TLSKey, "tls-key", "", "static file server TLS key")
+
+	cmd.Flags().IntVar(&cfg.OutputFps, "output-fps", 10, "framerate of the MJPEG stream")
+
+	cmd.Flags().IntVar(&cfg.Quality, "quality", 5, "JPEG quality, from 2 (best) to 31 (worst)")
+
+	cmd.Flags().SortFlags = false
+
+	cmd.Run = func(cmd *cobra.Command, args []string) {
+		streamMjpeg(cfg)
+	}
+
+	cmd.PreRun = func(cmd *cobra.Command, args []string) {
+		isValidCfg := isValidMjpegCfg(cfg)
+		if !isValidCfg {
+			cmd.Usage()
+			os.Exit(1)
+		}
+	}
+
+	return cmd
+}
+
+func isValidMjpegCfg(cfg MjpegCfg) bool {
+	isValidCfg := true
+
+	if cfg.Quality < 2 || cfg.Quality > 31 {
+		fmt.Printf("Error: invalid value \"%d\" for flag \"quality\"\n", cfg.Quality)
+		isValidCfg = false
+	}
+
+	return isValidCfg
+}
+
+func streamMjpeg(cfg MjpegCfg) {
+	// Set up raspivid stream
+	// Inline headers allow the transcoder to pick up the video mid-stream when a client connects
+	raspiOptions := raspivid.Options{
+		Width:          cfg.Video.Width,
+		Height:         cfg.Video.Height,
+		Fps:            cfg.Video.Fps,
+		HorizontalFlip: cfg.Video.HorizontalFlip,
+		VerticalFlip:   cfg.Video.VerticalFlip,
+		InlineHeaders:  true,
+	}
+	raspiStream, err := raspivid.NewStream(raspiOptions)
+	if err != nil {
+		log.Fatal().Msg("Encountered an error streaming video from the Raspberry Pi Camera Module")
+	}
+	verifyVideo(raspiStream, cfg.Video)
+
+	// Set up MJPEG handler
+	handler := mjpeg.Handler{
+		Options: mjpeg.Options{
+			Fps:     cfg.OutputFps,
+			Quality: cfg.Quality,
+		},
+	}
+
+	// Set up static file server
+	srv := server.Static{
+		Port:      cfg.Port,
+		Directory: cfg.Directory,
+		Cert:      cfg.TLSCert,
+		Key:       cfg.TLSKey,
+	}
+	srv.Handle("/stream.mjpeg", &handler)
+
+	// Set up a channel for exiting
+	stop := make(chan struct{})
+	osStopper(stop)
+
+	// Serve the video stream
+	go func() {
+		err := srv.ListenAndServe()
+		if errors.Is(err, server.ErrInvalidDirectory) {
+			log.Fatal().Msg("Directory does not exist")
+		}
+		if err != nil {
+			log.Debug().Err(err).Msg("Encountered an error serving video")
+			log.Fatal().Msg("Encountered an error serving video")
+		}
+		stop <- struct{}{}
+	}()
+
+	// Stream video
+	go func() {
+		if err := feedMjpeg(raspiStream, &handler); err != nil {
+			log.Fatal().Msg("Encountered an error streaming/transcoding video")
+		}
+		stop <- struct{}{}
+	}()
+
+	// Wait for a stop signal
+	<-stop
+
+	log.Info().Msg("Shutting down")
+
+	raspiStream.Video.Close()
+	handler.Close()
+	srv.Shutdown(serverShutdownDeadline)
+}
+
+func feedMjpeg(raspiStream *raspivid.Stream, handler *mjpeg.Handler) error {
+	if err := raspiStream.Start(); err != nil {
+		log.Debug().Err(err).Msg("Encountered an error starting video stream")
+		return err
+	}
+	log.Debug().Str("cmd", raspiStream.String()).Msg("Started raspivid")
+
+	if err := handler.Feed(raspiStream.Video); err != nil {
+		log.Debug().Err(err).Msg("Encountered an error feeding video to the transcoder")
+		return err
+	}
+
+	if err := raspiStream.Wait(); err != nil {
+		log.Debug().Err(err).Msg("Encountered an error waiting for video stream")
+		return err
+	}
+
+	return nil
+}
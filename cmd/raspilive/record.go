@@ -0,0 +1,241 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"filippo.io/age"
+	"github.com/jaredpetersen/raspilive/internal/raspivid"
+	"github.com/jaredpetersen/raspilive/internal/record"
+	"github.com/jaredpetersen/raspilive/internal/upload"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+// RecordCfg represents the recording configuration options
+type RecordCfg struct {
+	Video     *VideoCfg
+	Directory string        // Directory to write the recordings to, disabled if not provided alongside a stream
+	Length    time.Duration // Target length of each recording
+	MaxAge    time.Duration // Remove recordings older than this, keeping them regardless of age if zero
+	MaxSize   int           // Maximum disk space in megabytes for the recordings, unlimited if zero
+}
+
+func newRecordCmd(video *VideoCfg) *cobra.Command {
+	cfg := RecordCfg{
+		Video: video,
+	}
+	uploadCfg := UploadCfg{}
+	diskCfg := DiskCfg{}
+	var signKey string
+	var encryptTo string
+
+	cmd := &cobra.Command{
+		Use:   "record",
+		Short: "Record video to rolling MP4 files",
+		Long:  "Record video to rolling MP4 files",
+	}
+
+	cmd.Flags().StringVar(&cfg.Directory, "directory", "", "directory to write the recordings to")
+	cmd.MarkFlagRequired("directory")
+
+	cmd.Flags().DurationVar(&cfg.Length, "length", 10*time.Minute, "target length of each recording, cut on the next keyframe")
+
+	cmd.Flags().DurationVar(&cfg.MaxAge, "max-age", 0, "remove recordings older than this, such as 168h (0 keeps them regardless of age)")
+
+	cmd.Flags().IntVar(&cfg.MaxSize, "max-size", 0, "maximum disk space in megabytes for the recordings, removing the oldest past it (0 is unlimited)")
+
+	addUploadFlags(cmd, &uploadCfg)
+
+	addDiskFlags(cmd, &diskCfg)
+
+	addSignKeyFlag(cmd, &signKey)
+
+	addEncryptToFlag(cmd, &encryptTo)
+
+	cmd.Flags().SortFlags = false
+
+	cmd.Run = func(cmd *cobra.Command, args []string) {
+		recordVideo(cfg, uploadCfg, diskCfg, signKey, encryptTo)
+	}
+
+	cmd.PreRun = func(cmd *cobra.Command, args []string) {
+		isValidCfg := isValidRecordCfg(cfg, "")
+		if !isValidUploadCfg(uploadCfg) {
+			isValidCfg = false
+		}
+		if !isValidDiskCfg(diskCfg) {
+			isValidCfg = false
+		}
+		if !isValidEncryptTo(encryptTo) {
+			isValidCfg = false
+		}
+		if !isValidCfg {
+			cmd.Usage()
+			os.Exit(1)
+		}
+	}
+
+	return cmd
+}
+
+// addRecordFlags adds the flags for recording alongside the stream to the command.
+func addRecordFlags(cmd *cobra.Command, cfg *RecordCfg) {
+	cmd.Flags().StringVar(&cfg.Directory, "record", "", "directory to also write rolling MP4 recordings of the video to")
+
+	cmd.Flags().DurationVar(&cfg.Length, "record-length", 10*time.Minute, "target length of each recording, cut on the next keyframe")
+
+	cmd.Flags().DurationVar(&cfg.MaxAge, "record-max-age", 0, "remove recordings older than this, such as 168h (0 keeps them regardless of age)")
+
+	cmd.Flags().IntVar(&cfg.MaxSize, "record-max-size", 0, "maximum disk space in megabytes for the recordings, removing the oldest past it (0 is unlimited)")
+}
+
+// isValidRecordCfg checks the recording options, whose flags are named with the given prefix.
+func isValidRecordCfg(cfg RecordCfg, prefix string) bool {
+	isValidCfg := true
+
+	if cfg.Length < time.Second {
+		fmt.Printf("Error: invalid value \"%s\" for flag \"%slength\"\n", cfg.Length, prefix)
+		isValidCfg = false
+	}
+
+	if cfg.MaxAge < 0 {
+		fmt.Printf("Error: invalid value \"%s\" for flag \"%smax-age\"\n", cfg.MaxAge, prefix)
+		isValidCfg = false
+	}
+
+	if cfg.MaxSize < 0 {
+		fmt.Printf("Error: invalid value \"%d\" for flag \"%smax-size\"\n", cfg.MaxSize, prefix)
+		isValidCfg = false
+	}
+
+	return isValidCfg
+}
+
+// newRecorder sets up the recorder for the recording options, chaining the recordings if there is a key to sign them
+// with and encrypting them if there is a recipient to encrypt them to.
+func newRecorder(cfg RecordCfg, fps int, signKey ed25519.PrivateKey, recipient age.Recipient) *record.Recorder {
+	recorder := &record.Recorder{
+		Directory: cfg.Directory,
+		Options: record.Options{
+			Fps:     fps,
+			Length:  int(cfg.Length.Seconds()),
+			MaxAge:  cfg.MaxAge,
+			MaxSize: int64(cfg.MaxSize) * 1024 * 1024,
+			Chain:   newChain(signKey, cfg.Directory),
+		},
+	}
+	if recipient != nil {
+		recorder.Options.Recipient = recipient
+	}
+
+	return recorder
+}
+
+// startRecording taps the camera's video for rolling recordings alongside the stream.
+//
+// The stream carries on if recording fails, such as when the disk fills up.
+func startRecording(raspiStream *raspivid.Stream, cfg RecordCfg, fps int, signKey ed25519.PrivateKey, recipient age.Recipient) *record.Recorder {
+	tapped, tap := io.Pipe()
+
+	recorder := newRecorder(cfg, fps, signKey, recipient)
+	if err := recorder.Mux(tapped); err != nil {
+		log.Debug().Err(err).Msg("Encountered an error starting recorder")
+		log.Fatal().Msg("Encountered an error recording video")
+	}
+	log.Debug().Str("cmd", recorder.String()).Msg("Started recorder")
+
+	raspiStream.Video = &teeReadCloser{ReadCloser: raspiStream.Video, w: tap}
+
+	go func() {
+		if err := recorder.Wait(); err != nil {
+			log.Debug().Err(err).Msg("Encountered an error waiting for recorder")
+			log.Warn().Msg("Encountered an error recording video, recording stopped")
+		}
+
+		// Stop taking in video so that the tap never holds it up
+		tapped.Close()
+	}()
+
+	return recorder
+}
+
+func recordVideo(cfg RecordCfg, uploadCfg UploadCfg, diskCfg DiskCfg, signKey string, encryptTo string) {
+	// Set up raspivid stream
+	raspiOptions := raspivid.Options{
+		Width:          cfg.Video.Width,
+		Height:         cfg.Video.Height,
+		Fps:            cfg.Video.Fps,
+		HorizontalFlip: cfg.Video.HorizontalFlip,
+		VerticalFlip:   cfg.Video.VerticalFlip,
+	}
+	raspiStream, err := raspivid.NewStream(raspiOptions)
+	if err != nil {
+		log.Fatal().Msg("Encountered an error streaming video from the Raspberry Pi Camera Module")
+	}
+	verifyVideo(raspiStream, cfg.Video)
+
+	// Set up recorder
+	recorder := newRecorder(cfg, cfg.Video.Fps, loadSignKey(signKey), loadRecipient(encryptTo))
+
+	// Keep the disk from filling up, which would bring the recording down
+	guards := startDiskGuards(diskCfg, guardedDirectory{Directory: cfg.Directory, Pruner: recorder})
+
+	// Upload the recordings if asked to
+	var uploaders []*upload.Uploader
+	if uploadCfg.Bucket != "" {
+		uploaders = append(uploaders, startUploading(uploadCfg, cfg.Directory, ""))
+	}
+
+	// Set up a channel for exiting
+	stop := make(chan struct{})
+	osStopper(stop)
+
+	// Record video
+	go func() {
+		if err := muxRecording(raspiStream, recorder); err != nil {
+			log.Fatal().Msg("Encountered an error recording video")
+		}
+		stop <- struct{}{}
+	}()
+
+	// Wait for a stop signal
+	<-stop
+
+	log.Info().Msg("Shutting down")
+
+	// Let the last recording finish up so that it does not need to be recovered
+	raspiStream.Video.Close()
+	recorder.Wait()
+	stopUploads(uploaders)
+	stopDiskGuards(guards)
+}
+
+func muxRecording(raspiStream *raspivid.Stream, recorder *record.Recorder) error {
+	if err := recorder.Mux(raspiStream.Video); err != nil {
+		log.Debug().Err(err).Msg("Encountered an error starting recorder")
+		return err
+	}
+	log.Debug().Str("cmd", recorder.String()).Msg("Started recorder")
+
+	if err := raspiStream.Start(); err != nil {
+		log.Debug().Err(err).Msg("Encountered an error starting video stream")
+		return err
+	}
+	log.Debug().Str("cmd", raspiStream.String()).Msg("Started raspivid")
+
+	if err := recorder.Wait(); err != nil {
+		log.Debug().Err(err).Msg("Encountered an error waiting for recorder")
+		return err
+	}
+
+	if err := raspiStream.Wait(); err != nil {
+		log.Debug().Err(err).Msg("Encountered an error waiting for video stream")
+		return err
+	}
+
+	return nil
+}
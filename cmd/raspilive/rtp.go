@@ -0,0 +1,203 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/jaredpetersen/raspilive/internal/ffmpeg/rtp"
+	"github.com/jaredpetersen/raspilive/internal/raspivid"
+	"github.com/jaredpetersen/raspilive/internal/server"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+// RtpCfg represents the RTP configuration options
+type RtpCfg struct {
+	Video       *VideoCfg
+	Port        int
+	Directory   string
+	TLSCert     string
+	TLSKey      string
+	Destination string // Unicast or multicast host and port to send the video to
+	Payload     string // Format of the RTP payload
+	TTL         int    // Number of network hops multicast packets may take
+	Interface   string // Network interface to send from
+}
+
+func newRtpCmd(video *VideoCfg) *cobra.Command {
+	cfg := RtpCfg{
+		Video: video,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "rtp",
+		Short: "Stream video using RTP",
+		Long:  "Stream video using RTP",
+	}
+
+	cmd.Flags().IntVar(&cfg.Port, "port", 0, "static file server port")
+
+	cmd.Flags().StringVar(&cfg.Directory, "directory", "", "static file server directory")
+
+	cmd.Flags().StringVar(&cfg.TLSCert, "tls-cert", "", "static file server TLS certificate")
+
+	cmd.Flags().StringVar(&cfg.TLSKey, "tls-key", "", "static file server TLS key")
+
+	cmd.Flags().StringVar(&cfg.Destination, "destination", "", "unicast or multicast address to send video to, as host:port")
+	cmd.MarkFlagRequired("destination")
+
+	cmd.Flags().StringVar(&cfg.Payload, "payload", "", "format of the RTP payload (valid [\"h264\", \"mpegts\"], default \"h264\")")
+
+	cmd.Flags().IntVar(&cfg.TTL, "ttl", 1, "number of network hops multicast packets may take")
+
+	cmd.Flags().StringVar(&cfg.Interface, "interface", "", "network interface to send video from")
+
+	cmd.Flags().SortFlags = false
+
+	cmd.Run = func(cmd *cobra.Command, args []string) {
+		streamRtp(cfg)
+	}
+
+	cmd.PreRun = func(cmd *cobra.Command, args []string) {
+		isValidCfg := isValidRtpCfg(cfg)
+		if !isValidCfg {
+			cmd.Usage()
+			os.Exit(1)
+		}
+	}
+
+	return cmd
+}
+
+func isValidRtpCfg(cfg RtpCfg) bool {
+	isValidCfg := true
+
+	if _, _, err := net.SplitHostPort(cfg.Destination); err != nil {
+		fmt.Printf("Error: invalid value \"%s\" for flag \"destination\"\n", cfg.Destination)
+		isValidCfg = false
+	}
+
+	payload := strings.ToLower(cfg.Payload)
+	validPayload := payload == "" || payload == "h264" || payload == "mpegts"
+
+	if !validPayload {
+		fmt.Printf("Error: invalid value \"%s\" for flag \"payload\"\n", cfg.Payload)
+		isValidCfg = false
+	}
+
+	if cfg.TTL < 0 || cfg.TTL > 255 {
+		fmt.Printf("Error: invalid value \"%d\" for flag \"ttl\"\n", cfg.TTL)
+		isValidCfg = false
+	}
+
+	return isValidCfg
+}
+
+func streamRtp(cfg RtpCfg) {
+	// Set up raspivid stream
+	// Inline headers allow receivers to pick up the video whenever they join
+	raspiOptions := raspivid.Options{
+		Width:          cfg.Video.Width,
+		Height:         cfg.Video.Height,
+		Fps:            cfg.Video.Fps,
+		HorizontalFlip: cfg.Video.HorizontalFlip,
+		VerticalFlip:   cfg.Video.VerticalFlip,
+		InlineHeaders:  true,
+	}
+	raspiStream, err := raspivid.NewStream(raspiOptions)
+	if err != nil {
+		log.Fatal().Msg("Encountered an error streaming video from the Raspberry Pi Camera Module")
+	}
+	verifyVideo(raspiStream, cfg.Video)
+
+	var localAddress string
+	if cfg.Interface != "" {
+		localAddress, err = rtp.InterfaceAddress(cfg.Interface)
+		if err != nil {
+			log.Debug().Err(err).Msg("Encountered an error looking up network interface")
+			log.Fatal().Msg("Network interface does not exist or has no address")
+		}
+	}
+
+	// Set up RTP muxer
+	muxer := rtp.Muxer{
+		Address:   cfg.Destination,
+		Directory: cfg.Directory,
+		Options: rtp.Options{
+			Fps:          cfg.Video.Fps,
+			Payload:      cfg.Payload,
+			TTL:          cfg.TTL,
+			LocalAddress: localAddress,
+		},
+	}
+
+	// Set up static file server
+	srv := server.Static{
+		Port:      cfg.Port,
+		Directory: cfg.Directory,
+		Cert:      cfg.TLSCert,
+		Key:       cfg.TLSKey,
+	}
+
+	// Set up a channel for exiting
+	stop := make(chan struct{})
+	osStopper(stop)
+
+	// Serve the session description for the video stream
+	go func() {
+		err := srv.ListenAndServe()
+		if errors.Is(err, server.ErrInvalidDirectory) {
+			log.Fatal().Msg("Directory does not exist")
+		}
+		if err != nil {
+			log.Debug().Err(err).Msg("Encountered an error serving video")
+			log.Fatal().Msg("Encountered an error serving video")
+		}
+		stop <- struct{}{}
+	}()
+
+	// Stream video
+	go func() {
+		if err := muxRtp(raspiStream, &muxer); err != nil {
+			log.Fatal().Msg("Encountered an error streaming/muxing video")
+		}
+		stop <- struct{}{}
+	}()
+
+	// Wait for a stop signal
+	<-stop
+
+	log.Info().Msg("Shutting down")
+
+	raspiStream.Video.Close()
+	srv.Shutdown(serverShutdownDeadline)
+}
+
+func muxRtp(raspiStream *raspivid.Stream, muxer *rtp.Muxer) error {
+	if err := muxer.Mux(raspiStream.Video); err != nil {
+		log.Debug().Err(err).Msg("Encountered an error starting video mux")
+		return err
+	}
+	log.Debug().Str("cmd", muxer.String()).Msg("Started ffmpeg muxer")
+
+	if err := raspiStream.Start(); err != nil {
+		log.Debug().Err(err).Msg("Encountered an error starting video stream")
+		return err
+	}
+	log.Debug().Str("cmd", raspiStream.String()).Msg("Started raspivid")
+
+	if err := muxer.Wait(); err != nil {
+		log.Debug().Err(err).Msg("Encountered an error waiting for video mux")
+		return err
+	}
+
+	if err := raspiStream.Wait(); err != nil {
+		log.Debug().Err(err).Msg("Encountered an error waiting for video stream")
+		return err
+	}
+
+	return nil
+}
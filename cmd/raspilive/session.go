@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"time"
+
+	"github.com/jaredpetersen/raspilive/internal/hls"
+	"github.com/jaredpetersen/raspilive/internal/segment"
+	"github.com/jaredpetersen/raspilive/internal/server"
+	"github.com/jaredpetersen/raspilive/internal/session"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+// SessionCfg represents the options for keeping each run of the stream in a session of its own
+type SessionCfg struct {
+	Enabled     bool // Write each run to a subdirectory of its own, finished as video on demand when it ends
+	MaxSessions int  // Maximum number of sessions to keep, unlimited if zero
+}
+
+// addSessionFlags adds the flags for keeping each run of the stream in a session of its own to the command.
+func addSessionFlags(cmd *cobra.Command, cfg *SessionCfg) {
+	cmd.Flags().BoolVar(&cfg.Enabled, "sessions", false, "write each run to a subdirectory named with the time it started, finished as video on demand when it ends and listed at /camera/sessions/")
+
+	cmd.Flags().IntVar(&cfg.MaxSessions, "max-sessions", 0, "maximum number of sessions to keep, removing the oldest past it (0 keeps them all)")
+}
+
+// isValidSessionCfg checks the session options against the directory that the stream is written to.
+func isValidSessionCfg(cfg SessionCfg, directory string) bool {
+	isValidCfg := true
+
+	if cfg.MaxSessions < 0 {
+		fmt.Printf("Error: invalid value \"%d\" for flag \"max-sessions\"\n", cfg.MaxSessions)
+		isValidCfg = false
+	}
+
+	if cfg.Enabled && directory == "" {
+		fmt.Printf("Error: flag \"sessions\" requires flag \"directory\"\n")
+		isValidCfg = false
+	}
+
+	if cfg.MaxSessions != 0 && !cfg.Enabled {
+		fmt.Printf("Error: flag \"max-sessions\" requires flag \"sessions\"\n")
+		isValidCfg = false
+	}
+
+	return isValidCfg
+}
+
+// startSession starts a new session in the directory, if asked to, for the stream to be written to. There is no
+// session otherwise, leaving the stream to be written to the directory itself.
+func startSession(cfg SessionCfg, directory string) (*session.Library, *session.Tracker) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+
+	library := &session.Library{Directory: directory, MaxSessions: cfg.MaxSessions}
+	tracker, err := library.Start()
+	if err != nil {
+		log.Debug().Err(err).Msg("Encountered an error starting session")
+		log.Fatal().Str("directory", directory).Msg("Encountered an error starting session")
+	}
+	log.Info().Str("session", tracker.Name()).Msg("Started session")
+
+	return library, tracker
+}
+
+// redirectToSession points the files that players start from at the session in progress, so that they stream from
+// the same place regardless of which session it is.
+func redirectToSession(srv *server.Static, tracker *session.Tracker, names ...string) {
+	for _, name := range names {
+		srv.Handle("/"+name, http.RedirectHandler("/camera/"+tracker.Name()+"/"+name, http.StatusFound))
+	}
+}
+
+// sessionFollower keeps the playlist of the whole session and its description up to date with the segments listed in
+// the playlist or manifest that Ffmpeg writes to the session's directory, as Ffmpeg knows nothing of sessions.
+type sessionFollower struct {
+	directory string
+	name      string // File name of the playlist or manifest that Ffmpeg writes
+	list      func([]byte) ([]segment.Segment, string)
+	vod       *hls.VODPlaylist
+	tracker   *session.Tracker
+	kept      []segment.Segment // Segments of the session still on disk, oldest first
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+// followSession starts following the segments that Ffmpeg lists in the named playlist or manifest, which list reads
+// back.
+func followSession(tracker *session.Tracker, name string, list func([]byte) ([]segment.Segment, string), programDateTime bool) *sessionFollower {
+	follower := &sessionFollower{
+		directory: tracker.Directory(),
+		name:      name,
+		list:      list,
+		vod: &hls.VODPlaylist{
+			Playlist: hls.Playlist{
+				Storage:         segment.Dir(tracker.Directory()),
+				Name:            session.PlaylistName,
+				ProgramDateTime: programDateTime,
+			},
+		},
+		tracker: tracker,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	go func() {
+		defer close(follower.done)
+
+		ticker := time.NewTicker(playlistPollInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				follower.update(false)
+			case <-follower.stop:
+				return
+			}
+		}
+	}()
+
+	return follower
+}
+
+// Finish finishes off the session once Ffmpeg has stopped, catching up on the last of the segments.
+func (follower *sessionFollower) Finish() {
+	close(follower.stop)
+	<-follower.done
+
+	follower.update(true)
+}
+
+// update catches up on the segments that are new since the last update, dropping those that have been pruned from disk
+// to make room.
+func (follower *sessionFollower) update(ended bool) {
+	// Ffmpeg has yet to write the playlist if it can't be read
+	data, err := ioutil.ReadFile(path.Join(follower.directory, follower.name))
+	if err != nil && !ended {
+		return
+	}
+
+	segments, init := follower.list(data)
+	if init != "" {
+		follower.vod.Map = init
+	}
+
+	changed := ended
+	for _, seg := range segments {
+		if len(follower.kept) > 0 && seg.Number <= follower.kept[len(follower.kept)-1].Number {
+			continue
+		}
+
+		info, err := os.Stat(path.Join(follower.directory, seg.Name))
+		if err != nil {
+			continue
+		}
+		seg.Size = info.Size()
+		follower.kept = append(follower.kept, seg)
+		changed = true
+	}
+
+	var pruned []segment.Segment
+	for len(follower.kept) > 0 {
+		if _, err := os.Stat(path.Join(follower.directory, follower.kept[0].Name)); !os.IsNotExist(err) {
+			break
+		}
+		pruned = append(pruned, follower.kept[0])
+		follower.kept = follower.kept[1:]
+	}
+	if len(pruned) > 0 {
+		follower.vod.Evict(pruned)
+		changed = true
+	}
+
+	if !changed {
+		return
+	}
+
+	for _, manifest := range []segment.Manifest{follower.vod, follower.tracker} {
+		if err := manifest.Update(follower.kept, ended); err != nil {
+			log.Debug().Err(err).Msg("Encountered an error updating session")
+			log.Fatal().Msg("Encountered an error updating session")
+		}
+	}
+}
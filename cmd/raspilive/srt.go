@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jaredpetersen/raspilive/internal/ffmpeg/srt"
+	"github.com/jaredpetersen/raspilive/internal/raspivid"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+// srtReconnectDelay is how long to wait before restarting the stream after the SRT connection is lost
+const srtReconnectDelay = 5 * time.Second
+
+// SrtCfg represents the SRT configuration options
+type SrtCfg struct {
+	Video      *VideoCfg
+	Mode       string // Whether to wait for a connection ("listener") or establish one ("caller")
+	Address    string // Host to listen on or connect to
+	Port       int    // Port to listen on or connect to
+	Passphrase string // Passphrase used to encrypt the stream
+	KeyLength  int    // Length of the encryption key in bytes
+	Latency    int    // Amount of time in milliseconds to buffer for retransmission of lost packets
+	StreamID   string // Identifies the stream to the other side of the connection
+}
+
+func newSrtCmd(video *VideoCfg) *cobra.Command {
+	cfg := SrtCfg{
+		Video: video,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "srt",
+		Short: "Stream video using SRT",
+		Long:  "Stream video using SRT",
+	}
+
+	cmd.Flags().StringVar(&cfg.Mode, "mode", "listener", "connection mode (valid [\"listener\", \"caller\"])")
+
+	cmd.Flags().StringVar(&cfg.Address, "address", "", "address to listen on or connect to (default all interfaces when listening)")
+
+	cmd.Flags().IntVar(&cfg.Port, "port", 0, "port to listen on or connect to")
+	cmd.MarkFlagRequired("port")
+
+	cmd.Flags().StringVar(&cfg.Passphrase, "passphrase", "", "passphrase for encrypting the stream, 10 to 79 characters")
+
+	cmd.Flags().IntVar(&cfg.KeyLength, "key-length", 0, "encryption key length in bytes (valid [16, 24, 32], default 16)")
+
+	cmd.Flags().IntVar(&cfg.Latency, "latency", 120, "milliseconds of video to buffer for retransmitting lost packets")
+
+	cmd.Flags().StringVar(&cfg.StreamID, "stream-id", "", "stream identifier sent to the other side of the connection")
+
+	cmd.Flags().SortFlags = false
+
+	cmd.Run = func(cmd *cobra.Command, args []string) {
+		streamSrt(cfg)
+	}
+
+	cmd.PreRun = func(cmd *cobra.Command, args []string) {
+		isValidCfg := isValidSrtCfg(cfg)
+		if !isValidCfg {
+			cmd.Usage()
+			os.Exit(1)
+		}
+	}
+
+	return cmd
+}
+
+func isValidSrtCfg(cfg SrtCfg) bool {
+	isValidCfg := true
+
+	mode := strings.ToLower(cfg.Mode)
+	if mode != "listener" && mode != "caller" {
+		fmt.Printf("Error: invalid value \"%s\" for flag \"mode\"\n", cfg.Mode)
+		isValidCfg = false
+	}
+
+	if mode == "caller" && cfg.Address == "" {
+		fmt.Println("Error: flag \"address\" is required in caller mode")
+		isValidCfg = false
+	}
+
+	if cfg.Passphrase != "" && (len(cfg.Passphrase) < 10 || len(cfg.Passphrase) > 79) {
+		fmt.Println("Error: invalid length for flag \"passphrase\"")
+		isValidCfg = false
+	}
+
+	if cfg.KeyLength != 0 && cfg.KeyLength != 16 && cfg.KeyLength != 24 && cfg.KeyLength != 32 {
+		fmt.Printf("Error: invalid value \"%d\" for flag \"key-length\"\n", cfg.KeyLength)
+		isValidCfg = false
+	}
+
+	if cfg.Latency < 0 {
+		fmt.Printf("Error: invalid value \"%d\" for flag \"latency\"\n", cfg.Latency)
+		isValidCfg = false
+	}
+
+	return isValidCfg
+}
+
+func streamSrt(cfg SrtCfg) {
+	// Set up raspivid stream options
+	// Inline headers allow the other side to pick up the video when they join
+	raspiOptions := raspivid.Options{
+		Width:          cfg.Video.Width,
+		Height:         cfg.Video.Height,
+		Fps:            cfg.Video.Fps,
+		HorizontalFlip: cfg.Video.HorizontalFlip,
+		VerticalFlip:   cfg.Video.VerticalFlip,
+		InlineHeaders:  true,
+	}
+
+	address := cfg.Address
+	if address == "" {
+		address = "0.0.0.0"
+	}
+
+	muxerOptions := srt.Options{
+		Fps:        cfg.Video.Fps,
+		Mode:       cfg.Mode,
+		Latency:    cfg.Latency,
+		Passphrase: cfg.Passphrase,
+		KeyLength:  cfg.KeyLength,
+		StreamID:   cfg.StreamID,
+	}
+
+	// Set up a channel for exiting
+	stop := make(chan struct{})
+	osStopper(stop)
+
+	// Keep hold of the camera's current stream so that it may be closed when shutting down
+	var mu sync.Mutex
+	var current *raspivid.Stream
+	stopping := false
+
+	// Stream video, starting over whenever the connection is lost
+	muxed := make(chan error, 1)
+	go func() {
+		for {
+			mu.Lock()
+			if stopping {
+				mu.Unlock()
+				muxed <- nil
+				return
+			}
+			raspiStream, err := raspivid.NewStream(raspiOptions)
+			if err != nil {
+				mu.Unlock()
+				log.Fatal().Msg("Encountered an error streaming video from the Raspberry Pi Camera Module")
+			}
+			verifyVideo(raspiStream, cfg.Video)
+			current = raspiStream
+			mu.Unlock()
+
+			muxer := srt.Muxer{
+				Address: net.JoinHostPort(address, strconv.Itoa(cfg.Port)),
+				Options: muxerOptions,
+			}
+
+			if err := startSrt(raspiStream, &muxer); err != nil {
+				muxed <- err
+				return
+			}
+
+			// Ffmpeg quits when the connection is lost
+			err = muxer.Wait()
+
+			// Raspivid carries on for as long as someone reads its video, which nobody does once Ffmpeg quits
+			raspiStream.Video.Close()
+
+			mu.Lock()
+			restart := err != nil && !stopping
+			mu.Unlock()
+
+			if restart {
+				log.Debug().Err(err).Msg("Encountered an error waiting for video mux")
+				log.Warn().Msg("Lost SRT connection, restarting stream")
+				raspiStream.Wait()
+				time.Sleep(srtReconnectDelay)
+				continue
+			}
+
+			if err := raspiStream.Wait(); err != nil {
+				log.Debug().Err(err).Msg("Encountered an error waiting for video stream")
+				muxed <- err
+				return
+			}
+
+			muxed <- nil
+			return
+		}
+	}()
+
+	// Wait for a stop signal, or for the video to come to an end
+	select {
+	case <-stop:
+		log.Info().Msg("Shutting down")
+
+		// Let the muxer finish off the video it has before exiting
+		mu.Lock()
+		stopping = true
+		if current != nil {
+			current.Video.Close()
+		}
+		mu.Unlock()
+		waitForMuxer(muxed)
+	case err := <-muxed:
+		if err != nil {
+			log.Fatal().Msg("Encountered an error streaming/muxing video")
+		}
+		log.Info().Msg("Shutting down")
+	}
+}
+
+func startSrt(raspiStream *raspivid.Stream, muxer *srt.Muxer) error {
+	if err := muxer.Mux(raspiStream.Video); err != nil {
+		log.Debug().Err(err).Msg("Encountered an error starting video mux")
+		return err
+	}
+	log.Debug().Str("cmd", muxer.String()).Msg("Started ffmpeg muxer")
+
+	if err := raspiStream.Start(); err != nil {
+		log.Debug().Err(err).Msg("Encountered an error starting video stream")
+		return err
+	}
+	log.Debug().Str("cmd", raspiStream.String()).Msg("Started raspivid")
+
+	return nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"github.com/jaredpetersen/raspilive/internal/raspivid"
+	"github.com/jaredpetersen/raspilive/internal/tcp"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+// TCPCfg represents the TCP configuration options
+type TCPCfg struct {
+	Video *VideoCfg
+	Port  int
+}
+
+func newTCPCmd(video *VideoCfg) *cobra.Command {
+	cfg := TCPCfg{
+		Video: video,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "tcp",
+		Short: "Stream raw H.264 video over TCP",
+		Long:  "Stream raw H.264 video over TCP",
+	}
+
+	cmd.Flags().IntVar(&cfg.Port, "port", 0, "TCP server port")
+	cmd.MarkFlagRequired("port")
+
+	cmd.Flags().SortFlags = false
+
+	cmd.Run = func(cmd *cobra.Command, args []string) {
+		streamTCP(cfg)
+	}
+
+	return cmd
+}
+
+func streamTCP(cfg TCPCfg) {
+	// Set up raspivid stream
+	// Inline headers keep the parameter sets up to date for clients that join mid-stream
+	raspiOptions := raspivid.Options{
+		Width:          cfg.Video.Width,
+		Height:         cfg.Video.Height,
+		Fps:            cfg.Video.Fps,
+		HorizontalFlip: cfg.Video.HorizontalFlip,
+		VerticalFlip:   cfg.Video.VerticalFlip,
+		InlineHeaders:  true,
+	}
+	raspiStream, err := raspivid.NewStream(raspiOptions)
+	if err != nil {
+		log.Fatal().Msg("Encountered an error streaming video from the Raspberry Pi Camera Module")
+	}
+	verifyVideo(raspiStream, cfg.Video)
+
+	// Set up TCP server
+	srv := tcp.Server{
+		Port: cfg.Port,
+	}
+
+	// Set up a channel for exiting
+	stop := make(chan struct{})
+	osStopper(stop)
+
+	// Serve the video stream
+	go func() {
+		if err := srv.ListenAndServe(); err != nil {
+			log.Debug().Err(err).Msg("Encountered an error serving video")
+			log.Fatal().Msg("Encountered an error serving video")
+		}
+		stop <- struct{}{}
+	}()
+
+	// Stream video
+	go func() {
+		if err := feedTCP(raspiStream, &srv); err != nil {
+			log.Fatal().Msg("Encountered an error streaming video")
+		}
+		stop <- struct{}{}
+	}()
+
+	// Wait for a stop signal
+	<-stop
+
+	log.Info().Msg("Shutting down")
+
+	raspiStream.Video.Close()
+	srv.Close()
+}
+
+func feedTCP(raspiStream *raspivid.Stream, srv *tcp.Server) error {
+	if err := raspiStream.Start(); err != nil {
+		log.Debug().Err(err).Msg("Encountered an error starting video stream")
+		return err
+	}
+	log.Debug().Str("cmd", raspiStream.String()).Msg("Started raspivid")
+
+	if err := srv.Feed(raspiStream.Video); err != nil {
+		log.Debug().Err(err).Msg("Encountered an error reading video stream")
+		return err
+	}
+
+	if err := raspiStream.Wait(); err != nil {
+		log.Debug().Err(err).Msg("Encountered an error waiting for video stream")
+		return err
+	}
+
+	return nil
+}
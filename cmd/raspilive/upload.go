@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+
+	"github.com/jaredpetersen/raspilive/internal/s3"
+	"github.com/jaredpetersen/raspilive/internal/upload"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+// UploadCfg represents the options for uploading to S3-compatible object storage
+type UploadCfg struct {
+	Endpoint  string // Base URL of the object storage service
+	Bucket    string // Bucket to upload to, disabled if not provided
+	Region    string // Region the bucket is in
+	Prefix    string // Prefix for the object keys
+	QueueSize int    // Maximum number of files waiting to be uploaded
+	Retries   int    // Number of times to try a failed upload again
+	PartSize  int    // Size in megabytes past which files are uploaded in parts
+	Delete    bool   // Remove segments and recordings once they are uploaded
+}
+
+// addUploadFlags adds the flags for uploading the video to object storage to the command.
+func addUploadFlags(cmd *cobra.Command, cfg *UploadCfg) {
+	cmd.Flags().StringVar(&cfg.Endpoint, "upload-endpoint", "https://s3.amazonaws.com", "base URL of the S3-compatible object storage to upload to, such as http://minio.local:9000")
+
+	cmd.Flags().StringVar(&cfg.Bucket, "upload-bucket", "", "bucket to upload the video to, with credentials from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+
+	cmd.Flags().StringVar(&cfg.Region, "upload-region", "us-east-1", "region the bucket is in")
+
+	cmd.Flags().StringVar(&cfg.Prefix, "upload-prefix", "", "prefix for the object keys, such as a directory in the bucket")
+
+	cmd.Flags().IntVar(&cfg.QueueSize, "upload-queue", 100, "maximum number of files waiting to be uploaded, skipping the oldest past it")
+
+	cmd.Flags().IntVar(&cfg.Retries, "upload-retries", 5, "number of times to try a failed upload again")
+
+	cmd.Flags().IntVar(&cfg.PartSize, "upload-part-size", 16, "size in megabytes past which files are uploaded in parts (minimum 5)")
+
+	cmd.Flags().BoolVar(&cfg.Delete, "upload-delete", false, "remove segments and recordings from the device once they are uploaded")
+}
+
+// isValidUploadCfg checks the upload options.
+func isValidUploadCfg(cfg UploadCfg) bool {
+	if cfg.Bucket == "" {
+		return true
+	}
+
+	isValidCfg := true
+
+	if endpoint, err := url.Parse(cfg.Endpoint); err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		fmt.Printf("Error: invalid value \"%s\" for flag \"upload-endpoint\"\n", cfg.Endpoint)
+		isValidCfg = false
+	}
+
+	if cfg.QueueSize < 1 {
+		fmt.Printf("Error: invalid value \"%d\" for flag \"upload-queue\"\n", cfg.QueueSize)
+		isValidCfg = false
+	}
+
+	if cfg.Retries < 0 {
+		fmt.Printf("Error: invalid value \"%d\" for flag \"upload-retries\"\n", cfg.Retries)
+		isValidCfg = false
+	}
+
+	if cfg.PartSize < 5 {
+		fmt.Printf("Error: invalid value \"%d\" for flag \"upload-part-size\"\n", cfg.PartSize)
+		isValidCfg = false
+	}
+
+	if os.Getenv("AWS_ACCESS_KEY_ID") == "" || os.Getenv("AWS_SECRET_ACCESS_KEY") == "" {
+		fmt.Printf("Error: flag \"upload-bucket\" requires the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables\n")
+		isValidCfg = false
+	}
+
+	return isValidCfg
+}
+
+// startUploading ships the files in the directory to object storage as they are finished, filed under the given
+// prefix within the configured one.
+func startUploading(cfg UploadCfg, directory string, prefix string) *upload.Uploader {
+	uploader := &upload.Uploader{
+		Directory: directory,
+		Client: &s3.Client{
+			Endpoint:  cfg.Endpoint,
+			Region:    cfg.Region,
+			Bucket:    cfg.Bucket,
+			AccessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		},
+		Options: upload.Options{
+			Prefix:    path.Join(cfg.Prefix, prefix),
+			QueueSize: cfg.QueueSize,
+			Retries:   cfg.Retries,
+			PartSize:  int64(cfg.PartSize) * 1024 * 1024,
+			Delete:    cfg.Delete,
+		},
+	}
+
+	if err := uploader.Start(); err != nil {
+		log.Debug().Err(err).Msg("Encountered an error starting uploader")
+		log.Fatal().Msg("Encountered an error uploading video")
+	}
+	log.Debug().Str("cmd", uploader.String()).Msg("Started uploader")
+
+	return uploader
+}
+
+// startStreamUploads uploads the stream's directory to object storage, along with the recordings and clips saved
+// alongside it in their own prefixes.
+func startStreamUploads(cfg UploadCfg, directory string, recordCfg RecordCfg, clipCfg ClipCfg) []*upload.Uploader {
+	if cfg.Bucket == "" {
+		return nil
+	}
+
+	uploaders := []*upload.Uploader{startUploading(cfg, directory, "")}
+	if recordCfg.Directory != "" {
+		uploaders = append(uploaders, startUploading(cfg, recordCfg.Directory, "recordings"))
+	}
+	if clipCfg.Directory != "" {
+		uploaders = append(uploaders, startUploading(cfg, clipCfg.Directory, "clips"))
+	}
+
+	return uploaders
+}
+
+// stopUploads uploads whatever is left once the video has stopped, which must be after the muxers, recorders and
+// clippers have finished so that their last files are uploaded whole.
+func stopUploads(uploaders []*upload.Uploader) {
+	for _, uploader := range uploaders {
+		uploader.Stop()
+	}
+}
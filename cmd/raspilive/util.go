@@ -1,10 +1,49 @@
 package main
 
 import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
 	"os"
 	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/jaredpetersen/raspilive/internal/export"
+	"github.com/jaredpetersen/raspilive/internal/ffmpeg/abr"
+	"github.com/jaredpetersen/raspilive/internal/ffmpeg/audio"
+	ffmpegthumbnail "github.com/jaredpetersen/raspilive/internal/ffmpeg/thumbnail"
+	"github.com/jaredpetersen/raspilive/internal/h264"
+	"github.com/jaredpetersen/raspilive/internal/memfs"
+	"github.com/jaredpetersen/raspilive/internal/raspivid"
+	"github.com/jaredpetersen/raspilive/internal/segment"
+	"github.com/jaredpetersen/raspilive/internal/server"
+	"github.com/jaredpetersen/raspilive/internal/thumbnail"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+// Layout of the thumbnail sprite sheets for scrubbing previews.
+const (
+	thumbnailWidth   = 160
+	thumbnailColumns = 5
+	thumbnailRows    = 5
 )
 
+// Sizing of the store that keeps the video in memory.
+const (
+	memoryPlaylistSize = 5       // Segments covered by playlists that would otherwise keep every segment
+	memoryPinnedSize   = 4 << 20 // Bytes set aside for the playlists, initialization segments and thumbnails
+)
+
+// playlistPollInterval is how often playlists written by Ffmpeg are read back to keep up with the segments.
+const playlistPollInterval = 500 * time.Millisecond
+
+// maxBitrate is the highest bitrate in bits per second that the camera encodes video at.
+const maxBitrate = 25000000
+
 func osStopper(stop chan struct{}) {
 	// Set up a channel for OS signals so that we can quit gracefully if the user terminates the program
 	// Once we get this signal, sent a message to the stop channel
@@ -16,3 +55,399 @@ func osStopper(stop chan struct{}) {
 		stop <- struct{}{}
 	}()
 }
+
+// waitForMuxer waits for the muxer to finish off the video once the camera's video is closed, giving up on it after
+// the deadline. Errors are expected as the muxer is cut off, and are left to the debug logs.
+func waitForMuxer(muxed <-chan error) {
+	select {
+	case <-muxed:
+	case <-time.After(muxerShutdownDeadline):
+		log.Warn().Msg("Timed out waiting for the muxer to finish")
+	}
+}
+
+// videoMuxer muxes the video stream into a streaming format, either through Ffmpeg or natively.
+type videoMuxer interface {
+	Mux(video io.ReadCloser) error
+	Wait() error
+	String() string
+}
+
+// verifyVideo checks the video that the camera delivers against what was requested of it, warning about any differences
+// once the video starts.
+func verifyVideo(raspiStream *raspivid.Stream, video *VideoCfg) {
+	raspiStream.Video = h264.Watch(raspiStream.Video, func(sps h264.SPS) {
+		log.Debug().
+			Str("codec", sps.Codec()).
+			Int("width", sps.Width).
+			Int("height", sps.Height).
+			Float64("fps", sps.Fps()).
+			Msg("Received video")
+
+		if sps.Width != video.Width || sps.Height != video.Height {
+			log.Warn().
+				Int("width", sps.Width).
+				Int("height", sps.Height).
+				Msg("Camera is not delivering the requested resolution")
+		}
+
+		// Not every encoder includes timing information
+		if fps := sps.Fps(); fps != 0 && math.Round(fps) != float64(video.Fps) {
+			log.Warn().
+				Float64("fps", fps).
+				Msg("Camera is not delivering the requested framerate")
+		}
+	})
+}
+
+// newMemoryStore creates a store that keeps the video in memory, big enough to hold every segment that may be
+// referenced at once along with the ones being written, including those of any renditions, plus the playlists,
+// initialization segments and thumbnails that are never evicted.
+func newMemoryStore(segmentTime int, playlistSize int, storageSize int, renditions []abr.Rendition) *memfs.Store {
+	if segmentTime <= 0 {
+		segmentTime = 2
+	}
+
+	// Playlists that keep every segment are bounded by memory instead, dropping the oldest segments first
+	if playlistSize <= 0 {
+		playlistSize = memoryPlaylistSize
+	}
+
+	bitrate := int64(maxBitrate)
+	for _, rendition := range renditions {
+		bitrate += int64(rendition.Peak())
+	}
+
+	segments := playlistSize + storageSize + 2
+
+	return &memfs.Store{Capacity: int64(segments*segmentTime)*bitrate/8 + memoryPinnedSize}
+}
+
+// readFile reads the whole file from the file system.
+func readFile(files http.FileSystem, name string) ([]byte, error) {
+	f, err := files.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ioutil.ReadAll(f)
+}
+
+// serveUploads accepts the video that Ffmpeg uploads into the store, returning the URL to upload it to.
+func serveUploads(store *memfs.Store) (*memfs.UploadServer, string) {
+	uploads := &memfs.UploadServer{Store: store}
+
+	url, err := uploads.Listen()
+	if err != nil {
+		log.Debug().Err(err).Msg("Encountered an error accepting video uploads")
+		log.Fatal().Msg("Encountered an error accepting video uploads")
+	}
+
+	go func() {
+		if err := uploads.Serve(); err != nil {
+			log.Debug().Err(err).Msg("Encountered an error accepting video uploads")
+			log.Fatal().Msg("Encountered an error accepting video uploads")
+		}
+	}()
+
+	return uploads, url
+}
+
+// exportHandler is the handler that serves downloads of the retained video from `/camera/export`, reading the segments
+// back out of wherever the muxer keeps them.
+func exportHandler(catalog *export.Catalog, directory string, store *memfs.Store, auth string) *server.Export {
+	handler := &server.Export{Catalog: catalog, FileSystem: http.Dir(directory), Auth: auth}
+	if store != nil {
+		handler.FileSystem = store
+	}
+
+	return handler
+}
+
+// catalogSegments takes note of the segments listed in the playlist or manifest that Ffmpeg writes as they come along,
+// so that footage may be exported from them as it is from the segments of the native muxers.
+func catalogSegments(catalog *export.Catalog, files http.FileSystem, name string, list func([]byte) ([]segment.Segment, string)) {
+	go func() {
+		for range time.Tick(playlistPollInterval) {
+			// Ffmpeg has yet to write the playlist if it can't be read
+			data, err := readFile(files, name)
+			if err != nil {
+				continue
+			}
+
+			segments, init := list(data)
+			if init != "" {
+				catalog.SetInit(init)
+			}
+
+			// Footage is only exported by the wall clock time that it was captured
+			var captured []segment.Segment
+			for _, seg := range segments {
+				if !seg.Time.IsZero() && seg.Duration > 0 {
+					captured = append(captured, seg)
+				}
+			}
+			catalog.Update(captured, false)
+		}
+	}()
+}
+
+// AudioCfg represents the options for capturing audio alongside the video
+type AudioCfg struct {
+	Device   string // Device to capture audio from, disabled if not provided
+	Format   string // Ffmpeg input format of the device
+	Codec    string // Codec to encode the audio with
+	Rate     int    // Sample rate in hertz
+	Channels int    // Number of channels
+	Bitrate  int    // Target bitrate in kilobits per second
+}
+
+// addAudioFlags adds the flags for capturing audio alongside the video to the command.
+func addAudioFlags(cmd *cobra.Command, cfg *AudioCfg) {
+	cmd.Flags().StringVar(&cfg.Device, "audio-device", "", "ALSA device to capture audio from, such as plughw:1,0 (ffmpeg muxer only)")
+
+	cmd.Flags().StringVar(&cfg.Format, "audio-format", audio.FormatALSA, "ffmpeg input format of the audio device, such as lavfi to test with a generated tone")
+
+	cmd.Flags().StringVar(&cfg.Codec, "audio-codec", audio.CodecAAC, "codec to encode the audio with (valid [\"aac\", \"opus\"])")
+
+	cmd.Flags().IntVar(&cfg.Rate, "audio-rate", 48000, "audio sample rate in hertz")
+
+	cmd.Flags().IntVar(&cfg.Channels, "audio-channels", 1, "number of audio channels")
+
+	cmd.Flags().IntVar(&cfg.Bitrate, "audio-bitrate", 64, "audio bitrate in kilobits per second")
+}
+
+// isValidAudioCfg checks the audio options, which are only supported by the ffmpeg muxer.
+func isValidAudioCfg(cfg AudioCfg, muxer string) bool {
+	isValidCfg := true
+
+	codec := strings.ToLower(cfg.Codec)
+	if codec != audio.CodecAAC && codec != audio.CodecOpus {
+		fmt.Printf("Error: invalid value \"%s\" for flag \"audio-codec\"\n", cfg.Codec)
+		isValidCfg = false
+	}
+
+	if cfg.Rate < 1 {
+		fmt.Printf("Error: invalid value \"%d\" for flag \"audio-rate\"\n", cfg.Rate)
+		isValidCfg = false
+	}
+
+	if cfg.Channels < 1 {
+		fmt.Printf("Error: invalid value \"%d\" for flag \"audio-channels\"\n", cfg.Channels)
+		isValidCfg = false
+	}
+
+	if cfg.Bitrate < 1 {
+		fmt.Printf("Error: invalid value \"%d\" for flag \"audio-bitrate\"\n", cfg.Bitrate)
+		isValidCfg = false
+	}
+
+	if cfg.Device != "" && strings.ToLower(muxer) == "native" {
+		fmt.Printf("Error: flag \"audio-device\" requires the ffmpeg muxer\n")
+		isValidCfg = false
+	}
+
+	return isValidCfg
+}
+
+// audioInput converts the audio options for Ffmpeg.
+func audioInput(cfg AudioCfg) audio.Input {
+	return audio.Input{
+		Device:     cfg.Device,
+		Format:     cfg.Format,
+		Codec:      strings.ToLower(cfg.Codec),
+		SampleRate: cfg.Rate,
+		Channels:   cfg.Channels,
+		Bitrate:    cfg.Bitrate * 1000,
+	}
+}
+
+// parseRenditions parses the renditions for adaptive bitrate streaming, returning the first value that is invalid if
+// there is one.
+func parseRenditions(values []string) ([]abr.Rendition, string) {
+	renditions := []abr.Rendition{}
+	for _, value := range values {
+		rendition, err := abr.ParseRendition(value)
+		if err != nil {
+			return nil, value
+		}
+		renditions = append(renditions, rendition)
+	}
+
+	return renditions, ""
+}
+
+// renditionIntraPeriod is the number of frames between the camera's keyframes that lines them up with those forced into
+// the renditions at the start of every segment, so that players may switch between them. The camera is left to its own
+// default without renditions.
+func renditionIntraPeriod(fps int, segmentTime int, renditions []abr.Rendition) int {
+	if len(renditions) == 0 || fps <= 0 || segmentTime <= 0 {
+		return 0
+	}
+
+	return fps * segmentTime
+}
+
+// dvrPlaylistSize works out how many segments cover the DVR window, cutting back to what fits in the budget if the
+// camera were to run at its highest bitrate.
+func dvrPlaylistSize(window time.Duration, budget int, segmentTime int, storageSize int) int {
+	if segmentTime <= 0 {
+		segmentTime = 2
+	}
+
+	size := int(math.Ceil(window.Seconds() / float64(segmentTime)))
+
+	if budget > 0 {
+		segmentBytes := int64(segmentTime) * maxBitrate / 8
+		fits := int(int64(budget)*1024*1024/segmentBytes) - storageSize
+		if fits < 1 {
+			fits = 1
+		}
+		if size > fits {
+			size = fits
+		}
+	}
+
+	return size
+}
+
+// dvrBudget converts the DVR budget to bytes, which only applies when there is a DVR window.
+func dvrBudget(window time.Duration, budget int) int64 {
+	if window <= 0 {
+		return 0
+	}
+
+	return int64(budget) * 1024 * 1024
+}
+
+// thumbnailHeight scales the height of the thumbnails to the video, keeping it even as encoders prefer.
+func thumbnailHeight(video *VideoCfg) int {
+	return int(math.Round(float64(thumbnailWidth*video.Height)/float64(video.Width)/2)) * 2
+}
+
+// thumbnailRetention works out how far back thumbnails should be kept to cover the segments that are kept, keeping
+// them all if the segments are. Segments kept in memory are bounded by the store instead, which thumbnails are never
+// evicted from.
+func thumbnailRetention(segmentTime int, playlistSize int, storageSize int, memory bool) time.Duration {
+	if playlistSize <= 0 && memory {
+		playlistSize = memoryPlaylistSize
+	}
+	if playlistSize <= 0 {
+		return 0
+	}
+	if segmentTime <= 0 {
+		segmentTime = 2
+	}
+
+	return time.Duration((playlistSize+storageSize)*segmentTime) * time.Second
+}
+
+// sampledKeyframe is a keyframe picked out of the video to be decoded into a thumbnail.
+type sampledKeyframe struct {
+	at   time.Duration
+	data []byte
+}
+
+// generateThumbnails taps the keyframes of the camera's video for a track of thumbnail sprite sheets in the storage,
+// keeping the thumbnails taken within the retention period.
+//
+// Only the sampled keyframes are decoded, which is light enough to leave the video undisturbed. Keyframes are dropped
+// rather than holding up the video if the thumbnails fall behind.
+func generateThumbnails(raspiStream *raspivid.Stream, storage segment.Storage, video *VideoCfg, interval int, retention time.Duration) {
+	track := &thumbnail.Track{
+		Storage: storage,
+		Name:    "thumbnails.vtt",
+		Pattern: "thumbnails-%d.jpg",
+		Options: thumbnail.Options{
+			Interval:  interval,
+			Columns:   thumbnailColumns,
+			Rows:      thumbnailRows,
+			Retention: retention,
+		},
+	}
+
+	keyframes, keyframesInput := io.Pipe()
+	muxer := &ffmpegthumbnail.Muxer{
+		Options: ffmpegthumbnail.Options{Width: thumbnailWidth, Height: thumbnailHeight(video)},
+	}
+	if err := muxer.Mux(keyframes); err != nil {
+		log.Debug().Err(err).Msg("Encountered an error starting thumbnail mux")
+		log.Fatal().Msg("Encountered an error generating thumbnails")
+	}
+	log.Debug().Str("cmd", muxer.String()).Msg("Started thumbnail muxer")
+
+	tapped, tap := io.Pipe()
+	raspiStream.Video = &teeReadCloser{ReadCloser: raspiStream.Video, w: tap}
+
+	sampled := make(chan sampledKeyframe, 1)
+	pending := make(chan time.Duration, 8) // Times of the keyframes handed over to be decoded, oldest first
+
+	go func() {
+		err := thumbnail.Sample(tapped, video.Fps, time.Duration(interval)*time.Second, func(at time.Duration, keyframe []byte) {
+			select {
+			case sampled <- sampledKeyframe{at: at, data: keyframe}:
+			default:
+			}
+		})
+		if err != nil {
+			log.Debug().Err(err).Msg("Encountered an error sampling keyframes for thumbnails")
+		}
+
+		// Stop taking in video so that the tap never holds it up
+		tapped.Close()
+		close(sampled)
+	}()
+
+	go func() {
+		for keyframe := range sampled {
+			pending <- keyframe.at
+			if _, err := keyframesInput.Write(keyframe.data); err != nil {
+				break
+			}
+		}
+		keyframesInput.Close()
+	}()
+
+	go func() {
+		for {
+			data, err := muxer.Next()
+			if err != nil {
+				break
+			}
+
+			if err := track.Add(<-pending, data); err != nil {
+				log.Debug().Err(err).Msg("Encountered an error writing thumbnails")
+			}
+		}
+
+		if err := muxer.Wait(); err != nil {
+			log.Debug().Err(err).Msg("Encountered an error waiting for thumbnail mux")
+		}
+	}()
+}
+
+// teeReadCloser copies everything read from the stream to the writer, closing the writer once the stream ends.
+//
+// Failed writes are ignored so that the stream carries on even if nobody is listening anymore.
+type teeReadCloser struct {
+	io.ReadCloser
+	w *io.PipeWriter
+}
+
+func (tee *teeReadCloser) Read(p []byte) (int, error) {
+	n, err := tee.ReadCloser.Read(p)
+	if n > 0 {
+		tee.w.Write(p[:n])
+	}
+	if err != nil {
+		tee.w.CloseWithError(err)
+	}
+
+	return n, err
+}
+
+func (tee *teeReadCloser) Close() error {
+	tee.w.Close()
+	return tee.ReadCloser.Close()
+}
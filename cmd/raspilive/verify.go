@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/jaredpetersen/raspilive/internal/chain"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+// VerifyCfg represents the footage verification configuration options
+type VerifyCfg struct {
+	Directory string // Directory of footage to verify
+	Key       string // Public key that the hash chain manifest was signed with
+}
+
+func newVerifyCmd() *cobra.Command {
+	cfg := VerifyCfg{}
+
+	cmd := &cobra.Command{
+		Use:   "verify",
+		Short: "Verify footage against its hash chain manifest",
+		Long:  "Verify footage against its hash chain manifest, checking for missing, altered or reordered files",
+	}
+
+	cmd.Flags().StringVar(&cfg.Directory, "directory", "", "directory of footage to verify")
+	cmd.MarkFlagRequired("directory")
+
+	cmd.Flags().StringVar(&cfg.Key, "key", "", "Ed25519 public key that the manifest was signed with")
+	cmd.MarkFlagRequired("key")
+
+	cmd.Flags().SortFlags = false
+
+	cmd.Run = func(cmd *cobra.Command, args []string) {
+		verifyFootage(cfg)
+	}
+
+	return cmd
+}
+
+func verifyFootage(cfg VerifyCfg) {
+	publicKey, err := chain.LoadPublicKey(cfg.Key)
+	if err != nil {
+		log.Debug().Err(err).Msg("Encountered an error loading public key")
+		log.Fatal().Str("key", cfg.Key).Msg("Encountered an error loading public key")
+	}
+
+	report, err := chain.Verify(cfg.Directory, publicKey)
+	if os.IsNotExist(err) {
+		log.Fatal().Str("directory", cfg.Directory).Msg("Hash chain manifest does not exist")
+	}
+	if err != nil {
+		log.Debug().Err(err).Msg("Encountered an error verifying footage")
+		log.Fatal().Msg("Encountered an error verifying footage")
+	}
+
+	for _, problem := range report.Broken {
+		fmt.Println("BROKEN   " + problem)
+	}
+	for _, name := range report.Altered {
+		fmt.Println("ALTERED  " + name)
+	}
+	for _, name := range report.Missing {
+		fmt.Println("MISSING  " + name)
+	}
+	for _, name := range report.Unlisted {
+		fmt.Println("UNLISTED " + name)
+	}
+	for _, warning := range report.Warnings {
+		fmt.Println("WARNING  " + warning)
+	}
+
+	fmt.Printf("%d entries: %d verified, %d expired, %d superseded, %d missing, %d altered, %d unlisted\n",
+		report.Entries, len(report.Verified), len(report.Expired), report.Superseded, len(report.Missing),
+		len(report.Altered), len(report.Unlisted))
+
+	if !report.OK() {
+		fmt.Println("Verification failed")
+		os.Exit(1)
+	}
+
+	fmt.Println("Verification passed")
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jaredpetersen/raspilive/internal/ffmpeg/mse"
+	"github.com/jaredpetersen/raspilive/internal/raspivid"
+	"github.com/jaredpetersen/raspilive/internal/server"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+// WebSocketCfg represents the WebSocket configuration options
+type WebSocketCfg struct {
+	Video            *VideoCfg
+	Port             int
+	Directory        string
+	TLSCert          string
+	TLSKey           string
+	FragmentType     string // Whether to cut a fragment every group of pictures or every frame
+	KeyframeInterval int    // Number of frames between keyframes
+}
+
+func newWebSocketCmd(video *VideoCfg) *cobra.Command {
+	cfg := WebSocketCfg{
+		Video: video,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "websocket",
+		Short: "Stream video using fragmented MP4 over WebSocket",
+		Long:  "Stream video using fragmented MP4 over WebSocket",
+	}
+
+	cmd.Flags().IntVar(&cfg.Port, "port", 0, "static file server port")
+
+	cmd.Flags().StringVar(&cfg.Directory, "directory", "", "static file server directory")
+
+	cmd.Flags().StringVar(&cfg.TLSCert, "tls-cert", "", "static file server TLS certificate")
+
+	cmd.Flags().StringVar(&cfg.TLSKey, "tls-key", "", "static file server TLS key")
+
+	cmd.Flags().StringVar(&cfg.FragmentType, "fragment-type", "", "how often to send video fragments (valid [\"gop\", \"frame\"], default \"gop\")")
+
+	cmd.Flags().IntVar(&cfg.KeyframeInterval, "keyframe-interval", 30, "number of frames between keyframes")
+
+	cmd.Flags().SortFlags = false
+
+	cmd.Run = func(cmd *cobra.Command, args []string) {
+		streamWebSocket(cfg)
+	}
+
+	cmd.PreRun = func(cmd *cobra.Command, args []string) {
+		isValidCfg := isValidWebSocketCfg(cfg)
+		if !isValidCfg {
+			cmd.Usage()
+			os.Exit(1)
+		}
+	}
+
+	return cmd
+}
+
+func isValidWebSocketCfg(cfg WebSocketCfg) bool {
+	isValidCfg := true
+
+	fragmentType := strings.ToLower(cfg.FragmentType)
+	validFragmentType := fragmentType == "" || fragmentType == "gop" || fragmentType == "frame"
+
+	if !validFragmentType {
+		fmt.Printf("Error: invalid value \"%s\" for flag \"fragment-type\"\n", cfg.FragmentType)
+		isValidCfg = false
+	}
+
+	return isValidCfg
+}
+
+func streamWebSocket(cfg WebSocketCfg) {
+	// Set up raspivid stream
+	raspiOptions := raspivid.Options{
+		Width:          cfg.Video.Width,
+		Height:         cfg.Video.Height,
+		Fps:            cfg.Video.Fps,
+		HorizontalFlip: cfg.Video.HorizontalFlip,
+		VerticalFlip:   cfg.Video.VerticalFlip,
+		IntraPeriod:    cfg.KeyframeInterval,
+	}
+	raspiStream, err := raspivid.NewStream(raspiOptions)
+	if err != nil {
+		log.Fatal().Msg("Encountered an error streaming video from the Raspberry Pi Camera Module")
+	}
+	verifyVideo(raspiStream, cfg.Video)
+
+	// Set up WebSocket handler
+	handler := mse.Handler{
+		Options: mse.Options{
+			Fps:          cfg.Video.Fps,
+			FragmentType: cfg.FragmentType,
+		},
+	}
+
+	// Set up static file server
+	srv := server.Static{
+		Port:      cfg.Port,
+		Directory: cfg.Directory,
+		Cert:      cfg.TLSCert,
+		Key:       cfg.TLSKey,
+	}
+	srv.Handle("/stream.ws", &handler)
+
+	// Set up a channel for exiting
+	stop := make(chan struct{})
+	osStopper(stop)
+
+	// Serve the video stream
+	go func() {
+		err := srv.ListenAndServe()
+		if errors.Is(err, server.ErrInvalidDirectory) {
+			log.Fatal().Msg("Directory does not exist")
+		}
+		if err != nil {
+			log.Debug().Err(err).Msg("Encountered an error serving video")
+			log.Fatal().Msg("Encountered an error serving video")
+		}
+		stop <- struct{}{}
+	}()
+
+	// Stream video
+	go func() {
+		if err := feedWebSocket(raspiStream, &handler); err != nil {
+			log.Fatal().Msg("Encountered an error streaming/muxing video")
+		}
+		stop <- struct{}{}
+	}()
+
+	// Wait for a stop signal
+	<-stop
+
+	log.Info().Msg("Shutting down")
+
+	raspiStream.Video.Close()
+	handler.Close()
+	srv.Shutdown(serverShutdownDeadline)
+}
+
+func feedWebSocket(raspiStream *raspivid.Stream, handler *mse.Handler) error {
+	if err := raspiStream.Start(); err != nil {
+		log.Debug().Err(err).Msg("Encountered an error starting video stream")
+		return err
+	}
+	log.Debug().Str("cmd", raspiStream.String()).Msg("Started raspivid")
+
+	if err := handler.Feed(raspiStream.Video); err != nil {
+		return err
+	}
+
+	if err := raspiStream.Wait(); err != nil {
+		log.Debug().Err(err).Msg("Encountered an error waiting for video stream")
+		return err
+	}
+
+	return nil
+}
@@ -3,7 +3,11 @@ module github.com/jaredpetersen/raspilive
 go 1.16
 
 require (
+	filippo.io/age v1.0.0
+	github.com/gorilla/websocket v1.4.2
 	github.com/justinas/alice v1.2.0
 	github.com/rs/zerolog v1.20.0
 	github.com/spf13/cobra v1.1.3
+	golang.org/x/crypto v0.0.0-20211117183948-ae814b36b871 // indirect
+	golang.org/x/sys v0.10.0 // indirect
 )
@@ -0,0 +1,261 @@
+package chain
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jaredpetersen/raspilive/internal/segment"
+)
+
+// Name is the name of the hash chain manifest kept in the directory.
+const Name = "chain.jsonl"
+
+// genesis is the previous hash of the first entry in a chain.
+var genesis = strings.Repeat("0", 64)
+
+var now = time.Now
+
+// Entry is a single file recorded in the hash chain manifest.
+type Entry struct {
+	Sequence int       `json:"seq"`    // Position in the chain, counting from zero
+	Name     string    `json:"name"`   // Path of the file relative to the directory
+	Size     int64     `json:"size"`   // Size of the file in bytes
+	SHA256   string    `json:"sha256"` // Hex encoded SHA-256 of the file
+	Time     time.Time `json:"time"`   // Wall clock time that the file was added to the chain
+	Previous string    `json:"prev"`   // Hash of the previous entry, or zeros for the first
+	Hash     string    `json:"hash"`   // Hex encoded SHA-256 of the entry, covering everything above
+	Sig      string    `json:"sig"`    // Base64 encoded Ed25519 signature of the hash
+}
+
+// digest calculates the hash of the entry from its fields, in a form that is simple to reproduce with other tools.
+func (entry Entry) digest() string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%d\n%s\n%d\n%s\n%s\n%s",
+		entry.Sequence, entry.Name, entry.Size, entry.SHA256, entry.Time.UTC().Format(time.RFC3339Nano), entry.Previous)))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// Chain keeps a tamper-evident manifest of the files in a directory, for footage that may be used as evidence.
+//
+// Each file is recorded with its SHA-256 as an entry in the manifest, which is chained to the entry before it by hash
+// and signed with an Ed25519 key. Any file that is altered, or any entry that is altered, removed or reordered, no
+// longer matches, which Verify reports on. The manifest is JSON Lines so that it may be appended to as the video goes on
+// and read by other tools.
+//
+// Chains are segment manifests, taking in each segment as it is finished. The segments must be written to the
+// directory, along with the initialization segment if there is one, which is added again ahead of the next segment
+// whenever it is rewritten. Chains are safe for concurrent use.
+type Chain struct {
+	Directory string
+	Key       ed25519.PrivateKey
+	mu        sync.Mutex
+	last      *Entry
+	init      string // File name of the initialization segment, empty if there is none
+	initSum   string // SHA-256 of the initialization segment when it was last added
+}
+
+// Load reads the end of the manifest in the directory, if there is one, so that the chain carries on from it. An entry
+// cut short by a crash is dropped.
+func (chain *Chain) Load() error {
+	chain.mu.Lock()
+	defer chain.mu.Unlock()
+
+	chain.last = nil
+
+	file, err := os.Open(filepath.Join(chain.Directory, Name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var complete int64
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		var entry Entry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return fmt.Errorf("chain: invalid entry: %w", err)
+		}
+		chain.last = &entry
+		complete += int64(len(line))
+	}
+
+	return os.Truncate(filepath.Join(chain.Directory, Name), complete)
+}
+
+// SetInit sets the file name of the initialization segment that goes with fragmented MP4 segments.
+func (chain *Chain) SetInit(name string) {
+	chain.mu.Lock()
+	defer chain.mu.Unlock()
+
+	chain.init = name
+	chain.initSum = ""
+}
+
+// Update adds the newest segment to the chain, preceded by the initialization segment if it was written since it was
+// last added.
+func (chain *Chain) Update(segments []segment.Segment, ended bool) error {
+	if len(segments) == 0 {
+		return nil
+	}
+
+	chain.mu.Lock()
+	defer chain.mu.Unlock()
+
+	if chain.init != "" {
+		size, sum, err := hashFile(filepath.Join(chain.Directory, filepath.FromSlash(chain.init)))
+		if err != nil {
+			return err
+		}
+		if sum != chain.initSum {
+			if err := chain.add(chain.init, size, sum); err != nil {
+				return err
+			}
+			chain.initSum = sum
+		}
+	}
+
+	return chain.addFile(segments[len(segments)-1].Name)
+}
+
+// Add adds the file in the directory to the chain, unless it is the last one that was added.
+func (chain *Chain) Add(name string) error {
+	chain.mu.Lock()
+	defer chain.mu.Unlock()
+
+	return chain.addFile(name)
+}
+
+// addFile adds the file in the directory to the chain, unless it is the last one that was added.
+func (chain *Chain) addFile(name string) error {
+	if chain.last != nil && chain.last.Name == name {
+		return nil
+	}
+
+	size, sum, err := hashFile(filepath.Join(chain.Directory, filepath.FromSlash(name)))
+	if err != nil {
+		return err
+	}
+
+	return chain.add(name, size, sum)
+}
+
+// add appends an entry for the file with the given size and SHA-256 to the manifest.
+func (chain *Chain) add(name string, size int64, sum string) error {
+
+	entry := Entry{
+		Name:     name,
+		Size:     size,
+		SHA256:   sum,
+		Time:     now().UTC(),
+		Previous: genesis,
+	}
+	if chain.last != nil {
+		entry.Sequence = chain.last.Sequence + 1
+		entry.Previous = chain.last.Hash
+	}
+	entry.Hash = entry.digest()
+
+	digest, _ := hex.DecodeString(entry.Hash)
+	entry.Sig = base64.StdEncoding.EncodeToString(ed25519.Sign(chain.Key, digest))
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	manifest, err := os.OpenFile(filepath.Join(chain.Directory, Name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := manifest.Write(append(line, '\n')); err != nil {
+		manifest.Close()
+		return err
+	}
+
+	// Entries are only as good as their chance of surviving a power cut
+	if err := manifest.Sync(); err != nil {
+		manifest.Close()
+		return err
+	}
+	if err := manifest.Close(); err != nil {
+		return err
+	}
+
+	chain.last = &entry
+
+	return nil
+}
+
+// hashFile returns the size of the file and its hex encoded SHA-256.
+func hashFile(name string) (int64, string, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return 0, "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return 0, "", err
+	}
+
+	return size, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// readEntries reads every entry in the manifest.
+func readEntries(directory string) ([]Entry, error) {
+	data, err := os.ReadFile(filepath.Join(directory, Name))
+	if err != nil {
+		return nil, err
+	}
+
+	lines := bytes.Split(data, []byte("\n"))
+
+	var entries []Entry
+	for i, line := range lines {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		var entry Entry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			if i == len(lines)-1 {
+				// Cut short by a crash before it could be finished, so it never vouched for anything
+				break
+			}
+			return nil, fmt.Errorf("chain: invalid entry on line %d: %w", i+1, err)
+		}
+		entries = append(entries, entry)
+	}
+
+	if len(entries) == 0 {
+		return nil, errors.New("chain: empty manifest")
+	}
+
+	return entries, nil
+}
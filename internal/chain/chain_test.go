@@ -0,0 +1,307 @@
+package chain
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jaredpetersen/raspilive/internal/segment"
+)
+
+func stubNow(t *testing.T, stub time.Time) {
+	now = func() time.Time { return stub }
+	t.Cleanup(func() { now = time.Now })
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "raspilive-chain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	return dir
+}
+
+func writeFile(t *testing.T, name string, data string) {
+	if err := os.MkdirAll(path.Dir(name), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(name, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newKey(t *testing.T) (ed25519.PublicKey, ed25519.PrivateKey) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return publicKey, privateKey
+}
+
+// newChain writes the files and adds them to a new chain in order.
+func newChain(t *testing.T, dir string, key ed25519.PrivateKey, names ...string) *Chain {
+	stubNow(t, time.Date(2021, 3, 17, 12, 0, 0, 0, time.UTC))
+
+	chain := &Chain{Directory: dir, Key: key}
+	if err := chain.Load(); err != nil {
+		t.Fatal("Load returned an error", err)
+	}
+
+	for _, name := range names {
+		writeFile(t, path.Join(dir, name), "video "+name)
+		if err := chain.Update([]segment.Segment{{Name: name}}, false); err != nil {
+			t.Fatal("Update returned an error", err)
+		}
+	}
+
+	return chain
+}
+
+func verify(t *testing.T, dir string, publicKey ed25519.PublicKey) Report {
+	report, err := Verify(dir, publicKey)
+	if err != nil {
+		t.Fatal("Verify returned an error", err)
+	}
+
+	return report
+}
+
+func TestVerify(t *testing.T) {
+	dir := tempDir(t)
+	publicKey, privateKey := newKey(t)
+	newChain(t, dir, privateKey, "raspilive-0.ts", "raspilive-1.ts", "2021/03/17/raspilive-20210317T120000.000Z.mp4")
+
+	report := verify(t, dir, publicKey)
+
+	expected := []string{"raspilive-0.ts", "raspilive-1.ts", "2021/03/17/raspilive-20210317T120000.000Z.mp4"}
+	if !report.OK() || report.Entries != 3 || !reflect.DeepEqual(report.Verified, expected) {
+		t.Error("Verify returned incorrect report, got", report)
+	}
+}
+
+func TestChainSkipsRepeatedUpdates(t *testing.T) {
+	dir := tempDir(t)
+	_, privateKey := newKey(t)
+	chain := newChain(t, dir, privateKey, "raspilive-0.ts")
+
+	// The segmenter updates the manifests again with the same segment when the video ends
+	chain.Update([]segment.Segment{{Name: "raspilive-0.ts"}}, true)
+
+	entries, _ := readEntries(dir)
+	if len(entries) != 1 {
+		t.Error("Chain added incorrect number of entries, got", len(entries))
+	}
+}
+
+func TestChainAddsInit(t *testing.T) {
+	dir := tempDir(t)
+	publicKey, privateKey := newKey(t)
+
+	writeFile(t, path.Join(dir, "init.mp4"), "init")
+	chain := newChain(t, dir, privateKey)
+	chain.SetInit("init.mp4")
+
+	update := func(name string) {
+		writeFile(t, path.Join(dir, name), "video "+name)
+		if err := chain.Update([]segment.Segment{{Name: name}}, false); err != nil {
+			t.Fatal("Update returned an error", err)
+		}
+	}
+
+	update("raspilive-0.m4s")
+	update("raspilive-1.m4s")
+
+	// The parameter sets changed partway through
+	writeFile(t, path.Join(dir, "init.mp4"), "init again")
+	update("raspilive-2.m4s")
+
+	entries, _ := readEntries(dir)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name)
+	}
+	expected := []string{"init.mp4", "raspilive-0.m4s", "raspilive-1.m4s", "init.mp4", "raspilive-2.m4s"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Error("Chain added incorrect entries, got", names)
+	}
+
+	report := verify(t, dir, publicKey)
+	if !report.OK() || report.Superseded != 1 || len(report.Unlisted) != 0 {
+		t.Error("Verify returned incorrect report, got", report)
+	}
+
+	writeFile(t, path.Join(dir, "init.mp4"), "tampered")
+	if report := verify(t, dir, publicKey); report.OK() || !reflect.DeepEqual(report.Altered, []string{"init.mp4"}) {
+		t.Error("Verify did not report the altered initialization segment, got", report)
+	}
+}
+
+func TestChainCarriesOver(t *testing.T) {
+	dir := tempDir(t)
+	publicKey, privateKey := newKey(t)
+	newChain(t, dir, privateKey, "raspilive-0.ts")
+
+	// A crash left half of an entry behind
+	manifest, _ := os.OpenFile(path.Join(dir, Name), os.O_WRONLY|os.O_APPEND, 0644)
+	manifest.WriteString(`{"seq":1,"name":"rasp`)
+	manifest.Close()
+
+	if report := verify(t, dir, publicKey); !report.OK() || report.Entries != 1 {
+		t.Error("Verify did not drop the cut short entry, got", report)
+	}
+
+	newChain(t, dir, privateKey, "raspilive-1.ts")
+
+	report := verify(t, dir, publicKey)
+	if !report.OK() || report.Entries != 2 {
+		t.Error("Chain did not carry over, got", report)
+	}
+}
+
+func TestVerifyAltered(t *testing.T) {
+	dir := tempDir(t)
+	publicKey, privateKey := newKey(t)
+	newChain(t, dir, privateKey, "raspilive-0.ts", "raspilive-1.ts")
+
+	writeFile(t, path.Join(dir, "raspilive-1.ts"), "video raspilive-2.ts")
+
+	report := verify(t, dir, publicKey)
+	if report.OK() || !reflect.DeepEqual(report.Altered, []string{"raspilive-1.ts"}) {
+		t.Error("Verify did not report the altered file, got", report)
+	}
+}
+
+func TestVerifyMissingAndExpired(t *testing.T) {
+	dir := tempDir(t)
+	publicKey, privateKey := newKey(t)
+	newChain(t, dir, privateKey, "raspilive-0.ts", "raspilive-1.ts", "raspilive-2.ts", "raspilive-3.ts")
+
+	os.Remove(path.Join(dir, "raspilive-0.ts"))
+	os.Remove(path.Join(dir, "raspilive-2.ts"))
+
+	report := verify(t, dir, publicKey)
+	if !reflect.DeepEqual(report.Expired, []string{"raspilive-0.ts"}) {
+		t.Error("Verify returned incorrect expired files, got", report.Expired)
+	}
+	if report.OK() || !reflect.DeepEqual(report.Missing, []string{"raspilive-2.ts"}) {
+		t.Error("Verify returned incorrect missing files, got", report.Missing)
+	}
+}
+
+func TestVerifyEverythingMissing(t *testing.T) {
+	dir := tempDir(t)
+	publicKey, privateKey := newKey(t)
+	newChain(t, dir, privateKey, "raspilive-0.ts", "raspilive-1.ts")
+
+	os.Remove(path.Join(dir, "raspilive-0.ts"))
+	os.Remove(path.Join(dir, "raspilive-1.ts"))
+
+	report := verify(t, dir, publicKey)
+	if report.OK() || len(report.Expired) != 0 || len(report.Missing) != 2 {
+		t.Error("Verify did not report the files as missing, got", report)
+	}
+}
+
+func TestVerifyReordered(t *testing.T) {
+	dir := tempDir(t)
+	publicKey, privateKey := newKey(t)
+	newChain(t, dir, privateKey, "raspilive-0.ts", "raspilive-1.ts", "raspilive-2.ts")
+
+	data, _ := ioutil.ReadFile(path.Join(dir, Name))
+	lines := bytes.Split(bytes.TrimSpace(data), []byte("\n"))
+	lines[1], lines[2] = lines[2], lines[1]
+	ioutil.WriteFile(path.Join(dir, Name), append(bytes.Join(lines, []byte("\n")), '\n'), 0644)
+
+	report := verify(t, dir, publicKey)
+	if report.OK() || len(report.Broken) == 0 {
+		t.Error("Verify did not report the reordered entries, got", report)
+	}
+}
+
+func TestVerifyRemovedEntry(t *testing.T) {
+	dir := tempDir(t)
+	publicKey, privateKey := newKey(t)
+	newChain(t, dir, privateKey, "raspilive-0.ts", "raspilive-1.ts", "raspilive-2.ts")
+
+	data, _ := ioutil.ReadFile(path.Join(dir, Name))
+	lines := bytes.Split(bytes.TrimSpace(data), []byte("\n"))
+	lines = append(lines[:1], lines[2:]...)
+	ioutil.WriteFile(path.Join(dir, Name), append(bytes.Join(lines, []byte("\n")), '\n'), 0644)
+
+	report := verify(t, dir, publicKey)
+	if report.OK() || len(report.Broken) == 0 {
+		t.Error("Verify did not report the removed entry, got", report)
+	}
+	if !reflect.DeepEqual(report.Unlisted, []string{"raspilive-1.ts"}) {
+		t.Error("Verify did not report the unlisted file, got", report.Unlisted)
+	}
+}
+
+func TestVerifyForgedEntry(t *testing.T) {
+	dir := tempDir(t)
+	publicKey, privateKey := newKey(t)
+	newChain(t, dir, privateKey, "raspilive-0.ts")
+
+	// Someone without the key rewrites the file and chains it anew with a key of their own
+	os.Remove(path.Join(dir, Name))
+	_, forgedKey := newKey(t)
+	newChain(t, dir, forgedKey, "raspilive-0.ts")
+
+	report := verify(t, dir, publicKey)
+	if report.OK() || len(report.Broken) != 1 || !strings.Contains(report.Broken[0], "invalid signature") {
+		t.Error("Verify did not report the forged entry, got", report)
+	}
+}
+
+func TestVerifySuperseded(t *testing.T) {
+	dir := tempDir(t)
+	publicKey, privateKey := newKey(t)
+	newChain(t, dir, privateKey, "raspilive-0.ts", "raspilive-1.ts")
+
+	// The segment numbers start over after a restart
+	writeFile(t, path.Join(dir, "raspilive-0.ts"), "video raspilive-0.ts again")
+	chain := &Chain{Directory: dir, Key: privateKey}
+	chain.Load()
+	if err := chain.Add("raspilive-0.ts"); err != nil {
+		t.Fatal("Add returned an error", err)
+	}
+
+	report := verify(t, dir, publicKey)
+	expected := []string{"raspilive-1.ts", "raspilive-0.ts"}
+	if !report.OK() || report.Superseded != 1 || !reflect.DeepEqual(report.Verified, expected) {
+		t.Error("Verify returned incorrect report, got", report)
+	}
+}
+
+func TestVerifyClockSteppedBack(t *testing.T) {
+	dir := tempDir(t)
+	publicKey, privateKey := newKey(t)
+	chain := newChain(t, dir, privateKey, "raspilive-0.ts")
+
+	// The clock synced after booting without a real-time clock
+	stubNow(t, time.Date(2021, 3, 17, 11, 0, 0, 0, time.UTC))
+	writeFile(t, path.Join(dir, "raspilive-1.ts"), "video raspilive-1.ts")
+	chain.Update([]segment.Segment{{Name: "raspilive-1.ts"}}, false)
+
+	report := verify(t, dir, publicKey)
+	if !report.OK() || len(report.Verified) != 2 || len(report.Warnings) != 1 {
+		t.Error("Verify returned incorrect report, got", report)
+	}
+}
+
+func TestVerifyNoManifest(t *testing.T) {
+	publicKey, _ := newKey(t)
+
+	if _, err := Verify(tempDir(t), publicKey); err == nil {
+		t.Error("Verify did not return an error")
+	}
+}
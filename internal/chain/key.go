@@ -0,0 +1,114 @@
+package chain
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+)
+
+// LoadKey reads the Ed25519 private key from the PEM file, generating a new one if the file does not exist. The public
+// key is written alongside a generated key with a .pub extension so that it may be handed over for verification.
+//
+// Keys are PKCS #8, the same as those generated by `openssl genpkey -algorithm ed25519`.
+func LoadKey(name string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(name)
+	if os.IsNotExist(err) {
+		return generateKey(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, errors.New("chain: invalid private key")
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, errors.New("chain: invalid private key")
+	}
+
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, errors.New("chain: private key is not ed25519")
+	}
+
+	return privateKey, nil
+}
+
+// LoadPublicKey reads the Ed25519 public key from the PEM file. The private key is accepted as well, for checking
+// footage on the device that recorded it.
+func LoadPublicKey(name string) (ed25519.PublicKey, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("chain: invalid public key")
+	}
+
+	var key interface{}
+	switch block.Type {
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, errors.New("chain: invalid public key")
+	}
+	if err != nil {
+		return nil, errors.New("chain: invalid public key")
+	}
+
+	switch key := key.(type) {
+	case ed25519.PublicKey:
+		return key, nil
+	case ed25519.PrivateKey:
+		return key.Public().(ed25519.PublicKey), nil
+	default:
+		return nil, errors.New("chain: public key is not ed25519")
+	}
+}
+
+// generateKey generates a new private key and writes it to the file, along with its public key.
+func generateKey(name string) (ed25519.PrivateKey, error) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	privateData, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	publicData, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// Never overwrite a key that turned up in the meantime, since footage signed with it would become unverifiable
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := pem.Encode(file, &pem.Block{Type: "PRIVATE KEY", Bytes: privateData}); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err := file.Close(); err != nil {
+		return nil, err
+	}
+
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicData})
+	if err := os.WriteFile(name+".pub", publicPEM, 0644); err != nil {
+		return nil, err
+	}
+
+	return privateKey, nil
+}
@@ -0,0 +1,53 @@
+package chain
+
+import (
+	"os"
+	"path"
+	"testing"
+)
+
+func TestLoadKey(t *testing.T) {
+	name := path.Join(tempDir(t), "raspilive.key")
+
+	generated, err := LoadKey(name)
+	if err != nil {
+		t.Fatal("LoadKey returned an error", err)
+	}
+
+	if info, err := os.Stat(name); err != nil || info.Mode().Perm() != 0600 {
+		t.Error("LoadKey did not write a private key file only the owner can read")
+	}
+
+	loaded, err := LoadKey(name)
+	if err != nil {
+		t.Fatal("LoadKey returned an error", err)
+	}
+	if !generated.Equal(loaded) {
+		t.Error("LoadKey did not load the generated key")
+	}
+
+	publicKey, err := LoadPublicKey(name + ".pub")
+	if err != nil {
+		t.Fatal("LoadPublicKey returned an error", err)
+	}
+	if !publicKey.Equal(generated.Public()) {
+		t.Error("LoadPublicKey did not load the public key of the generated key")
+	}
+
+	// The private key stands in for the public key
+	if publicKey, err = LoadPublicKey(name); err != nil || !publicKey.Equal(generated.Public()) {
+		t.Error("LoadPublicKey did not load the public key from the private key, got", err)
+	}
+}
+
+func TestLoadKeyInvalid(t *testing.T) {
+	name := path.Join(tempDir(t), "raspilive.key")
+	writeFile(t, name, "not a key")
+
+	if _, err := LoadKey(name); err == nil {
+		t.Error("LoadKey did not return an error")
+	}
+	if _, err := LoadPublicKey(name); err == nil {
+		t.Error("LoadPublicKey did not return an error")
+	}
+}
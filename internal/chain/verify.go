@@ -0,0 +1,184 @@
+package chain
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Report is the outcome of verifying a directory of footage against its hash chain manifest.
+type Report struct {
+	Entries    int      // Number of entries in the manifest
+	Verified   []string // Files that match their entries
+	Superseded int      // Entries for files that were written again under the same name later on
+	Expired    []string // Files missing from the start of the chain, as retention removes the oldest video
+	Missing    []string // Files missing after the oldest file still present
+	Altered    []string // Files that no longer match their entries
+	Unlisted   []string // Video files that are not in the manifest, which it does not vouch for
+	Broken     []string // Problems with the manifest itself, such as altered, removed or reordered entries
+	Warnings   []string // Oddities that do not break the chain, such as the clock going back between entries
+}
+
+// OK reports whether the footage is intact, with nothing missing, altered or broken.
+func (report Report) OK() bool {
+	return len(report.Missing) == 0 && len(report.Altered) == 0 && len(report.Broken) == 0
+}
+
+// Verify checks the footage in the directory against its hash chain manifest, which must have been signed with the
+// private key belonging to the public key.
+//
+// Every entry must be signed, hashed correctly and chained to the entry before it. Every file must match the last entry
+// with its name; earlier entries for the same name were superseded when the file was written again, such as when a
+// segment number is reused after a restart. Files missing from the start of the chain are taken to have expired, while
+// those missing after a file that is still present are reported as missing. Entries timestamped before the one preceding
+// them are only warned about, as the clock may have stepped back.
+func Verify(directory string, publicKey ed25519.PublicKey) (Report, error) {
+	entries, err := readEntries(directory)
+	if err != nil {
+		return Report{}, err
+	}
+
+	report := Report{Entries: len(entries)}
+
+	previous := genesis
+	for i, entry := range entries {
+		if problem := check(entry, i, previous, publicKey); problem != "" {
+			report.Broken = append(report.Broken, fmt.Sprintf("entry %d (%s): %s", i, entry.Name, problem))
+		}
+		if i > 0 && entry.Time.Before(entries[i-1].Time) {
+			// Clocks step back when they sync after booting without a real-time clock, so the times vouch for nothing
+			report.Warnings = append(report.Warnings, fmt.Sprintf("entry %d (%s): timestamped before the entry preceding it", i, entry.Name))
+		}
+		previous = entry.Hash
+	}
+
+	latest := make(map[string]int)
+	for i, entry := range entries {
+		latest[entry.Name] = i
+	}
+
+	var missing []string
+	present := false
+	for i, entry := range entries {
+		if latest[entry.Name] != i {
+			report.Superseded++
+			continue
+		}
+
+		if !validName(entry.Name) {
+			report.Broken = append(report.Broken, fmt.Sprintf("entry %d (%s): invalid name", i, entry.Name))
+			continue
+		}
+
+		size, sum, err := hashFile(filepath.Join(directory, filepath.FromSlash(entry.Name)))
+		switch {
+		case os.IsNotExist(err):
+			missing = append(missing, entry.Name)
+			continue
+		case err != nil:
+			return Report{}, err
+		}
+
+		if !present {
+			// Everything before the oldest file still present went the way of retention
+			report.Expired = missing
+			missing = nil
+			present = true
+		}
+
+		if size != entry.Size || sum != entry.SHA256 {
+			report.Altered = append(report.Altered, entry.Name)
+		} else {
+			report.Verified = append(report.Verified, entry.Name)
+		}
+	}
+	report.Missing = missing
+
+	unlisted, err := unlistedFiles(directory, latest)
+	if err != nil {
+		return Report{}, err
+	}
+	report.Unlisted = unlisted
+
+	return report, nil
+}
+
+// check checks that the entry is in its place in the chain, hashed correctly and signed, describing the problem if not.
+func check(entry Entry, sequence int, previous string, publicKey ed25519.PublicKey) string {
+	if entry.Sequence != sequence {
+		return fmt.Sprintf("out of sequence, numbered %d", entry.Sequence)
+	}
+	if entry.Previous != previous {
+		return "not chained to the entry preceding it"
+	}
+	if entry.digest() != entry.Hash {
+		return "altered since it was hashed"
+	}
+
+	digest, err := hex.DecodeString(entry.Hash)
+	if err != nil {
+		return "invalid hash"
+	}
+	sig, err := base64.StdEncoding.DecodeString(entry.Sig)
+	if err != nil || !ed25519.Verify(publicKey, digest, sig) {
+		return "invalid signature"
+	}
+
+	return ""
+}
+
+// validName reports whether the name stays within the directory.
+func validName(name string) bool {
+	cleaned := path.Clean(name)
+	return name != "" && !path.IsAbs(cleaned) && cleaned != ".." && !strings.HasPrefix(cleaned, "../")
+}
+
+// unlistedFiles returns the video files in the directory that are not in the manifest. Hidden files are in progress, so
+// they are not expected to be listed.
+func unlistedFiles(directory string, listed map[string]int) ([]string, error) {
+	var unlisted []string
+
+	err := filepath.Walk(directory, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if strings.HasPrefix(info.Name(), ".") && name != directory {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		switch filepath.Ext(name) {
+		case ".ts", ".m4s", ".mp4", ".age":
+		default:
+			return nil
+		}
+
+		relative, err := filepath.Rel(directory, name)
+		if err != nil {
+			return err
+		}
+		relative = filepath.ToSlash(relative)
+
+		if _, ok := listed[relative]; !ok {
+			unlisted = append(unlisted, relative)
+		}
+
+		return nil
+	})
+
+	sort.Strings(unlisted)
+
+	return unlisted, err
+}
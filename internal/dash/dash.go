@@ -0,0 +1,206 @@
+package dash
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+
+	"github.com/jaredpetersen/raspilive/internal/chain"
+	"github.com/jaredpetersen/raspilive/internal/export"
+	"github.com/jaredpetersen/raspilive/internal/hls"
+	"github.com/jaredpetersen/raspilive/internal/metadata"
+	"github.com/jaredpetersen/raspilive/internal/segment"
+	"github.com/jaredpetersen/raspilive/internal/session"
+)
+
+// Options represents ways that the native muxer may be configured to mux video to DASH.
+//
+// Defaults matching those of Ffmpeg are used if a value is not provided.
+type Options struct {
+	Fps          int   // Framerate of the output video
+	SegmentTime  int   // Segment length target duration in seconds
+	PlaylistSize int   // Maximum number of playlist entries
+	StorageSize  int   // Maximum number of unreferenced segments to keep in storage before removal
+	Window       int   // Duration of video to keep for viewers to rewind through in seconds, in place of PlaylistSize
+	Budget       int64 // Maximum size of all the segments in storage in bytes, dropping the oldest segments past it
+	HLSPlaylist  bool  // Also write an HLS playlist that references the same segments
+
+	UTCTiming string // URL that players may fetch the time from to sync their clocks, as an xs:dateTime
+
+	// EpochNumbers numbers the segments from the seconds since the Unix epoch so that they keep increasing across
+	// restarts, marking the first one as a discontinuity
+	EpochNumbers bool
+
+	Thumbnails Thumbnails // Thumbnail track to list in the manifest alongside the video, if it has media
+
+	// Metadata is timed metadata to carry in the segments as ID3 tags in event messages, presented along with the
+	// frames captured as it arrived
+	Metadata *metadata.Queue
+
+	// Catalog takes note of the segments as they are written so that footage may be exported from them for as long as
+	// they are kept in storage
+	Catalog *export.Catalog
+
+	// Chain keeps a tamper-evident manifest of the segments as they are written, which must be to the chain's directory
+	Chain *chain.Chain
+
+	// Session keeps track of the session that the segments are written to, which must be to the session's directory,
+	// along with a playlist of every segment in it that is finished as video on demand once the stream ends. Segments
+	// are kept in storage for as long as the session goes on, unless Shortfall calls for cutting them back.
+	Session *session.Tracker
+
+	// Shortfall reports how many bytes need freeing up in storage, if any, cutting the segments kept back as far as the
+	// live edge to make room
+	Shortfall func() int64
+}
+
+// Muxer represents the native DASH muxer.
+//
+// Video is packaged into CMAF segments within raspilive itself rather than with Ffmpeg. The same segments may be listed
+// in an HLS playlist alongside the DASH manifest so that both kinds of players are served without any extra work. The
+// video stream must be H.264 in the Annex-B format, as produced by raspivid.
+type Muxer struct {
+	Directory string
+	Storage   segment.Storage // Where the video is kept in place of the directory, such as in memory
+	Options   Options
+	done      chan struct{}
+	err       error
+}
+
+// Mux begins muxing the video stream to the DASH format.
+func (muxer *Muxer) Mux(video io.ReadCloser) error {
+	if muxer.Storage == nil && muxer.Directory != "" {
+		if info, err := os.Stat(muxer.Directory); err != nil || !info.IsDir() {
+			return errors.New("dash: invalid directory")
+		}
+	}
+
+	muxer.done = make(chan struct{})
+
+	go func() {
+		defer close(muxer.done)
+		muxer.err = muxer.mux(video)
+	}()
+
+	return nil
+}
+
+// Wait blocks until the video stream is finished processing by Mux.
+func (muxer *Muxer) Wait() error {
+	if muxer.done == nil {
+		return errors.New("dash: not started")
+	}
+
+	<-muxer.done
+
+	return muxer.err
+}
+
+func (muxer *Muxer) String() string {
+	if muxer.done == nil {
+		return ""
+	}
+
+	if muxer.Storage != nil {
+		return "native dash muxer (memory)"
+	}
+
+	return fmt.Sprintf("native dash muxer (%s)", path.Join(muxer.Directory, "livestream.mpd"))
+}
+
+func (muxer *Muxer) mux(video io.ReadCloser) error {
+	storage := muxer.Storage
+	if storage == nil {
+		storage = segment.Dir(muxer.Directory)
+	}
+
+	fps := muxer.Options.Fps
+	if fps <= 0 {
+		fps = 30
+	}
+
+	segmentTime := muxer.Options.SegmentTime
+	if segmentTime <= 0 {
+		segmentTime = 2
+	}
+
+	mpd := &MPD{
+		Storage:     storage,
+		Name:        "livestream.mpd",
+		InitName:    "init.m4s",
+		Media:       "raspilive-$Number$.m4s",
+		Fps:         fps,
+		SegmentTime: segmentTime,
+		UTCTiming:   muxer.Options.UTCTiming,
+		Thumbnails:  muxer.Options.Thumbnails,
+	}
+	if muxer.Options.Metadata != nil {
+		mpd.Events = metadata.SchemeID3
+	}
+
+	manifests := []segment.Manifest{mpd}
+
+	if muxer.Options.HLSPlaylist {
+		manifests = append(manifests, &hls.Playlist{
+			Storage: storage,
+			Name:    "livestream.m3u8",
+			Map:     "init.m4s",
+		})
+	}
+
+	if muxer.Options.Chain != nil {
+		muxer.Options.Chain.SetInit("init.m4s")
+		manifests = append(manifests, muxer.Options.Chain)
+	}
+
+	if muxer.Options.Catalog != nil {
+		muxer.Options.Catalog.SetInit("init.m4s")
+		manifests = append(manifests, muxer.Options.Catalog)
+	}
+
+	// List every segment of the session for playing it back once it ends
+	if muxer.Options.Session != nil {
+		manifests = append(manifests, &hls.VODPlaylist{
+			Playlist: hls.Playlist{
+				Storage: storage,
+				Name:    session.PlaylistName,
+				Map:     "init.m4s",
+			},
+		}, muxer.Options.Session)
+	}
+
+	format := &segment.FMP4{
+		Storage:  storage,
+		InitName: "init.m4s",
+		Pattern:  "raspilive-%d.m4s",
+	}
+
+	options := segment.Options{
+		Fps:          fps,
+		SegmentTime:  segmentTime,
+		PlaylistSize: muxer.Options.PlaylistSize,
+		StorageSize:  muxer.Options.StorageSize,
+		StartNumber:  1,
+		Window:       muxer.Options.Window,
+		Budget:       muxer.Options.Budget,
+		Metadata:     muxer.Options.Metadata,
+		Shortfall:    muxer.Options.Shortfall,
+	}
+	if muxer.Options.EpochNumbers {
+		options.StartNumber = int(now().Unix())
+		options.Discontinuity = true
+	}
+
+	// The whole session is played back from storage, so nothing is removed from it until the session itself is, short
+	// of running out of space
+	if muxer.Options.Session != nil {
+		options.StorageSize = 0
+		options.Budget = 0
+	}
+
+	seg := segment.New(storage, format, options, manifests...)
+
+	return seg.Feed(video)
+}
@@ -0,0 +1,344 @@
+package dash
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jaredpetersen/raspilive/internal/export"
+	"github.com/jaredpetersen/raspilive/internal/h264"
+	"github.com/jaredpetersen/raspilive/internal/metadata"
+	"github.com/jaredpetersen/raspilive/internal/session"
+)
+
+var (
+	fakeSPS = []byte{
+		0x67, 0x64, 0x00, 0x1F, 0xAC, 0xD9, 0x40, 0x50, 0x05, 0xBB, 0x01, 0x10, 0x00, 0x00, 0x03, 0x00,
+		0x10, 0x00, 0x00, 0x03, 0x03, 0xC0, 0xF1, 0x83, 0x19, 0x60,
+	}
+	fakePPS    = []byte{0x68, 0xEE, 0x3C, 0x80}
+	fakeIDR    = []byte{0x65, 0x88, 0x84}
+	fakeNonIDR = []byte{0x41, 0x9A, 0x02}
+)
+
+// fakeVideo builds an Annex-B stream with a keyframe every keyframeInterval frames.
+func fakeVideo(frames int, keyframeInterval int) []byte {
+	var stream []byte
+	for _, nalu := range [][]byte{fakeSPS, fakePPS} {
+		stream = append(stream, h264.StartCode...)
+		stream = append(stream, nalu...)
+	}
+
+	for i := 0; i < frames; i++ {
+		nalu := fakeNonIDR
+		if i%keyframeInterval == 0 {
+			nalu = fakeIDR
+		}
+		stream = append(stream, h264.StartCode...)
+		stream = append(stream, nalu...)
+	}
+
+	return stream
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "raspilive-dash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	return dir
+}
+
+func mux(t *testing.T, muxer *Muxer, video []byte) {
+	if err := muxer.Mux(ioutil.NopCloser(bytes.NewReader(video))); err != nil {
+		t.Fatal("Mux returned an error", err)
+	}
+	if err := muxer.Wait(); err != nil {
+		t.Fatal("Wait returned an error", err)
+	}
+}
+
+func readFile(t *testing.T, name string) string {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal("Failed to read file", err)
+	}
+	return string(data)
+}
+
+func TestMux(t *testing.T) {
+	dir := tempDir(t)
+
+	muxer := Muxer{
+		Directory: dir,
+		Options: Options{
+			Fps:         30,
+			SegmentTime: 1,
+		},
+	}
+
+	mux(t, &muxer, fakeVideo(90, 30))
+
+	manifest := readFile(t, path.Join(dir, "livestream.mpd"))
+
+	expected := []string{
+		`type="static" mediaPresentationDuration="PT3S"`,
+		`codecs="avc1.64001F"`,
+		`width="1280" height="720" frameRate="30"`,
+		`<SegmentTemplate timescale="90000" presentationTimeOffset="126000" initialization="init.m4s" media="raspilive-$Number$.m4s" startNumber="1">`,
+		`<S t="126000" d="90000" />`,
+		`<S t="216000" d="90000" />`,
+		`<S t="306000" d="90000" />`,
+	}
+	for _, e := range expected {
+		if !strings.Contains(manifest, e) {
+			t.Errorf("Mux wrote manifest without %s, got\n%s", e, manifest)
+		}
+	}
+
+	for _, name := range []string{"init.m4s", "raspilive-1.m4s", "raspilive-2.m4s", "raspilive-3.m4s"} {
+		if _, err := os.Stat(path.Join(dir, name)); err != nil {
+			t.Error("Mux did not write", name)
+		}
+	}
+
+	if _, err := os.Stat(path.Join(dir, "livestream.m3u8")); err == nil {
+		t.Error("Mux wrote HLS playlist without being asked to")
+	}
+}
+
+func TestMuxHLSPlaylist(t *testing.T) {
+	dir := tempDir(t)
+
+	muxer := Muxer{
+		Directory: dir,
+		Options: Options{
+			Fps:          30,
+			SegmentTime:  1,
+			PlaylistSize: 2,
+			HLSPlaylist:  true,
+		},
+	}
+
+	mux(t, &muxer, fakeVideo(90, 30))
+
+	expected := "#EXTM3U\n" +
+		"#EXT-X-VERSION:7\n" +
+		"#EXT-X-TARGETDURATION:1\n" +
+		"#EXT-X-MEDIA-SEQUENCE:2\n" +
+		"#EXT-X-INDEPENDENT-SEGMENTS\n" +
+		"#EXT-X-MAP:URI=\"init.m4s\"\n" +
+		"#EXTINF:1.000000,\nraspilive-2.m4s\n" +
+		"#EXTINF:1.000000,\nraspilive-3.m4s\n" +
+		"#EXT-X-ENDLIST\n"
+	if playlist := readFile(t, path.Join(dir, "livestream.m3u8")); playlist != expected {
+		t.Error("Mux wrote incorrect HLS playlist, got\n", playlist)
+	}
+
+	// Both manifests list the same segments
+	if manifest := readFile(t, path.Join(dir, "livestream.mpd")); !strings.Contains(manifest, `startNumber="2"`) {
+		t.Error("Mux wrote manifest with incorrect start number, got\n", manifest)
+	}
+}
+
+func TestMuxSession(t *testing.T) {
+	library := &session.Library{Directory: tempDir(t)}
+	tracker, err := library.Start()
+	if err != nil {
+		t.Fatal("Start returned an error", err)
+	}
+	dir := tracker.Directory()
+
+	muxer := Muxer{
+		Directory: dir,
+		Options: Options{
+			Fps:          30,
+			SegmentTime:  1,
+			PlaylistSize: 1,
+			StorageSize:  1,
+			Session:      tracker,
+		},
+	}
+
+	mux(t, &muxer, fakeVideo(90, 30))
+
+	expected := "#EXTM3U\n" +
+		"#EXT-X-VERSION:7\n" +
+		"#EXT-X-TARGETDURATION:1\n" +
+		"#EXT-X-MEDIA-SEQUENCE:1\n" +
+		"#EXT-X-PLAYLIST-TYPE:VOD\n" +
+		"#EXT-X-INDEPENDENT-SEGMENTS\n" +
+		"#EXT-X-MAP:URI=\"init.m4s\"\n" +
+		"#EXTINF:1.000000,\nraspilive-1.m4s\n" +
+		"#EXTINF:1.000000,\nraspilive-2.m4s\n" +
+		"#EXTINF:1.000000,\nraspilive-3.m4s\n" +
+		"#EXT-X-ENDLIST\n"
+	if playlist := readFile(t, path.Join(dir, session.PlaylistName)); playlist != expected {
+		t.Error("Mux wrote incorrect session playlist, got\n", playlist)
+	}
+
+	sessions, _ := library.Sessions()
+	if len(sessions) != 1 || sessions[0].Live || sessions[0].Segments != 3 {
+		t.Error("Mux did not finish the session, got", sessions)
+	}
+}
+
+func TestMuxThumbnails(t *testing.T) {
+	dir := tempDir(t)
+
+	muxer := Muxer{
+		Directory: dir,
+		Options: Options{
+			Fps:         30,
+			SegmentTime: 1,
+			Thumbnails:  Thumbnails{Media: "thumbnails-$Number$.jpg", Interval: 5, Columns: 5, Rows: 5, Width: 160, Height: 90},
+		},
+	}
+
+	mux(t, &muxer, fakeVideo(30, 30))
+
+	manifest := readFile(t, path.Join(dir, "livestream.mpd"))
+	if !strings.Contains(manifest, `<SegmentTemplate media="thumbnails-$Number$.jpg" duration="125" startNumber="1" />`) {
+		t.Error("Mux wrote manifest without the thumbnail track, got\n", manifest)
+	}
+}
+
+func TestMuxMetadata(t *testing.T) {
+	dir := tempDir(t)
+
+	queue := &metadata.Queue{}
+	queue.Push([]byte(`{"temperature":21.5}`))
+
+	muxer := Muxer{
+		Directory: dir,
+		Options: Options{
+			Fps:         30,
+			SegmentTime: 1,
+			Metadata:    queue,
+		},
+	}
+
+	mux(t, &muxer, fakeVideo(30, 30))
+
+	manifest := readFile(t, path.Join(dir, "livestream.mpd"))
+	if !strings.Contains(manifest, `<InbandEventStream schemeIdUri="https://aomedia.org/emsg/ID3" value="" />`) {
+		t.Error("Mux wrote manifest without the event stream, got\n", manifest)
+	}
+
+	segment := readFile(t, path.Join(dir, "raspilive-1.m4s"))
+	if !strings.Contains(segment, "emsg") || !strings.Contains(segment, `{"temperature":21.5}`) {
+		t.Error("Mux did not carry metadata in the segment")
+	}
+}
+
+func TestMuxCatalog(t *testing.T) {
+	catalog := &export.Catalog{}
+
+	muxer := Muxer{
+		Directory: tempDir(t),
+		Options: Options{
+			Fps:          30,
+			SegmentTime:  1,
+			PlaylistSize: 1,
+			Catalog:      catalog,
+		},
+	}
+
+	mux(t, &muxer, fakeVideo(90, 30))
+
+	if catalog.Init() != "init.m4s" {
+		t.Error("Mux set incorrect init segment in the catalog, got", catalog.Init())
+	}
+
+	// The catalog holds on to the segments that slid out of the manifest
+	segments := catalog.Range(time.Time{}, time.Now().Add(time.Hour))
+	if len(segments) != 3 || segments[0].Name != "raspilive-1.m4s" {
+		t.Error("Mux did not catalog the segments, got", segments)
+	}
+}
+
+func TestMuxInvalidDirectory(t *testing.T) {
+	muxer := Muxer{
+		Directory: path.Join(tempDir(t), "nonexistent"),
+	}
+
+	if err := muxer.Mux(ioutil.NopCloser(bytes.NewReader(nil))); err == nil || err.Error() != "dash: invalid directory" {
+		t.Error("Mux returned incorrect error, got", err)
+	}
+}
+
+func TestWaitNotStarted(t *testing.T) {
+	muxer := Muxer{}
+
+	err := muxer.Wait()
+	if err == nil || err.Error() != "dash: not started" {
+		t.Error("Wait returned incorrect error, got", err)
+	}
+}
+
+func TestString(t *testing.T) {
+	muxer := Muxer{Directory: "/tmp/camera"}
+
+	if muxer.String() != "" {
+		t.Error("String returned incorrect value before starting, got", muxer.String())
+	}
+
+	muxer.done = make(chan struct{})
+	if expected := "native dash muxer (/tmp/camera/livestream.mpd)"; muxer.String() != expected {
+		t.Error("String returned incorrect value, got", muxer.String())
+	}
+}
+
+// TestMuxFfprobe validates the CMAF segments against real H.264 video using Ffmpeg and Ffprobe, when they are
+// available.
+func TestMuxFfprobe(t *testing.T) {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		t.Skip("ffmpeg is not installed")
+	}
+	if _, err := exec.LookPath("ffprobe"); err != nil {
+		t.Skip("ffprobe is not installed")
+	}
+
+	video, err := exec.Command(
+		"ffmpeg", "-v", "error",
+		"-f", "lavfi", "-i", "testsrc=size=320x240:rate=30:duration=6",
+		"-c:v", "libx264", "-g", "30", "-bsf:v", "h264_mp4toannexb",
+		"-f", "h264", "pipe:1").Output()
+	if err != nil {
+		t.Skip("ffmpeg is not able to encode H.264 video", err)
+	}
+
+	dir := tempDir(t)
+	muxer := Muxer{
+		Directory: dir,
+		Options: Options{
+			Fps:         30,
+			SegmentTime: 2,
+			HLSPlaylist: true,
+		},
+	}
+	mux(t, &muxer, video)
+
+	// Not every build of ffprobe reads DASH manifests, but all of them read the HLS playlist of the same segments
+	out, err := exec.Command(
+		"ffprobe", "-v", "error",
+		"-count_frames",
+		"-show_entries", "stream=codec_name,width,height,nb_read_frames",
+		"-of", "default=noprint_wrappers=1",
+		path.Join(dir, "livestream.m3u8")).Output()
+	if err != nil {
+		t.Fatal("ffprobe rejected the segments", err)
+	}
+
+	expected := "codec_name=h264\nwidth=320\nheight=240\nnb_read_frames=180\n"
+	if string(out) != expected {
+		t.Error("ffprobe found incorrect stream, got\n", string(out))
+	}
+}
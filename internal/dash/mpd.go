@@ -0,0 +1,169 @@
+package dash
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/jaredpetersen/raspilive/internal/segment"
+)
+
+var now = time.Now
+
+// Thumbnails describes a track of thumbnail sprite sheets for scrubbing previews, listed in the manifest as an image
+// adaptation set.
+type Thumbnails struct {
+	Media    string // URI template of the sprite sheets, numbered from one at the start of the stream
+	Interval int    // Seconds between thumbnails
+	Columns  int    // Number of thumbnails across each sprite sheet
+	Rows     int    // Number of thumbnails down each sprite sheet
+	Width    int    // Width of each thumbnail in pixels
+	Height   int    // Height of each thumbnail in pixels
+}
+
+// MPD is a DASH manifest describing the segments with a timeline as they become available.
+type MPD struct {
+	Storage     segment.Storage // Where to write the manifest
+	Name        string          // File name of the manifest
+	InitName    string          // URI of the initialization segment
+	Media       string          // URI template of the media segments
+	Fps         int             // Framerate of the video
+	SegmentTime int             // Segment length target duration in seconds
+	UTCTiming   string          // URL that players may fetch the time from to sync their clocks, as an xs:dateTime
+	Thumbnails  Thumbnails      // Thumbnail track to list alongside the video, if it has media
+	Events      string          // Scheme of the event messages carried in the segments, if there are any
+	start       int64           // Presentation timestamp that the period starts at
+	available   time.Time       // Wall clock time that the period started at
+}
+
+// Update rewrites the manifest with the segments that are currently available.
+func (mpd *MPD) Update(segments []segment.Segment, ended bool) error {
+	if len(segments) == 0 {
+		return nil
+	}
+
+	// Anchor the timeline to the wall clock time that the first segment was captured, falling back to when it shows
+	// up, which is right as it finishes
+	if mpd.available.IsZero() {
+		first := segments[0]
+		mpd.start = first.Start
+		mpd.available = first.Time.UTC()
+		if first.Time.IsZero() {
+			mpd.available = now().Add(-time.Duration(first.Duration) * time.Second / segment.Timescale).UTC()
+		}
+	}
+
+	return segment.WriteFile(mpd.Storage, mpd.Name, func(w io.Writer) error {
+		return mpd.encode(w, segments, ended)
+	})
+}
+
+// encode writes the manifest, switching it over to a static presentation if the stream has ended.
+func (mpd *MPD) encode(w io.Writer, segments []segment.Segment, ended bool) error {
+	bandwidth := 0.0
+	maxDuration := 0.0
+	window := 0.0
+	for _, seg := range segments {
+		if seg.Duration > 0 {
+			bandwidth = math.Max(bandwidth, float64(seg.Size*8)/seg.Seconds())
+		}
+		maxDuration = math.Max(maxDuration, seg.Seconds())
+		window += seg.Seconds()
+	}
+
+	last := segments[len(segments)-1]
+
+	// Players only pay attention to the event messages in the segments that they are told about
+	var events string
+	if mpd.Events != "" {
+		events = fmt.Sprintf("\t\t\t<InbandEventStream schemeIdUri=\"%s\" value=\"\" />\n", mpd.Events)
+	}
+
+	published := now().UTC().Format(time.RFC3339)
+
+	var presentation, timing string
+	if ended {
+		total := float64(last.Start+last.Duration-mpd.start) / segment.Timescale
+		presentation = fmt.Sprintf(`type="static" mediaPresentationDuration="%s"`, duration(total))
+	} else {
+		presentation = fmt.Sprintf(
+			`type="dynamic" availabilityStartTime="%s" publishTime="%s" minimumUpdatePeriod="%s" timeShiftBufferDepth="%s"`,
+			mpd.available.Format(time.RFC3339), published,
+			duration(float64(mpd.SegmentTime)), duration(window))
+
+		// Players need to agree with the camera on the time to know which segments are available, so point them at
+		// a clock or at least hand them the time that the manifest was published
+		timing = fmt.Sprintf("\t<UTCTiming schemeIdUri=\"urn:mpeg:dash:utc:direct:2014\" value=\"%s\" />\n", published)
+		if mpd.UTCTiming != "" {
+			timing = fmt.Sprintf(
+				"\t<UTCTiming schemeIdUri=\"urn:mpeg:dash:utc:http-xsdate:2014\" value=\"%s\" />\n", mpd.UTCTiming)
+		}
+	}
+
+	_, err := fmt.Fprintf(w, `<?xml version="1.0" encoding="utf-8"?>
+<MPD xmlns="urn:mpeg:dash:schema:mpd:2011" profiles="urn:mpeg:dash:profile:isoff-live:2011" %s minBufferTime="%s" maxSegmentDuration="%s">
+	<Period id="0" start="PT0S">
+		<AdaptationSet id="0" contentType="video" mimeType="video/mp4" segmentAlignment="true" startWithSAP="1">
+%s			<Representation id="0" codecs="%s" bandwidth="%d" width="%d" height="%d" frameRate="%d">
+				<SegmentTemplate timescale="%d" presentationTimeOffset="%d" initialization="%s" media="%s" startNumber="%d">
+					<SegmentTimeline>
+`,
+		presentation, duration(maxDuration), duration(maxDuration), events,
+		last.Codec, int(bandwidth), last.Width, last.Height, mpd.Fps,
+		segment.Timescale, mpd.start, mpd.InitName, mpd.Media, segments[0].Number)
+	if err != nil {
+		return err
+	}
+
+	for _, seg := range segments {
+		if _, err := fmt.Fprintf(w, "\t\t\t\t\t\t<S t=\"%d\" d=\"%d\" />\n", seg.Start, seg.Duration); err != nil {
+			return err
+		}
+	}
+
+	_, err = io.WriteString(w, `					</SegmentTimeline>
+				</SegmentTemplate>
+			</Representation>
+		</AdaptationSet>
+`)
+	if err != nil {
+		return err
+	}
+
+	if mpd.Thumbnails.Media != "" {
+		if err := mpd.encodeThumbnails(w); err != nil {
+			return err
+		}
+	}
+
+	_, err = io.WriteString(w, "\t</Period>\n"+timing+"</MPD>\n")
+	return err
+}
+
+// encodeThumbnails writes the adaptation set of the thumbnail track, with each sprite sheet as a segment covering the
+// thumbnails tiled in it.
+func (mpd *MPD) encodeThumbnails(w io.Writer) error {
+	thumbnails := mpd.Thumbnails
+	tiles := thumbnails.Columns * thumbnails.Rows
+
+	// Sprite sheets vary in size, so go by a rough estimate of a few kilobytes per thumbnail
+	bandwidth := 8 * 4000 / thumbnails.Interval
+
+	_, err := fmt.Fprintf(w, `		<AdaptationSet id="1" contentType="image" mimeType="image/jpeg">
+			<SegmentTemplate media="%s" duration="%d" startNumber="1" />
+			<Representation id="thumbnails" bandwidth="%d" width="%d" height="%d">
+				<EssentialProperty schemeIdUri="http://dashif.org/thumbnail_tile" value="%dx%d" />
+			</Representation>
+		</AdaptationSet>
+`,
+		thumbnails.Media, tiles*thumbnails.Interval, bandwidth,
+		thumbnails.Columns*thumbnails.Width, thumbnails.Rows*thumbnails.Height, thumbnails.Columns, thumbnails.Rows)
+	return err
+}
+
+// duration formats the seconds as an ISO 8601 duration.
+func duration(seconds float64) string {
+	return "PT" + strconv.FormatFloat(math.Round(seconds*1000)/1000, 'f', -1, 64) + "S"
+}
@@ -0,0 +1,155 @@
+package dash
+
+import (
+	"io/ioutil"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jaredpetersen/raspilive/internal/segment"
+)
+
+func TestMPDUpdate(t *testing.T) {
+	now = func() time.Time { return time.Date(2021, 3, 17, 12, 0, 10, 0, time.UTC) }
+	defer func() { now = time.Now }()
+
+	dir := tempDir(t)
+	mpd := MPD{
+		Storage:     segment.Dir(dir),
+		Name:        "livestream.mpd",
+		InitName:    "init.m4s",
+		Media:       "raspilive-$Number$.m4s",
+		Fps:         30,
+		SegmentTime: 2,
+	}
+
+	segments := []segment.Segment{
+		{Name: "raspilive-4.m4s", Number: 4, Start: 126000, Duration: 180000, Size: 250000, Codec: "avc1.640028", Width: 1280, Height: 720},
+		{Name: "raspilive-5.m4s", Number: 5, Start: 306000, Duration: 135000, Size: 150000, Codec: "avc1.640028", Width: 1280, Height: 720},
+	}
+
+	if err := mpd.Update(segments, false); err != nil {
+		t.Fatal("Update returned an error", err)
+	}
+
+	data, err := ioutil.ReadFile(path.Join(dir, "livestream.mpd"))
+	if err != nil {
+		t.Fatal("Update did not write manifest", err)
+	}
+	manifest := string(data)
+
+	expected := []string{
+		`type="dynamic" availabilityStartTime="2021-03-17T12:00:08Z" publishTime="2021-03-17T12:00:10Z"`,
+		`minimumUpdatePeriod="PT2S" timeShiftBufferDepth="PT3.5S" minBufferTime="PT2S" maxSegmentDuration="PT2S"`,
+		`bandwidth="1000000"`,
+		`startNumber="4"`,
+		`<S t="126000" d="180000" />`,
+		`<S t="306000" d="135000" />`,
+		`<UTCTiming schemeIdUri="urn:mpeg:dash:utc:direct:2014" value="2021-03-17T12:00:10Z" />`,
+	}
+	for _, e := range expected {
+		if !strings.Contains(manifest, e) {
+			t.Errorf("Update wrote manifest without %s, got\n%s", e, manifest)
+		}
+	}
+}
+
+func TestMPDUpdateCaptureTime(t *testing.T) {
+	dir := tempDir(t)
+	mpd := MPD{Storage: segment.Dir(dir), Name: "livestream.mpd", UTCTiming: "/camera/time"}
+
+	captured := time.Date(2021, 3, 17, 12, 0, 6, 500000000, time.UTC)
+	segments := []segment.Segment{
+		{Name: "raspilive-1.m4s", Number: 1, Start: 126000, Duration: 180000, Time: captured},
+	}
+
+	if err := mpd.Update(segments, false); err != nil {
+		t.Fatal("Update returned an error", err)
+	}
+
+	data, err := ioutil.ReadFile(path.Join(dir, "livestream.mpd"))
+	if err != nil {
+		t.Fatal("Update did not write manifest", err)
+	}
+	manifest := string(data)
+
+	expected := []string{
+		`availabilityStartTime="2021-03-17T12:00:06Z"`,
+		`<UTCTiming schemeIdUri="urn:mpeg:dash:utc:http-xsdate:2014" value="/camera/time" />`,
+	}
+	for _, e := range expected {
+		if !strings.Contains(manifest, e) {
+			t.Errorf("Update wrote manifest without %s, got\n%s", e, manifest)
+		}
+	}
+}
+
+func TestMPDUpdateThumbnails(t *testing.T) {
+	dir := tempDir(t)
+	mpd := MPD{
+		Storage: segment.Dir(dir),
+		Name:    "livestream.mpd",
+		Thumbnails: Thumbnails{
+			Media:    "thumbnails-$Number$.jpg",
+			Interval: 5,
+			Columns:  5,
+			Rows:     5,
+			Width:    160,
+			Height:   90,
+		},
+	}
+
+	segments := []segment.Segment{{Name: "raspilive-1.m4s", Number: 1, Start: 126000, Duration: 180000}}
+	if err := mpd.Update(segments, false); err != nil {
+		t.Fatal("Update returned an error", err)
+	}
+
+	data, err := ioutil.ReadFile(path.Join(dir, "livestream.mpd"))
+	if err != nil {
+		t.Fatal("Update did not write manifest", err)
+	}
+	manifest := string(data)
+
+	expected := []string{
+		`<AdaptationSet id="1" contentType="image" mimeType="image/jpeg">`,
+		`<SegmentTemplate media="thumbnails-$Number$.jpg" duration="125" startNumber="1" />`,
+		`<Representation id="thumbnails" bandwidth="6400" width="800" height="450">`,
+		`<EssentialProperty schemeIdUri="http://dashif.org/thumbnail_tile" value="5x5" />`,
+	}
+	for _, e := range expected {
+		if !strings.Contains(manifest, e) {
+			t.Errorf("Update wrote manifest without %s, got\n%s", e, manifest)
+		}
+	}
+}
+
+func TestMPDUpdateNoSegments(t *testing.T) {
+	dir := tempDir(t)
+	mpd := MPD{Storage: segment.Dir(dir), Name: "livestream.mpd"}
+
+	if err := mpd.Update(nil, false); err != nil {
+		t.Fatal("Update returned an error", err)
+	}
+
+	if _, err := ioutil.ReadFile(path.Join(dir, "livestream.mpd")); err == nil {
+		t.Error("Update wrote manifest without any segments")
+	}
+}
+
+func TestDuration(t *testing.T) {
+	testCases := []struct {
+		seconds  float64
+		expected string
+	}{
+		{2, "PT2S"},
+		{3.5, "PT3.5S"},
+		{1.0333333, "PT1.033S"},
+	}
+
+	for _, tc := range testCases {
+		if d := duration(tc.seconds); d != tc.expected {
+			t.Error("duration returned incorrect value, got", d)
+		}
+	}
+}
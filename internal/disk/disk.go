@@ -0,0 +1,260 @@
+package disk
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// defaultInterval is how often the guard checks the free space if it is not given an interval.
+const defaultInterval = 10 * time.Second
+
+// ErrCriticallyLow indicates that there is too little free space to start writing video at all.
+var ErrCriticallyLow = errors.New("disk: critically low space")
+
+// Space is the space on a filesystem in bytes.
+type Space struct {
+	Free  int64 // Space available to raspilive
+	Total int64 // Size of the filesystem
+}
+
+// Stat returns the space on the filesystem that holds the directory.
+func Stat(directory string) (Space, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(directory, &stat); err != nil {
+		return Space{}, err
+	}
+
+	return Space{
+		Free:  int64(stat.Bavail) * int64(stat.Bsize),
+		Total: int64(stat.Blocks) * int64(stat.Bsize),
+	}, nil
+}
+
+var stat = Stat
+
+// SameFilesystem reports whether the two directories are on the same filesystem, sharing its space.
+func SameFilesystem(a string, b string) bool {
+	infoA, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	infoB, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+
+	statA, okA := infoA.Sys().(*syscall.Stat_t)
+	statB, okB := infoB.Sys().(*syscall.Stat_t)
+
+	return okA && okB && statA.Dev == statB.Dev
+}
+
+// Pruner removes video to free up space.
+type Pruner interface {
+	// PruneOldest removes the oldest video that may be removed, reporting whether there was any.
+	PruneOldest() (bool, error)
+}
+
+// Options represents ways that the guard may be configured.
+type Options struct {
+	MinFree  int64         // Free space in bytes to maintain by pruning the oldest video
+	Critical int64         // Free space in bytes below which video may not start being written
+	Interval time.Duration // How often to check the free space
+}
+
+// Status is what the guard last saw of the space on the filesystem.
+type Status struct {
+	Directory string `json:"directory"` // Directory on the filesystem
+
+	Free    int64     `json:"free"`    // Space available in bytes
+	Total   int64     `json:"total"`   // Size of the filesystem in bytes
+	MinFree int64     `json:"minFree"` // Free space in bytes that the guard maintains
+	Low     bool      `json:"low"`     // Whether the free space is below the minimum
+	Pruned  int       `json:"pruned"`  // Number of files removed to free up space so far
+	Checked time.Time `json:"checked"` // When the free space was last checked
+}
+
+// Guard keeps the filesystem holding a directory from filling up, which would otherwise bring the muxers down.
+//
+// Whenever the free space drops below the minimum, the guard asks the pruners to remove their oldest video in turn until
+// there is enough space again, warning about it along the way. Segmenters may also be given Shortfall so that they cut
+// back on the segments they keep as they write them. Guards are safe for concurrent use.
+type Guard struct {
+	Directory string
+	Options   Options
+	Pruners   []Pruner // Removers of video to free up space, in the order they are asked
+	mu        sync.Mutex
+	status    Status
+	warned    bool // Whether the space has been warned about being low
+	exhausted bool // Whether the pruners ran out of video to remove while the space was low
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+// Check checks the free space, returning ErrCriticallyLow if there is too little of it to start writing video.
+func (guard *Guard) Check() error {
+	space, err := stat(guard.Directory)
+	if err != nil {
+		return err
+	}
+
+	guard.update(space)
+
+	if space.Free < guard.Options.Critical {
+		return ErrCriticallyLow
+	}
+
+	return nil
+}
+
+// Start begins checking the free space regularly, pruning video whenever it runs low.
+func (guard *Guard) Start() error {
+	if _, err := stat(guard.Directory); err != nil {
+		return errors.New("disk: invalid directory")
+	}
+
+	guard.stop = make(chan struct{})
+	guard.done = make(chan struct{})
+
+	interval := guard.Options.Interval
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+
+	go func() {
+		defer close(guard.done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			guard.guard()
+
+			select {
+			case <-ticker.C:
+			case <-guard.stop:
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Stop stops checking the free space.
+func (guard *Guard) Stop() error {
+	if guard.done == nil {
+		return errors.New("disk: not started")
+	}
+
+	select {
+	case <-guard.stop:
+	default:
+		close(guard.stop)
+	}
+
+	<-guard.done
+
+	return nil
+}
+
+// Status returns what the guard last saw of the space on the filesystem.
+func (guard *Guard) Status() Status {
+	guard.mu.Lock()
+	defer guard.mu.Unlock()
+
+	return guard.status
+}
+
+// Shortfall returns how many bytes need freeing up to get back to the minimum free space, checking it then and there.
+func (guard *Guard) Shortfall() int64 {
+	space, err := stat(guard.Directory)
+	if err != nil {
+		return 0
+	}
+
+	if space.Free >= guard.Options.MinFree {
+		return 0
+	}
+
+	return guard.Options.MinFree - space.Free
+}
+
+// guard checks the free space, pruning the oldest video until there is enough of it.
+func (guard *Guard) guard() {
+	space, err := stat(guard.Directory)
+	if err != nil {
+		log.Debug().Err(err).Str("directory", guard.Directory).Msg("Encountered an error checking disk space")
+		return
+	}
+
+	low := guard.update(space)
+
+	switch {
+	case low && !guard.warned:
+		log.Warn().
+			Int64("free", space.Free/1024/1024).
+			Int64("min-free", guard.Options.MinFree/1024/1024).
+			Str("directory", guard.Directory).
+			Msg("Disk space is low, removing the oldest video")
+		guard.warned = true
+	case !low && guard.warned:
+		log.Info().Int64("free", space.Free/1024/1024).Str("directory", guard.Directory).Msg("Disk space recovered")
+		guard.warned = false
+		guard.exhausted = false
+	}
+
+	for low {
+		pruned := false
+		for _, pruner := range guard.Pruners {
+			ok, err := pruner.PruneOldest()
+			if err != nil {
+				log.Debug().Err(err).Msg("Encountered an error removing video to free up disk space")
+			}
+			if ok {
+				pruned = true
+				break
+			}
+		}
+
+		if !pruned {
+			if !guard.exhausted {
+				log.Warn().
+					Int64("free", space.Free/1024/1024).
+					Str("directory", guard.Directory).
+					Msg("Disk space is low and there is no more video to remove")
+				guard.exhausted = true
+			}
+			return
+		}
+
+		guard.mu.Lock()
+		guard.status.Pruned++
+		guard.mu.Unlock()
+
+		if space, err = stat(guard.Directory); err != nil {
+			return
+		}
+		low = guard.update(space)
+	}
+}
+
+// update takes note of the space on the filesystem, reporting whether it is low.
+func (guard *Guard) update(space Space) bool {
+	guard.mu.Lock()
+	defer guard.mu.Unlock()
+
+	guard.status.Directory = guard.Directory
+	guard.status.Free = space.Free
+	guard.status.Total = space.Total
+	guard.status.MinFree = guard.Options.MinFree
+	guard.status.Low = space.Free < guard.Options.MinFree
+	guard.status.Checked = time.Now().UTC()
+
+	return guard.status.Low
+}
@@ -0,0 +1,173 @@
+package disk
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+// fakeDisk is a filesystem whose free space goes up as its video is pruned.
+type fakeDisk struct {
+	free   int64
+	files  int   // Number of files that may be pruned
+	size   int64 // Size of each file
+	pruned int
+}
+
+func (disk *fakeDisk) PruneOldest() (bool, error) {
+	if disk.files == 0 {
+		return false, nil
+	}
+
+	disk.files--
+	disk.free += disk.size
+	disk.pruned++
+
+	return true, nil
+}
+
+func stubStat(t *testing.T, disk *fakeDisk) {
+	original := stat
+	stat = func(directory string) (Space, error) { return Space{Free: disk.free, Total: 1000}, nil }
+	t.Cleanup(func() { stat = original })
+}
+
+func TestStat(t *testing.T) {
+	dir, err := ioutil.TempDir("", "raspilive-disk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	space, err := Stat(dir)
+	if err != nil {
+		t.Fatal("Stat returned an error", err)
+	}
+	if space.Total <= 0 || space.Free < 0 || space.Free > space.Total {
+		t.Error("Stat returned incorrect space, got", space)
+	}
+}
+
+func TestStatInvalidDirectory(t *testing.T) {
+	if _, err := Stat("/nonexistent/raspilive"); err == nil {
+		t.Error("Stat did not return an error")
+	}
+}
+
+func TestSameFilesystem(t *testing.T) {
+	dir, err := ioutil.TempDir("", "raspilive-disk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if !SameFilesystem(dir, os.TempDir()) {
+		t.Error("SameFilesystem reported a directory on a different filesystem from its parent")
+	}
+	if SameFilesystem(dir, "/nonexistent/raspilive") {
+		t.Error("SameFilesystem reported a missing directory on the same filesystem")
+	}
+}
+
+func TestGuardPrunes(t *testing.T) {
+	disk := &fakeDisk{free: 100, files: 10, size: 50}
+	stubStat(t, disk)
+
+	guard := &Guard{Options: Options{MinFree: 300}, Pruners: []Pruner{disk}}
+	guard.guard()
+
+	if disk.pruned != 4 {
+		t.Error("Guard pruned incorrect number of files, got", disk.pruned)
+	}
+
+	status := guard.Status()
+	if status.Low || status.Free != 300 || status.Pruned != 4 {
+		t.Error("Guard returned incorrect status, got", status)
+	}
+}
+
+func TestGuardPrunesInOrder(t *testing.T) {
+	first := &fakeDisk{free: 100, files: 1, size: 50}
+	second := &fakeDisk{free: 100, files: 10, size: 50}
+	stubStat(t, first)
+
+	guard := &Guard{Options: Options{MinFree: 200}, Pruners: []Pruner{first, second}}
+	guard.guard()
+
+	// The first pruner is asked until it runs out of video, then the second, which frees up space elsewhere in this test
+	if first.pruned != 1 || second.pruned != 10 {
+		t.Error("Guard pruned in incorrect order, got", first.pruned, second.pruned)
+	}
+	if !guard.Status().Low {
+		t.Error("Guard did not report low space")
+	}
+}
+
+func TestGuardEnoughSpace(t *testing.T) {
+	disk := &fakeDisk{free: 500, files: 10, size: 50}
+	stubStat(t, disk)
+
+	guard := &Guard{Options: Options{MinFree: 300}, Pruners: []Pruner{disk}}
+	guard.guard()
+
+	if disk.pruned != 0 {
+		t.Error("Guard pruned with enough space, got", disk.pruned)
+	}
+}
+
+func TestGuardShortfall(t *testing.T) {
+	disk := &fakeDisk{free: 100}
+	stubStat(t, disk)
+
+	guard := &Guard{Options: Options{MinFree: 300}}
+	if shortfall := guard.Shortfall(); shortfall != 200 {
+		t.Error("Shortfall returned incorrect shortfall, got", shortfall)
+	}
+
+	disk.free = 400
+	if shortfall := guard.Shortfall(); shortfall != 0 {
+		t.Error("Shortfall returned incorrect shortfall, got", shortfall)
+	}
+}
+
+func TestGuardCheck(t *testing.T) {
+	disk := &fakeDisk{free: 100}
+	stubStat(t, disk)
+
+	guard := &Guard{Options: Options{MinFree: 300, Critical: 150}}
+	if err := guard.Check(); err != ErrCriticallyLow {
+		t.Error("Check returned incorrect error, got", err)
+	}
+
+	disk.free = 200
+	if err := guard.Check(); err != nil {
+		t.Error("Check returned an error", err)
+	}
+}
+
+func TestGuardStartStop(t *testing.T) {
+	disk := &fakeDisk{free: 100, files: 10, size: 50}
+	stubStat(t, disk)
+
+	guard := &Guard{Options: Options{MinFree: 300, Interval: time.Hour}, Pruners: []Pruner{disk}}
+	if err := guard.Start(); err != nil {
+		t.Fatal("Start returned an error", err)
+	}
+	if err := guard.Stop(); err != nil {
+		t.Fatal("Stop returned an error", err)
+	}
+
+	// The space is checked as soon as the guard starts
+	if disk.pruned != 4 {
+		t.Error("Guard pruned incorrect number of files, got", disk.pruned)
+	}
+}
+
+func TestStopNotStarted(t *testing.T) {
+	guard := &Guard{}
+
+	if err := guard.Stop(); err == nil || err.Error() != "disk: not started" {
+		t.Error("Stop returned incorrect error, got", err)
+	}
+}
@@ -0,0 +1,78 @@
+package export
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jaredpetersen/raspilive/internal/segment"
+)
+
+// defaultCapacity is the number of segments a catalog keeps track of if it is not given a capacity, which is a day of
+// two second segments.
+const defaultCapacity = 43200
+
+// Catalog keeps track of the segments written by the segmenter so that footage may be exported from them for as long
+// as they are kept in storage, including after they slide out of the manifests.
+//
+// Catalogs are safe for concurrent use.
+type Catalog struct {
+	Capacity int // Maximum number of segments to keep track of, dropping the oldest past it
+	mu       sync.Mutex
+	init     string
+	segments []segment.Segment
+}
+
+// SetInit sets the file name of the initialization segment that goes with fragmented MP4 segments.
+func (catalog *Catalog) SetInit(name string) {
+	catalog.mu.Lock()
+	defer catalog.mu.Unlock()
+
+	catalog.init = name
+}
+
+// Init returns the file name of the initialization segment, or an empty string if the segments are MPEG-TS.
+func (catalog *Catalog) Init() string {
+	catalog.mu.Lock()
+	defer catalog.mu.Unlock()
+
+	return catalog.init
+}
+
+// Update takes note of the segments that have not been seen before.
+func (catalog *Catalog) Update(segments []segment.Segment, ended bool) error {
+	catalog.mu.Lock()
+	defer catalog.mu.Unlock()
+
+	for _, seg := range segments {
+		if n := len(catalog.segments); n > 0 && !seg.Time.After(catalog.segments[n-1].Time) {
+			continue
+		}
+		catalog.segments = append(catalog.segments, seg)
+	}
+
+	capacity := catalog.Capacity
+	if capacity <= 0 {
+		capacity = defaultCapacity
+	}
+	if len(catalog.segments) > capacity {
+		catalog.segments = append([]segment.Segment{}, catalog.segments[len(catalog.segments)-capacity:]...)
+	}
+
+	return nil
+}
+
+// Range returns the segments that were captured at any point between the start and end times, oldest first.
+func (catalog *Catalog) Range(start time.Time, end time.Time) []segment.Segment {
+	catalog.mu.Lock()
+	defer catalog.mu.Unlock()
+
+	var segments []segment.Segment
+	for _, seg := range catalog.segments {
+		segEnd := seg.Time.Add(time.Duration(seg.Seconds() * float64(time.Second)))
+		if seg.Time.Before(end) && segEnd.After(start) {
+			segments = append(segments, seg)
+		}
+	}
+
+	return segments
+}
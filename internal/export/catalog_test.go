@@ -0,0 +1,79 @@
+package export
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jaredpetersen/raspilive/internal/segment"
+)
+
+// segmentsFrom builds two second segments captured one after another from the given time.
+func segmentsFrom(start time.Time, numbers ...int) []segment.Segment {
+	var segments []segment.Segment
+	for _, number := range numbers {
+		segments = append(segments, segment.Segment{
+			Number:   number,
+			Time:     start.Add(time.Duration(number*2) * time.Second),
+			Duration: 2 * segment.Timescale,
+		})
+	}
+
+	return segments
+}
+
+func TestCatalog(t *testing.T) {
+	start := time.Date(2021, 3, 17, 12, 0, 0, 0, time.UTC)
+	catalog := &Catalog{Capacity: 4}
+
+	// Manifests are given a sliding window of segments, which overlap from one update to the next
+	catalog.Update(segmentsFrom(start, 0, 1, 2), false)
+	catalog.Update(segmentsFrom(start, 1, 2, 3), false)
+	catalog.Update(segmentsFrom(start, 3, 4), true)
+
+	segments := catalog.Range(start, start.Add(time.Hour))
+	if len(segments) != 4 {
+		t.Fatal("Catalog kept track of incorrect number of segments, got", len(segments))
+	}
+	for i, seg := range segments {
+		if seg.Number != i+1 {
+			t.Errorf("Catalog kept incorrect segment %d, got number %d", i, seg.Number)
+		}
+	}
+}
+
+func TestCatalogRange(t *testing.T) {
+	start := time.Date(2021, 3, 17, 12, 0, 0, 0, time.UTC)
+	catalog := &Catalog{}
+	catalog.Update(segmentsFrom(start, 0, 1, 2, 3, 4), false)
+
+	testCases := []struct {
+		name     string
+		start    time.Duration
+		end      time.Duration
+		expected []int
+	}{
+		{"partial segments", 3 * time.Second, 5 * time.Second, []int{1, 2}},
+		{"segment boundaries", 2 * time.Second, 6 * time.Second, []int{1, 2}},
+		{"before", -10 * time.Second, 0, nil},
+		{"after", 10 * time.Second, 20 * time.Second, nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			segments := catalog.Range(start.Add(tc.start), start.Add(tc.end))
+
+			var numbers []int
+			for _, seg := range segments {
+				numbers = append(numbers, seg.Number)
+			}
+			if len(numbers) != len(tc.expected) {
+				t.Fatal("Range returned incorrect segments, got", numbers)
+			}
+			for i := range numbers {
+				if numbers[i] != tc.expected[i] {
+					t.Fatal("Range returned incorrect segments, got", numbers)
+				}
+			}
+		})
+	}
+}
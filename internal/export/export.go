@@ -0,0 +1,175 @@
+package export
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+
+	"github.com/jaredpetersen/raspilive/internal/fmp4"
+	"github.com/jaredpetersen/raspilive/internal/h264"
+	"github.com/jaredpetersen/raspilive/internal/mpegts"
+	"github.com/jaredpetersen/raspilive/internal/segment"
+)
+
+// ErrNoVideo indicates that none of the segments to export could be found in storage.
+var ErrNoVideo = errors.New("export: no video")
+
+// frame is a single frame of video read back out of a segment.
+type frame struct {
+	duration int64 // Duration in segment.Timescale ticks
+	nalus    [][]byte
+	keyframe bool
+}
+
+// Write remuxes the segments into a single MP4 file written to w, copying the video as is.
+//
+// Segments are read from the file system, skipping any that have been removed from storage since they were written.
+// They are read as MPEG-TS if init is empty, and as fragmented MP4 that goes with the named initialization segment
+// otherwise. Timestamps start over from zero and carry straight on across any gaps between the segments, such as
+// from a restart.
+//
+// Nothing is written if none of the segments could be found, returning ErrNoVideo.
+func Write(w io.Writer, files http.FileSystem, init string, segments []segment.Segment) error {
+	var track fmp4.Track
+	if init != "" {
+		data, err := readFile(files, init)
+		if os.IsNotExist(err) {
+			return ErrNoVideo
+		}
+		if err != nil {
+			return err
+		}
+
+		track, err = fmp4.ReadInit(data)
+		if err != nil {
+			return err
+		}
+	}
+
+	format := &segment.MP4{}
+	format.Begin(w, 0, 0)
+
+	var pts int64
+	written := false
+
+	for _, seg := range segments {
+		data, err := readFile(files, seg.Name)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		var frames []frame
+		if init != "" {
+			frames, err = readFMP4(data, track)
+		} else {
+			frames, err = readTS(data, seg)
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, frame := range frames {
+			if err := format.Write(pts, frame.duration, frame.nalus, frame.keyframe); err != nil {
+				return err
+			}
+			pts += frame.duration
+			written = true
+		}
+	}
+
+	if !written {
+		return ErrNoVideo
+	}
+
+	return format.End()
+}
+
+// readTS reads the frames out of an MPEG-TS segment, each of which already begins with the parameter sets if it is a
+// keyframe.
+func readTS(data []byte, seg segment.Segment) ([]frame, error) {
+	var timestamps []int64
+	var frames []frame
+
+	err := mpegts.ReadVideo(bytes.NewReader(data), func(pts int64, data []byte) error {
+		var f frame
+
+		scanner := h264.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			nalu := scanner.Bytes()
+			switch h264.Type(nalu) {
+			case h264.TypeAUD:
+				continue
+			case h264.TypeIDR:
+				f.keyframe = true
+			}
+			f.nalus = append(f.nalus, append([]byte{}, nalu...))
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+
+		timestamps = append(timestamps, pts)
+		frames = append(frames, f)
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Each frame lasts until the next one, and the last one until the end of the segment, minding that timestamps
+	// wrap around after 33 bits. The segment is taken to start with its first frame, as segments written by Ffmpeg do
+	// not say when they start.
+	for i := range frames {
+		end := timestamps[0] + seg.Duration
+		if i+1 < len(timestamps) {
+			end = timestamps[i+1]
+		}
+		frames[i].duration = (end - timestamps[i]) & (1<<33 - 1)
+	}
+
+	return frames, nil
+}
+
+// readFMP4 reads the frames out of a fragmented MP4 segment, giving the keyframes the parameter sets from the track.
+func readFMP4(data []byte, track fmp4.Track) ([]frame, error) {
+	frag, err := fmp4.ReadFragment(data)
+	if err != nil {
+		return nil, err
+	}
+
+	frames := make([]frame, 0, len(frag.Samples))
+	for _, sample := range frag.Samples {
+		nalus, err := fmp4.NALUnits(sample.Data)
+		if err != nil {
+			return nil, err
+		}
+		if sample.Keyframe {
+			nalus = append([][]byte{track.SPS, track.PPS}, nalus...)
+		}
+
+		frames = append(frames, frame{
+			duration: int64(sample.Duration) * segment.Timescale / int64(track.Timescale),
+			nalus:    nalus,
+			keyframe: sample.Keyframe,
+		})
+	}
+
+	return frames, nil
+}
+
+func readFile(files http.FileSystem, name string) ([]byte, error) {
+	file, err := files.Open("/" + name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ioutil.ReadAll(file)
+}
@@ -0,0 +1,170 @@
+package export
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/jaredpetersen/raspilive/internal/fmp4"
+	"github.com/jaredpetersen/raspilive/internal/h264"
+	"github.com/jaredpetersen/raspilive/internal/segment"
+)
+
+var (
+	fakeSPS = []byte{
+		0x67, 0x64, 0x00, 0x1F, 0xAC, 0xD9, 0x40, 0x50, 0x05, 0xBB, 0x01, 0x10, 0x00, 0x00, 0x03, 0x00,
+		0x10, 0x00, 0x00, 0x03, 0x03, 0xC0, 0xF1, 0x83, 0x19, 0x60,
+	}
+	fakePPS    = []byte{0x68, 0xEE, 0x3C, 0x80}
+	fakeIDR    = []byte{0x65, 0x88, 0x84}
+	fakeNonIDR = []byte{0x41, 0x9A, 0x02}
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "raspilive-export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	return dir
+}
+
+// writeSegments segments the frames in the format, with a keyframe every second, keeping track of them in the
+// catalog.
+func writeSegments(t *testing.T, storage segment.Storage, format segment.Format, catalog *Catalog, frames int) {
+	seg := segment.New(storage, format, segment.Options{Fps: 30, SegmentTime: 1, PlaylistSize: 2}, catalog)
+
+	for i := 0; i < frames; i++ {
+		au := h264.AccessUnit{NALUnits: [][]byte{fakeNonIDR}}
+		if i%30 == 0 {
+			au = h264.AccessUnit{NALUnits: [][]byte{fakeIDR}}
+		}
+		if i == 0 {
+			au = h264.AccessUnit{NALUnits: [][]byte{fakeSPS, fakePPS, fakeIDR}}
+		}
+
+		if err := seg.Write(au); err != nil {
+			t.Fatal("Write returned an error", err)
+		}
+	}
+
+	if err := seg.Close(); err != nil {
+		t.Fatal("Close returned an error", err)
+	}
+}
+
+// readExport reads the fragments back out of the exported MP4 file, checking that it begins with an initialization
+// segment.
+func readExport(t *testing.T, data []byte) []fmp4.Fragment {
+	var fragments []fmp4.Fragment
+	start := -1
+
+	for offset := 0; offset < len(data); {
+		size := int(binary.BigEndian.Uint32(data[offset:]))
+		typ := string(data[offset+4 : offset+8])
+
+		switch typ {
+		case "moov":
+			track, err := fmp4.ReadInit(data[:offset+size])
+			if err != nil || !bytes.Equal(track.SPS, fakeSPS) || !bytes.Equal(track.PPS, fakePPS) {
+				t.Fatal("Export wrote incorrect initialization segment", err)
+			}
+		case "styp":
+			start = offset
+		case "mdat":
+			fragment, err := fmp4.ReadFragment(data[start : offset+size])
+			if err != nil {
+				t.Fatal("Export wrote invalid fragment", err)
+			}
+			fragments = append(fragments, fragment)
+		}
+
+		offset += size
+	}
+
+	return fragments
+}
+
+func TestWrite(t *testing.T) {
+	testCases := []struct {
+		name   string
+		init   string
+		format func(storage segment.Storage) segment.Format
+	}{
+		{"mpegts", "", func(storage segment.Storage) segment.Format {
+			return &segment.TS{Pattern: "raspilive-%d.ts"}
+		}},
+		{"fmp4", "init.mp4", func(storage segment.Storage) segment.Format {
+			return &segment.FMP4{Storage: storage, InitName: "init.mp4", Pattern: "raspilive-%d.m4s"}
+		}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := tempDir(t)
+			catalog := &Catalog{}
+			catalog.SetInit(tc.init)
+
+			writeSegments(t, segment.Dir(dir), tc.format(segment.Dir(dir)), catalog, 120)
+
+			// Skip the first segment and lose the third from storage
+			segments := catalog.Range(time.Time{}, time.Now().Add(time.Hour))
+			if len(segments) != 4 {
+				t.Fatal("Catalog kept track of incorrect number of segments, got", len(segments))
+			}
+			os.Remove(path.Join(dir, segments[2].Name))
+
+			var exported bytes.Buffer
+			if err := Write(&exported, http.Dir(dir), catalog.Init(), segments[1:]); err != nil {
+				t.Fatal("Write returned an error", err)
+			}
+
+			fragments := readExport(t, exported.Bytes())
+			if len(fragments) != 2 {
+				t.Fatal("Write wrote incorrect number of fragments, got", len(fragments))
+			}
+
+			var decodeTime uint64
+			for i, fragment := range fragments {
+				if fragment.BaseMediaDecodeTime != decodeTime {
+					t.Errorf("Write wrote incorrect decode time for fragment %d, got %d", i, fragment.BaseMediaDecodeTime)
+				}
+				if len(fragment.Samples) != 30 || !fragment.Samples[0].Keyframe || fragment.Samples[1].Keyframe {
+					t.Errorf("Write wrote incorrect samples for fragment %d", i)
+				}
+
+				for _, sample := range fragment.Samples {
+					if sample.Duration != 3000 {
+						t.Error("Write wrote incorrect sample duration, got", sample.Duration)
+					}
+					decodeTime += uint64(sample.Duration)
+
+					// Parameter sets live in the initialization segment, and access unit delimiters are left out
+					nalus, _ := fmp4.NALUnits(sample.Data)
+					if len(nalus) != 1 {
+						t.Error("Write wrote incorrect NAL units, got", len(nalus))
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestWriteNoVideo(t *testing.T) {
+	dir := tempDir(t)
+	segments := []segment.Segment{{Name: "raspilive-1.ts", Duration: 90000}}
+
+	var exported bytes.Buffer
+	if err := Write(&exported, http.Dir(dir), "", segments); err != ErrNoVideo {
+		t.Error("Write returned incorrect error, got", err)
+	}
+	if exported.Len() != 0 {
+		t.Error("Write wrote to the output without any video")
+	}
+}
@@ -0,0 +1,136 @@
+package abr
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Codec is the RFC 6381 codec of the transcoded renditions, which are encoded in the Main profile at level 4.0 so that
+// they play back on just about anything.
+const Codec = "avc1.4D4028"
+
+// level is the H.264 level that the renditions are encoded at, matching Codec, as Ffmpeg understands it numerically.
+const level = "40"
+
+// Encoders that renditions may be transcoded with, in order of preference.
+const (
+	EncoderV4L2M2M = "h264_v4l2m2m" // Hardware encoder on the Raspberry Pi
+	EncoderX264    = "libx264"      // Software encoder
+)
+
+var execCommand = exec.Command
+
+// Rendition is a lower quality copy of the video transcoded by Ffmpeg for adaptive bitrate streaming.
+type Rendition struct {
+	Width   int // Width of the video in pixels
+	Height  int // Height of the video in pixels
+	Bitrate int // Target bitrate of the video in bits per second
+}
+
+// ParseRendition parses a rendition described as WIDTHxHEIGHT:BITRATE, where the bitrate may be suffixed with k or M,
+// such as "640x360:800k".
+func ParseRendition(s string) (Rendition, error) {
+	invalid := errors.New("ffmpeg abr: invalid rendition")
+
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 {
+		return Rendition{}, invalid
+	}
+
+	size := strings.Split(strings.ToLower(parts[0]), "x")
+	if len(size) != 2 {
+		return Rendition{}, invalid
+	}
+
+	width, err := strconv.Atoi(size[0])
+	if err != nil || width <= 0 || width%2 != 0 {
+		return Rendition{}, invalid
+	}
+
+	height, err := strconv.Atoi(size[1])
+	if err != nil || height <= 0 || height%2 != 0 {
+		return Rendition{}, invalid
+	}
+
+	bitrate, err := parseBitrate(parts[1])
+	if err != nil || bitrate <= 0 {
+		return Rendition{}, invalid
+	}
+
+	return Rendition{Width: width, Height: height, Bitrate: bitrate}, nil
+}
+
+func parseBitrate(s string) (int, error) {
+	multiplier := 1.0
+	switch {
+	case strings.HasSuffix(s, "k"):
+		multiplier = 1000
+		s = strings.TrimSuffix(s, "k")
+	case strings.HasSuffix(s, "M"):
+		multiplier = 1000000
+		s = strings.TrimSuffix(s, "M")
+	}
+
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(value * multiplier), nil
+}
+
+func (rendition Rendition) String() string {
+	return fmt.Sprintf("%dx%d:%d", rendition.Width, rendition.Height, rendition.Bitrate)
+}
+
+// Peak returns the highest bitrate in bits per second that the rendition is allowed to reach, which is what players
+// must be told to expect.
+func (rendition Rendition) Peak() int {
+	return rendition.Bitrate * 3 / 2
+}
+
+// Args returns the Ffmpeg arguments that transcode the output video stream with the given index into the rendition.
+//
+// Keyframes are forced at every segment boundary so that players may switch between renditions cleanly.
+func (rendition Rendition) Args(stream int, encoder string, segmentTime int) []string {
+	spec := "v:" + strconv.Itoa(stream)
+
+	args := []string{
+		"-c:" + spec, encoder,
+		"-b:" + spec, strconv.Itoa(rendition.Bitrate),
+		"-maxrate:" + spec, strconv.Itoa(rendition.Peak()),
+		"-bufsize:" + spec, strconv.Itoa(rendition.Bitrate * 2),
+		"-s:" + spec, fmt.Sprintf("%dx%d", rendition.Width, rendition.Height),
+		"-pix_fmt:" + spec, "yuv420p",
+		"-force_key_frames:" + spec, fmt.Sprintf("expr:gte(t,n_forced*%d)", segmentTime),
+	}
+
+	if encoder == EncoderX264 {
+		args = append(args, "-profile:"+spec, "main", "-level:"+spec, level, "-preset:"+spec, "veryfast")
+	} else {
+		// The hardware encoder only understands the numeric profile, which is Main
+		args = append(args, "-profile:"+spec, "77", "-level:"+spec, level)
+	}
+
+	return args
+}
+
+// Encoder picks the H.264 encoder to transcode renditions with, preferring the hardware encoder if Ffmpeg has it.
+func Encoder() string {
+	out, err := execCommand("ffmpeg", "-hide_banner", "-encoders").Output()
+	if err != nil {
+		return EncoderX264
+	}
+
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[1] == EncoderV4L2M2M {
+			return EncoderV4L2M2M
+		}
+	}
+
+	return EncoderX264
+}
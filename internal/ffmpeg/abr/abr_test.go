@@ -0,0 +1,147 @@
+package abr
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+)
+
+const fakeEncoders = `Encoders:
+ V..... = Video
+ ------
+ V....D libx264              libx264 H.264 / AVC / MPEG-4 AVC / MPEG-4 part 10 (codec h264)
+ V..... h264_v4l2m2m         V4L2 mem2mem H.264 encoder wrapper (codec h264)
+`
+
+func TestMain(m *testing.M) {
+	switch os.Getenv("GO_TEST_MODE") {
+	case "":
+		os.Exit(m.Run())
+	case "ffmpeg":
+		os.Stdout.WriteString(fakeEncoders)
+		os.Exit(0)
+	case "ffmpeg-software":
+		os.Stdout.WriteString(" V....D libx264              libx264 H.264 / AVC / MPEG-4 AVC / MPEG-4 part 10 (codec h264)\n")
+		os.Exit(0)
+	}
+}
+
+func TestParseRendition(t *testing.T) {
+	testCases := []struct {
+		value    string
+		expected Rendition
+	}{
+		{"640x360:800k", Rendition{Width: 640, Height: 360, Bitrate: 800000}},
+		{"1280X720:2.5M", Rendition{Width: 1280, Height: 720, Bitrate: 2500000}},
+		{"426x240:400000", Rendition{Width: 426, Height: 240, Bitrate: 400000}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.value, func(t *testing.T) {
+			rendition, err := ParseRendition(tc.value)
+			if err != nil {
+				t.Fatal("ParseRendition returned an error", err)
+			}
+			if rendition != tc.expected {
+				t.Error("ParseRendition returned incorrect rendition, got", rendition)
+			}
+		})
+	}
+}
+
+func TestParseRenditionInvalid(t *testing.T) {
+	for _, value := range []string{"", "640x360", "640:800k", "641x360:800k", "640x360:fast", "640x360:0", "axb:800k"} {
+		t.Run(value, func(t *testing.T) {
+			if _, err := ParseRendition(value); err == nil {
+				t.Error("ParseRendition did not return an error")
+			}
+		})
+	}
+}
+
+func TestArgs(t *testing.T) {
+	rendition := Rendition{Width: 640, Height: 360, Bitrate: 800000}
+
+	expected := []string{
+		"-c:v:1", "libx264",
+		"-b:v:1", "800000",
+		"-maxrate:v:1", "1200000",
+		"-bufsize:v:1", "1600000",
+		"-s:v:1", "640x360",
+		"-pix_fmt:v:1", "yuv420p",
+		"-force_key_frames:v:1", "expr:gte(t,n_forced*2)",
+		"-profile:v:1", "main",
+		"-level:v:1", "40",
+		"-preset:v:1", "veryfast",
+	}
+	if args := rendition.Args(1, EncoderX264, 2); !equal(args, expected) {
+		t.Error("Args returned incorrect arguments, got", args)
+	}
+
+	expected = []string{
+		"-c:v:2", "h264_v4l2m2m",
+		"-b:v:2", "800000",
+		"-maxrate:v:2", "1200000",
+		"-bufsize:v:2", "1600000",
+		"-s:v:2", "640x360",
+		"-pix_fmt:v:2", "yuv420p",
+		"-force_key_frames:v:2", "expr:gte(t,n_forced*4)",
+		"-profile:v:2", "77",
+		"-level:v:2", "40",
+	}
+	if args := rendition.Args(2, EncoderV4L2M2M, 4); !equal(args, expected) {
+		t.Error("Args returned incorrect arguments, got", args)
+	}
+}
+
+func TestEncoderPrefersHardware(t *testing.T) {
+	execCommand = mockExecCommand("ffmpeg")
+	defer func() { execCommand = exec.Command }()
+
+	if encoder := Encoder(); encoder != EncoderV4L2M2M {
+		t.Error("Encoder returned incorrect encoder, got", encoder)
+	}
+}
+
+func TestEncoderFallsBackToSoftware(t *testing.T) {
+	execCommand = mockExecCommand("ffmpeg-software")
+	defer func() { execCommand = exec.Command }()
+
+	if encoder := Encoder(); encoder != EncoderX264 {
+		t.Error("Encoder returned incorrect encoder, got", encoder)
+	}
+}
+
+func TestEncoderFallsBackToSoftwareOnError(t *testing.T) {
+	execCommand = func(command string, args ...string) *exec.Cmd {
+		return exec.Command("totallyfakecommandthatdoesnotexist")
+	}
+	defer func() { execCommand = exec.Command }()
+
+	if encoder := Encoder(); encoder != EncoderX264 {
+		t.Error("Encoder returned incorrect encoder, got", encoder)
+	}
+}
+
+func mockExecCommand(mode string) func(command string, args ...string) *exec.Cmd {
+	return func(command string, args ...string) *exec.Cmd {
+		cs := append([]string{command}, args...)
+		cmd := exec.Command(os.Args[0], cs...)
+		cmd.Env = append(os.Environ(), "GO_TEST_MODE="+mode)
+		return cmd
+	}
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,88 @@
+package audio
+
+import (
+	"errors"
+	"strconv"
+)
+
+// Codecs that the audio may be encoded with.
+const (
+	CodecAAC  = "aac"
+	CodecOpus = "opus"
+)
+
+// FormatALSA is the Ffmpeg input format of ALSA devices, such as USB microphones.
+const FormatALSA = "alsa"
+
+// Input is an audio source that Ffmpeg captures and encodes alongside the video, such as an ALSA microphone.
+//
+// Ffmpeg will step in and use its own defaults if a value is not provided.
+type Input struct {
+	Device     string // Device to capture from, such as plughw:1,0 for ALSA, or a source like sine for lavfi
+	Format     string // Ffmpeg input format of the device, ALSA if not provided
+	Codec      string // Codec to encode the audio with, AAC if not provided
+	SampleRate int    // Sample rate in hertz
+	Channels   int    // Number of channels
+	Bitrate    int    // Target bitrate in bits per second
+}
+
+// SyncArgs are the input arguments that keep the audio and video in sync.
+//
+// Raspivid's video carries no timestamps of its own, so both inputs are stamped with the wall clock as they come in.
+var SyncArgs = []string{"-use_wallclock_as_timestamps", "1", "-thread_queue_size", "1024"}
+
+// InputArgs returns the arguments that add the device as an input to Ffmpeg, stamped with the wall clock like the
+// video.
+func (input Input) InputArgs() []string {
+	format := input.Format
+	if format == "" {
+		format = FormatALSA
+	}
+
+	args := append([]string{}, SyncArgs...)
+	args = append(args, "-f", format)
+
+	// Sources other than devices, like lavfi, are configured in the device itself
+	if format == FormatALSA {
+		if input.SampleRate != 0 {
+			args = append(args, "-sample_rate", strconv.Itoa(input.SampleRate))
+		}
+		if input.Channels != 0 {
+			args = append(args, "-channels", strconv.Itoa(input.Channels))
+		}
+	}
+
+	return append(args, "-i", input.Device)
+}
+
+// CodecArgs returns the arguments that encode the audio.
+//
+// Microphones drift away from the camera's clock over time, so the audio is stretched or squeezed to match the
+// timestamps that it was given.
+func (input Input) CodecArgs() ([]string, error) {
+	var encoder string
+	switch input.Codec {
+	case "", CodecAAC:
+		encoder = "aac"
+	case CodecOpus:
+		encoder = "libopus"
+	default:
+		return nil, errors.New("ffmpeg audio: invalid codec")
+	}
+
+	args := []string{"-c:a", encoder}
+
+	if input.Bitrate != 0 {
+		args = append(args, "-b:a", strconv.Itoa(input.Bitrate))
+	}
+
+	if input.SampleRate != 0 {
+		args = append(args, "-ar", strconv.Itoa(input.SampleRate))
+	}
+
+	if input.Channels != 0 {
+		args = append(args, "-ac", strconv.Itoa(input.Channels))
+	}
+
+	return append(args, "-af", "aresample=async=1000"), nil
+}
@@ -0,0 +1,116 @@
+package audio
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestInputArgs(t *testing.T) {
+	testCases := []struct {
+		input        Input
+		expectedArgs []string
+	}{
+		{
+			Input{Device: "plughw:1,0"},
+			[]string{
+				"-use_wallclock_as_timestamps", "1",
+				"-thread_queue_size", "1024",
+				"-f", "alsa",
+				"-i", "plughw:1,0",
+			},
+		},
+		{
+			Input{Device: "plughw:1,0", SampleRate: 44100, Channels: 2},
+			[]string{
+				"-use_wallclock_as_timestamps", "1",
+				"-thread_queue_size", "1024",
+				"-f", "alsa",
+				"-sample_rate", "44100",
+				"-channels", "2",
+				"-i", "plughw:1,0",
+			},
+		},
+		{
+			Input{Device: "sine=frequency=440", Format: "lavfi", SampleRate: 44100, Channels: 2},
+			[]string{
+				"-use_wallclock_as_timestamps", "1",
+				"-thread_queue_size", "1024",
+				"-f", "lavfi",
+				"-i", "sine=frequency=440",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%v", tc.input), func(t *testing.T) {
+			args := tc.input.InputArgs()
+
+			if !equal(args, tc.expectedArgs) {
+				t.Error("Input args do not match, got", args, "but wanted", tc.expectedArgs)
+			}
+		})
+	}
+}
+
+func TestCodecArgs(t *testing.T) {
+	testCases := []struct {
+		input        Input
+		expectedArgs []string
+	}{
+		{
+			Input{Device: "plughw:1,0"},
+			[]string{"-c:a", "aac", "-af", "aresample=async=1000"},
+		},
+		{
+			Input{Device: "plughw:1,0", Codec: CodecAAC, SampleRate: 48000, Channels: 1, Bitrate: 64000},
+			[]string{
+				"-c:a", "aac",
+				"-b:a", "64000",
+				"-ar", "48000",
+				"-ac", "1",
+				"-af", "aresample=async=1000",
+			},
+		},
+		{
+			Input{Device: "plughw:1,0", Codec: CodecOpus, Bitrate: 32000},
+			[]string{"-c:a", "libopus", "-b:a", "32000", "-af", "aresample=async=1000"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%v", tc.input), func(t *testing.T) {
+			args, err := tc.input.CodecArgs()
+
+			if err != nil {
+				t.Error("CodecArgs produced an err", err)
+			}
+
+			if !equal(args, tc.expectedArgs) {
+				t.Error("Codec args do not match, got", args, "but wanted", tc.expectedArgs)
+			}
+		})
+	}
+}
+
+func TestCodecArgsInvalidCodecReturnsError(t *testing.T) {
+	input := Input{Device: "plughw:1,0", Codec: "mp3"}
+	_, err := input.CodecArgs()
+
+	if err == nil || err.Error() != "ffmpeg audio: invalid codec" {
+		t.Error("CodecArgs failed to return an error for invalid codec", err)
+	}
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -3,40 +3,93 @@ package dash
 import (
 	"errors"
 	"io"
+	"io/ioutil"
+	"os"
 	"os/exec"
 	"path"
 	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jaredpetersen/raspilive/internal/ffmpeg/abr"
+	"github.com/jaredpetersen/raspilive/internal/ffmpeg/audio"
 )
 
 // Options represents ways that Ffmpeg may be configured to mux video to DASH.
 //
 // Ffmpeg will step in and use its own defaults if a value is not provided.
 type Options struct {
-	Fps          int // Framerate of the output video
-	SegmentTime  int // Segment length target duration in seconds
-	PlaylistSize int // Maximum number of playlist entries
-	StorageSize  int // Maximum number of unreferenced segments to keep on disk before removal
+	Fps          int             // Framerate of the output video
+	SegmentTime  int             // Segment length target duration in seconds
+	PlaylistSize int             // Maximum number of playlist entries
+	StorageSize  int             // Maximum number of unreferenced segments to keep on disk before removal
+	Renditions   []abr.Rendition // Lower quality renditions to transcode alongside the original video
+	Encoder      string          // Encoder for the renditions, picking the best available one if not provided
+	UTCTimingURL string          // URL that players may fetch the time from to sync their clocks, as an xs:dateTime
+
+	// EpochNumbers names the segments with the seconds since the Unix epoch that the session started at, as Ffmpeg
+	// always numbers DASH segments from one, so that they never collide with those of earlier sessions
+	EpochNumbers bool
+
+	Audio audio.Input // Audio to capture alongside the video, left out if there is no device
 }
 
 // Muxer represents the DASH muxer.
 type Muxer struct {
 	Directory string
+	URL       string // Base URL to upload the video to with HTTP PUT in place of the directory
 	Options   Options
 	cmd       *exec.Cmd
 }
 
 var execCommand = exec.Command
 
+var now = time.Now
+
 // Mux begins muxing the video stream to the DASH format.
 func (muxer *Muxer) Mux(video io.ReadCloser) error {
-	args := []string{
-		"-i", "pipe:0",
-		"-codec", "copy",
-		"-f", "dash",
-		"-an",
-		"-dash_segment_type", "mp4",
-		"-media_seg_name", "raspilive-$Number$.m4s",
-		"-init_seg_name", "init.m4s",
+	args := []string{"-i", "pipe:0"}
+
+	hasAudio := muxer.Options.Audio.Device != ""
+	if hasAudio {
+		args = append(append(append([]string{}, audio.SyncArgs...), args...), muxer.Options.Audio.InputArgs()...)
+	}
+
+	codecArgs, err := muxer.codecArgs()
+	if err != nil {
+		return err
+	}
+	args = append(args, codecArgs...)
+	args = append(args, "-f", "dash")
+	if !hasAudio {
+		args = append(args, "-an")
+	}
+	args = append(args, "-dash_segment_type", "mp4")
+
+	mediaName, initName := "raspilive-", "init"
+	if muxer.Options.EpochNumbers {
+		session := strconv.FormatInt(now().Unix(), 10)
+		mediaName, initName = mediaName+session+"-", initName+"-"+session
+	}
+
+	// Each rendition is a separate representation of the same adaptation set, with segments of its own, while the audio
+	// is an adaptation set of its own
+	if len(muxer.Options.Renditions) > 0 || hasAudio {
+		adaptationSets := "id=0,streams=v"
+		if hasAudio {
+			adaptationSets += " id=1,streams=a"
+		}
+
+		args = append(
+			args,
+			"-media_seg_name", mediaName+"$RepresentationID$-$Number$.m4s",
+			"-init_seg_name", initName+"-$RepresentationID$.m4s",
+			"-adaptation_sets", adaptationSets)
+	} else {
+		args = append(
+			args,
+			"-media_seg_name", mediaName+"$Number$.m4s",
+			"-init_seg_name", initName+".m4s")
 	}
 
 	if muxer.Options.Fps != 0 {
@@ -55,7 +108,15 @@ func (muxer *Muxer) Mux(video io.ReadCloser) error {
 		args = append(args, "-extra_window_size", strconv.Itoa(muxer.Options.StorageSize))
 	}
 
-	args = append(args, path.Join(muxer.Directory, "livestream.mpd"))
+	if muxer.Options.UTCTimingURL != "" {
+		args = append(args, "-utc_timing_url", muxer.Options.UTCTimingURL)
+	}
+
+	if muxer.URL != "" {
+		args = append(args, "-method", "PUT")
+	}
+
+	args = append(args, muxer.output("livestream.mpd"))
 
 	muxer.cmd = execCommand("ffmpeg", args...)
 	muxer.cmd.Stdin = video
@@ -63,6 +124,103 @@ func (muxer *Muxer) Mux(video io.ReadCloser) error {
 	return muxer.cmd.Start()
 }
 
+// codecArgs returns the arguments that copy the original video and transcode any renditions or audio alongside it.
+func (muxer *Muxer) codecArgs() ([]string, error) {
+	var audioArgs []string
+	if muxer.Options.Audio.Device != "" {
+		var err error
+		audioArgs, err = muxer.Options.Audio.CodecArgs()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(muxer.Options.Renditions) == 0 && audioArgs == nil {
+		return []string{"-codec", "copy"}, nil
+	}
+
+	// Only look for an encoder when there is something to encode, as doing so runs Ffmpeg
+	encoder := muxer.Options.Encoder
+	if encoder == "" && len(muxer.Options.Renditions) > 0 {
+		encoder = abr.Encoder()
+	}
+
+	segmentTime := muxer.Options.SegmentTime
+	if segmentTime == 0 {
+		segmentTime = 5
+	}
+
+	args := []string{}
+	for i := 0; i <= len(muxer.Options.Renditions); i++ {
+		args = append(args, "-map", "0:v")
+	}
+
+	if audioArgs != nil {
+		args = append(args, "-map", "1:a")
+	}
+
+	args = append(args, "-c:v:0", "copy")
+	for i, rendition := range muxer.Options.Renditions {
+		args = append(args, rendition.Args(i+1, encoder, segmentTime)...)
+	}
+
+	return append(args, audioArgs...), nil
+}
+
+// PruneOldest removes the oldest segment that has fallen out of the manifest to free up space, reporting whether there
+// was one to remove. Ffmpeg otherwise keeps StorageSize of them around for players that are behind. Segments still in
+// the manifest and initialization segments are never removed.
+func (muxer *Muxer) PruneOldest() (bool, error) {
+	if muxer.URL != "" {
+		return false, nil
+	}
+
+	data, err := ioutil.ReadFile(path.Join(muxer.Directory, "livestream.mpd"))
+	if err != nil {
+		// Nothing can be told apart from the live segments before Ffmpeg writes the manifest
+		return false, nil
+	}
+	listed, err := parseManifest(data)
+	if err != nil || len(listed) == 0 {
+		return false, nil
+	}
+
+	entries, err := ioutil.ReadDir(muxer.Directory)
+	if err != nil {
+		return false, err
+	}
+
+	var oldest os.FileInfo
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || path.Ext(name) != ".m4s" || strings.HasPrefix(name, "init") || listed[name] {
+			continue
+		}
+
+		if oldest == nil || entry.ModTime().Before(oldest.ModTime()) {
+			oldest = entry
+		}
+	}
+	if oldest == nil {
+		return false, nil
+	}
+
+	if err := os.Remove(path.Join(muxer.Directory, oldest.Name())); err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// output returns the location that Ffmpeg should write the named file to.
+func (muxer *Muxer) output(name string) string {
+	if muxer.URL != "" {
+		return strings.TrimSuffix(muxer.URL, "/") + "/" + name
+	}
+
+	return path.Join(muxer.Directory, name)
+}
+
 // Wait waits for the video stream to finish processing.
 //
 // The mux operation must have been started by Start.
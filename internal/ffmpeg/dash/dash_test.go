@@ -8,6 +8,10 @@ import (
 	"path"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/jaredpetersen/raspilive/internal/ffmpeg/abr"
+	"github.com/jaredpetersen/raspilive/internal/ffmpeg/audio"
 )
 
 const fakeVideoStreamContent = "fakevideostream"
@@ -23,6 +27,9 @@ func TestMain(m *testing.M) {
 }
 
 func TestStart(t *testing.T) {
+	now = func() time.Time { return time.Unix(1615982400, 0) }
+	defer func() { now = time.Now }()
+
 	testCases := []struct {
 		muxer        Muxer
 		expectedArgs []string
@@ -55,6 +62,119 @@ func TestStart(t *testing.T) {
 				path.Join("camera", "livestream.mpd"),
 			},
 		},
+		{
+			Muxer{URL: "http://127.0.0.1:8080"},
+			[]string{
+				"ffmpeg",
+				"-i", "pipe:0",
+				"-codec", "copy",
+				"-f", "dash",
+				"-an",
+				"-dash_segment_type", "mp4",
+				"-media_seg_name", "raspilive-$Number$.m4s",
+				"-init_seg_name", "init.m4s",
+				"-method", "PUT",
+				"http://127.0.0.1:8080/livestream.mpd",
+			},
+		},
+		{
+			Muxer{Options: Options{
+				SegmentTime: 2,
+				Renditions:  []abr.Rendition{{Width: 640, Height: 360, Bitrate: 800000}},
+				Encoder:     "libx264",
+			}},
+			[]string{
+				"ffmpeg",
+				"-i", "pipe:0",
+				"-map", "0:v",
+				"-map", "0:v",
+				"-c:v:0", "copy",
+				"-c:v:1", "libx264",
+				"-b:v:1", "800000",
+				"-maxrate:v:1", "1200000",
+				"-bufsize:v:1", "1600000",
+				"-s:v:1", "640x360",
+				"-pix_fmt:v:1", "yuv420p",
+				"-force_key_frames:v:1", "expr:gte(t,n_forced*2)",
+				"-profile:v:1", "main",
+				"-level:v:1", "40",
+				"-preset:v:1", "veryfast",
+				"-f", "dash",
+				"-an",
+				"-dash_segment_type", "mp4",
+				"-media_seg_name", "raspilive-$RepresentationID$-$Number$.m4s",
+				"-init_seg_name", "init-$RepresentationID$.m4s",
+				"-adaptation_sets", "id=0,streams=v",
+				"-seg_duration", "2",
+				"livestream.mpd",
+			},
+		},
+		{
+			Muxer{Options: Options{Audio: audio.Input{Device: "plughw:1,0", Codec: audio.CodecOpus, Bitrate: 64000}}},
+			[]string{
+				"ffmpeg",
+				"-use_wallclock_as_timestamps", "1",
+				"-thread_queue_size", "1024",
+				"-i", "pipe:0",
+				"-use_wallclock_as_timestamps", "1",
+				"-thread_queue_size", "1024",
+				"-f", "alsa",
+				"-i", "plughw:1,0",
+				"-map", "0:v",
+				"-map", "1:a",
+				"-c:v:0", "copy",
+				"-c:a", "libopus",
+				"-b:a", "64000",
+				"-af", "aresample=async=1000",
+				"-f", "dash",
+				"-dash_segment_type", "mp4",
+				"-media_seg_name", "raspilive-$RepresentationID$-$Number$.m4s",
+				"-init_seg_name", "init-$RepresentationID$.m4s",
+				"-adaptation_sets", "id=0,streams=v id=1,streams=a",
+				"livestream.mpd",
+			},
+		},
+		{
+			Muxer{Options: Options{
+				SegmentTime: 2,
+				Renditions:  []abr.Rendition{{Width: 640, Height: 360, Bitrate: 800000}},
+				Encoder:     "libx264",
+				Audio:       audio.Input{Device: "sine", Format: "lavfi"},
+			}},
+			[]string{
+				"ffmpeg",
+				"-use_wallclock_as_timestamps", "1",
+				"-thread_queue_size", "1024",
+				"-i", "pipe:0",
+				"-use_wallclock_as_timestamps", "1",
+				"-thread_queue_size", "1024",
+				"-f", "lavfi",
+				"-i", "sine",
+				"-map", "0:v",
+				"-map", "0:v",
+				"-map", "1:a",
+				"-c:v:0", "copy",
+				"-c:v:1", "libx264",
+				"-b:v:1", "800000",
+				"-maxrate:v:1", "1200000",
+				"-bufsize:v:1", "1600000",
+				"-s:v:1", "640x360",
+				"-pix_fmt:v:1", "yuv420p",
+				"-force_key_frames:v:1", "expr:gte(t,n_forced*2)",
+				"-profile:v:1", "main",
+				"-level:v:1", "40",
+				"-preset:v:1", "veryfast",
+				"-c:a", "aac",
+				"-af", "aresample=async=1000",
+				"-f", "dash",
+				"-dash_segment_type", "mp4",
+				"-media_seg_name", "raspilive-$RepresentationID$-$Number$.m4s",
+				"-init_seg_name", "init-$RepresentationID$.m4s",
+				"-adaptation_sets", "id=0,streams=v id=1,streams=a",
+				"-seg_duration", "2",
+				"livestream.mpd",
+			},
+		},
 		{
 			Muxer{Options: Options{Fps: 60}},
 			[]string{
@@ -115,6 +235,35 @@ func TestStart(t *testing.T) {
 				"livestream.mpd",
 			},
 		},
+		{
+			Muxer{Options: Options{EpochNumbers: true}},
+			[]string{
+				"ffmpeg",
+				"-i", "pipe:0",
+				"-codec", "copy",
+				"-f", "dash",
+				"-an",
+				"-dash_segment_type", "mp4",
+				"-media_seg_name", "raspilive-1615982400-$Number$.m4s",
+				"-init_seg_name", "init-1615982400.m4s",
+				"livestream.mpd",
+			},
+		},
+		{
+			Muxer{Options: Options{UTCTimingURL: "/camera/time"}},
+			[]string{
+				"ffmpeg",
+				"-i", "pipe:0",
+				"-codec", "copy",
+				"-f", "dash",
+				"-an",
+				"-dash_segment_type", "mp4",
+				"-media_seg_name", "raspilive-$Number$.m4s",
+				"-init_seg_name", "init.m4s",
+				"-utc_timing_url", "/camera/time",
+				"livestream.mpd",
+			},
+		},
 		{
 			Muxer{Directory: "dash", Options: Options{Fps: 30, SegmentTime: 5, PlaylistSize: 25, StorageSize: 50}},
 			[]string{
@@ -215,6 +364,49 @@ func TestWaitAgainReturnsError(t *testing.T) {
 	}
 }
 
+func TestPruneOldest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "raspilive-dash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := []string{"init-0.m4s", "raspilive-0-00001.m4s", "raspilive-0-00002.m4s", "raspilive-0-00003.m4s"}
+	for i, name := range files {
+		file := path.Join(dir, name)
+		if err := ioutil.WriteFile(file, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+		modTime := time.Now().Add(time.Duration(i-len(files)) * time.Minute)
+		if err := os.Chtimes(file, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(path.Join(dir, "livestream.mpd"), []byte(testManifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	muxer := Muxer{Directory: dir}
+
+	for _, pruned := range []string{"raspilive-0-00001.m4s", "raspilive-0-00002.m4s"} {
+		if ok, err := muxer.PruneOldest(); !ok || err != nil {
+			t.Fatal("PruneOldest did not prune a segment", err)
+		}
+		if _, err := os.Stat(path.Join(dir, pruned)); !os.IsNotExist(err) {
+			t.Error("PruneOldest did not remove the oldest segment", pruned)
+		}
+	}
+
+	if ok, err := muxer.PruneOldest(); ok || err != nil {
+		t.Error("PruneOldest pruned a segment in the manifest", err)
+	}
+	for _, kept := range []string{"init-0.m4s", "raspilive-0-00003.m4s"} {
+		if _, err := os.Stat(path.Join(dir, kept)); err != nil {
+			t.Error("PruneOldest removed", kept)
+		}
+	}
+}
+
 func TestStringReturnsStringifiedCommand(t *testing.T) {
 	execCommand = mockExecCommand
 	defer func() { execCommand = exec.Command }()
@@ -0,0 +1,152 @@
+package dash
+
+import (
+	"encoding/xml"
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jaredpetersen/raspilive/internal/segment"
+)
+
+// segmentTemplate is a segment template in a manifest written by Ffmpeg.
+type segmentTemplate struct {
+	Media                  string `xml:"media,attr"`
+	Initialization         string `xml:"initialization,attr"`
+	StartNumber            *int   `xml:"startNumber,attr"`
+	Timescale              int64  `xml:"timescale,attr"`
+	PresentationTimeOffset int64  `xml:"presentationTimeOffset,attr"`
+	Timeline               []struct {
+		Time     *int64 `xml:"t,attr"`
+		Duration int64  `xml:"d,attr"`
+		Repeat   int    `xml:"r,attr"`
+	} `xml:"SegmentTimeline>S"`
+}
+
+// manifest is the part of a manifest written by Ffmpeg that lists the segments.
+type manifest struct {
+	AvailabilityStartTime string `xml:"availabilityStartTime,attr"`
+	Periods               []struct {
+		AdaptationSets []struct {
+			Template        *segmentTemplate `xml:"SegmentTemplate"`
+			Representations []struct {
+				ID       string           `xml:"id,attr"`
+				Template *segmentTemplate `xml:"SegmentTemplate"`
+			} `xml:"Representation"`
+		} `xml:"AdaptationSet"`
+	} `xml:"Period"`
+}
+
+// numberIdentifier matches the segment number in a template, which may be formatted with a width.
+var numberIdentifier = regexp.MustCompile(`\$Number(%0(\d+)d)?\$`)
+
+// parseManifest reads the file names of the segments listed in the manifest, including the initialization segments.
+func parseManifest(data []byte) (map[string]bool, error) {
+	var mpd manifest
+	if err := xml.Unmarshal(data, &mpd); err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]bool)
+	for _, period := range mpd.Periods {
+		for _, set := range period.AdaptationSets {
+			for _, representation := range set.Representations {
+				template := representation.Template
+				if template == nil {
+					template = set.Template
+				}
+				if template == nil {
+					continue
+				}
+
+				id := strings.NewReplacer("$RepresentationID$", representation.ID)
+				if template.Initialization != "" {
+					names[path.Base(id.Replace(template.Initialization))] = true
+				}
+
+				number := 1
+				if template.StartNumber != nil {
+					number = *template.StartNumber
+				}
+				for _, s := range template.Timeline {
+					for i := 0; i <= s.Repeat; i++ {
+						names[path.Base(id.Replace(segmentName(template.Media, number)))] = true
+						number++
+					}
+				}
+			}
+		}
+	}
+
+	return names, nil
+}
+
+// Segments returns the segments of the video listed in the manifest, oldest first, for taking note of in an export
+// catalog, along with the file name of their initialization segment. The video is the first representation, which is
+// the one copied straight from the camera.
+//
+// The wall clock time that each segment was captured is worked out from when the manifest became available, which
+// Ffmpeg sets to when the video started.
+func Segments(data []byte) ([]segment.Segment, string, error) {
+	var mpd manifest
+	if err := xml.Unmarshal(data, &mpd); err != nil {
+		return nil, "", err
+	}
+
+	available, err := time.Parse(time.RFC3339Nano, mpd.AvailabilityStartTime)
+	if err != nil || len(mpd.Periods) == 0 || len(mpd.Periods[0].AdaptationSets) == 0 {
+		return nil, "", nil
+	}
+
+	set := mpd.Periods[0].AdaptationSets[0]
+	if len(set.Representations) == 0 {
+		return nil, "", nil
+	}
+	representation := set.Representations[0]
+	template := representation.Template
+	if template == nil {
+		template = set.Template
+	}
+	if template == nil || template.Timescale <= 0 {
+		return nil, "", nil
+	}
+
+	id := strings.NewReplacer("$RepresentationID$", representation.ID)
+
+	number := 1
+	if template.StartNumber != nil {
+		number = *template.StartNumber
+	}
+
+	var segments []segment.Segment
+	var t int64
+	for _, s := range template.Timeline {
+		if s.Time != nil {
+			t = *s.Time
+		}
+		for i := 0; i <= s.Repeat; i++ {
+			offset := time.Duration(float64(t-template.PresentationTimeOffset) / float64(template.Timescale) * float64(time.Second))
+			segments = append(segments, segment.Segment{
+				Name:     path.Base(id.Replace(segmentName(template.Media, number))),
+				Number:   number,
+				Duration: s.Duration * segment.Timescale / template.Timescale,
+				Time:     available.Add(offset).UTC(),
+			})
+			t += s.Duration
+			number++
+		}
+	}
+
+	return segments, path.Base(id.Replace(template.Initialization)), nil
+}
+
+// segmentName fills in the segment number in the template.
+func segmentName(media string, number int) string {
+	return numberIdentifier.ReplaceAllStringFunc(media, func(identifier string) string {
+		width, _ := strconv.Atoi(numberIdentifier.FindStringSubmatch(identifier)[2])
+		return fmt.Sprintf("%0*d", width, number)
+	})
+}
@@ -0,0 +1,83 @@
+package dash
+
+import (
+	"testing"
+	"time"
+)
+
+const testManifest = `<?xml version="1.0" encoding="utf-8"?>
+<MPD xmlns="urn:mpeg:dash:schema:mpd:2011" type="dynamic" availabilityStartTime="2021-03-17T12:00:00.000Z">
+	<Period id="0" start="PT0.0S">
+		<AdaptationSet id="0" contentType="video">
+			<Representation id="0" mimeType="video/mp4" codecs="avc1.640028" bandwidth="2000000" width="1280" height="720">
+				<SegmentTemplate timescale="1000000" initialization="init-$RepresentationID$.m4s" media="raspilive-$RepresentationID$-$Number%05d$.m4s" startNumber="3">
+					<SegmentTimeline>
+						<S t="4000000" d="2000000" r="1" />
+						<S d="1900000" />
+					</SegmentTimeline>
+				</SegmentTemplate>
+			</Representation>
+		</AdaptationSet>
+	</Period>
+</MPD>
+`
+
+func TestParseManifest(t *testing.T) {
+	listed, err := parseManifest([]byte(testManifest))
+	if err != nil {
+		t.Fatal("parseManifest returned an error", err)
+	}
+
+	expected := []string{"init-0.m4s", "raspilive-0-00003.m4s", "raspilive-0-00004.m4s", "raspilive-0-00005.m4s"}
+	if len(listed) != len(expected) {
+		t.Error("parseManifest listed incorrect number of segments, got", listed)
+	}
+	for _, name := range expected {
+		if !listed[name] {
+			t.Error("parseManifest did not list", name)
+		}
+	}
+}
+
+func TestParseManifestInvalidReturnsError(t *testing.T) {
+	if _, err := parseManifest([]byte("<MPD")); err == nil {
+		t.Error("parseManifest failed to return an error")
+	}
+}
+
+func TestSegments(t *testing.T) {
+	segments, init, err := Segments([]byte(testManifest))
+	if err != nil {
+		t.Fatal("Segments returned an error", err)
+	}
+
+	if init != "init-0.m4s" {
+		t.Error("Segments returned incorrect initialization segment, got", init)
+	}
+
+	expected := []struct {
+		name     string
+		number   int
+		duration int64
+		time     time.Time
+	}{
+		{"raspilive-0-00003.m4s", 3, 180000, time.Date(2021, 3, 17, 12, 0, 4, 0, time.UTC)},
+		{"raspilive-0-00004.m4s", 4, 180000, time.Date(2021, 3, 17, 12, 0, 6, 0, time.UTC)},
+		{"raspilive-0-00005.m4s", 5, 171000, time.Date(2021, 3, 17, 12, 0, 8, 0, time.UTC)},
+	}
+	if len(segments) != len(expected) {
+		t.Fatal("Segments returned incorrect segments, got", segments)
+	}
+	for i, seg := range segments {
+		if seg.Name != expected[i].name || seg.Number != expected[i].number || seg.Duration != expected[i].duration ||
+			!seg.Time.Equal(expected[i].time) {
+			t.Error("Segments returned incorrect segment, got", seg)
+		}
+	}
+}
+
+func TestSegmentsInvalidReturnsError(t *testing.T) {
+	if _, _, err := Segments([]byte("<MPD")); err == nil {
+		t.Error("Segments did not return an error")
+	}
+}
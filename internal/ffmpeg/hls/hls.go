@@ -2,27 +2,46 @@ package hls
 
 import (
 	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
+	"os"
 	"os/exec"
 	"path"
 	"strconv"
 	"strings"
+
+	"github.com/jaredpetersen/raspilive/internal/ffmpeg/abr"
+	"github.com/jaredpetersen/raspilive/internal/ffmpeg/audio"
 )
 
 // Options represents ways that Ffmpeg may be configured to mux video to HLS.
 //
 // Ffmpeg will step in and use its own defaults if a value is not provided.
 type Options struct {
-	Fps          int    // Framerate of the output video
-	SegmentType  string // Format of the video segment
-	SegmentTime  int    // Segment length target duration in seconds
-	PlaylistSize int    // Maximum number of playlist entries
-	StorageSize  int    // Maximum number of unreferenced segments to keep on disk before removal
+	Fps          int             // Framerate of the output video
+	SegmentType  string          // Format of the video segment
+	SegmentTime  int             // Segment length target duration in seconds
+	PlaylistSize int             // Maximum number of playlist entries
+	StorageSize  int             // Maximum number of unreferenced segments to keep on disk before removal
+	Renditions   []abr.Rendition // Lower quality renditions to transcode alongside the original video
+	Encoder      string          // Encoder for the renditions, picking the best available one if not provided
+	KeyInfoFile  string          // Key info file for encrypting segments with AES-128, read again before every segment
+
+	ProgramDateTime bool // Tag the segments in the playlist with the wall clock time they were captured
+	TimestampNames  bool // Name the segments with the UTC time they were captured instead of the sequence number
+
+	// EpochNumbers numbers the segments from the seconds since the Unix epoch so that they keep increasing across
+	// restarts, marking the first one as a discontinuity
+	EpochNumbers bool
+
+	Audio audio.Input // Audio to capture alongside the video, left out if there is no device
 }
 
 // Muxer represents the HLS muxer.
 type Muxer struct {
 	Directory string
+	URL       string // Base URL to upload the video to with HTTP PUT in place of the directory
 	Options   Options
 	cmd       *exec.Cmd
 }
@@ -31,25 +50,64 @@ var execCommand = exec.Command
 
 // Mux begins muxing the video stream to the HLS format.
 func (muxer *Muxer) Mux(video io.ReadCloser) error {
-	args := []string{
-		"-i", "pipe:0",
-		"-codec", "copy",
-		"-f", "hls",
-		"-an",
+	args := []string{"-i", "pipe:0"}
+
+	hasAudio := muxer.Options.Audio.Device != ""
+	if hasAudio {
+		// Each variant stream would need audio of its own, which would be encoded over and over again
+		if len(muxer.Options.Renditions) > 0 {
+			return errors.New("ffmpeg hls: audio is not supported with renditions")
+		}
+
+		// Players only support Opus in fragmented MP4
+		if muxer.Options.Audio.Codec == audio.CodecOpus && strings.ToLower(muxer.Options.SegmentType) != "fmp4" {
+			return errors.New("ffmpeg hls: opus audio requires fmp4 segments")
+		}
+
+		args = append(append(append([]string{}, audio.SyncArgs...), args...), muxer.Options.Audio.InputArgs()...)
+	}
+
+	codecArgs, err := muxer.codecArgs()
+	if err != nil {
+		return err
+	}
+	args = append(args, codecArgs...)
+	args = append(args, "-f", "hls")
+	if !hasAudio {
+		args = append(args, "-an")
 	}
 	hlsFlags := []string{}
 
+	// Each variant stream gets its own playlist and segments when transcoding renditions
+	segmentName := "raspilive-"
+	playlistName := "livestream.m3u8"
+	if len(muxer.Options.Renditions) > 0 {
+		segmentName = "raspilive-%v-"
+		playlistName = "livestream-%v.m3u8"
+	}
+
+	tsName, fmp4Name := segmentName+"%03d.ts", segmentName+"%d.m4s"
+	if muxer.Options.TimestampNames {
+		// Ffmpeg names the segments with the local time, which is forced to UTC below so that the names stay in order
+		// across daylight saving time
+		args = append(args, "-strftime", "1")
+		tsName, fmp4Name = segmentName+"%Y%m%dT%H%M%SZ.ts", segmentName+"%Y%m%dT%H%M%SZ.m4s"
+	}
+
 	segmentType := strings.ToLower(muxer.Options.SegmentType)
 	if segmentType == "" || segmentType == "mpegts" {
 		args = append(
 			args,
 			"-hls_segment_type", "mpegts",
-			"-hls_segment_filename", path.Join(muxer.Directory, "raspilive-%03d.ts"))
+			"-hls_segment_filename", muxer.output(tsName))
 	} else if segmentType == "fmp4" {
 		args = append(
 			args,
 			"-hls_segment_type", "fmp4",
-			"-hls_segment_filename", path.Join(muxer.Directory, "raspilive-%d.m4s"))
+			"-hls_segment_filename", muxer.output(fmp4Name))
+		if len(muxer.Options.Renditions) > 0 {
+			args = append(args, "-hls_fmp4_init_filename", "init-%v.mp4")
+		}
 	} else {
 		return errors.New("ffmpeg dash: invalid segment type")
 	}
@@ -72,18 +130,181 @@ func (muxer *Muxer) Mux(video io.ReadCloser) error {
 		hlsFlags = append(hlsFlags, "delete_segments")
 	}
 
+	if muxer.Options.EpochNumbers {
+		args = append(args, "-hls_start_number_source", "epoch")
+		hlsFlags = append(hlsFlags, "discont_start")
+
+		// Ffmpeg can only read back the playlist of the last session from disk, not from an upload URL
+		if muxer.URL == "" {
+			hlsFlags = append(hlsFlags, "append_list")
+		}
+	}
+
+	if muxer.Options.KeyInfoFile != "" {
+		args = append(args, "-hls_key_info_file", muxer.Options.KeyInfoFile)
+		hlsFlags = append(hlsFlags, "periodic_rekey")
+	}
+
+	if muxer.Options.ProgramDateTime {
+		hlsFlags = append(hlsFlags, "program_date_time")
+	}
+
+	if muxer.URL != "" {
+		args = append(args, "-method", "PUT")
+	}
+
 	if len(hlsFlags) > 0 {
 		args = append(args, "-hls_flags", strings.Join(hlsFlags, "+"))
 	}
 
-	args = append(args, path.Join(muxer.Directory, "livestream.m3u8"))
+	if len(muxer.Options.Renditions) > 0 {
+		args = append(args, "-var_stream_map", muxer.varStreamMap())
+	}
+
+	args = append(args, muxer.output(playlistName))
 
 	muxer.cmd = execCommand("ffmpeg", args...)
 	muxer.cmd.Stdin = video
+	if muxer.Options.TimestampNames {
+		if muxer.cmd.Env == nil {
+			muxer.cmd.Env = os.Environ()
+		}
+		muxer.cmd.Env = append(muxer.cmd.Env, "TZ=UTC")
+	}
 
 	return muxer.cmd.Start()
 }
 
+// codecArgs returns the arguments that copy the original video and transcode any renditions or audio alongside it.
+func (muxer *Muxer) codecArgs() ([]string, error) {
+	if muxer.Options.Audio.Device != "" {
+		audioArgs, err := muxer.Options.Audio.CodecArgs()
+		if err != nil {
+			return nil, err
+		}
+
+		return append([]string{"-map", "0:v", "-map", "1:a", "-c:v", "copy"}, audioArgs...), nil
+	}
+
+	if len(muxer.Options.Renditions) == 0 {
+		return []string{"-codec", "copy"}, nil
+	}
+
+	encoder := muxer.Options.Encoder
+	if encoder == "" {
+		encoder = abr.Encoder()
+	}
+
+	segmentTime := muxer.Options.SegmentTime
+	if segmentTime == 0 {
+		segmentTime = 2
+	}
+
+	args := []string{}
+	for i := 0; i <= len(muxer.Options.Renditions); i++ {
+		args = append(args, "-map", "0:v")
+	}
+
+	args = append(args, "-c:v:0", "copy")
+	for i, rendition := range muxer.Options.Renditions {
+		args = append(args, rendition.Args(i+1, encoder, segmentTime)...)
+	}
+
+	return args, nil
+}
+
+// varStreamMap lists the variant streams, with the original video first.
+func (muxer *Muxer) varStreamMap() string {
+	streams := []string{}
+	for i := 0; i <= len(muxer.Options.Renditions); i++ {
+		streams = append(streams, "v:"+strconv.Itoa(i))
+	}
+
+	return strings.Join(streams, " ")
+}
+
+// Playlists returns the file names of the media playlists that Ffmpeg writes.
+func (muxer *Muxer) Playlists() []string {
+	if len(muxer.Options.Renditions) == 0 {
+		return []string{"livestream.m3u8"}
+	}
+
+	names := make([]string, 0, len(muxer.Options.Renditions)+1)
+	for i := 0; i <= len(muxer.Options.Renditions); i++ {
+		names = append(names, VariantName(i))
+	}
+
+	return names
+}
+
+// PruneOldest removes the oldest segment that has fallen out of the playlists to free up space, reporting whether there
+// was one to remove. Ffmpeg otherwise keeps StorageSize of them around for players that are behind. Segments still in
+// the playlists and initialization segments are never removed.
+func (muxer *Muxer) PruneOldest() (bool, error) {
+	if muxer.URL != "" {
+		return false, nil
+	}
+
+	listed := make(map[string]bool)
+	for _, playlist := range muxer.Playlists() {
+		data, err := ioutil.ReadFile(path.Join(muxer.Directory, playlist))
+		if err != nil {
+			// Ffmpeg replaces the playlists as it writes them
+			continue
+		}
+
+		for _, segment := range parsePlaylist(data) {
+			listed[segment.name] = true
+		}
+	}
+	if len(listed) == 0 {
+		// Nothing can be told apart from the live segments before Ffmpeg writes the playlists
+		return false, nil
+	}
+
+	entries, err := ioutil.ReadDir(muxer.Directory)
+	if err != nil {
+		return false, err
+	}
+
+	var oldest os.FileInfo
+	for _, entry := range entries {
+		name := entry.Name()
+		ext := path.Ext(name)
+		if entry.IsDir() || (ext != ".ts" && ext != ".m4s") || strings.HasPrefix(name, "init") || listed[name] {
+			continue
+		}
+
+		if oldest == nil || entry.ModTime().Before(oldest.ModTime()) {
+			oldest = entry
+		}
+	}
+	if oldest == nil {
+		return false, nil
+	}
+
+	if err := os.Remove(path.Join(muxer.Directory, oldest.Name())); err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// VariantName returns the file name of the playlist for the variant stream with the given index when transcoding
+// renditions, where the original video is the first variant.
+func VariantName(index int) string {
+	return fmt.Sprintf("livestream-%d.m3u8", index)
+}
+
+// output returns the location that Ffmpeg should write the named file to.
+func (muxer *Muxer) output(name string) string {
+	if muxer.URL != "" {
+		return strings.TrimSuffix(muxer.URL, "/") + "/" + name
+	}
+
+	return path.Join(muxer.Directory, name)
+}
+
 // Wait blocks until the video stream is finished processing by Mux.
 func (muxer *Muxer) Wait() error {
 	if muxer.cmd == nil {
@@ -8,6 +8,10 @@ import (
 	"path"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/jaredpetersen/raspilive/internal/ffmpeg/abr"
+	"github.com/jaredpetersen/raspilive/internal/ffmpeg/audio"
 )
 
 const fakeVideoStreamContent = "fakevideostream"
@@ -53,6 +57,111 @@ func TestStart(t *testing.T) {
 				path.Join("camera", "livestream.m3u8"),
 			},
 		},
+		{
+			Muxer{URL: "http://127.0.0.1:8080/"},
+			[]string{
+				"ffmpeg",
+				"-i", "pipe:0",
+				"-codec", "copy",
+				"-f", "hls",
+				"-an",
+				"-hls_segment_type", "mpegts",
+				"-hls_segment_filename", "http://127.0.0.1:8080/raspilive-%03d.ts",
+				"-method", "PUT",
+				"http://127.0.0.1:8080/livestream.m3u8",
+			},
+		},
+		{
+			Muxer{Options: Options{
+				Renditions: []abr.Rendition{{Width: 640, Height: 360, Bitrate: 800000}},
+				Encoder:    "libx264",
+			}},
+			[]string{
+				"ffmpeg",
+				"-i", "pipe:0",
+				"-map", "0:v",
+				"-map", "0:v",
+				"-c:v:0", "copy",
+				"-c:v:1", "libx264",
+				"-b:v:1", "800000",
+				"-maxrate:v:1", "1200000",
+				"-bufsize:v:1", "1600000",
+				"-s:v:1", "640x360",
+				"-pix_fmt:v:1", "yuv420p",
+				"-force_key_frames:v:1", "expr:gte(t,n_forced*2)",
+				"-profile:v:1", "main",
+				"-level:v:1", "40",
+				"-preset:v:1", "veryfast",
+				"-f", "hls",
+				"-an",
+				"-hls_segment_type", "mpegts",
+				"-hls_segment_filename", "raspilive-%v-%03d.ts",
+				"-var_stream_map", "v:0 v:1",
+				"livestream-%v.m3u8",
+			},
+		},
+		{
+			Muxer{Options: Options{
+				SegmentType: "fmp4",
+				SegmentTime: 4,
+				Renditions: []abr.Rendition{
+					{Width: 854, Height: 480, Bitrate: 1500000},
+					{Width: 640, Height: 360, Bitrate: 800000},
+				},
+				Encoder: "h264_v4l2m2m",
+			}},
+			[]string{
+				"ffmpeg",
+				"-i", "pipe:0",
+				"-map", "0:v",
+				"-map", "0:v",
+				"-map", "0:v",
+				"-c:v:0", "copy",
+				"-c:v:1", "h264_v4l2m2m",
+				"-b:v:1", "1500000",
+				"-maxrate:v:1", "2250000",
+				"-bufsize:v:1", "3000000",
+				"-s:v:1", "854x480",
+				"-pix_fmt:v:1", "yuv420p",
+				"-force_key_frames:v:1", "expr:gte(t,n_forced*4)",
+				"-profile:v:1", "77",
+				"-level:v:1", "40",
+				"-c:v:2", "h264_v4l2m2m",
+				"-b:v:2", "800000",
+				"-maxrate:v:2", "1200000",
+				"-bufsize:v:2", "1600000",
+				"-s:v:2", "640x360",
+				"-pix_fmt:v:2", "yuv420p",
+				"-force_key_frames:v:2", "expr:gte(t,n_forced*4)",
+				"-profile:v:2", "77",
+				"-level:v:2", "40",
+				"-f", "hls",
+				"-an",
+				"-hls_segment_type", "fmp4",
+				"-hls_segment_filename", "raspilive-%v-%d.m4s",
+				"-hls_fmp4_init_filename", "init-%v.mp4",
+				"-hls_time", "4",
+				"-hls_flags", "split_by_time",
+				"-var_stream_map", "v:0 v:1 v:2",
+				"livestream-%v.m3u8",
+			},
+		},
+		{
+			Muxer{Options: Options{StorageSize: 2, KeyInfoFile: "/tmp/keys/livestream.keyinfo"}},
+			[]string{
+				"ffmpeg",
+				"-i", "pipe:0",
+				"-codec", "copy",
+				"-f", "hls",
+				"-an",
+				"-hls_segment_type", "mpegts",
+				"-hls_segment_filename", "raspilive-%03d.ts",
+				"-hls_delete_threshold", "2",
+				"-hls_key_info_file", "/tmp/keys/livestream.keyinfo",
+				"-hls_flags", "delete_segments+periodic_rekey",
+				"livestream.m3u8",
+			},
+		},
 		{
 			Muxer{Options: Options{Fps: 60}},
 			[]string{
@@ -137,6 +246,114 @@ func TestStart(t *testing.T) {
 				"livestream.m3u8",
 			},
 		},
+		{
+			Muxer{Options: Options{EpochNumbers: true}},
+			[]string{
+				"ffmpeg",
+				"-i", "pipe:0",
+				"-codec", "copy",
+				"-f", "hls",
+				"-an",
+				"-hls_segment_type", "mpegts",
+				"-hls_segment_filename", "raspilive-%03d.ts",
+				"-hls_start_number_source", "epoch",
+				"-hls_flags", "discont_start+append_list",
+				"livestream.m3u8",
+			},
+		},
+		{
+			Muxer{URL: "http://127.0.0.1:8080", Options: Options{EpochNumbers: true}},
+			[]string{
+				"ffmpeg",
+				"-i", "pipe:0",
+				"-codec", "copy",
+				"-f", "hls",
+				"-an",
+				"-hls_segment_type", "mpegts",
+				"-hls_segment_filename", "http://127.0.0.1:8080/raspilive-%03d.ts",
+				"-hls_start_number_source", "epoch",
+				"-method", "PUT",
+				"-hls_flags", "discont_start",
+				"http://127.0.0.1:8080/livestream.m3u8",
+			},
+		},
+		{
+			Muxer{Options: Options{ProgramDateTime: true}},
+			[]string{
+				"ffmpeg",
+				"-i", "pipe:0",
+				"-codec", "copy",
+				"-f", "hls",
+				"-an",
+				"-hls_segment_type", "mpegts",
+				"-hls_segment_filename", "raspilive-%03d.ts",
+				"-hls_flags", "program_date_time",
+				"livestream.m3u8",
+			},
+		},
+		{
+			Muxer{Options: Options{SegmentType: "fmp4", TimestampNames: true}},
+			[]string{
+				"ffmpeg",
+				"-i", "pipe:0",
+				"-codec", "copy",
+				"-f", "hls",
+				"-an",
+				"-strftime", "1",
+				"-hls_segment_type", "fmp4",
+				"-hls_segment_filename", "raspilive-%Y%m%dT%H%M%SZ.m4s",
+				"livestream.m3u8",
+			},
+		},
+		{
+			Muxer{Options: Options{Audio: audio.Input{Device: "plughw:1,0", SampleRate: 48000, Channels: 1, Bitrate: 64000}}},
+			[]string{
+				"ffmpeg",
+				"-use_wallclock_as_timestamps", "1",
+				"-thread_queue_size", "1024",
+				"-i", "pipe:0",
+				"-use_wallclock_as_timestamps", "1",
+				"-thread_queue_size", "1024",
+				"-f", "alsa",
+				"-sample_rate", "48000",
+				"-channels", "1",
+				"-i", "plughw:1,0",
+				"-map", "0:v",
+				"-map", "1:a",
+				"-c:v", "copy",
+				"-c:a", "aac",
+				"-b:a", "64000",
+				"-ar", "48000",
+				"-ac", "1",
+				"-af", "aresample=async=1000",
+				"-f", "hls",
+				"-hls_segment_type", "mpegts",
+				"-hls_segment_filename", "raspilive-%03d.ts",
+				"livestream.m3u8",
+			},
+		},
+		{
+			Muxer{Options: Options{SegmentType: "fmp4", Audio: audio.Input{Device: "sine", Format: "lavfi", Codec: audio.CodecOpus}}},
+			[]string{
+				"ffmpeg",
+				"-use_wallclock_as_timestamps", "1",
+				"-thread_queue_size", "1024",
+				"-i", "pipe:0",
+				"-use_wallclock_as_timestamps", "1",
+				"-thread_queue_size", "1024",
+				"-f", "lavfi",
+				"-i", "sine",
+				"-map", "0:v",
+				"-map", "1:a",
+				"-c:v", "copy",
+				"-c:a", "libopus",
+				"-af", "aresample=async=1000",
+				"-f", "hls",
+				"-hls_segment_type", "fmp4",
+				"-hls_segment_filename", "raspilive-%d.m4s",
+				"livestream.m3u8",
+			},
+		},
 		{
 			Muxer{
 				Directory: "hls",
@@ -183,6 +400,21 @@ func TestStart(t *testing.T) {
 	}
 }
 
+func TestStartTimestampNamesUseUTC(t *testing.T) {
+	execCommand = mockExecCommand
+	defer func() { execCommand = exec.Command }()
+
+	hlsMuxer := Muxer{Options: Options{TimestampNames: true}}
+	if err := hlsMuxer.Mux(ioutil.NopCloser(strings.NewReader("totallyfakevideostream"))); err != nil {
+		t.Fatal("Start produced an err", err)
+	}
+
+	env := hlsMuxer.cmd.Env
+	if len(env) == 0 || env[len(env)-1] != "TZ=UTC" {
+		t.Error("Command does not name segments with the UTC time, got", env)
+	}
+}
+
 func TestStartInvalidSegmentTypeReturnsError(t *testing.T) {
 	execCommand = mockExecCommand
 	defer func() { execCommand = exec.Command }()
@@ -197,6 +429,37 @@ func TestStartInvalidSegmentTypeReturnsError(t *testing.T) {
 	}
 }
 
+func TestStartAudioWithRenditionsReturnsError(t *testing.T) {
+	execCommand = mockExecCommand
+	defer func() { execCommand = exec.Command }()
+
+	videoStream := ioutil.NopCloser(strings.NewReader("totallyfakevideostream"))
+
+	hlsMuxer := Muxer{Options: Options{
+		Renditions: []abr.Rendition{{Width: 640, Height: 360, Bitrate: 800000}},
+		Audio:      audio.Input{Device: "plughw:1,0"},
+	}}
+	err := hlsMuxer.Mux(videoStream)
+
+	if err == nil || err.Error() != "ffmpeg hls: audio is not supported with renditions" {
+		t.Error("Start failed to return an error for audio with renditions", err)
+	}
+}
+
+func TestStartOpusAudioWithMpegtsReturnsError(t *testing.T) {
+	execCommand = mockExecCommand
+	defer func() { execCommand = exec.Command }()
+
+	videoStream := ioutil.NopCloser(strings.NewReader("totallyfakevideostream"))
+
+	hlsMuxer := Muxer{Options: Options{Audio: audio.Input{Device: "plughw:1,0", Codec: audio.CodecOpus}}}
+	err := hlsMuxer.Mux(videoStream)
+
+	if err == nil || err.Error() != "ffmpeg hls: opus audio requires fmp4 segments" {
+		t.Error("Start failed to return an error for opus audio with mpegts", err)
+	}
+}
+
 func TestStartReturnsFfmpegError(t *testing.T) {
 	execCommand = mockFailedExecCommand
 	defer func() { execCommand = exec.Command }()
@@ -254,6 +517,57 @@ func TestWaitAgainReturnsError(t *testing.T) {
 	}
 }
 
+func TestPlaylists(t *testing.T) {
+	muxer := Muxer{}
+	if playlists := muxer.Playlists(); len(playlists) != 1 || playlists[0] != "livestream.m3u8" {
+		t.Error("Playlists returned incorrect playlists, got", playlists)
+	}
+
+	muxer.Options.Renditions = []abr.Rendition{{Width: 640, Height: 360, Bitrate: 800000}}
+	if playlists := muxer.Playlists(); len(playlists) != 2 || playlists[0] != "livestream-0.m3u8" || playlists[1] != "livestream-1.m3u8" {
+		t.Error("Playlists returned incorrect playlists, got", playlists)
+	}
+}
+
+func TestPruneOldest(t *testing.T) {
+	dir := tempDir(t)
+	files := []string{"init.mp4", "raspilive-0.m4s", "raspilive-1.m4s", "raspilive-2.m4s"}
+	for i, name := range files {
+		file := path.Join(dir, name)
+		if err := ioutil.WriteFile(file, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+		modTime := time.Now().Add(time.Duration(i-len(files)) * time.Minute)
+		if err := os.Chtimes(file, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	playlist := "#EXTM3U\n#EXT-X-MEDIA-SEQUENCE:2\n#EXT-X-MAP:URI=\"init.mp4\"\n#EXTINF:2.000000,\nraspilive-2.m4s\n"
+	if err := ioutil.WriteFile(path.Join(dir, "livestream.m3u8"), []byte(playlist), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	muxer := Muxer{Directory: dir}
+
+	for _, pruned := range []string{"raspilive-0.m4s", "raspilive-1.m4s"} {
+		if ok, err := muxer.PruneOldest(); !ok || err != nil {
+			t.Fatal("PruneOldest did not prune a segment", err)
+		}
+		if _, err := os.Stat(path.Join(dir, pruned)); !os.IsNotExist(err) {
+			t.Error("PruneOldest did not remove the oldest segment", pruned)
+		}
+	}
+
+	if ok, err := muxer.PruneOldest(); ok || err != nil {
+		t.Error("PruneOldest pruned a segment in the playlist", err)
+	}
+	for _, kept := range []string{"init.mp4", "raspilive-2.m4s"} {
+		if _, err := os.Stat(path.Join(dir, kept)); err != nil {
+			t.Error("PruneOldest removed", kept)
+		}
+	}
+}
+
 func TestStringReturnsStringifiedCommand(t *testing.T) {
 	execCommand = mockExecCommand
 	defer func() { execCommand = exec.Command }()
@@ -0,0 +1,241 @@
+package hls
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Keyring generates the AES-128 keys that Ffmpeg encrypts segments with, rotating through them as segments are written.
+//
+// Ffmpeg learns about the current key through the key info file, which it reads again before every segment. Keys are
+// kept in their own directory, away from the files being served, and handed out to players by the keyring itself so
+// that they may be protected separately from the segments. Every run names its keys uniquely, and the keys of earlier
+// runs are loaded back in and kept for as long as the playlists still list segments encrypted with them, as they do
+// when Ffmpeg appends to the playlist of the run before.
+type Keyring struct {
+	Directory string          // Private directory to keep the keys in
+	URI       string          // Base URI that players fetch the keys from
+	Rotation  int             // Number of segments to encrypt with each key, no fewer than two
+	Segments  http.FileSystem // Where Ffmpeg writes the segments, checked before removing the keys they are encrypted with
+	mu        sync.Mutex
+	keys      []string                   // Names of the keys, oldest first
+	loaded    map[string]bool            // Names of the keys left behind by earlier runs
+	segments  map[string]map[string]bool // Names of the segments encrypted with each key that may still be around
+	run       string                     // Random prefix of the names of this run's keys
+	sequence  int
+	newest    int // Sequence number of the newest segment encrypted with the current key
+	count     int // Number of segments listed so far that are encrypted with the current key
+}
+
+// InfoFile returns the location of the key info file for Ffmpeg.
+func (kr *Keyring) InfoFile() string {
+	return path.Join(kr.Directory, "livestream.keyinfo")
+}
+
+// Load picks up the keys left behind in the directory by earlier runs, so that they are handed out for as long as
+// segments encrypted with them are listed.
+func (kr *Keyring) Load() error {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	entries, err := ioutil.ReadDir(kr.Directory)
+	if err != nil {
+		return err
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].ModTime().Before(entries[j].ModTime()) })
+
+	kr.loaded = make(map[string]bool)
+	for _, entry := range entries {
+		if entry.IsDir() || path.Ext(entry.Name()) != ".key" {
+			continue
+		}
+
+		kr.keys = append(kr.keys, entry.Name())
+		kr.loaded[entry.Name()] = true
+	}
+
+	return nil
+}
+
+// Rotate generates a new key for the segments that follow.
+func (kr *Keyring) Rotate() error {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	return kr.rotate()
+}
+
+func (kr *Keyring) rotate() error {
+	if kr.run == "" {
+		// Names are never reused across runs, as players and caches may still hold on to a key by its URI
+		run := make([]byte, 4)
+		if _, err := rand.Read(run); err != nil {
+			return err
+		}
+		kr.run = hex.EncodeToString(run)
+	}
+
+	key := make([]byte, 16)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("raspilive-%s-%d.key", kr.run, kr.sequence)
+	keyFile := path.Join(kr.Directory, name)
+	if err := ioutil.WriteFile(keyFile, key, 0600); err != nil {
+		return err
+	}
+
+	// Swap the key info file into place all at once so that Ffmpeg never reads half of it
+	info := strings.TrimSuffix(kr.URI, "/") + "/" + name + "\n" + keyFile + "\n"
+	tmpFile := kr.InfoFile() + ".tmp"
+	if err := ioutil.WriteFile(tmpFile, []byte(info), 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpFile, kr.InfoFile()); err != nil {
+		return err
+	}
+
+	if kr.sequence == 0 {
+		kr.newest = -1
+	}
+
+	kr.sequence++
+	kr.keys = append(kr.keys, name)
+	kr.count = 0
+
+	return nil
+}
+
+// Update keeps the keys in step with the media playlists that Ffmpeg writes, rotating to a new key once the current
+// one has been used for enough segments and removing the oldest keys once the last segment encrypted with them is
+// gone.
+func (kr *Keyring) Update(playlists ...[]byte) error {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	// Nothing can be told about the keys until Ffmpeg has written a playlist
+	if len(playlists) == 0 {
+		return nil
+	}
+
+	if kr.segments == nil {
+		kr.segments = make(map[string]map[string]bool)
+	}
+
+	current := kr.keys[len(kr.keys)-1]
+	listed := make(map[string]bool)
+	advanced := false
+	for _, playlist := range playlists {
+		for _, seg := range parsePlaylist(playlist) {
+			listed[seg.name] = true
+			if seg.key == "" {
+				continue
+			}
+
+			if kr.segments[seg.key] == nil {
+				kr.segments[seg.key] = make(map[string]bool)
+			}
+			kr.segments[seg.key][seg.name] = true
+
+			// Every variant stream shares the same sequence numbers, so each segment is only counted once
+			if seg.key == current && seg.sequence > kr.newest {
+				kr.newest = seg.sequence
+				kr.count++
+				advanced = true
+			}
+		}
+	}
+
+	// Ffmpeg has already started on the next segment by the time a segment is listed, so rotate one segment early
+	threshold := kr.Rotation - 1
+	if threshold < 1 {
+		threshold = 1
+	}
+	if advanced && kr.count >= threshold {
+		if err := kr.rotate(); err != nil {
+			return err
+		}
+	}
+
+	// Segments are removed oldest first, so the oldest keys are the first to go
+	for len(kr.keys) > 1 && kr.unused(kr.keys[0], listed) {
+		os.Remove(path.Join(kr.Directory, kr.keys[0]))
+		delete(kr.segments, kr.keys[0])
+		kr.keys = kr.keys[1:]
+	}
+
+	return nil
+}
+
+// unused reports whether every segment encrypted with the key is gone, forgetting about the ones that are.
+func (kr *Keyring) unused(key string, listed map[string]bool) bool {
+	segments := kr.segments[key]
+	if len(segments) == 0 {
+		// Ffmpeg has yet to finish a segment with it, unless it is left over from a run whose segments are no longer
+		// listed
+		return kr.loaded[key]
+	}
+
+	for name := range segments {
+		if listed[name] || kr.exists(name) {
+			return false
+		}
+		delete(segments, name)
+	}
+
+	return true
+}
+
+// exists reports whether the segment is still stored, assuming that it is if that cannot be told for sure.
+func (kr *Keyring) exists(name string) bool {
+	if kr.Segments == nil {
+		return false
+	}
+
+	f, err := kr.Segments.Open(name)
+	if err != nil {
+		return !os.IsNotExist(err)
+	}
+	f.Close()
+
+	return true
+}
+
+// Key returns the key with the given name, as long as it is still in use.
+func (kr *Keyring) Key(name string) ([]byte, error) {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	for _, key := range kr.keys {
+		if key == name {
+			return ioutil.ReadFile(path.Join(kr.Directory, name))
+		}
+	}
+
+	return nil, errors.New("ffmpeg hls: key not found")
+}
+
+// ServeHTTP serves the keys by name.
+func (kr *Keyring) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key, err := kr.Key(path.Base(r.URL.Path))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Keep keys out of shared caches like CDNs, unlike the segments
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(key)
+}
@@ -0,0 +1,212 @@
+package hls
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "raspilive-keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	return dir
+}
+
+func TestKeyringRotate(t *testing.T) {
+	dir := tempDir(t)
+	keyring := Keyring{Directory: dir, URI: "/keys/"}
+
+	for i := 0; i < 3; i++ {
+		if err := keyring.Rotate(); err != nil {
+			t.Fatal("Rotate returned an error", err)
+		}
+	}
+
+	info, err := ioutil.ReadFile(keyring.InfoFile())
+	if err != nil {
+		t.Fatal("Rotate did not write key info file", err)
+	}
+
+	expected := "/keys/" + runKey(&keyring, 2) + "\n" + path.Join(dir, runKey(&keyring, 2)) + "\n"
+	if string(info) != expected {
+		t.Error("Rotate wrote incorrect key info file, got\n", string(info))
+	}
+
+	key, err := ioutil.ReadFile(path.Join(dir, runKey(&keyring, 2)))
+	if err != nil || len(key) != 16 {
+		t.Error("Rotate did not write a 16 byte key", err)
+	}
+}
+
+// runKey returns the name of the keyring's key with the given index in this run.
+func runKey(keyring *Keyring, index int) string {
+	return fmt.Sprintf("raspilive-%s-%d.key", keyring.run, index)
+}
+
+// encryptedPlaylist writes a media playlist listing segments from the first sequence number, each encrypted with the
+// keyring's key of the same index.
+func encryptedPlaylist(keyring *Keyring, first int, keys ...int) []byte {
+	playlist := fmt.Sprintf("#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:2\n#EXT-X-MEDIA-SEQUENCE:%d\n", first)
+
+	previous := -1
+	for i, key := range keys {
+		if key != previous {
+			playlist += fmt.Sprintf("#EXT-X-KEY:METHOD=AES-128,URI=\"/keys/%s\",IV=0x%032x\n", runKey(keyring, key), first+i)
+			previous = key
+		}
+		playlist += fmt.Sprintf("#EXTINF:2.000000,\nraspilive-%03d.ts\n", first+i)
+	}
+
+	return []byte(playlist)
+}
+
+func TestKeyringUpdateRotates(t *testing.T) {
+	keyring := Keyring{Directory: tempDir(t), URI: "/keys/", Rotation: 3}
+	keyring.Rotate()
+
+	// Ffmpeg starts on the third segment before the second is listed, so the key is switched for the fourth
+	keyring.Update(encryptedPlaylist(&keyring, 0, 0))
+	if _, err := keyring.Key(runKey(&keyring, 1)); err == nil {
+		t.Fatal("Update rotated too early")
+	}
+
+	keyring.Update(encryptedPlaylist(&keyring, 0, 0, 0))
+	if _, err := keyring.Key(runKey(&keyring, 1)); err != nil {
+		t.Fatal("Update did not rotate")
+	}
+
+	// Listing the same segments again, or the segment that was already under way, does not count towards the new key
+	keyring.Update(encryptedPlaylist(&keyring, 0, 0, 0))
+	keyring.Update(encryptedPlaylist(&keyring, 0, 0, 0, 0))
+	keyring.Update(encryptedPlaylist(&keyring, 0, 0, 0, 0, 1))
+	if _, err := keyring.Key(runKey(&keyring, 2)); err == nil {
+		t.Fatal("Update rotated too early")
+	}
+
+	keyring.Update(encryptedPlaylist(&keyring, 0, 0, 0, 0, 1, 1))
+	if _, err := keyring.Key(runKey(&keyring, 2)); err != nil {
+		t.Fatal("Update did not rotate")
+	}
+}
+
+func TestKeyringUpdateRemovesKeysOnceSegmentsAreGone(t *testing.T) {
+	segments := tempDir(t)
+	keyring := Keyring{Directory: tempDir(t), URI: "/keys/", Rotation: 2, Segments: http.Dir(segments)}
+	keyring.Rotate()
+
+	keyring.Update(encryptedPlaylist(&keyring, 0, 0))
+	keyring.Update(encryptedPlaylist(&keyring, 0, 0, 0, 1))
+
+	// The segment has fallen out of the playlist but is still stored
+	ioutil.WriteFile(path.Join(segments, "raspilive-001.ts"), nil, 0644)
+	keyring.Update(encryptedPlaylist(&keyring, 2, 1, 1))
+	if _, err := keyring.Key(runKey(&keyring, 0)); err != nil {
+		t.Fatal("Update removed a key that a stored segment is encrypted with")
+	}
+
+	os.Remove(path.Join(segments, "raspilive-001.ts"))
+	keyring.Update(encryptedPlaylist(&keyring, 2, 1, 1))
+	if _, err := keyring.Key(runKey(&keyring, 0)); err == nil {
+		t.Error("Update did not remove a key that no segment is encrypted with")
+	}
+	if _, err := os.Stat(path.Join(keyring.Directory, runKey(&keyring, 0))); !os.IsNotExist(err) {
+		t.Error("Update did not remove the key file")
+	}
+
+	for _, name := range []string{runKey(&keyring, 1), runKey(&keyring, 2)} {
+		if _, err := keyring.Key(name); err != nil {
+			t.Error("Update removed key in use", name)
+		}
+	}
+}
+
+func TestKeyringLoadCarriesOverKeys(t *testing.T) {
+	dir := tempDir(t)
+	earlier := Keyring{Directory: dir, URI: "/keys/", Rotation: 2}
+	earlier.Rotate()
+	earlier.Rotate()
+	previous := encryptedPlaylist(&earlier, 0, 0, 1)
+
+	keyring := Keyring{Directory: dir, URI: "/keys/", Rotation: 2, Segments: http.Dir(tempDir(t))}
+	if err := keyring.Load(); err != nil {
+		t.Fatal("Load returned an error", err)
+	}
+	keyring.Rotate()
+
+	if runKey(&keyring, 0) == runKey(&earlier, 0) {
+		t.Fatal("Rotate reused the name of a key from an earlier run")
+	}
+
+	// Ffmpeg appends to the playlist of the earlier run
+	playlist := append(previous, encryptedPlaylist(&keyring, 2, 0)[len("#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:2\n#EXT-X-MEDIA-SEQUENCE:2\n"):]...)
+	keyring.Update(playlist)
+	for _, name := range []string{runKey(&earlier, 0), runKey(&earlier, 1), runKey(&keyring, 0)} {
+		if _, err := keyring.Key(name); err != nil {
+			t.Error("Keyring does not hand out key of listed segments", name)
+		}
+	}
+
+	keyring.Update(encryptedPlaylist(&keyring, 2, 0))
+	for _, name := range []string{runKey(&earlier, 0), runKey(&earlier, 1)} {
+		if _, err := keyring.Key(name); err == nil {
+			t.Error("Update did not remove key of an earlier run that is no longer listed", name)
+		}
+	}
+}
+
+func TestKeyringRotateGeneratesDifferentKeys(t *testing.T) {
+	dir := tempDir(t)
+	keyring := Keyring{Directory: dir}
+
+	keyring.Rotate()
+	keyring.Rotate()
+
+	first, _ := keyring.Key(runKey(&keyring, 0))
+	second, _ := keyring.Key(runKey(&keyring, 1))
+	if len(first) != 16 || string(first) == string(second) {
+		t.Error("Rotate did not generate a new key")
+	}
+}
+
+func TestKeyringServeHTTP(t *testing.T) {
+	keyring := Keyring{Directory: tempDir(t)}
+	keyring.Rotate()
+
+	srv := httptest.NewServer(&keyring)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/keys/" + runKey(&keyring, 0))
+	if err != nil {
+		t.Fatal("Request to server failed:", err)
+	}
+	if resp.StatusCode != 200 {
+		t.Error("Request to server failed with status code", resp.StatusCode)
+	}
+	if cacheControl := resp.Header.Get("Cache-Control"); cacheControl != "no-store" {
+		t.Error("Response has incorrect cache control:", cacheControl)
+	}
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	if len(body) != 16 {
+		t.Error("Response body was not a key, got", len(body), "bytes")
+	}
+
+	for _, name := range []string{runKey(&keyring, 1), "livestream.keyinfo", strings.Repeat("../", 3) + "etc/passwd"} {
+		resp, err := http.Get(srv.URL + "/keys/" + name)
+		if err != nil {
+			t.Fatal("Request to server failed:", err)
+		}
+		if resp.StatusCode != 404 {
+			t.Error("Request for", name, "returned status code", resp.StatusCode)
+		}
+	}
+}
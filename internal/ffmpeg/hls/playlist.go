@@ -0,0 +1,161 @@
+package hls
+
+import (
+	"math"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jaredpetersen/raspilive/internal/segment"
+)
+
+// listedSegment is a segment listed in a media playlist written by Ffmpeg.
+type listedSegment struct {
+	name     string    // File name of the segment
+	sequence int       // Media sequence number of the segment
+	key      string    // File name of the key that the segment is encrypted with, empty if it is not encrypted
+	seconds  float64   // Duration of the segment
+	captured time.Time // Wall clock time that the segment was captured, zero if the playlist does not say
+}
+
+// programDateTimeLayouts are the layouts that the wall clock time of a segment may be written in. Ffmpeg leaves the
+// colon out of the time zone offset, unlike RFC 3339.
+var programDateTimeLayouts = []string{"2006-01-02T15:04:05.999999999-0700", time.RFC3339Nano}
+
+// parsePlaylist reads the segments listed in the media playlist, oldest first, along with the keys they are encrypted
+// with.
+func parsePlaylist(playlist []byte) []listedSegment {
+	var segments []listedSegment
+
+	sequence, key, seconds := 0, "", 0.0
+	var captured time.Time
+	for _, line := range strings.Split(string(playlist), "\n") {
+		line = strings.TrimSpace(line)
+
+		switch {
+		case strings.HasPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"):
+			sequence, _ = strconv.Atoi(strings.TrimPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"))
+		case strings.HasPrefix(line, "#EXT-X-KEY:"):
+			key = keyName(line)
+		case strings.HasPrefix(line, "#EXTINF:"):
+			duration := strings.TrimPrefix(line, "#EXTINF:")
+			if comma := strings.IndexByte(duration, ','); comma >= 0 {
+				duration = duration[:comma]
+			}
+			seconds, _ = strconv.ParseFloat(duration, 64)
+		case strings.HasPrefix(line, "#EXT-X-PROGRAM-DATE-TIME:"):
+			captured = parseProgramDateTime(strings.TrimPrefix(line, "#EXT-X-PROGRAM-DATE-TIME:"))
+		case line == "" || strings.HasPrefix(line, "#"):
+			continue
+		default:
+			segments = append(segments, listedSegment{
+				name:     path.Base(line),
+				sequence: sequence,
+				key:      key,
+				seconds:  seconds,
+				captured: captured,
+			})
+			sequence++
+
+			// Segments without a wall clock time of their own follow straight on from the one before
+			if !captured.IsZero() {
+				captured = captured.Add(time.Duration(seconds * float64(time.Second)))
+			}
+			seconds = 0
+		}
+	}
+
+	return segments
+}
+
+// parseProgramDateTime reads the wall clock time of a segment, returning the zero time if it cannot be read.
+func parseProgramDateTime(value string) time.Time {
+	for _, layout := range programDateTimeLayouts {
+		if captured, err := time.Parse(layout, value); err == nil {
+			return captured
+		}
+	}
+
+	return time.Time{}
+}
+
+// keyName returns the file name of the key in the key tag, empty if the segments that follow are not encrypted.
+func keyName(tag string) string {
+	if strings.Contains(tag, "METHOD=NONE") {
+		return ""
+	}
+
+	return uriName(tag)
+}
+
+// uriName returns the file name in the URI attribute of the tag, empty if it has none.
+func uriName(tag string) string {
+	start := strings.Index(tag, `URI="`)
+	if start < 0 {
+		return ""
+	}
+
+	uri := tag[start+len(`URI="`):]
+	if end := strings.IndexByte(uri, '"'); end >= 0 {
+		uri = uri[:end]
+	}
+
+	return path.Base(uri)
+}
+
+// PeakBandwidth returns the highest bitrate in bits per second of the segments listed in the media playlist, as the
+// BANDWIDTH of its variant in the master playlist, or zero if no segment has been written yet.
+func PeakBandwidth(playlist []byte, segments http.FileSystem) int {
+	peak := 0
+	for _, segment := range parsePlaylist(playlist) {
+		if segment.seconds <= 0 {
+			continue
+		}
+
+		file, err := segments.Open(segment.name)
+		if err != nil {
+			// Ffmpeg may have removed the segment since writing the playlist
+			continue
+		}
+		info, err := file.Stat()
+		file.Close()
+		if err != nil {
+			continue
+		}
+
+		if bandwidth := int(math.Ceil(float64(info.Size()*8) / segment.seconds)); bandwidth > peak {
+			peak = bandwidth
+		}
+	}
+
+	return peak
+}
+
+// Segments returns the segments listed in the media playlist, oldest first, for keeping track of outside of Ffmpeg. Each
+// has the wall clock time it was captured if the playlist tags it with one. The file name of the initialization segment
+// is returned along with them if they are fragmented MP4.
+func Segments(playlist []byte) ([]segment.Segment, string) {
+	init := ""
+	for _, line := range strings.Split(string(playlist), "\n") {
+		if strings.HasPrefix(line, "#EXT-X-MAP:") {
+			init = uriName(strings.TrimSpace(line))
+		}
+	}
+
+	var segments []segment.Segment
+	for _, listed := range parsePlaylist(playlist) {
+		seg := segment.Segment{
+			Name:     listed.name,
+			Number:   listed.sequence,
+			Duration: int64(math.Round(listed.seconds * segment.Timescale)),
+		}
+		if !listed.captured.IsZero() {
+			seg.Time = listed.captured.UTC()
+		}
+		segments = append(segments, seg)
+	}
+
+	return segments, init
+}
@@ -0,0 +1,102 @@
+package hls
+
+import (
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jaredpetersen/raspilive/internal/segment"
+)
+
+func TestParsePlaylist(t *testing.T) {
+	playlist := "#EXTM3U\n" +
+		"#EXT-X-VERSION:3\n" +
+		"#EXT-X-TARGETDURATION:2\n" +
+		"#EXT-X-MEDIA-SEQUENCE:7\n" +
+		"#EXT-X-KEY:METHOD=AES-128,URI=\"/keys/raspilive-1.key\",IV=0x00000000000000000000000000000007\n" +
+		"#EXTINF:2.000000,\n" +
+		"raspilive-007.ts\n" +
+		"#EXT-X-KEY:METHOD=NONE\n" +
+		"#EXTINF:2.000000,\n" +
+		"http://127.0.0.1:8080/raspilive-008.ts\n"
+
+	expected := []listedSegment{
+		{name: "raspilive-007.ts", sequence: 7, key: "raspilive-1.key", seconds: 2},
+		{name: "raspilive-008.ts", sequence: 8, seconds: 2},
+	}
+
+	segments := parsePlaylist([]byte(playlist))
+	if len(segments) != len(expected) {
+		t.Fatal("parsePlaylist returned incorrect segments, got", segments)
+	}
+	for i := range expected {
+		if segments[i] != expected[i] {
+			t.Error("parsePlaylist returned incorrect segment, got", segments[i])
+		}
+	}
+}
+
+func TestPeakBandwidth(t *testing.T) {
+	dir := tempDir(t)
+	ioutil.WriteFile(path.Join(dir, "raspilive-000.ts"), []byte(strings.Repeat("v", 500000)), 0644)
+	ioutil.WriteFile(path.Join(dir, "raspilive-001.ts"), []byte(strings.Repeat("v", 400000)), 0644)
+
+	playlist := "#EXTM3U\n" +
+		"#EXTINF:2.000000,\n" +
+		"raspilive-000.ts\n" +
+		"#EXTINF:1.000000,\n" +
+		"raspilive-001.ts\n" +
+		"#EXTINF:2.000000,\n" +
+		"raspilive-002.ts\n"
+
+	if peak := PeakBandwidth([]byte(playlist), http.Dir(dir)); peak != 3200000 {
+		t.Error("PeakBandwidth returned incorrect bandwidth, got", peak)
+	}
+	if peak := PeakBandwidth([]byte("#EXTM3U\n"), http.Dir(dir)); peak != 0 {
+		t.Error("PeakBandwidth returned bandwidth without segments, got", peak)
+	}
+}
+
+func TestSegments(t *testing.T) {
+	playlist := "#EXTM3U\n" +
+		"#EXT-X-VERSION:7\n" +
+		"#EXT-X-TARGETDURATION:2\n" +
+		"#EXT-X-MEDIA-SEQUENCE:3\n" +
+		"#EXT-X-MAP:URI=\"init.mp4\"\n" +
+		"#EXTINF:2.000000,\n" +
+		"raspilive-3.m4s\n" +
+		"#EXT-X-PROGRAM-DATE-TIME:2021-03-17T12:00:02.000+0000\n" +
+		"#EXTINF:2.000000,\n" +
+		"raspilive-4.m4s\n" +
+		"#EXTINF:1.500000,\n" +
+		"raspilive-5.m4s\n" +
+		"#EXT-X-PROGRAM-DATE-TIME:2021-03-17T05:00:05.500-07:00\n" +
+		"#EXTINF:2.000000,\n" +
+		"raspilive-6.m4s\n"
+
+	expected := []segment.Segment{
+		{Name: "raspilive-3.m4s", Number: 3, Duration: 180000},
+		{Name: "raspilive-4.m4s", Number: 4, Duration: 180000, Time: time.Date(2021, 3, 17, 12, 0, 2, 0, time.UTC)},
+		{Name: "raspilive-5.m4s", Number: 5, Duration: 135000, Time: time.Date(2021, 3, 17, 12, 0, 4, 0, time.UTC)},
+		{Name: "raspilive-6.m4s", Number: 6, Duration: 180000, Time: time.Date(2021, 3, 17, 12, 0, 5, 500000000, time.UTC)},
+	}
+
+	// There is no telling when the first segment was captured
+	segments, init := Segments([]byte(playlist))
+	if init != "init.mp4" {
+		t.Error("Segments returned incorrect initialization segment, got", init)
+	}
+	if len(segments) != len(expected) {
+		t.Fatal("Segments returned incorrect segments, got", segments)
+	}
+	for i := range expected {
+		seg := segments[i]
+		if seg.Name != expected[i].Name || seg.Number != expected[i].Number || seg.Duration != expected[i].Duration ||
+			!seg.Time.Equal(expected[i].Time) {
+			t.Error("Segments returned incorrect segment, got", seg)
+		}
+	}
+}
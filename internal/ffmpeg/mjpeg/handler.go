@@ -0,0 +1,219 @@
+package mjpeg
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Handler serves MJPEG video to HTTP clients as a `multipart/x-mixed-replace` stream.
+//
+// The video is only transcoded while at least one client is connected. Use Feed to supply the H.264 video stream.
+type Handler struct {
+	Options Options
+	mu      sync.Mutex
+	clients map[chan []byte]struct{}
+	input   *io.PipeWriter
+}
+
+// Feed reads the H.264 video stream, passing it along to the transcoder while clients are connected and discarding it
+// otherwise.
+//
+// Blocks until the video stream ends.
+func (hdlr *Handler) Feed(video io.Reader) error {
+	buf := make([]byte, 32*1024)
+
+	for {
+		n, err := video.Read(buf)
+		if n > 0 {
+			hdlr.mu.Lock()
+			input := hdlr.input
+			hdlr.mu.Unlock()
+
+			// Write errors just indicate that the transcoder was stopped out from under us
+			if input != nil {
+				input.Write(buf[:n])
+			}
+		}
+
+		if err == io.EOF || errors.Is(err, os.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// ServeHTTP streams MJPEG video to the client until the client disconnects.
+func (hdlr *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	frames, err := hdlr.subscribe()
+	if err != nil {
+		log.Debug().Err(err).Msg("Encountered an error starting MJPEG transcode")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	defer hdlr.unsubscribe(frames)
+
+	mpw := multipart.NewWriter(w)
+	mpw.SetBoundary(boundary)
+
+	w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary="+boundary)
+	w.Header().Set("Cache-Control", "no-cache, no-store")
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case frame, ok := <-frames:
+			if !ok {
+				return
+			}
+
+			header := textproto.MIMEHeader{}
+			header.Set("Content-Type", "image/jpeg")
+			header.Set("Content-Length", strconv.Itoa(len(frame)))
+
+			part, err := mpw.CreatePart(header)
+			if err != nil {
+				return
+			}
+			if _, err := part.Write(frame); err != nil {
+				return
+			}
+
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+// Close disconnects all of the clients and stops the transcoder.
+func (hdlr *Handler) Close() {
+	hdlr.mu.Lock()
+	defer hdlr.mu.Unlock()
+
+	for client := range hdlr.clients {
+		close(client)
+		delete(hdlr.clients, client)
+	}
+
+	hdlr.stop()
+}
+
+func (hdlr *Handler) subscribe() (chan []byte, error) {
+	hdlr.mu.Lock()
+	defer hdlr.mu.Unlock()
+
+	if hdlr.input == nil {
+		if err := hdlr.start(); err != nil {
+			return nil, err
+		}
+	}
+
+	if hdlr.clients == nil {
+		hdlr.clients = make(map[chan []byte]struct{})
+	}
+
+	client := make(chan []byte, 1)
+	hdlr.clients[client] = struct{}{}
+
+	return client, nil
+}
+
+func (hdlr *Handler) unsubscribe(client chan []byte) {
+	hdlr.mu.Lock()
+	defer hdlr.mu.Unlock()
+
+	if _, ok := hdlr.clients[client]; ok {
+		delete(hdlr.clients, client)
+	}
+
+	if len(hdlr.clients) == 0 {
+		hdlr.stop()
+	}
+}
+
+// start launches the transcoder. Must be called with the lock held.
+func (hdlr *Handler) start() error {
+	reader, writer := io.Pipe()
+
+	muxer := Muxer{Options: hdlr.Options}
+	if err := muxer.Mux(reader); err != nil {
+		return err
+	}
+	log.Debug().Str("cmd", muxer.String()).Msg("Started ffmpeg MJPEG transcoder")
+
+	hdlr.input = writer
+	go hdlr.broadcast(&muxer, writer)
+
+	return nil
+}
+
+// stop shuts down the transcoder by ending its input. Must be called with the lock held.
+func (hdlr *Handler) stop() {
+	if hdlr.input == nil {
+		return
+	}
+
+	hdlr.input.Close()
+	hdlr.input = nil
+	log.Debug().Msg("Stopped ffmpeg MJPEG transcoder")
+}
+
+func (hdlr *Handler) broadcast(muxer *Muxer, input *io.PipeWriter) {
+	frames := multipart.NewReader(muxer.Frames, boundary)
+
+	for {
+		part, err := frames.NextPart()
+		if err != nil {
+			break
+		}
+
+		frame, err := ioutil.ReadAll(part)
+		if err != nil {
+			break
+		}
+
+		hdlr.mu.Lock()
+		for client := range hdlr.clients {
+			// Drop the frame for clients that are falling behind rather than hold up everyone else
+			select {
+			case client <- frame:
+			default:
+			}
+		}
+		hdlr.mu.Unlock()
+	}
+
+	// Make sure that the transcoder is not left waiting on more video if it quit on its own
+	input.Close()
+
+	if err := muxer.Wait(); err != nil {
+		log.Debug().Err(err).Msg("Encountered an error waiting for MJPEG transcode")
+	}
+
+	hdlr.mu.Lock()
+	defer hdlr.mu.Unlock()
+
+	// Disconnect everyone if the transcoder died while it was still in use so that they may reconnect
+	if hdlr.input == input {
+		for client := range hdlr.clients {
+			close(client)
+			delete(hdlr.clients, client)
+		}
+		hdlr.input = nil
+	}
+}
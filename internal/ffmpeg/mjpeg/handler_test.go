@@ -0,0 +1,122 @@
+package mjpeg
+
+import (
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServeHTTPStreamsFrames(t *testing.T) {
+	execCommand = mockExecCommand
+	defer func() { execCommand = exec.Command }()
+
+	hdlr := Handler{}
+	defer hdlr.Close()
+
+	srv := httptest.NewServer(&hdlr)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal("Request to server failed:", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		t.Error("Request to server failed with status code", resp.StatusCode)
+	}
+
+	mediaType, params, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "multipart/x-mixed-replace" {
+		t.Fatal("Response has incorrect content type:", mediaType)
+	}
+
+	frames := multipart.NewReader(resp.Body, params["boundary"])
+	for i := 0; i < 2; i++ {
+		part, err := frames.NextPart()
+		if err != nil {
+			t.Fatal("Failed to read frame:", err)
+		}
+		if part.Header.Get("Content-Type") != "image/jpeg" {
+			t.Error("Frame has incorrect content type:", part.Header.Get("Content-Type"))
+		}
+
+		frame, _ := ioutil.ReadAll(part)
+		if string(frame) != fakeFrameContent {
+			t.Error("Frame is invalid:", string(frame))
+		}
+	}
+}
+
+func TestServeHTTPStopsTranscodeWithoutClients(t *testing.T) {
+	execCommand = mockExecCommand
+	defer func() { execCommand = exec.Command }()
+
+	hdlr := Handler{}
+	defer hdlr.Close()
+
+	srv := httptest.NewServer(&hdlr)
+	defer srv.Close()
+
+	if hdlr.input != nil {
+		t.Fatal("Transcode started before any clients connected")
+	}
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal("Request to server failed:", err)
+	}
+
+	hdlr.mu.Lock()
+	started := hdlr.input != nil
+	hdlr.mu.Unlock()
+	if !started {
+		t.Error("Transcode was not started for client")
+	}
+
+	resp.Body.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	hdlr.mu.Lock()
+	stopped := hdlr.input == nil
+	hdlr.mu.Unlock()
+	if !stopped {
+		t.Error("Transcode was not stopped after client disconnected")
+	}
+}
+
+func TestServeHTTPReturnsErrorForFailedTranscode(t *testing.T) {
+	execCommand = mockFailedExecCommand
+	defer func() { execCommand = exec.Command }()
+
+	hdlr := Handler{}
+	defer hdlr.Close()
+
+	srv := httptest.NewServer(&hdlr)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal("Request to server failed:", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 500 {
+		t.Error("Request to server did not fail, got status code", resp.StatusCode)
+	}
+}
+
+func TestFeedDiscardsVideoWithoutClients(t *testing.T) {
+	hdlr := Handler{}
+
+	err := hdlr.Feed(strings.NewReader("totallyfakevideostream"))
+	if err != nil {
+		t.Error("Feed returned an error", err)
+	}
+}
@@ -0,0 +1,92 @@
+package mjpeg
+
+import (
+	"errors"
+	"io"
+	"os/exec"
+	"strconv"
+)
+
+// boundary separates the individual JPEG frames in the multipart MJPEG stream.
+const boundary = "raspilive"
+
+// Options represents ways that Ffmpeg may be configured to transcode video to MJPEG.
+//
+// Ffmpeg will step in and use its own defaults if a value is not provided.
+type Options struct {
+	Fps     int // Framerate of the output video
+	Quality int // JPEG quality scale, from 2 (best) to 31 (worst)
+}
+
+// Muxer represents the MJPEG muxer.
+//
+// The video is transcoded to a stream of JPEG images and made available via Frames in the multipart format.
+type Muxer struct {
+	Options Options
+	Frames  io.ReadCloser
+	cmd     *exec.Cmd
+}
+
+var execCommand = exec.Command
+
+// Mux begins transcoding the video stream to the MJPEG format.
+func (muxer *Muxer) Mux(video io.ReadCloser) error {
+	args := []string{
+		"-i", "pipe:0",
+		"-f", "mpjpeg",
+		"-an",
+		"-boundary_tag", boundary,
+	}
+
+	if muxer.Options.Fps != 0 {
+		args = append(args, "-r", strconv.Itoa(muxer.Options.Fps))
+	}
+
+	if muxer.Options.Quality != 0 {
+		args = append(args, "-q:v", strconv.Itoa(muxer.Options.Quality))
+	}
+
+	args = append(args, "pipe:1")
+
+	cmd := execCommand("ffmpeg", args...)
+	cmd.Stdin = video
+
+	frames, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	muxer.cmd = cmd
+	muxer.Frames = frames
+
+	return muxer.cmd.Start()
+}
+
+// Wait waits for the video stream to finish processing.
+//
+// The frames must be read in their entirety before calling Wait.
+func (muxer *Muxer) Wait() error {
+	if muxer.cmd == nil {
+		return errors.New("ffmpeg mjpeg: not started")
+	}
+
+	err := muxer.cmd.Wait()
+
+	// Ignore 255 status -- just indicates that we exited early
+	if err != nil && err.Error() == "exit status 255" {
+		err = nil
+	}
+
+	return err
+}
+
+func (muxer *Muxer) String() string {
+	var cmdStr string
+	if muxer.cmd == nil {
+		cmdStr = ""
+	} else {
+		cmdStr = muxer.cmd.String()
+	}
+
+	return cmdStr
+}
@@ -0,0 +1,243 @@
+package mjpeg
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+const fakeFrameContent = "fakejpegframe"
+
+func TestMain(m *testing.M) {
+	// Facilitate the "mocking" of os/exec by running a faked CLI program
+	switch os.Getenv("GO_TEST_MODE") {
+	case "":
+		os.Exit(m.Run())
+	case "ffmpeg":
+		// Continually produce frames until the video input ends
+		frames := multipart.NewWriter(os.Stdout)
+		frames.SetBoundary(boundary)
+		part, _ := frames.CreatePart(nil)
+		part.Write([]byte(fakeFrameContent))
+		go func() {
+			for {
+				time.Sleep(10 * time.Millisecond)
+				part, _ := frames.CreatePart(nil)
+				part.Write([]byte(fakeFrameContent))
+			}
+		}()
+		io.Copy(ioutil.Discard, os.Stdin)
+		os.Exit(0)
+	}
+}
+
+func TestMux(t *testing.T) {
+	testCases := []struct {
+		muxer        Muxer
+		expectedArgs []string
+	}{
+		{
+			Muxer{},
+			[]string{
+				"ffmpeg",
+				"-i", "pipe:0",
+				"-f", "mpjpeg",
+				"-an",
+				"-boundary_tag", "raspilive",
+				"pipe:1",
+			},
+		},
+		{
+			Muxer{Options: Options{Fps: 10}},
+			[]string{
+				"ffmpeg",
+				"-i", "pipe:0",
+				"-f", "mpjpeg",
+				"-an",
+				"-boundary_tag", "raspilive",
+				"-r", "10",
+				"pipe:1",
+			},
+		},
+		{
+			Muxer{Options: Options{Quality: 5}},
+			[]string{
+				"ffmpeg",
+				"-i", "pipe:0",
+				"-f", "mpjpeg",
+				"-an",
+				"-boundary_tag", "raspilive",
+				"-q:v", "5",
+				"pipe:1",
+			},
+		},
+		{
+			Muxer{Options: Options{Fps: 15, Quality: 2}},
+			[]string{
+				"ffmpeg",
+				"-i", "pipe:0",
+				"-f", "mpjpeg",
+				"-an",
+				"-boundary_tag", "raspilive",
+				"-r", "15",
+				"-q:v", "2",
+				"pipe:1",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%v", tc.muxer), func(t *testing.T) {
+			execCommand = mockExecCommand
+			defer func() { execCommand = exec.Command }()
+
+			videoStream := ioutil.NopCloser(strings.NewReader("totallyfakevideostream"))
+
+			mjpegMuxer := tc.muxer
+			err := mjpegMuxer.Mux(videoStream)
+
+			if err != nil {
+				t.Error("Mux produced an err", err)
+			}
+
+			ffmpegArgs := mjpegMuxer.cmd.Args[1:]
+
+			if !equal(ffmpegArgs, tc.expectedArgs) {
+				t.Error("Command args do not match, got", ffmpegArgs, "but wanted", tc.expectedArgs)
+			}
+
+			if mjpegMuxer.Frames == nil {
+				t.Error("Mux produced a Muxer without frame output")
+			}
+		})
+	}
+}
+
+func TestMuxProducesFrames(t *testing.T) {
+	execCommand = mockExecCommand
+	defer func() { execCommand = exec.Command }()
+
+	videoStream := ioutil.NopCloser(strings.NewReader("totallyfakevideostream"))
+
+	mjpegMuxer := Muxer{}
+	mjpegMuxer.Mux(videoStream)
+
+	part, err := multipart.NewReader(mjpegMuxer.Frames, boundary).NextPart()
+	if err != nil {
+		t.Fatal("Failed to read frame:", err)
+	}
+
+	frame, _ := ioutil.ReadAll(part)
+	if string(frame) != fakeFrameContent {
+		t.Error("Frame is invalid:", string(frame))
+	}
+}
+
+func TestMuxReturnsFfmpegError(t *testing.T) {
+	execCommand = mockFailedExecCommand
+	defer func() { execCommand = exec.Command }()
+
+	videoStream := ioutil.NopCloser(strings.NewReader("totallyfakevideostream"))
+
+	mjpegMuxer := Muxer{}
+	err := mjpegMuxer.Mux(videoStream)
+
+	if err == nil {
+		t.Error("Mux failed to return an error")
+	}
+}
+
+func TestWait(t *testing.T) {
+	execCommand = mockExecCommand
+	defer func() { execCommand = exec.Command }()
+
+	videoStream := ioutil.NopCloser(strings.NewReader("totallyfakevideostream"))
+
+	mjpegMuxer := Muxer{}
+	mjpegMuxer.Mux(videoStream)
+	io.Copy(ioutil.Discard, mjpegMuxer.Frames)
+	err := mjpegMuxer.Wait()
+
+	if err != nil {
+		t.Error("Wait returned an error", err)
+	}
+}
+
+func TestWaitWithoutStartReturnsError(t *testing.T) {
+	execCommand = mockExecCommand
+	defer func() { execCommand = exec.Command }()
+
+	mjpegMuxer := Muxer{}
+	err := mjpegMuxer.Wait()
+
+	if err == nil || err.Error() != "ffmpeg mjpeg: not started" {
+		t.Error("Wait failed to return correct error when run without Mux", err)
+	}
+}
+
+func TestStringReturnsStringifiedCommand(t *testing.T) {
+	execCommand = mockExecCommand
+	defer func() { execCommand = exec.Command }()
+
+	videoStream := ioutil.NopCloser(strings.NewReader("totallyfakevideostream"))
+
+	mjpegMuxer := Muxer{Options: Options{Fps: 15, Quality: 2}}
+	mjpegMuxer.Mux(videoStream)
+	defer mjpegMuxer.Wait()
+	defer io.Copy(ioutil.Discard, mjpegMuxer.Frames)
+
+	cmdStr := mjpegMuxer.String()
+	expectedCmdStr := "ffmpeg -i pipe:0 -f mpjpeg -an -boundary_tag raspilive -r 15 -q:v 2 pipe:1"
+
+	if !strings.Contains(cmdStr, expectedCmdStr) {
+		t.Error("String returned incorrect value, got:", cmdStr)
+	}
+}
+
+func TestStringReturnsNilForUnstartedOperation(t *testing.T) {
+	mjpegMuxer := Muxer{Options: Options{Fps: 15, Quality: 2}}
+
+	cmdStr := mjpegMuxer.String()
+	if cmdStr != "" {
+		t.Error("String returned incorrect value, got:", cmdStr)
+	}
+}
+
+// mockExecCommand sets up a mocked exec.Command using TestMain
+func mockExecCommand(command string, args ...string) *exec.Cmd {
+	cs := append([]string{command}, args...)
+	cmd := exec.Command(os.Args[0], cs...)
+	cmd.Env = append(os.Environ(), "GO_TEST_MODE=ffmpeg")
+	return cmd
+}
+
+// mockFailedExecCommand sets up a exec.Command that will fail
+func mockFailedExecCommand(command string, args ...string) *exec.Cmd {
+	cmd := exec.Command("totallyfakecommandthatdoesnotexist")
+	return cmd
+}
+
+func equal(a, b []string) bool {
+	// If one is nil, the other must also be nil.
+	if (a == nil) != (b == nil) {
+		return false
+	}
+
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,274 @@
+package mse
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// maxBoxSize is the largest MP4 box that will be read into memory.
+const maxBoxSize = 64 * 1024 * 1024
+
+// Sample flag indicating that the sample is not a keyframe, per ISO/IEC 14496-12.
+const sampleIsNonSyncSample = 0x00010000
+
+// defaultMimeType is used when the codec cannot be determined from the initialization segment.
+//
+// Raspivid defaults to the H.264 High profile, level 4.
+const defaultMimeType = `video/mp4; codecs="avc1.640028"`
+
+// fragment is a self-contained piece of media from the fragmented MP4 stream.
+type fragment struct {
+	data     []byte
+	keyframe bool // Whether the fragment starts with a keyframe and may be used to join the stream
+}
+
+// segmentReader splits a fragmented MP4 stream into its initialization segment and media fragments.
+type segmentReader struct {
+	r io.Reader
+}
+
+// ReadInit reads the initialization segment from the start of the stream.
+func (sr *segmentReader) ReadInit() ([]byte, error) {
+	var init []byte
+
+	for {
+		typ, box, err := readBox(sr.r)
+		if err != nil {
+			return nil, err
+		}
+
+		init = append(init, box...)
+
+		if typ == "moov" {
+			return init, nil
+		}
+	}
+}
+
+// ReadFragment reads the next media fragment from the stream.
+//
+// Any boxes preceding the media data, such as segment type or index boxes, are included in the fragment.
+func (sr *segmentReader) ReadFragment() (fragment, error) {
+	var data []byte
+	var moof []byte
+
+	for {
+		typ, box, err := readBox(sr.r)
+		if err == io.EOF && data != nil {
+			return fragment{}, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return fragment{}, err
+		}
+
+		data = append(data, box...)
+
+		switch typ {
+		case "moof":
+			moof = box
+		case "mdat":
+			if moof == nil {
+				return fragment{}, errors.New("mse: media data without movie fragment")
+			}
+			return fragment{data: data, keyframe: isKeyframe(moof)}, nil
+		}
+	}
+}
+
+// readBox reads an entire MP4 box, including its header.
+func readBox(r io.Reader) (string, []byte, error) {
+	header := make([]byte, 8, 16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return "", nil, err
+	}
+
+	size := uint64(binary.BigEndian.Uint32(header[0:4]))
+	typ := string(header[4:8])
+
+	if size == 1 {
+		largeSize := make([]byte, 8)
+		if _, err := io.ReadFull(r, largeSize); err != nil {
+			return "", nil, unexpected(err)
+		}
+		header = append(header, largeSize...)
+		size = binary.BigEndian.Uint64(largeSize)
+	}
+
+	if size < uint64(len(header)) || size > maxBoxSize {
+		return "", nil, fmt.Errorf("mse: invalid size %d for box %q", size, typ)
+	}
+
+	box := make([]byte, size)
+	copy(box, header)
+	if _, err := io.ReadFull(r, box[len(header):]); err != nil {
+		return "", nil, unexpected(err)
+	}
+
+	return typ, box, nil
+}
+
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// payload returns the contents of a box without its header.
+func payload(box []byte) []byte {
+	if len(box) >= 16 && binary.BigEndian.Uint32(box[0:4]) == 1 {
+		return box[16:]
+	}
+	if len(box) >= 8 {
+		return box[8:]
+	}
+	return nil
+}
+
+// child finds the first child box of the given type within the contents of a parent box.
+func child(contents []byte, typ string) []byte {
+	for len(contents) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(contents[0:4]))
+		if size == 1 && len(contents) >= 16 {
+			size = binary.BigEndian.Uint64(contents[8:16])
+		}
+		if size < 8 || size > uint64(len(contents)) {
+			return nil
+		}
+
+		if string(contents[4:8]) == typ {
+			return contents[:size]
+		}
+
+		contents = contents[size:]
+	}
+
+	return nil
+}
+
+// find walks down the box hierarchy, returning the contents of the box at the end of the path.
+func find(contents []byte, path ...string) []byte {
+	for _, typ := range path {
+		box := child(contents, typ)
+		if box == nil {
+			return nil
+		}
+		contents = payload(box)
+	}
+
+	return contents
+}
+
+// isKeyframe determines whether the first sample in the movie fragment is a keyframe.
+//
+// Defaults to true when the fragment does not specify so that clients are not kept waiting on malformed fragments.
+func isKeyframe(moof []byte) bool {
+	traf := find(payload(moof), "traf")
+	if traf == nil {
+		return true
+	}
+
+	if flags, ok := firstSampleFlags(find(traf, "trun")); ok {
+		return flags&sampleIsNonSyncSample == 0
+	}
+
+	if flags, ok := defaultSampleFlags(find(traf, "tfhd")); ok {
+		return flags&sampleIsNonSyncSample == 0
+	}
+
+	return true
+}
+
+// firstSampleFlags reads the flags of the first sample from the track fragment run.
+func firstSampleFlags(trun []byte) (uint32, bool) {
+	if len(trun) < 8 {
+		return 0, false
+	}
+
+	flags := binary.BigEndian.Uint32(trun[0:4]) & 0xFFFFFF
+	sampleCount := binary.BigEndian.Uint32(trun[4:8])
+	offset := 8
+
+	// Data offset
+	if flags&0x1 != 0 {
+		offset += 4
+	}
+
+	// First sample flags
+	if flags&0x4 != 0 {
+		if len(trun) < offset+4 {
+			return 0, false
+		}
+		return binary.BigEndian.Uint32(trun[offset : offset+4]), true
+	}
+
+	if flags&0x400 == 0 || sampleCount == 0 {
+		return 0, false
+	}
+
+	// Sample duration and size precede the sample flags
+	if flags&0x100 != 0 {
+		offset += 4
+	}
+	if flags&0x200 != 0 {
+		offset += 4
+	}
+
+	if len(trun) < offset+4 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(trun[offset : offset+4]), true
+}
+
+// defaultSampleFlags reads the default sample flags from the track fragment header.
+func defaultSampleFlags(tfhd []byte) (uint32, bool) {
+	if len(tfhd) < 8 {
+		return 0, false
+	}
+
+	flags := binary.BigEndian.Uint32(tfhd[0:4]) & 0xFFFFFF
+	if flags&0x20 == 0 {
+		return 0, false
+	}
+
+	// Skip past the track ID and any other optional fields that come before the default sample flags
+	offset := 8
+	if flags&0x1 != 0 {
+		offset += 8
+	}
+	for _, field := range []uint32{0x2, 0x8, 0x10} {
+		if flags&field != 0 {
+			offset += 4
+		}
+	}
+
+	if len(tfhd) < offset+4 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(tfhd[offset : offset+4]), true
+}
+
+// mimeType determines the MIME type and codec of the video from the initialization segment.
+func mimeType(init []byte) string {
+	stsd := find(init, "moov", "trak", "mdia", "minf", "stbl", "stsd")
+
+	// Skip past the version, flags, and entry count
+	if len(stsd) < 8 {
+		return defaultMimeType
+	}
+	avc1 := child(stsd[8:], "avc1")
+
+	// Skip past the visual sample entry fields to get to the child boxes
+	if len(payload(avc1)) < 78 {
+		return defaultMimeType
+	}
+	avcC := find(payload(avc1)[78:], "avcC")
+
+	if len(avcC) < 4 {
+		return defaultMimeType
+	}
+
+	return fmt.Sprintf(`video/mp4; codecs="avc1.%02x%02x%02x"`, avcC[1], avcC[2], avcC[3])
+}
@@ -0,0 +1,191 @@
+package mse
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+// box builds an MP4 box out of the given contents.
+func box(typ string, contents ...[]byte) []byte {
+	data := bytes.Join(contents, nil)
+
+	header := make([]byte, 8)
+	binary.BigEndian.PutUint32(header[0:4], uint32(8+len(data)))
+	copy(header[4:8], typ)
+
+	return append(header, data...)
+}
+
+// fullBox builds an MP4 full box out of the given flags and contents.
+func fullBox(typ string, flags uint32, contents ...[]byte) []byte {
+	return box(typ, append([][]byte{uint32Bytes(flags)}, contents...)...)
+}
+
+func uint32Bytes(values ...uint32) []byte {
+	data := make([]byte, 4*len(values))
+	for i, value := range values {
+		binary.BigEndian.PutUint32(data[i*4:], value)
+	}
+	return data
+}
+
+// fakeInit builds an initialization segment for an H.264 track with the given profile, compatibility, and level.
+func fakeInit(profile, compatibility, level byte) []byte {
+	avcC := box("avcC", []byte{1, profile, compatibility, level, 0xFF})
+	avc1 := box("avc1", make([]byte, 78), avcC)
+	stsd := fullBox("stsd", 0, uint32Bytes(1), avc1)
+
+	return append(
+		box("ftyp", []byte("isom"), uint32Bytes(0x200)),
+		box("moov", box("trak", box("mdia", box("minf", box("stbl", stsd)))))...)
+}
+
+// fakeFragment builds a media fragment whose first sample has the given flags.
+func fakeFragment(sampleFlags uint32) []byte {
+	// Data offset and first sample flags present
+	trun := fullBox("trun", 0x5, uint32Bytes(1, 0, sampleFlags))
+	moof := box("moof", box("traf", fullBox("tfhd", 0, uint32Bytes(1)), trun))
+
+	return append(moof, box("mdat", []byte("fakevideo"))...)
+}
+
+func TestReadInit(t *testing.T) {
+	init := fakeInit(0x64, 0x00, 0x28)
+	stream := append(append([]byte{}, init...), fakeFragment(0)...)
+
+	segments := segmentReader{r: bytes.NewReader(stream)}
+
+	result, err := segments.ReadInit()
+	if err != nil {
+		t.Fatal("ReadInit returned an error", err)
+	}
+
+	if !bytes.Equal(result, init) {
+		t.Error("ReadInit returned an incorrect initialization segment")
+	}
+}
+
+func TestReadFragment(t *testing.T) {
+	keyframe := fakeFragment(0x02000000)
+	interframe := fakeFragment(0x01010000)
+	prefixedKeyframe := append(box("styp", []byte("msdh")), fakeFragment(0x02000000)...)
+
+	stream := bytes.Join([][]byte{fakeInit(0x64, 0x00, 0x28), keyframe, interframe, prefixedKeyframe}, nil)
+	segments := segmentReader{r: bytes.NewReader(stream)}
+	segments.ReadInit()
+
+	testCases := []struct {
+		data     []byte
+		keyframe bool
+	}{
+		{keyframe, true},
+		{interframe, false},
+		{prefixedKeyframe, true},
+	}
+
+	for _, tc := range testCases {
+		frag, err := segments.ReadFragment()
+		if err != nil {
+			t.Fatal("ReadFragment returned an error", err)
+		}
+		if !bytes.Equal(frag.data, tc.data) {
+			t.Error("ReadFragment returned incorrect data")
+		}
+		if frag.keyframe != tc.keyframe {
+			t.Error("ReadFragment returned incorrect keyframe, got", frag.keyframe)
+		}
+	}
+
+	if _, err := segments.ReadFragment(); err != io.EOF {
+		t.Error("ReadFragment failed to return EOF at end of stream, got", err)
+	}
+}
+
+func TestReadFragmentTruncatedReturnsError(t *testing.T) {
+	frag := fakeFragment(0)
+	segments := segmentReader{r: bytes.NewReader(frag[:len(frag)-4])}
+
+	if _, err := segments.ReadFragment(); err != io.ErrUnexpectedEOF {
+		t.Error("ReadFragment failed to return an error for truncated fragment, got", err)
+	}
+}
+
+func TestReadFragmentWithoutMovieFragmentReturnsError(t *testing.T) {
+	segments := segmentReader{r: bytes.NewReader(box("mdat", []byte("fakevideo")))}
+
+	if _, err := segments.ReadFragment(); err == nil {
+		t.Error("ReadFragment failed to return an error for media data without movie fragment")
+	}
+}
+
+func TestIsKeyframe(t *testing.T) {
+	testCases := []struct {
+		name     string
+		moof     []byte
+		expected bool
+	}{
+		{
+			"first sample flags keyframe",
+			box("moof", box("traf", fullBox("trun", 0x5, uint32Bytes(1, 0, 0x02000000)))),
+			true,
+		},
+		{
+			"first sample flags interframe",
+			box("moof", box("traf", fullBox("trun", 0x5, uint32Bytes(1, 0, 0x01010000)))),
+			false,
+		},
+		{
+			"sample flags keyframe",
+			box("moof", box("traf", fullBox("trun", 0x701, uint32Bytes(1, 0, 3000, 1024, 0x02000000)))),
+			true,
+		},
+		{
+			"sample flags interframe",
+			box("moof", box("traf", fullBox("trun", 0x701, uint32Bytes(1, 0, 3000, 1024, 0x01010000)))),
+			false,
+		},
+		{
+			"default sample flags interframe",
+			box("moof", box("traf",
+				fullBox("tfhd", 0x38, uint32Bytes(1, 3000, 1024, 0x01010000)),
+				fullBox("trun", 0x1, uint32Bytes(1, 0)))),
+			false,
+		},
+		{
+			"unspecified",
+			box("moof", box("traf", fullBox("trun", 0x1, uint32Bytes(1, 0)))),
+			true,
+		},
+		{
+			"empty",
+			box("moof"),
+			true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if isKeyframe(tc.moof) != tc.expected {
+				t.Error("isKeyframe returned incorrect value")
+			}
+		})
+	}
+}
+
+func TestMimeType(t *testing.T) {
+	mime := mimeType(fakeInit(0x4D, 0x40, 0x1F))
+
+	if mime != `video/mp4; codecs="avc1.4d401f"` {
+		t.Error("mimeType returned incorrect value, got", mime)
+	}
+}
+
+func TestMimeTypeDefault(t *testing.T) {
+	mime := mimeType(box("ftyp", []byte("isom")))
+
+	if mime != defaultMimeType {
+		t.Error("mimeType returned incorrect value, got", mime)
+	}
+}
@@ -0,0 +1,212 @@
+package mse
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/rs/zerolog/log"
+)
+
+// clientQueueSize is the number of fragments that may be waiting to be sent to a client before it is considered slow.
+const clientQueueSize = 128
+
+// writeTimeout is the amount of time a client has to accept a message before it is disconnected.
+const writeTimeout = 10 * time.Second
+
+// Handler serves fragmented MP4 video to WebSocket clients for playback via Media Source Extensions.
+//
+// Clients first receive a text message containing the MIME type and codec of the video, followed by binary messages
+// containing the initialization segment and the media fragments starting from the latest keyframe. Clients that fall
+// behind skip ahead to the next keyframe rather than hold up the video stream. Use Feed to supply the H.264 video
+// stream.
+type Handler struct {
+	Options  Options
+	mu       sync.Mutex
+	init     []byte
+	mimeType string
+	gop      [][]byte
+	clients  map[*client]struct{}
+	upgrader websocket.Upgrader
+}
+
+type client struct {
+	send     chan []byte
+	skipping bool
+}
+
+// enqueue queues the fragment for sending, skipping the rest of the group of pictures if the client has fallen behind.
+func (c *client) enqueue(data []byte, keyframe bool) {
+	if c.skipping && !keyframe {
+		return
+	}
+
+	select {
+	case c.send <- data:
+		c.skipping = false
+	default:
+		c.skipping = true
+	}
+}
+
+// Feed muxes the H.264 video stream to fragmented MP4 and distributes it to the connected clients.
+//
+// Blocks until the video stream ends.
+func (hdlr *Handler) Feed(video io.ReadCloser) error {
+	muxer := Muxer{Options: hdlr.Options}
+	if err := muxer.Mux(video); err != nil {
+		log.Debug().Err(err).Msg("Encountered an error starting video mux")
+		return err
+	}
+	log.Debug().Str("cmd", muxer.String()).Msg("Started ffmpeg muxer")
+
+	segments := segmentReader{r: muxer.Video}
+
+	init, err := segments.ReadInit()
+	if err == nil {
+		hdlr.mu.Lock()
+		hdlr.init = init
+		hdlr.mimeType = mimeType(init)
+		hdlr.mu.Unlock()
+
+		for {
+			frag, err := segments.ReadFragment()
+			if err != nil {
+				break
+			}
+			hdlr.broadcast(frag)
+		}
+	}
+
+	// Errors reading the stream are just a consequence of ffmpeg quitting
+	if err := muxer.Wait(); err != nil {
+		log.Debug().Err(err).Msg("Encountered an error waiting for video mux")
+		return err
+	}
+
+	return nil
+}
+
+// ServeHTTP upgrades the connection to a WebSocket and streams video to the client until the client disconnects.
+func (hdlr *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	hdlr.mu.Lock()
+	ready := hdlr.init != nil
+	mimeType := hdlr.mimeType
+	hdlr.mu.Unlock()
+
+	if !ready {
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	}
+
+	// Upgrade takes care of responding to the client if something goes wrong
+	conn, err := hdlr.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	c := hdlr.subscribe()
+	defer hdlr.unsubscribe(c)
+
+	// Read from the connection to process control messages and detect when the client goes away
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(mimeType)); err != nil {
+		return
+	}
+
+	for {
+		select {
+		case <-done:
+			return
+		case data, ok := <-c.send:
+			if !ok {
+				conn.WriteControl(
+					websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseGoingAway, ""),
+					time.Now().Add(writeTimeout))
+				return
+			}
+
+			conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			if err := conn.WriteMessage(websocket.BinaryMessage, data); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Close disconnects all of the clients.
+func (hdlr *Handler) Close() {
+	hdlr.mu.Lock()
+	defer hdlr.mu.Unlock()
+
+	for c := range hdlr.clients {
+		close(c.send)
+		delete(hdlr.clients, c)
+	}
+}
+
+// subscribe registers a new client, queueing up the initialization segment and the latest group of pictures, or waiting
+// for the next keyframe if the latest group of pictures is not cached.
+func (hdlr *Handler) subscribe() *client {
+	hdlr.mu.Lock()
+	defer hdlr.mu.Unlock()
+
+	if hdlr.clients == nil {
+		hdlr.clients = make(map[*client]struct{})
+	}
+
+	c := &client{send: make(chan []byte, clientQueueSize)}
+	c.enqueue(hdlr.init, true)
+	for i, data := range hdlr.gop {
+		c.enqueue(data, i == 0)
+	}
+
+	// The group of pictures was too long to cache, so there is nothing to decode until the next keyframe
+	if len(hdlr.gop) == 0 {
+		c.skipping = true
+	}
+
+	hdlr.clients[c] = struct{}{}
+
+	return c
+}
+
+func (hdlr *Handler) unsubscribe(c *client) {
+	hdlr.mu.Lock()
+	defer hdlr.mu.Unlock()
+
+	delete(hdlr.clients, c)
+}
+
+// broadcast sends the fragment to all of the clients and caches it for clients that connect later.
+func (hdlr *Handler) broadcast(frag fragment) {
+	hdlr.mu.Lock()
+	defer hdlr.mu.Unlock()
+
+	// Give up on caching overly long groups of pictures, new clients will have to wait for the next keyframe
+	if frag.keyframe {
+		hdlr.gop = [][]byte{frag.data}
+	} else if len(hdlr.gop) > 0 && len(hdlr.gop) < clientQueueSize {
+		hdlr.gop = append(hdlr.gop, frag.data)
+	} else {
+		hdlr.gop = nil
+	}
+
+	for c := range hdlr.clients {
+		c.enqueue(frag.data, frag.keyframe)
+	}
+}
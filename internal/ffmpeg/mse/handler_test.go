@@ -0,0 +1,147 @@
+package mse
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestFeedCachesLatestGroupOfPictures(t *testing.T) {
+	execCommand = mockExecCommand
+	defer func() { execCommand = exec.Command }()
+
+	hdlr := Handler{}
+
+	videoStream := ioutil.NopCloser(strings.NewReader("totallyfakevideostream"))
+	if err := hdlr.Feed(videoStream); err != nil {
+		t.Fatal("Feed returned an error", err)
+	}
+
+	if !bytes.Equal(hdlr.init, fakeInit(0x64, 0x00, 0x28)) {
+		t.Error("Feed did not store the initialization segment")
+	}
+
+	if hdlr.mimeType != `video/mp4; codecs="avc1.640028"` {
+		t.Error("Feed did not determine the MIME type, got", hdlr.mimeType)
+	}
+
+	if len(hdlr.gop) != 2 || !bytes.Equal(hdlr.gop[0], fakeFragment(0x02000000)) {
+		t.Error("Feed did not cache the latest group of pictures")
+	}
+}
+
+func TestFeedReturnsFfmpegError(t *testing.T) {
+	execCommand = mockFailedExecCommand
+	defer func() { execCommand = exec.Command }()
+
+	hdlr := Handler{}
+
+	videoStream := ioutil.NopCloser(strings.NewReader("totallyfakevideostream"))
+	if err := hdlr.Feed(videoStream); err == nil {
+		t.Error("Feed failed to return an error")
+	}
+}
+
+func TestServeHTTPStreamsFromLatestKeyframe(t *testing.T) {
+	hdlr := Handler{}
+	hdlr.init = fakeInit(0x64, 0x00, 0x28)
+	hdlr.mimeType = mimeType(hdlr.init)
+	hdlr.broadcast(fragment{data: []byte("keyframe1"), keyframe: true})
+	hdlr.broadcast(fragment{data: []byte("keyframe2"), keyframe: true})
+	hdlr.broadcast(fragment{data: []byte("interframe2"), keyframe: false})
+	defer hdlr.Close()
+
+	srv := httptest.NewServer(&hdlr)
+	defer srv.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal("Failed to connect to server:", err)
+	}
+	defer conn.Close()
+
+	expectedMessages := []struct {
+		messageType int
+		data        []byte
+	}{
+		{websocket.TextMessage, []byte(`video/mp4; codecs="avc1.640028"`)},
+		{websocket.BinaryMessage, hdlr.init},
+		{websocket.BinaryMessage, []byte("keyframe2")},
+		{websocket.BinaryMessage, []byte("interframe2")},
+		{websocket.BinaryMessage, []byte("interframe3")},
+	}
+
+	for i, expected := range expectedMessages {
+		// Send out a live fragment once the client has caught up
+		if i == len(expectedMessages)-1 {
+			hdlr.broadcast(fragment{data: []byte("interframe3"), keyframe: false})
+		}
+
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal("Failed to read message:", err)
+		}
+		if messageType != expected.messageType || !bytes.Equal(data, expected.data) {
+			t.Error("Message", i, "is invalid, got", messageType, string(data))
+		}
+	}
+}
+
+func TestServeHTTPReturnsErrorBeforeVideoIsReady(t *testing.T) {
+	hdlr := Handler{}
+
+	srv := httptest.NewServer(&hdlr)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal("Request to server failed:", err)
+	}
+
+	if resp.StatusCode != 503 {
+		t.Error("Request to server did not fail, got status code", resp.StatusCode)
+	}
+}
+
+func TestEnqueueSkipsToNextKeyframeWhenBehind(t *testing.T) {
+	c := client{send: make(chan []byte, 2)}
+
+	c.enqueue([]byte("keyframe1"), true)
+	c.enqueue([]byte("interframe1"), false)
+	c.enqueue([]byte("interframe2"), false)
+
+	// Make room for more fragments
+	<-c.send
+	<-c.send
+
+	c.enqueue([]byte("interframe3"), false)
+	c.enqueue([]byte("keyframe2"), true)
+
+	if len(c.send) != 1 || string(<-c.send) != "keyframe2" {
+		t.Error("Client did not skip ahead to the next keyframe")
+	}
+}
+
+func TestSubscribeWaitsForKeyframeWhenGroupOfPicturesIsNotCached(t *testing.T) {
+	hdlr := Handler{init: []byte("init")}
+
+	// Groups of pictures longer than the queue are given up on
+	hdlr.broadcast(fragment{data: []byte("keyframe1"), keyframe: true})
+	for i := 0; i < clientQueueSize; i++ {
+		hdlr.broadcast(fragment{data: []byte("interframe1"), keyframe: false})
+	}
+
+	c := hdlr.subscribe()
+	hdlr.broadcast(fragment{data: []byte("interframe2"), keyframe: false})
+	hdlr.broadcast(fragment{data: []byte("keyframe2"), keyframe: true})
+
+	if len(c.send) != 2 || string(<-c.send) != "init" || string(<-c.send) != "keyframe2" {
+		t.Error("Client did not wait for the next keyframe")
+	}
+}
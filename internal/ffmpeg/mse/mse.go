@@ -0,0 +1,95 @@
+package mse
+
+import (
+	"errors"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Options represents ways that Ffmpeg may be configured to mux video to fragmented MP4.
+//
+// Ffmpeg will step in and use its own defaults if a value is not provided.
+type Options struct {
+	Fps          int    // Framerate of the output video
+	FragmentType string // Whether to cut a fragment every group of pictures or every frame
+}
+
+// Muxer represents the fragmented MP4 muxer.
+//
+// The muxed video is made available via Video as a fragmented MP4 stream suitable for Media Source Extensions.
+type Muxer struct {
+	Options Options
+	Video   io.ReadCloser
+	cmd     *exec.Cmd
+}
+
+var execCommand = exec.Command
+
+// Mux begins muxing the video stream to the fragmented MP4 format.
+func (muxer *Muxer) Mux(video io.ReadCloser) error {
+	args := []string{
+		"-i", "pipe:0",
+		"-codec", "copy",
+		"-f", "mp4",
+		"-an",
+	}
+
+	fragmentType := strings.ToLower(muxer.Options.FragmentType)
+	if fragmentType == "" || fragmentType == "gop" {
+		args = append(args, "-movflags", "frag_keyframe+empty_moov+default_base_moof")
+	} else if fragmentType == "frame" {
+		args = append(args, "-movflags", "frag_every_frame+empty_moov+default_base_moof")
+	} else {
+		return errors.New("ffmpeg mse: invalid fragment type")
+	}
+
+	if muxer.Options.Fps != 0 {
+		args = append(args, "-r", strconv.Itoa(muxer.Options.Fps))
+	}
+
+	args = append(args, "pipe:1")
+
+	cmd := execCommand("ffmpeg", args...)
+	cmd.Stdin = video
+
+	output, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	muxer.cmd = cmd
+	muxer.Video = output
+
+	return muxer.cmd.Start()
+}
+
+// Wait waits for the video stream to finish processing.
+//
+// The muxed video must be read in its entirety before calling Wait.
+func (muxer *Muxer) Wait() error {
+	if muxer.cmd == nil {
+		return errors.New("ffmpeg mse: not started")
+	}
+
+	err := muxer.cmd.Wait()
+
+	// Ignore 255 status -- just indicates that we exited early
+	if err != nil && err.Error() == "exit status 255" {
+		err = nil
+	}
+
+	return err
+}
+
+func (muxer *Muxer) String() string {
+	var cmdStr string
+	if muxer.cmd == nil {
+		cmdStr = ""
+	} else {
+		cmdStr = muxer.cmd.String()
+	}
+
+	return cmdStr
+}
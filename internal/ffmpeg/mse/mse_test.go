@@ -0,0 +1,240 @@
+package mse
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	// Facilitate the "mocking" of os/exec by running a faked CLI program
+	switch os.Getenv("GO_TEST_MODE") {
+	case "":
+		os.Exit(m.Run())
+	case "ffmpeg":
+		io.Copy(ioutil.Discard, os.Stdin)
+		os.Stdout.Write(fakeStream())
+		os.Exit(0)
+	}
+}
+
+// fakeStream builds a fragmented MP4 stream with two groups of pictures.
+func fakeStream() []byte {
+	return bytes.Join([][]byte{
+		fakeInit(0x64, 0x00, 0x28),
+		fakeFragment(0x02000000),
+		fakeFragment(0x01010000),
+		fakeFragment(0x02000000),
+		fakeFragment(0x01010000),
+	}, nil)
+}
+
+func TestMux(t *testing.T) {
+	testCases := []struct {
+		muxer        Muxer
+		expectedArgs []string
+	}{
+		{
+			Muxer{},
+			[]string{
+				"ffmpeg",
+				"-i", "pipe:0",
+				"-codec", "copy",
+				"-f", "mp4",
+				"-an",
+				"-movflags", "frag_keyframe+empty_moov+default_base_moof",
+				"pipe:1",
+			},
+		},
+		{
+			Muxer{Options: Options{Fps: 60}},
+			[]string{
+				"ffmpeg",
+				"-i", "pipe:0",
+				"-codec", "copy",
+				"-f", "mp4",
+				"-an",
+				"-movflags", "frag_keyframe+empty_moov+default_base_moof",
+				"-r", "60",
+				"pipe:1",
+			},
+		},
+		{
+			Muxer{Options: Options{FragmentType: "GoP"}},
+			[]string{
+				"ffmpeg",
+				"-i", "pipe:0",
+				"-codec", "copy",
+				"-f", "mp4",
+				"-an",
+				"-movflags", "frag_keyframe+empty_moov+default_base_moof",
+				"pipe:1",
+			},
+		},
+		{
+			Muxer{Options: Options{Fps: 30, FragmentType: "FrAmE"}},
+			[]string{
+				"ffmpeg",
+				"-i", "pipe:0",
+				"-codec", "copy",
+				"-f", "mp4",
+				"-an",
+				"-movflags", "frag_every_frame+empty_moov+default_base_moof",
+				"-r", "30",
+				"pipe:1",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%v", tc.muxer), func(t *testing.T) {
+			execCommand = mockExecCommand
+			defer func() { execCommand = exec.Command }()
+
+			videoStream := ioutil.NopCloser(strings.NewReader("totallyfakevideostream"))
+
+			mseMuxer := tc.muxer
+			err := mseMuxer.Mux(videoStream)
+
+			if err != nil {
+				t.Error("Mux produced an err", err)
+			}
+
+			ffmpegArgs := mseMuxer.cmd.Args[1:]
+
+			if !equal(ffmpegArgs, tc.expectedArgs) {
+				t.Error("Command args do not match, got", ffmpegArgs, "but wanted", tc.expectedArgs)
+			}
+
+			if mseMuxer.Video == nil {
+				t.Error("Mux produced a Muxer without video output")
+			}
+		})
+	}
+}
+
+func TestMuxInvalidFragmentTypeReturnsError(t *testing.T) {
+	execCommand = mockExecCommand
+	defer func() { execCommand = exec.Command }()
+
+	videoStream := ioutil.NopCloser(strings.NewReader("totallyfakevideostream"))
+
+	mseMuxer := Muxer{Options: Options{FragmentType: "badtype"}}
+	err := mseMuxer.Mux(videoStream)
+
+	if err == nil || err.Error() != "ffmpeg mse: invalid fragment type" {
+		t.Error("Mux failed to return an error for invalid fragment type")
+	}
+}
+
+func TestMuxReturnsFfmpegError(t *testing.T) {
+	execCommand = mockFailedExecCommand
+	defer func() { execCommand = exec.Command }()
+
+	videoStream := ioutil.NopCloser(strings.NewReader("totallyfakevideostream"))
+
+	mseMuxer := Muxer{}
+	err := mseMuxer.Mux(videoStream)
+
+	if err == nil {
+		t.Error("Mux failed to return an error")
+	}
+}
+
+func TestWait(t *testing.T) {
+	execCommand = mockExecCommand
+	defer func() { execCommand = exec.Command }()
+
+	videoStream := ioutil.NopCloser(strings.NewReader("totallyfakevideostream"))
+
+	mseMuxer := Muxer{}
+	mseMuxer.Mux(videoStream)
+	io.Copy(ioutil.Discard, mseMuxer.Video)
+	err := mseMuxer.Wait()
+
+	if err != nil {
+		t.Error("Wait returned an error", err)
+	}
+}
+
+func TestWaitWithoutStartReturnsError(t *testing.T) {
+	mseMuxer := Muxer{}
+	err := mseMuxer.Wait()
+
+	if err == nil || err.Error() != "ffmpeg mse: not started" {
+		t.Error("Wait failed to return correct error when run without Mux", err)
+	}
+}
+
+func TestStringReturnsStringifiedCommand(t *testing.T) {
+	execCommand = mockExecCommand
+	defer func() { execCommand = exec.Command }()
+
+	videoStream := ioutil.NopCloser(strings.NewReader("totallyfakevideostream"))
+
+	mseMuxer := Muxer{Options: Options{Fps: 30, FragmentType: "frame"}}
+	mseMuxer.Mux(videoStream)
+	defer mseMuxer.Wait()
+	defer io.Copy(ioutil.Discard, mseMuxer.Video)
+
+	cmdStr := mseMuxer.String()
+	expectedCmdStr := "ffmpeg " +
+		"-i pipe:0 " +
+		"-codec copy " +
+		"-f mp4 " +
+		"-an " +
+		"-movflags frag_every_frame+empty_moov+default_base_moof " +
+		"-r 30 " +
+		"pipe:1"
+
+	if !strings.Contains(cmdStr, expectedCmdStr) {
+		t.Error("String returned incorrect value, got:", cmdStr)
+	}
+}
+
+func TestStringReturnsNilForUnstartedOperation(t *testing.T) {
+	mseMuxer := Muxer{Options: Options{Fps: 30, FragmentType: "frame"}}
+
+	cmdStr := mseMuxer.String()
+	if cmdStr != "" {
+		t.Error("String returned incorrect value, got:", cmdStr)
+	}
+}
+
+// mockExecCommand sets up a mocked exec.Command using TestMain
+func mockExecCommand(command string, args ...string) *exec.Cmd {
+	cs := append([]string{command}, args...)
+	cmd := exec.Command(os.Args[0], cs...)
+	cmd.Env = append(os.Environ(), "GO_TEST_MODE=ffmpeg")
+	return cmd
+}
+
+// mockFailedExecCommand sets up a exec.Command that will fail
+func mockFailedExecCommand(command string, args ...string) *exec.Cmd {
+	cmd := exec.Command("totallyfakecommandthatdoesnotexist")
+	return cmd
+}
+
+func equal(a, b []string) bool {
+	// If one is nil, the other must also be nil.
+	if (a == nil) != (b == nil) {
+		return false
+	}
+
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
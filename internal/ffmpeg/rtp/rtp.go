@@ -0,0 +1,182 @@
+package rtp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// SessionDescriptionFile is the name of the SDP file describing the stream.
+const SessionDescriptionFile = "livestream.sdp"
+
+// Options represents ways that Ffmpeg may be configured to send video over RTP.
+//
+// Ffmpeg will step in and use its own defaults if a value is not provided.
+type Options struct {
+	Fps          int    // Framerate of the output video
+	Payload      string // Format of the RTP payload
+	TTL          int    // Number of network hops multicast packets may take
+	LocalAddress string // Address of the local network interface to send from
+}
+
+// Muxer represents the RTP muxer.
+//
+// The video is sent to the address as RTP packets and described by an SDP file written to the directory so that
+// receivers know how to play it.
+type Muxer struct {
+	Address   string // Unicast or multicast host and port to send the video to
+	Directory string // Directory to write the SDP file to
+	Options   Options
+	cmd       *exec.Cmd
+}
+
+var execCommand = exec.Command
+
+// Mux begins sending the video stream over RTP.
+func (muxer *Muxer) Mux(video io.ReadCloser) error {
+	host, port, err := net.SplitHostPort(muxer.Address)
+	if err != nil {
+		return errors.New("ffmpeg rtp: invalid address")
+	}
+
+	sdpFile := path.Join(muxer.Directory, SessionDescriptionFile)
+
+	args := []string{
+		"-i", "pipe:0",
+		"-codec", "copy",
+	}
+
+	payload := strings.ToLower(muxer.Options.Payload)
+	if payload == "" || payload == "h264" {
+		// Ffmpeg knows the H.264 parameter sets, so let it describe the stream
+		args = append(args, "-f", "rtp", "-an", "-sdp_file", sdpFile)
+	} else if payload == "mpegts" {
+		// Ffmpeg doesn't describe MPEG-TS streams, but everything there is to know about them is in the stream itself
+		if err := ioutil.WriteFile(sdpFile, mpegtsSessionDescription(host, port, muxer.Options.TTL), 0644); err != nil {
+			return err
+		}
+		args = append(args, "-f", "rtp_mpegts", "-an")
+	} else {
+		return errors.New("ffmpeg rtp: invalid payload")
+	}
+
+	if muxer.Options.Fps != 0 {
+		args = append(args, "-r", strconv.Itoa(muxer.Options.Fps))
+	}
+
+	query := url.Values{}
+	if muxer.Options.TTL != 0 {
+		query.Set("ttl", strconv.Itoa(muxer.Options.TTL))
+	}
+	if muxer.Options.LocalAddress != "" {
+		query.Set("localaddr", muxer.Options.LocalAddress)
+	}
+
+	rtpURL := url.URL{Scheme: "rtp", Host: muxer.Address, RawQuery: query.Encode()}
+	args = append(args, rtpURL.String())
+
+	muxer.cmd = execCommand("ffmpeg", args...)
+	muxer.cmd.Stdin = video
+
+	return muxer.cmd.Start()
+}
+
+// Wait waits for the video stream to finish processing.
+//
+// The mux operation must have been started by Mux.
+func (muxer *Muxer) Wait() error {
+	if muxer.cmd == nil {
+		return errors.New("ffmpeg rtp: not started")
+	}
+
+	err := muxer.cmd.Wait()
+
+	// Ignore 255 status -- just indicates that we exited early
+	if err != nil && err.Error() == "exit status 255" {
+		err = nil
+	}
+
+	return err
+}
+
+func (muxer *Muxer) String() string {
+	var cmdStr string
+	if muxer.cmd == nil {
+		cmdStr = ""
+	} else {
+		cmdStr = muxer.cmd.String()
+	}
+
+	return cmdStr
+}
+
+// mpegtsSessionDescription describes an MPEG-TS stream sent over RTP, per RFC 2250.
+func mpegtsSessionDescription(host string, port string, ttl int) []byte {
+	ip := net.ParseIP(host)
+
+	addrType := "IP4"
+	if ip != nil && ip.To4() == nil {
+		addrType = "IP6"
+	}
+
+	// IPv4 multicast addresses must specify the TTL
+	connAddr := host
+	if ip != nil && ip.To4() != nil && ip.IsMulticast() {
+		if ttl == 0 {
+			ttl = 1
+		}
+		connAddr = fmt.Sprintf("%s/%d", host, ttl)
+	}
+
+	lines := []string{
+		"v=0",
+		"o=- 0 0 IN IP4 127.0.0.1",
+		"s=raspilive",
+		fmt.Sprintf("c=IN %s %s", addrType, connAddr),
+		"t=0 0",
+		fmt.Sprintf("m=video %s RTP/AVP 33", port),
+		"a=rtpmap:33 MP2T/90000",
+	}
+
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+// InterfaceAddress finds the IP address of the named network interface, preferring IPv4.
+func InterfaceAddress(name string) (string, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return "", err
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return "", err
+	}
+
+	var ipv6 string
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		if ipNet.IP.To4() != nil {
+			return ipNet.IP.String(), nil
+		}
+		if ipv6 == "" {
+			ipv6 = ipNet.IP.String()
+		}
+	}
+
+	if ipv6 == "" {
+		return "", errors.New("ffmpeg rtp: interface has no address")
+	}
+
+	return ipv6, nil
+}
@@ -0,0 +1,355 @@
+package rtp
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const fakeVideoStreamContent = "fakevideostream"
+
+func TestMain(m *testing.M) {
+	switch os.Getenv("GO_TEST_MODE") {
+	case "":
+		os.Exit(m.Run())
+	case "ffmpeg":
+		os.Stdout.WriteString(fakeVideoStreamContent)
+		os.Exit(0)
+	}
+}
+
+func TestMux(t *testing.T) {
+	tempDir := t.TempDir()
+
+	testCases := []struct {
+		muxer        Muxer
+		expectedArgs []string
+	}{
+		{
+			Muxer{Address: "239.0.0.1:5004", Directory: tempDir},
+			[]string{
+				"ffmpeg",
+				"-i", "pipe:0",
+				"-codec", "copy",
+				"-f", "rtp",
+				"-an",
+				"-sdp_file", path.Join(tempDir, "livestream.sdp"),
+				"rtp://239.0.0.1:5004",
+			},
+		},
+		{
+			Muxer{Address: "239.0.0.1:5004", Directory: tempDir, Options: Options{Fps: 60}},
+			[]string{
+				"ffmpeg",
+				"-i", "pipe:0",
+				"-codec", "copy",
+				"-f", "rtp",
+				"-an",
+				"-sdp_file", path.Join(tempDir, "livestream.sdp"),
+				"-r", "60",
+				"rtp://239.0.0.1:5004",
+			},
+		},
+		{
+			Muxer{Address: "239.0.0.1:5004", Directory: tempDir, Options: Options{Payload: "H264"}},
+			[]string{
+				"ffmpeg",
+				"-i", "pipe:0",
+				"-codec", "copy",
+				"-f", "rtp",
+				"-an",
+				"-sdp_file", path.Join(tempDir, "livestream.sdp"),
+				"rtp://239.0.0.1:5004",
+			},
+		},
+		{
+			Muxer{Address: "239.0.0.1:5004", Directory: tempDir, Options: Options{Payload: "MpEgTs"}},
+			[]string{
+				"ffmpeg",
+				"-i", "pipe:0",
+				"-codec", "copy",
+				"-f", "rtp_mpegts",
+				"-an",
+				"rtp://239.0.0.1:5004",
+			},
+		},
+		{
+			Muxer{Address: "239.0.0.1:5004", Directory: tempDir, Options: Options{TTL: 4}},
+			[]string{
+				"ffmpeg",
+				"-i", "pipe:0",
+				"-codec", "copy",
+				"-f", "rtp",
+				"-an",
+				"-sdp_file", path.Join(tempDir, "livestream.sdp"),
+				"rtp://239.0.0.1:5004?ttl=4",
+			},
+		},
+		{
+			Muxer{Address: "239.0.0.1:5004", Directory: tempDir, Options: Options{LocalAddress: "192.168.1.2"}},
+			[]string{
+				"ffmpeg",
+				"-i", "pipe:0",
+				"-codec", "copy",
+				"-f", "rtp",
+				"-an",
+				"-sdp_file", path.Join(tempDir, "livestream.sdp"),
+				"rtp://239.0.0.1:5004?localaddr=192.168.1.2",
+			},
+		},
+		{
+			Muxer{
+				Address:   "[ff02::1]:5004",
+				Directory: tempDir,
+				Options:   Options{Fps: 30, Payload: "mpegts", TTL: 2, LocalAddress: "fe80::1"},
+			},
+			[]string{
+				"ffmpeg",
+				"-i", "pipe:0",
+				"-codec", "copy",
+				"-f", "rtp_mpegts",
+				"-an",
+				"-r", "30",
+				"rtp://[ff02::1]:5004?localaddr=fe80%3A%3A1&ttl=2",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%v", tc.muxer), func(t *testing.T) {
+			execCommand = mockExecCommand
+			defer func() { execCommand = exec.Command }()
+
+			videoStream := ioutil.NopCloser(strings.NewReader("totallyfakevideostream"))
+
+			rtpMuxer := tc.muxer
+			err := rtpMuxer.Mux(videoStream)
+
+			if err != nil {
+				t.Error("Mux produced an err", err)
+			}
+
+			ffmpegArgs := rtpMuxer.cmd.Args[1:]
+
+			if !equal(ffmpegArgs, tc.expectedArgs) {
+				t.Error("Command args do not match, got", ffmpegArgs, "but wanted", tc.expectedArgs)
+			}
+		})
+	}
+}
+
+func TestMuxWritesMpegtsSessionDescription(t *testing.T) {
+	testCases := []struct {
+		address    string
+		ttl        int
+		connection string
+		media      string
+	}{
+		{"239.0.0.1:5004", 4, "c=IN IP4 239.0.0.1/4", "m=video 5004 RTP/AVP 33"},
+		{"239.0.0.1:5004", 0, "c=IN IP4 239.0.0.1/1", "m=video 5004 RTP/AVP 33"},
+		{"192.168.1.50:1234", 4, "c=IN IP4 192.168.1.50", "m=video 1234 RTP/AVP 33"},
+		{"[ff02::1]:5004", 4, "c=IN IP6 ff02::1", "m=video 5004 RTP/AVP 33"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.address, func(t *testing.T) {
+			execCommand = mockExecCommand
+			defer func() { execCommand = exec.Command }()
+
+			tempDir := t.TempDir()
+			videoStream := ioutil.NopCloser(strings.NewReader("totallyfakevideostream"))
+
+			rtpMuxer := Muxer{Address: tc.address, Directory: tempDir, Options: Options{Payload: "mpegts", TTL: tc.ttl}}
+			if err := rtpMuxer.Mux(videoStream); err != nil {
+				t.Fatal("Mux produced an err", err)
+			}
+			rtpMuxer.Wait()
+
+			sdp, err := ioutil.ReadFile(filepath.Join(tempDir, SessionDescriptionFile))
+			if err != nil {
+				t.Fatal("Mux did not write the session description", err)
+			}
+
+			expectedSdp := "v=0\r\n" +
+				"o=- 0 0 IN IP4 127.0.0.1\r\n" +
+				"s=raspilive\r\n" +
+				tc.connection + "\r\n" +
+				"t=0 0\r\n" +
+				tc.media + "\r\n" +
+				"a=rtpmap:33 MP2T/90000\r\n"
+
+			if string(sdp) != expectedSdp {
+				t.Error("Session description is incorrect, got", string(sdp))
+			}
+		})
+	}
+}
+
+func TestMuxInvalidPayloadReturnsError(t *testing.T) {
+	execCommand = mockExecCommand
+	defer func() { execCommand = exec.Command }()
+
+	videoStream := ioutil.NopCloser(strings.NewReader("totallyfakevideostream"))
+
+	rtpMuxer := Muxer{Address: "239.0.0.1:5004", Options: Options{Payload: "badpayload"}}
+	err := rtpMuxer.Mux(videoStream)
+
+	if err == nil || err.Error() != "ffmpeg rtp: invalid payload" {
+		t.Error("Mux failed to return an error for invalid payload")
+	}
+}
+
+func TestMuxInvalidAddressReturnsError(t *testing.T) {
+	execCommand = mockExecCommand
+	defer func() { execCommand = exec.Command }()
+
+	videoStream := ioutil.NopCloser(strings.NewReader("totallyfakevideostream"))
+
+	rtpMuxer := Muxer{Address: "239.0.0.1"}
+	err := rtpMuxer.Mux(videoStream)
+
+	if err == nil || err.Error() != "ffmpeg rtp: invalid address" {
+		t.Error("Mux failed to return an error for invalid address")
+	}
+}
+
+func TestMuxReturnsFfmpegError(t *testing.T) {
+	execCommand = mockFailedExecCommand
+	defer func() { execCommand = exec.Command }()
+
+	videoStream := ioutil.NopCloser(strings.NewReader("totallyfakevideostream"))
+
+	rtpMuxer := Muxer{Address: "239.0.0.1:5004", Directory: t.TempDir()}
+	err := rtpMuxer.Mux(videoStream)
+
+	if err == nil {
+		t.Error("Mux failed to return an error")
+	}
+}
+
+func TestWait(t *testing.T) {
+	execCommand = mockExecCommand
+	defer func() { execCommand = exec.Command }()
+
+	videoStream := ioutil.NopCloser(strings.NewReader("totallyfakevideostream"))
+
+	rtpMuxer := Muxer{Address: "239.0.0.1:5004", Directory: t.TempDir()}
+	rtpMuxer.Mux(videoStream)
+	err := rtpMuxer.Wait()
+
+	if err != nil {
+		t.Error("Wait returned an error", err)
+	}
+}
+
+func TestWaitWithoutStartReturnsError(t *testing.T) {
+	rtpMuxer := Muxer{Address: "239.0.0.1:5004"}
+	err := rtpMuxer.Wait()
+
+	if err == nil || err.Error() != "ffmpeg rtp: not started" {
+		t.Error("Wait failed to return correct error when run without Mux", err)
+	}
+}
+
+func TestStringReturnsStringifiedCommand(t *testing.T) {
+	execCommand = mockExecCommand
+	defer func() { execCommand = exec.Command }()
+
+	videoStream := ioutil.NopCloser(strings.NewReader("totallyfakevideostream"))
+
+	rtpMuxer := Muxer{Address: "239.0.0.1:5004", Directory: "rtp", Options: Options{Fps: 30, TTL: 4}}
+	rtpMuxer.Mux(videoStream)
+	defer rtpMuxer.Wait()
+
+	cmdStr := rtpMuxer.String()
+	expectedCmdStr := "ffmpeg " +
+		"-i pipe:0 " +
+		"-codec copy " +
+		"-f rtp " +
+		"-an " +
+		"-sdp_file " + path.Join("rtp", "livestream.sdp") + " " +
+		"-r 30 " +
+		"rtp://239.0.0.1:5004?ttl=4"
+
+	if !strings.Contains(cmdStr, expectedCmdStr) {
+		t.Error("String returned incorrect value, got:", cmdStr)
+	}
+}
+
+func TestStringReturnsNilForUnstartedOperation(t *testing.T) {
+	rtpMuxer := Muxer{Address: "239.0.0.1:5004"}
+
+	cmdStr := rtpMuxer.String()
+	if cmdStr != "" {
+		t.Error("String returned incorrect value, got:", cmdStr)
+	}
+}
+
+func TestInterfaceAddress(t *testing.T) {
+	ifaces, _ := net.Interfaces()
+
+	for _, iface := range ifaces {
+		addrs, _ := iface.Addrs()
+		if len(addrs) == 0 {
+			continue
+		}
+
+		addr, err := InterfaceAddress(iface.Name)
+		if err != nil {
+			t.Fatal("InterfaceAddress returned an error", err)
+		}
+		if net.ParseIP(addr) == nil {
+			t.Error("InterfaceAddress returned an invalid address", addr)
+		}
+		return
+	}
+
+	t.Skip("No network interfaces with addresses")
+}
+
+func TestInterfaceAddressInvalidInterfaceReturnsError(t *testing.T) {
+	_, err := InterfaceAddress("totallyfakeinterface")
+
+	if err == nil {
+		t.Error("InterfaceAddress failed to return an error for invalid interface")
+	}
+}
+
+// mockExecCommand sets up a mocked exec.Command using TestMain
+func mockExecCommand(command string, args ...string) *exec.Cmd {
+	cs := append([]string{command}, args...)
+	cmd := exec.Command(os.Args[0], cs...)
+	cmd.Env = append(os.Environ(), "GO_TEST_MODE=ffmpeg")
+	return cmd
+}
+
+// mockFailedExecCommand sets up a exec.Command that will fail
+func mockFailedExecCommand(command string, args ...string) *exec.Cmd {
+	cmd := exec.Command("totallyfakecommandthatdoesnotexist")
+	return cmd
+}
+
+func equal(a, b []string) bool {
+	// If one is nil, the other must also be nil.
+	if (a == nil) != (b == nil) {
+		return false
+	}
+
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,112 @@
+package srt
+
+import (
+	"errors"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Options represents ways that Ffmpeg may be configured to send video over SRT.
+//
+// Ffmpeg will step in and use its own defaults if a value is not provided.
+type Options struct {
+	Fps        int    // Framerate of the output video
+	Mode       string // Whether to wait for a connection ("listener") or establish one ("caller")
+	Latency    int    // Amount of time in milliseconds to buffer for retransmission of lost packets
+	Passphrase string // Passphrase used to encrypt the stream
+	KeyLength  int    // Length of the encryption key in bytes
+	StreamID   string // Identifies the stream to the other side of the connection
+}
+
+// Muxer represents the SRT muxer.
+//
+// The video is sent as MPEG-TS over SRT.
+type Muxer struct {
+	Address string // Host and port to listen on or connect to
+	Options Options
+	cmd     *exec.Cmd
+}
+
+var execCommand = exec.Command
+
+// Mux begins muxing the video stream to MPEG-TS and sending it over SRT.
+func (muxer *Muxer) Mux(video io.ReadCloser) error {
+	args := []string{
+		"-i", "pipe:0",
+		"-codec", "copy",
+		"-f", "mpegts",
+		"-an",
+	}
+
+	if muxer.Options.Fps != 0 {
+		args = append(args, "-r", strconv.Itoa(muxer.Options.Fps))
+	}
+
+	mode := strings.ToLower(muxer.Options.Mode)
+	if mode == "listener" || mode == "caller" {
+		args = append(args, "-mode", mode)
+	} else if mode != "" {
+		return errors.New("ffmpeg srt: invalid mode")
+	}
+
+	// Ffmpeg expects latency in microseconds
+	if muxer.Options.Latency != 0 {
+		args = append(args, "-latency", strconv.Itoa(muxer.Options.Latency*1000))
+	}
+
+	if muxer.Options.Passphrase != "" {
+		args = append(args, "-passphrase", muxer.Options.Passphrase)
+	}
+
+	if muxer.Options.KeyLength != 0 {
+		args = append(args, "-pbkeylen", strconv.Itoa(muxer.Options.KeyLength))
+	}
+
+	if muxer.Options.StreamID != "" {
+		args = append(args, "-streamid", muxer.Options.StreamID)
+	}
+
+	args = append(args, "srt://"+muxer.Address)
+
+	muxer.cmd = execCommand("ffmpeg", args...)
+	muxer.cmd.Stdin = video
+
+	return muxer.cmd.Start()
+}
+
+// Wait waits for the video stream to finish processing.
+//
+// The mux operation must have been started by Mux.
+func (muxer *Muxer) Wait() error {
+	if muxer.cmd == nil {
+		return errors.New("ffmpeg srt: not started")
+	}
+
+	err := muxer.cmd.Wait()
+
+	// Ignore 255 status -- just indicates that we exited early
+	if err != nil && err.Error() == "exit status 255" {
+		err = nil
+	}
+
+	return err
+}
+
+// String returns the command used to mux the video, with the passphrase redacted.
+func (muxer *Muxer) String() string {
+	if muxer.cmd == nil {
+		return ""
+	}
+
+	args := make([]string, len(muxer.cmd.Args))
+	copy(args, muxer.cmd.Args)
+	for i := 1; i < len(args); i++ {
+		if args[i-1] == "-passphrase" {
+			args[i] = "REDACTED"
+		}
+	}
+
+	return strings.Join(args, " ")
+}
@@ -0,0 +1,287 @@
+package srt
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+const fakeVideoStreamContent = "fakevideostream"
+
+func TestMain(m *testing.M) {
+	switch os.Getenv("GO_TEST_MODE") {
+	case "":
+		os.Exit(m.Run())
+	case "ffmpeg":
+		os.Stdout.WriteString(fakeVideoStreamContent)
+		os.Exit(0)
+	}
+}
+
+func TestMux(t *testing.T) {
+	testCases := []struct {
+		muxer        Muxer
+		expectedArgs []string
+	}{
+		{
+			Muxer{Address: "example.com:9000"},
+			[]string{
+				"ffmpeg",
+				"-i", "pipe:0",
+				"-codec", "copy",
+				"-f", "mpegts",
+				"-an",
+				"srt://example.com:9000",
+			},
+		},
+		{
+			Muxer{Address: "example.com:9000", Options: Options{Fps: 60}},
+			[]string{
+				"ffmpeg",
+				"-i", "pipe:0",
+				"-codec", "copy",
+				"-f", "mpegts",
+				"-an",
+				"-r", "60",
+				"srt://example.com:9000",
+			},
+		},
+		{
+			Muxer{Address: "0.0.0.0:9000", Options: Options{Mode: "LiStEnEr"}},
+			[]string{
+				"ffmpeg",
+				"-i", "pipe:0",
+				"-codec", "copy",
+				"-f", "mpegts",
+				"-an",
+				"-mode", "listener",
+				"srt://0.0.0.0:9000",
+			},
+		},
+		{
+			Muxer{Address: "example.com:9000", Options: Options{Mode: "CaLlEr"}},
+			[]string{
+				"ffmpeg",
+				"-i", "pipe:0",
+				"-codec", "copy",
+				"-f", "mpegts",
+				"-an",
+				"-mode", "caller",
+				"srt://example.com:9000",
+			},
+		},
+		{
+			Muxer{Address: "example.com:9000", Options: Options{Latency: 250}},
+			[]string{
+				"ffmpeg",
+				"-i", "pipe:0",
+				"-codec", "copy",
+				"-f", "mpegts",
+				"-an",
+				"-latency", "250000",
+				"srt://example.com:9000",
+			},
+		},
+		{
+			Muxer{Address: "example.com:9000", Options: Options{Passphrase: "correcthorsebatterystaple", KeyLength: 32}},
+			[]string{
+				"ffmpeg",
+				"-i", "pipe:0",
+				"-codec", "copy",
+				"-f", "mpegts",
+				"-an",
+				"-passphrase", "correcthorsebatterystaple",
+				"-pbkeylen", "32",
+				"srt://example.com:9000",
+			},
+		},
+		{
+			Muxer{Address: "example.com:9000", Options: Options{StreamID: "frontdoor"}},
+			[]string{
+				"ffmpeg",
+				"-i", "pipe:0",
+				"-codec", "copy",
+				"-f", "mpegts",
+				"-an",
+				"-streamid", "frontdoor",
+				"srt://example.com:9000",
+			},
+		},
+		{
+			Muxer{
+				Address: "example.com:9000",
+				Options: Options{
+					Fps:        30,
+					Mode:       "caller",
+					Latency:    2000,
+					Passphrase: "correcthorsebatterystaple",
+					KeyLength:  16,
+					StreamID:   "frontdoor",
+				},
+			},
+			[]string{
+				"ffmpeg",
+				"-i", "pipe:0",
+				"-codec", "copy",
+				"-f", "mpegts",
+				"-an",
+				"-r", "30",
+				"-mode", "caller",
+				"-latency", "2000000",
+				"-passphrase", "correcthorsebatterystaple",
+				"-pbkeylen", "16",
+				"-streamid", "frontdoor",
+				"srt://example.com:9000",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%v", tc.muxer), func(t *testing.T) {
+			execCommand = mockExecCommand
+			defer func() { execCommand = exec.Command }()
+
+			videoStream := ioutil.NopCloser(strings.NewReader("totallyfakevideostream"))
+
+			srtMuxer := tc.muxer
+			err := srtMuxer.Mux(videoStream)
+
+			if err != nil {
+				t.Error("Mux produced an err", err)
+			}
+
+			ffmpegArgs := srtMuxer.cmd.Args[1:]
+
+			if !equal(ffmpegArgs, tc.expectedArgs) {
+				t.Error("Command args do not match, got", ffmpegArgs, "but wanted", tc.expectedArgs)
+			}
+		})
+	}
+}
+
+func TestMuxInvalidModeReturnsError(t *testing.T) {
+	execCommand = mockExecCommand
+	defer func() { execCommand = exec.Command }()
+
+	videoStream := ioutil.NopCloser(strings.NewReader("totallyfakevideostream"))
+
+	srtMuxer := Muxer{Address: "example.com:9000", Options: Options{Mode: "rendezvous"}}
+	err := srtMuxer.Mux(videoStream)
+
+	if err == nil || err.Error() != "ffmpeg srt: invalid mode" {
+		t.Error("Mux failed to return an error for invalid mode")
+	}
+}
+
+func TestMuxReturnsFfmpegError(t *testing.T) {
+	execCommand = mockFailedExecCommand
+	defer func() { execCommand = exec.Command }()
+
+	videoStream := ioutil.NopCloser(strings.NewReader("totallyfakevideostream"))
+
+	srtMuxer := Muxer{Address: "example.com:9000"}
+	err := srtMuxer.Mux(videoStream)
+
+	if err == nil {
+		t.Error("Mux failed to return an error")
+	}
+}
+
+func TestWait(t *testing.T) {
+	execCommand = mockExecCommand
+	defer func() { execCommand = exec.Command }()
+
+	videoStream := ioutil.NopCloser(strings.NewReader("totallyfakevideostream"))
+
+	srtMuxer := Muxer{Address: "example.com:9000"}
+	srtMuxer.Mux(videoStream)
+	err := srtMuxer.Wait()
+
+	if err != nil {
+		t.Error("Wait returned an error", err)
+	}
+}
+
+func TestWaitWithoutStartReturnsError(t *testing.T) {
+	srtMuxer := Muxer{Address: "example.com:9000"}
+	err := srtMuxer.Wait()
+
+	if err == nil || err.Error() != "ffmpeg srt: not started" {
+		t.Error("Wait failed to return correct error when run without Mux", err)
+	}
+}
+
+func TestStringReturnsStringifiedCommandWithoutPassphrase(t *testing.T) {
+	execCommand = mockExecCommand
+	defer func() { execCommand = exec.Command }()
+
+	videoStream := ioutil.NopCloser(strings.NewReader("totallyfakevideostream"))
+
+	srtMuxer := Muxer{
+		Address: "0.0.0.0:9000",
+		Options: Options{Fps: 30, Mode: "listener", Latency: 120, Passphrase: "correcthorsebatterystaple"},
+	}
+	srtMuxer.Mux(videoStream)
+	defer srtMuxer.Wait()
+
+	cmdStr := srtMuxer.String()
+	expectedCmdStr := "ffmpeg " +
+		"-i pipe:0 " +
+		"-codec copy " +
+		"-f mpegts " +
+		"-an " +
+		"-r 30 " +
+		"-mode listener " +
+		"-latency 120000 " +
+		"-passphrase REDACTED " +
+		"srt://0.0.0.0:9000"
+
+	if !strings.Contains(cmdStr, expectedCmdStr) {
+		t.Error("String returned incorrect value, got:", cmdStr)
+	}
+}
+
+func TestStringReturnsNilForUnstartedOperation(t *testing.T) {
+	srtMuxer := Muxer{Address: "example.com:9000"}
+
+	cmdStr := srtMuxer.String()
+	if cmdStr != "" {
+		t.Error("String returned incorrect value, got:", cmdStr)
+	}
+}
+
+// mockExecCommand sets up a mocked exec.Command using TestMain
+func mockExecCommand(command string, args ...string) *exec.Cmd {
+	cs := append([]string{command}, args...)
+	cmd := exec.Command(os.Args[0], cs...)
+	cmd.Env = append(os.Environ(), "GO_TEST_MODE=ffmpeg")
+	return cmd
+}
+
+// mockFailedExecCommand sets up a exec.Command that will fail
+func mockFailedExecCommand(command string, args ...string) *exec.Cmd {
+	cmd := exec.Command("totallyfakecommandthatdoesnotexist")
+	return cmd
+}
+
+func equal(a, b []string) bool {
+	// If one is nil, the other must also be nil.
+	if (a == nil) != (b == nil) {
+		return false
+	}
+
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,108 @@
+package thumbnail
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"os/exec"
+)
+
+// boundary separates the individual JPEG thumbnails in the multipart output.
+const boundary = "raspilive"
+
+// Options represents ways that Ffmpeg may be configured to decode keyframes into thumbnails.
+//
+// Ffmpeg keeps the size of the video if a value is not provided.
+type Options struct {
+	Width  int // Width of the thumbnails in pixels
+	Height int // Height of the thumbnails in pixels
+}
+
+// Muxer represents the thumbnail muxer.
+//
+// Keyframes are decoded into JPEG thumbnails one for one. The video fed to the muxer should only be made up of
+// keyframes that stand on their own, as Ffmpeg would otherwise decode every frame.
+type Muxer struct {
+	Options Options
+	frames  *multipart.Reader
+	cmd     *exec.Cmd
+}
+
+var execCommand = exec.Command
+
+// Mux begins decoding the keyframes into thumbnails.
+func (muxer *Muxer) Mux(keyframes io.ReadCloser) error {
+	args := []string{
+		"-f", "h264",
+		"-i", "pipe:0",
+		"-vsync", "passthrough",
+		"-an",
+	}
+
+	if muxer.Options.Width != 0 && muxer.Options.Height != 0 {
+		args = append(args, "-vf", fmt.Sprintf("scale=%d:%d", muxer.Options.Width, muxer.Options.Height))
+	}
+
+	args = append(args, "-f", "mpjpeg", "-boundary_tag", boundary, "pipe:1")
+
+	cmd := execCommand("ffmpeg", args...)
+	cmd.Stdin = keyframes
+
+	frames, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	muxer.cmd = cmd
+	muxer.frames = multipart.NewReader(frames, boundary)
+
+	return muxer.cmd.Start()
+}
+
+// Next returns the next thumbnail, blocking until it has been decoded.
+//
+// Returns io.EOF once the keyframes have run out and every thumbnail has been read.
+func (muxer *Muxer) Next() ([]byte, error) {
+	if muxer.frames == nil {
+		return nil, errors.New("ffmpeg thumbnail: not started")
+	}
+
+	part, err := muxer.frames.NextPart()
+	if err != nil {
+		return nil, err
+	}
+	defer part.Close()
+
+	return ioutil.ReadAll(part)
+}
+
+// Wait waits for the keyframes to finish processing.
+//
+// The thumbnails must be read in their entirety before calling Wait.
+func (muxer *Muxer) Wait() error {
+	if muxer.cmd == nil {
+		return errors.New("ffmpeg thumbnail: not started")
+	}
+
+	err := muxer.cmd.Wait()
+
+	// Ignore 255 status -- just indicates that we exited early
+	if err != nil && err.Error() == "exit status 255" {
+		err = nil
+	}
+
+	return err
+}
+
+func (muxer *Muxer) String() string {
+	var cmdStr string
+	if muxer.cmd == nil {
+		cmdStr = ""
+	} else {
+		cmdStr = muxer.cmd.String()
+	}
+
+	return cmdStr
+}
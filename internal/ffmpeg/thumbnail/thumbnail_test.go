@@ -0,0 +1,198 @@
+package thumbnail
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+const fakeThumbnailContent = "fakejpegthumbnail"
+
+func TestMain(m *testing.M) {
+	// Facilitate the "mocking" of os/exec by running a faked CLI program
+	switch os.Getenv("GO_TEST_MODE") {
+	case "":
+		os.Exit(m.Run())
+	case "ffmpeg":
+		// Produce a couple of thumbnails once the keyframes end
+		io.Copy(ioutil.Discard, os.Stdin)
+		thumbnails := multipart.NewWriter(os.Stdout)
+		thumbnails.SetBoundary(boundary)
+		for i := 0; i < 2; i++ {
+			part, _ := thumbnails.CreatePart(nil)
+			part.Write([]byte(fakeThumbnailContent))
+		}
+		thumbnails.Close()
+		os.Exit(0)
+	}
+}
+
+func TestMux(t *testing.T) {
+	testCases := []struct {
+		muxer        Muxer
+		expectedArgs []string
+	}{
+		{
+			Muxer{},
+			[]string{
+				"ffmpeg",
+				"-f", "h264",
+				"-i", "pipe:0",
+				"-vsync", "passthrough",
+				"-an",
+				"-f", "mpjpeg",
+				"-boundary_tag", "raspilive",
+				"pipe:1",
+			},
+		},
+		{
+			Muxer{Options: Options{Width: 160, Height: 90}},
+			[]string{
+				"ffmpeg",
+				"-f", "h264",
+				"-i", "pipe:0",
+				"-vsync", "passthrough",
+				"-an",
+				"-vf", "scale=160:90",
+				"-f", "mpjpeg",
+				"-boundary_tag", "raspilive",
+				"pipe:1",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%v", tc.muxer), func(t *testing.T) {
+			execCommand = mockExecCommand
+			defer func() { execCommand = exec.Command }()
+
+			keyframes := ioutil.NopCloser(strings.NewReader("totallyfakekeyframes"))
+
+			thumbnailMuxer := tc.muxer
+			err := thumbnailMuxer.Mux(keyframes)
+
+			if err != nil {
+				t.Error("Mux produced an err", err)
+			}
+
+			ffmpegArgs := thumbnailMuxer.cmd.Args[1:]
+
+			if !equal(ffmpegArgs, tc.expectedArgs) {
+				t.Error("Command args do not match, got", ffmpegArgs, "but wanted", tc.expectedArgs)
+			}
+
+			for {
+				if _, err := thumbnailMuxer.Next(); err != nil {
+					break
+				}
+			}
+			thumbnailMuxer.Wait()
+		})
+	}
+}
+
+func TestNext(t *testing.T) {
+	execCommand = mockExecCommand
+	defer func() { execCommand = exec.Command }()
+
+	keyframes := ioutil.NopCloser(strings.NewReader("totallyfakekeyframes"))
+
+	thumbnailMuxer := Muxer{}
+	thumbnailMuxer.Mux(keyframes)
+
+	for i := 0; i < 2; i++ {
+		thumbnail, err := thumbnailMuxer.Next()
+		if err != nil {
+			t.Fatal("Next returned an error", err)
+		}
+		if string(thumbnail) != fakeThumbnailContent {
+			t.Error("Thumbnail is invalid:", string(thumbnail))
+		}
+	}
+
+	if _, err := thumbnailMuxer.Next(); err != io.EOF {
+		t.Error("Next did not return EOF once the thumbnails ran out, got", err)
+	}
+
+	if err := thumbnailMuxer.Wait(); err != nil {
+		t.Error("Wait returned an error", err)
+	}
+}
+
+func TestNextWithoutStartReturnsError(t *testing.T) {
+	thumbnailMuxer := Muxer{}
+
+	if _, err := thumbnailMuxer.Next(); err == nil || err.Error() != "ffmpeg thumbnail: not started" {
+		t.Error("Next failed to return correct error when run without Mux", err)
+	}
+}
+
+func TestMuxReturnsFfmpegError(t *testing.T) {
+	execCommand = mockFailedExecCommand
+	defer func() { execCommand = exec.Command }()
+
+	keyframes := ioutil.NopCloser(strings.NewReader("totallyfakekeyframes"))
+
+	thumbnailMuxer := Muxer{}
+	err := thumbnailMuxer.Mux(keyframes)
+
+	if err == nil {
+		t.Error("Mux failed to return an error")
+	}
+}
+
+func TestWaitWithoutStartReturnsError(t *testing.T) {
+	thumbnailMuxer := Muxer{}
+	err := thumbnailMuxer.Wait()
+
+	if err == nil || err.Error() != "ffmpeg thumbnail: not started" {
+		t.Error("Wait failed to return correct error when run without Mux", err)
+	}
+}
+
+func TestStringReturnsNilForUnstartedOperation(t *testing.T) {
+	thumbnailMuxer := Muxer{Options: Options{Width: 160, Height: 90}}
+
+	cmdStr := thumbnailMuxer.String()
+	if cmdStr != "" {
+		t.Error("String returned incorrect value, got:", cmdStr)
+	}
+}
+
+// mockExecCommand sets up a mocked exec.Command using TestMain
+func mockExecCommand(command string, args ...string) *exec.Cmd {
+	cs := append([]string{command}, args...)
+	cmd := exec.Command(os.Args[0], cs...)
+	cmd.Env = append(os.Environ(), "GO_TEST_MODE=ffmpeg")
+	return cmd
+}
+
+// mockFailedExecCommand sets up a exec.Command that will fail
+func mockFailedExecCommand(command string, args ...string) *exec.Cmd {
+	cmd := exec.Command("totallyfakecommandthatdoesnotexist")
+	return cmd
+}
+
+func equal(a, b []string) bool {
+	// If one is nil, the other must also be nil.
+	if (a == nil) != (b == nil) {
+		return false
+	}
+
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,277 @@
+package fmp4
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// Sample flags, per ISO/IEC 14496-12.
+const (
+	flagsKeyframe    = 0x02000000 // Does not depend on other samples
+	flagsNonKeyframe = 0x01010000 // Depends on other samples, not a sync sample
+)
+
+// Track describes the H.264 video track.
+type Track struct {
+	Width     int    // Width of the video in pixels
+	Height    int    // Height of the video in pixels
+	Timescale uint32 // Number of timestamp ticks per second
+	SPS       []byte // Sequence parameter set, without start code
+	PPS       []byte // Picture parameter set, without start code
+}
+
+// Sample is a single frame of video.
+type Sample struct {
+	Duration uint32 // Duration in timescale ticks
+	Keyframe bool
+	Data     []byte // NAL units, each prefixed with its length as produced by SampleData
+}
+
+// Event is a timed message carried in the segment alongside the video as an event message box, such as an ID3 tag.
+type Event struct {
+	Scheme           string // URI identifying the scheme of the message
+	Value            string // Value within the scheme, if any
+	Timescale        uint32 // Number of timestamp ticks per second
+	PresentationTime uint64 // Presentation timestamp that the event applies from, in timescale ticks
+	ID               uint32 // Identifier of the event, unique among events of the same scheme and value
+	Data             []byte // Message body
+}
+
+// Fragment is a self-contained piece of the video track, made up of consecutive samples.
+type Fragment struct {
+	Sequence            uint32 // Sequence number of the fragment, starting from one
+	BaseMediaDecodeTime uint64 // Decode timestamp of the first sample, in timescale ticks
+	Samples             []Sample
+	Events              []Event // Events to carry ahead of the samples
+}
+
+// SampleData converts the NAL units into the length-prefixed format used by MP4 samples.
+func SampleData(nalus [][]byte) []byte {
+	size := 0
+	for _, nalu := range nalus {
+		size += 4 + len(nalu)
+	}
+
+	data := make([]byte, 0, size)
+	for _, nalu := range nalus {
+		data = append(data, u32(uint32(len(nalu)))...)
+		data = append(data, nalu...)
+	}
+
+	return data
+}
+
+// WriteInit writes the initialization segment, which describes the track to players.
+func WriteInit(w io.Writer, track Track) error {
+	ftyp := box("ftyp", []byte("iso6"), u32(0), []byte("iso6cmfcmp41"))
+
+	mvhd := fullBox("mvhd", 0, 0,
+		u32(0), u32(0), // Creation and modification time
+		u32(1000),                    // Timescale
+		u32(0),                       // Duration, unknown for live video
+		u32(0x00010000), u16(0x0100), // Rate, volume
+		make([]byte, 10), // Reserved
+		matrix(),
+		make([]byte, 24), // Pre-defined
+		u32(2))           // Next track ID
+
+	tkhd := fullBox("tkhd", 0, 0x000003, // Enabled, in movie
+		u32(0), u32(0), // Creation and modification time
+		u32(1), u32(0), // Track ID, reserved
+		u32(0),                         // Duration
+		make([]byte, 8),                // Reserved
+		u16(0), u16(0), u16(0), u16(0), // Layer, alternate group, volume, reserved
+		matrix(),
+		u32(uint32(track.Width)<<16), u32(uint32(track.Height)<<16))
+
+	mdhd := fullBox("mdhd", 0, 0,
+		u32(0), u32(0), // Creation and modification time
+		u32(track.Timescale),
+		u32(0),      // Duration
+		u16(0x55C4), // Undetermined language
+		u16(0))      // Pre-defined
+
+	hdlr := fullBox("hdlr", 0, 0,
+		u32(0), []byte("vide"), make([]byte, 12), []byte("VideoHandler\x00"))
+
+	vmhd := fullBox("vmhd", 0, 1, make([]byte, 8))
+	dinf := box("dinf", fullBox("dref", 0, 0, u32(1), fullBox("url ", 0, 1)))
+
+	stbl := box("stbl",
+		fullBox("stsd", 0, 0, u32(1), avc1(track)),
+		fullBox("stts", 0, 0, u32(0)),
+		fullBox("stsc", 0, 0, u32(0)),
+		fullBox("stsz", 0, 0, u32(0), u32(0)),
+		fullBox("stco", 0, 0, u32(0)))
+
+	trak := box("trak", tkhd, box("mdia", mdhd, hdlr, box("minf", vmhd, dinf, stbl)))
+
+	mvex := box("mvex", fullBox("trex", 0, 0,
+		u32(1), // Track ID
+		u32(1), // Default sample description index
+		u32(0), u32(0), u32(0)))
+
+	_, err := w.Write(append(ftyp, box("moov", mvhd, trak, mvex)...))
+	return err
+}
+
+// WriteFragment writes the fragment as a media segment, made up of a movie fragment and its media data.
+func WriteFragment(w io.Writer, frag Fragment) error {
+	styp := box("styp", []byte("msdh"), u32(0), []byte("msdhmsix"))
+
+	size := 0
+	entries := make([]byte, 0, 12*len(frag.Samples))
+	for _, sample := range frag.Samples {
+		flags := uint32(flagsNonKeyframe)
+		if sample.Keyframe {
+			flags = flagsKeyframe
+		}
+
+		entries = append(entries, u32(sample.Duration)...)
+		entries = append(entries, u32(uint32(len(sample.Data)))...)
+		entries = append(entries, u32(flags)...)
+		size += len(sample.Data)
+	}
+
+	moof := buildMoof(frag, entries, 0)
+
+	// Media data follows immediately after the movie fragment, past the media data box header
+	moof = buildMoof(frag, entries, uint32(len(moof)+8))
+
+	mdat := make([]byte, 0, 8+size)
+	mdat = append(mdat, u32(uint32(8+size))...)
+	mdat = append(mdat, "mdat"...)
+	for _, sample := range frag.Samples {
+		mdat = append(mdat, sample.Data...)
+	}
+
+	// Event messages must come before the movie fragment that they go with
+	header := styp
+	for _, event := range frag.Events {
+		header = append(header, emsg(event)...)
+	}
+
+	if _, err := w.Write(append(header, moof...)); err != nil {
+		return err
+	}
+
+	_, err := w.Write(mdat)
+	return err
+}
+
+func buildMoof(frag Fragment, entries []byte, dataOffset uint32) []byte {
+	mfhd := fullBox("mfhd", 0, 0, u32(frag.Sequence))
+
+	tfhd := fullBox("tfhd", 0, 0x020000, u32(1)) // Default base is moof
+
+	tfdt := fullBox("tfdt", 1, 0, u64(frag.BaseMediaDecodeTime))
+
+	trun := fullBox("trun", 0, 0x000701, // Data offset, sample duration, size, and flags
+		u32(uint32(len(frag.Samples))),
+		u32(dataOffset),
+		entries)
+
+	return box("moof", mfhd, box("traf", tfhd, tfdt, trun))
+}
+
+// emsg builds a version 1 event message box, which gives the presentation time of the event in absolute terms.
+func emsg(event Event) []byte {
+	return fullBox("emsg", 1, 0,
+		u32(event.Timescale),
+		u64(event.PresentationTime),
+		u32(0), // Duration, as the event applies at a single point in time
+		u32(event.ID),
+		[]byte(event.Scheme+"\x00"),
+		[]byte(event.Value+"\x00"),
+		event.Data)
+}
+
+func avc1(track Track) []byte {
+	return box("avc1",
+		make([]byte, 6), u16(1), // Reserved, data reference index
+		make([]byte, 16), // Pre-defined and reserved
+		u16(uint16(track.Width)), u16(uint16(track.Height)),
+		u32(0x00480000), u32(0x00480000), // 72 DPI
+		u32(0),           // Reserved
+		u16(1),           // Frame count
+		make([]byte, 32), // Compressor name
+		u16(0x0018),      // Depth
+		u16(0xFFFF),      // Pre-defined
+		avcC(track.SPS, track.PPS))
+}
+
+// avcC builds the decoder configuration record out of the parameter sets, per ISO/IEC 14496-15.
+func avcC(sps []byte, pps []byte) []byte {
+	var profile, compatibility, level byte
+	if len(sps) >= 4 {
+		profile, compatibility, level = sps[1], sps[2], sps[3]
+	}
+
+	config := []byte{
+		1, // Configuration version
+		profile, compatibility, level,
+		0xFF, // Four byte NAL unit lengths
+		0xE1, // One sequence parameter set
+	}
+	config = append(config, u16(uint16(len(sps)))...)
+	config = append(config, sps...)
+	config = append(config, 1) // One picture parameter set
+	config = append(config, u16(uint16(len(pps)))...)
+	config = append(config, pps...)
+
+	// High profiles also describe the chroma format and bit depth, which are always 4:2:0 and 8 bits for raspivid
+	if profile == 100 || profile == 110 || profile == 122 || profile == 144 {
+		config = append(config, 0xFD, 0xF8, 0xF8, 0x00)
+	}
+
+	return box("avcC", config)
+}
+
+func matrix() []byte {
+	return concat(
+		u32(0x00010000), u32(0), u32(0),
+		u32(0), u32(0x00010000), u32(0),
+		u32(0), u32(0), u32(0x40000000))
+}
+
+func box(typ string, payload ...[]byte) []byte {
+	body := concat(payload...)
+
+	b := make([]byte, 0, 8+len(body))
+	b = append(b, u32(uint32(8+len(body)))...)
+	b = append(b, typ...)
+	b = append(b, body...)
+
+	return b
+}
+
+func fullBox(typ string, version byte, flags uint32, payload ...[]byte) []byte {
+	header := []byte{version, byte(flags >> 16), byte(flags >> 8), byte(flags)}
+	return box(typ, append([][]byte{header}, payload...)...)
+}
+
+func concat(parts ...[]byte) []byte {
+	var b []byte
+	for _, part := range parts {
+		b = append(b, part...)
+	}
+	return b
+}
+
+func u16(v uint16) []byte {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, v)
+	return b
+}
+
+func u32(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	return b
+}
+
+func u64(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
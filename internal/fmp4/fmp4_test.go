@@ -0,0 +1,180 @@
+package fmp4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+var (
+	fakeSPS = []byte{0x67, 0x64, 0x00, 0x28, 0xAC}
+	fakePPS = []byte{0x68, 0xEE, 0x3C, 0x80}
+)
+
+// findBox looks up a box by its path of types, returning its payload.
+func findBox(t *testing.T, data []byte, path ...string) []byte {
+	for len(data) >= 8 {
+		size := int(binary.BigEndian.Uint32(data))
+		if size < 8 || size > len(data) {
+			t.Fatal("Box has invalid size", size)
+		}
+
+		if string(data[4:8]) == path[0] {
+			payload := data[8:size]
+			if len(path) == 1 {
+				return payload
+			}
+			return findBox(t, payload, path[1:]...)
+		}
+
+		data = data[size:]
+	}
+
+	t.Fatal("Box not found", path)
+	return nil
+}
+
+func TestSampleData(t *testing.T) {
+	data := SampleData([][]byte{{0x65, 0x88}, {0x06}})
+
+	expected := []byte{0x00, 0x00, 0x00, 0x02, 0x65, 0x88, 0x00, 0x00, 0x00, 0x01, 0x06}
+	if !bytes.Equal(data, expected) {
+		t.Errorf("SampleData returned incorrect data, got % X", data)
+	}
+}
+
+func TestWriteInit(t *testing.T) {
+	var init bytes.Buffer
+
+	err := WriteInit(&init, Track{Width: 1280, Height: 720, Timescale: 90000, SPS: fakeSPS, PPS: fakePPS})
+	if err != nil {
+		t.Fatal("WriteInit returned an error", err)
+	}
+
+	if ftyp := findBox(t, init.Bytes(), "ftyp"); string(ftyp[:4]) != "iso6" {
+		t.Error("WriteInit wrote incorrect major brand, got", string(ftyp[:4]))
+	}
+
+	tkhd := findBox(t, init.Bytes(), "moov", "trak", "tkhd")
+	if width, height := binary.BigEndian.Uint32(tkhd[76:]), binary.BigEndian.Uint32(tkhd[80:]); width != 1280<<16 || height != 720<<16 {
+		t.Error("WriteInit wrote incorrect track dimensions, got", width>>16, height>>16)
+	}
+
+	mdhd := findBox(t, init.Bytes(), "moov", "trak", "mdia", "mdhd")
+	if timescale := binary.BigEndian.Uint32(mdhd[12:]); timescale != 90000 {
+		t.Error("WriteInit wrote incorrect timescale, got", timescale)
+	}
+
+	stsd := findBox(t, init.Bytes(), "moov", "trak", "mdia", "minf", "stbl", "stsd")
+	avc1 := findBox(t, stsd[8:], "avc1")
+	if width, height := binary.BigEndian.Uint16(avc1[24:]), binary.BigEndian.Uint16(avc1[26:]); width != 1280 || height != 720 {
+		t.Error("WriteInit wrote incorrect sample entry dimensions, got", width, height)
+	}
+
+	avcC := findBox(t, avc1[78:], "avcC")
+	expected := []byte{0x01, 0x64, 0x00, 0x28, 0xFF, 0xE1, 0x00, 0x05}
+	expected = append(expected, fakeSPS...)
+	expected = append(expected, 0x01, 0x00, 0x04)
+	expected = append(expected, fakePPS...)
+	expected = append(expected, 0xFD, 0xF8, 0xF8, 0x00)
+	if !bytes.Equal(avcC, expected) {
+		t.Errorf("WriteInit wrote incorrect decoder configuration, got % X", avcC)
+	}
+
+	findBox(t, init.Bytes(), "moov", "mvex", "trex")
+}
+
+func TestWriteFragment(t *testing.T) {
+	var segment bytes.Buffer
+
+	frag := Fragment{
+		Sequence:            7,
+		BaseMediaDecodeTime: 180000,
+		Samples: []Sample{
+			{Duration: 3000, Keyframe: true, Data: SampleData([][]byte{{0x65, 0x88, 0x84}})},
+			{Duration: 3000, Data: SampleData([][]byte{{0x41, 0x9A}})},
+		},
+	}
+
+	if err := WriteFragment(&segment, frag); err != nil {
+		t.Fatal("WriteFragment returned an error", err)
+	}
+
+	findBox(t, segment.Bytes(), "styp")
+
+	mfhd := findBox(t, segment.Bytes(), "moof", "mfhd")
+	if sequence := binary.BigEndian.Uint32(mfhd[4:]); sequence != 7 {
+		t.Error("WriteFragment wrote incorrect sequence number, got", sequence)
+	}
+
+	tfdt := findBox(t, segment.Bytes(), "moof", "traf", "tfdt")
+	if tfdt[0] != 1 || binary.BigEndian.Uint64(tfdt[4:]) != 180000 {
+		t.Errorf("WriteFragment wrote incorrect base media decode time, got % X", tfdt)
+	}
+
+	trun := findBox(t, segment.Bytes(), "moof", "traf", "trun")
+	if count := binary.BigEndian.Uint32(trun[4:]); count != 2 {
+		t.Fatal("WriteFragment wrote incorrect sample count, got", count)
+	}
+
+	// The data offset is relative to the start of the movie fragment, which comes right after the segment type
+	moofStart := int(binary.BigEndian.Uint32(segment.Bytes()))
+	offset := moofStart + int(binary.BigEndian.Uint32(trun[8:]))
+	if sample := segment.Bytes()[offset : offset+7]; !bytes.Equal(sample, frag.Samples[0].Data) {
+		t.Errorf("WriteFragment wrote incorrect data offset, points to % X", sample)
+	}
+
+	expectedEntries := []uint32{3000, 7, flagsKeyframe, 3000, 6, flagsNonKeyframe}
+	for i, expected := range expectedEntries {
+		if entry := binary.BigEndian.Uint32(trun[12+4*i:]); entry != expected {
+			t.Errorf("WriteFragment wrote incorrect sample entry %d, got %X", i, entry)
+		}
+	}
+
+	mdat := findBox(t, segment.Bytes(), "mdat")
+	if !bytes.Equal(mdat, append(frag.Samples[0].Data, frag.Samples[1].Data...)) {
+		t.Errorf("WriteFragment wrote incorrect media data, got % X", mdat)
+	}
+}
+
+func TestWriteFragmentEvents(t *testing.T) {
+	var segment bytes.Buffer
+
+	frag := Fragment{
+		Sequence:            1,
+		BaseMediaDecodeTime: 180000,
+		Samples:             []Sample{{Duration: 3000, Keyframe: true, Data: SampleData([][]byte{{0x65, 0x88}})}},
+		Events: []Event{
+			{Scheme: "https://aomedia.org/emsg/ID3", Timescale: 90000, PresentationTime: 183000, ID: 4, Data: []byte("ID3")},
+		},
+	}
+
+	if err := WriteFragment(&segment, frag); err != nil {
+		t.Fatal("WriteFragment returned an error", err)
+	}
+
+	// Event messages sit between the segment type and the movie fragment
+	styp := int(binary.BigEndian.Uint32(segment.Bytes()))
+	if box := string(segment.Bytes()[styp+4 : styp+8]); box != "emsg" {
+		t.Fatal("WriteFragment did not write the event message after the segment type, got", box)
+	}
+
+	emsg := findBox(t, segment.Bytes(), "emsg")
+	expected := []byte{0x01, 0x00, 0x00, 0x00}
+	expected = append(expected, u32(90000)...)
+	expected = append(expected, u64(183000)...)
+	expected = append(expected, u32(0)...)
+	expected = append(expected, u32(4)...)
+	expected = append(expected, "https://aomedia.org/emsg/ID3\x00\x00ID3"...)
+	if !bytes.Equal(emsg, expected) {
+		t.Errorf("WriteFragment wrote incorrect event message, got % X", emsg)
+	}
+
+	// The data offset is relative to the movie fragment, so the event messages must not throw it off
+	moofStart := styp + 8 + len(emsg)
+	trun := findBox(t, segment.Bytes(), "moof", "traf", "trun")
+	offset := moofStart + int(binary.BigEndian.Uint32(trun[8:]))
+	if sample := segment.Bytes()[offset : offset+6]; !bytes.Equal(sample, frag.Samples[0].Data) {
+		t.Errorf("WriteFragment wrote incorrect data offset, points to % X", sample)
+	}
+}
@@ -0,0 +1,245 @@
+package fmp4
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// errInvalid indicates that the data is not fragmented MP4 that may be read back.
+var errInvalid = errors.New("fmp4: invalid data")
+
+// ReadInit reads the video track back out of an initialization segment, as written by WriteInit.
+func ReadInit(data []byte) (Track, error) {
+	moov, ok := child(data, "moov")
+	if !ok {
+		return Track{}, errInvalid
+	}
+	trak, ok := child(moov, "trak")
+	if !ok {
+		return Track{}, errInvalid
+	}
+
+	var track Track
+
+	tkhd, ok := child(trak, "tkhd")
+	if !ok || len(tkhd) < 84 {
+		return Track{}, errInvalid
+	}
+	track.Width = int(binary.BigEndian.Uint32(tkhd[76:]) >> 16)
+	track.Height = int(binary.BigEndian.Uint32(tkhd[80:]) >> 16)
+
+	mdia, _ := child(trak, "mdia")
+	mdhd, ok := child(mdia, "mdhd")
+	if !ok || len(mdhd) < 16 || mdhd[0] != 0 {
+		return Track{}, errInvalid
+	}
+	track.Timescale = binary.BigEndian.Uint32(mdhd[12:])
+	if track.Timescale == 0 {
+		return Track{}, errInvalid
+	}
+
+	minf, _ := child(mdia, "minf")
+	stbl, _ := child(minf, "stbl")
+	stsd, ok := child(stbl, "stsd")
+	if !ok || len(stsd) < 8 {
+		return Track{}, errInvalid
+	}
+
+	// The sample entry comes after the entry count, and its boxes after the fields describing the video
+	avc1, ok := child(stsd[8:], "avc1")
+	if !ok || len(avc1) < 78 {
+		return Track{}, errInvalid
+	}
+	avcC, ok := child(avc1[78:], "avcC")
+	if !ok || len(avcC) < 8 {
+		return Track{}, errInvalid
+	}
+
+	spsLength := int(binary.BigEndian.Uint16(avcC[6:]))
+	if avcC[5]&0x1F != 1 || len(avcC) < 8+spsLength+3 {
+		return Track{}, errInvalid
+	}
+	track.SPS = avcC[8 : 8+spsLength]
+
+	pps := avcC[8+spsLength:]
+	ppsLength := int(binary.BigEndian.Uint16(pps[1:]))
+	if pps[0] != 1 || len(pps) < 3+ppsLength {
+		return Track{}, errInvalid
+	}
+	track.PPS = pps[3 : 3+ppsLength]
+
+	return track, nil
+}
+
+// ReadFragment reads the fragment back out of a media segment, as written by WriteFragment.
+//
+// Event messages are skipped.
+func ReadFragment(data []byte) (Fragment, error) {
+	var frag Fragment
+	var trun []byte
+	var dataStart int
+	found := false
+
+	for offset := 0; offset+8 <= len(data); {
+		size := int(binary.BigEndian.Uint32(data[offset:]))
+		if size < 8 || offset+size > len(data) {
+			return Fragment{}, errInvalid
+		}
+
+		if string(data[offset+4:offset+8]) == "moof" {
+			moof := data[offset+8 : offset+size]
+
+			mfhd, ok := child(moof, "mfhd")
+			if !ok || len(mfhd) < 8 {
+				return Fragment{}, errInvalid
+			}
+			frag.Sequence = binary.BigEndian.Uint32(mfhd[4:])
+
+			traf, _ := child(moof, "traf")
+			tfdt, ok := child(traf, "tfdt")
+			switch {
+			case ok && len(tfdt) >= 12 && tfdt[0] == 1:
+				frag.BaseMediaDecodeTime = binary.BigEndian.Uint64(tfdt[4:])
+			case ok && len(tfdt) >= 8:
+				frag.BaseMediaDecodeTime = uint64(binary.BigEndian.Uint32(tfdt[4:]))
+			default:
+				return Fragment{}, errInvalid
+			}
+
+			trun, ok = child(traf, "trun")
+			if !ok {
+				return Fragment{}, errInvalid
+			}
+
+			// Data offsets count from the start of the movie fragment
+			dataStart = offset
+			found = true
+		}
+
+		offset += size
+	}
+
+	if !found {
+		return Fragment{}, errInvalid
+	}
+
+	samples, err := readSamples(trun, data[dataStart:])
+	if err != nil {
+		return Fragment{}, err
+	}
+	frag.Samples = samples
+
+	return frag, nil
+}
+
+// NALUnits splits sample data back into its NAL units, undoing SampleData.
+func NALUnits(data []byte) ([][]byte, error) {
+	var nalus [][]byte
+	for len(data) > 0 {
+		if len(data) < 4 {
+			return nil, errInvalid
+		}
+
+		size := int(binary.BigEndian.Uint32(data))
+		if len(data) < 4+size {
+			return nil, errInvalid
+		}
+
+		nalus = append(nalus, data[4:4+size])
+		data = data[4+size:]
+	}
+
+	return nalus, nil
+}
+
+// readSamples reads the samples listed by the track run out of the data following the start of the movie fragment.
+//
+// Sample durations and sizes must be given for each sample, as there are no defaults to fall back on.
+func readSamples(trun []byte, data []byte) ([]Sample, error) {
+	if len(trun) < 8 {
+		return nil, errInvalid
+	}
+
+	flags := binary.BigEndian.Uint32(trun) & 0x00FFFFFF
+	if flags&0x000300 != 0x000300 {
+		return nil, errors.New("fmp4: unsupported track run")
+	}
+
+	count := int(binary.BigEndian.Uint32(trun[4:]))
+	fields := trun[8:]
+
+	offset := 0
+	if flags&0x000001 != 0 {
+		if len(fields) < 4 {
+			return nil, errInvalid
+		}
+		offset = int(int32(binary.BigEndian.Uint32(fields)))
+		fields = fields[4:]
+	}
+
+	firstFlags := uint32(0)
+	hasFirstFlags := flags&0x000004 != 0
+	if hasFirstFlags {
+		if len(fields) < 4 {
+			return nil, errInvalid
+		}
+		firstFlags = binary.BigEndian.Uint32(fields)
+		fields = fields[4:]
+	}
+
+	entrySize := 8
+	for _, field := range []uint32{0x000400, 0x000800} {
+		if flags&field != 0 {
+			entrySize += 4
+		}
+	}
+	if len(fields) < count*entrySize {
+		return nil, errInvalid
+	}
+
+	samples := make([]Sample, 0, count)
+	for i := 0; i < count; i++ {
+		entry := fields[i*entrySize:]
+		duration := binary.BigEndian.Uint32(entry)
+		size := int(binary.BigEndian.Uint32(entry[4:]))
+
+		sampleFlags := uint32(flagsNonKeyframe)
+		if flags&0x000400 != 0 {
+			sampleFlags = binary.BigEndian.Uint32(entry[8:])
+		}
+		if i == 0 && hasFirstFlags {
+			sampleFlags = firstFlags
+		}
+
+		if offset < 0 || offset+size > len(data) {
+			return nil, errInvalid
+		}
+
+		samples = append(samples, Sample{
+			Duration: duration,
+			Keyframe: sampleFlags&0x00010000 == 0, // Not a non-sync sample
+			Data:     data[offset : offset+size],
+		})
+		offset += size
+	}
+
+	return samples, nil
+}
+
+// child finds the payload of the first box of the given type among the boxes in the data.
+func child(data []byte, typ string) ([]byte, bool) {
+	for len(data) >= 8 {
+		size := int(binary.BigEndian.Uint32(data))
+		if size < 8 || size > len(data) {
+			return nil, false
+		}
+
+		if string(data[4:8]) == typ {
+			return data[8:size], true
+		}
+
+		data = data[size:]
+	}
+
+	return nil, false
+}
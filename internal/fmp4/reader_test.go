@@ -0,0 +1,77 @@
+package fmp4
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestReadInit(t *testing.T) {
+	expected := Track{Width: 1280, Height: 720, Timescale: 90000, SPS: fakeSPS, PPS: fakePPS}
+
+	var init bytes.Buffer
+	WriteInit(&init, expected)
+
+	track, err := ReadInit(init.Bytes())
+	if err != nil {
+		t.Fatal("ReadInit returned an error", err)
+	}
+	if !reflect.DeepEqual(track, expected) {
+		t.Error("ReadInit read incorrect track, got", track)
+	}
+}
+
+func TestReadInitInvalid(t *testing.T) {
+	if _, err := ReadInit([]byte("not an initialization segment")); err == nil {
+		t.Error("ReadInit did not return an error")
+	}
+}
+
+func TestReadFragment(t *testing.T) {
+	expected := Fragment{
+		Sequence:            3,
+		BaseMediaDecodeTime: 1 << 40,
+		Samples: []Sample{
+			{Duration: 3000, Keyframe: true, Data: SampleData([][]byte{{0x65, 0x88, 0x84}})},
+			{Duration: 3003, Keyframe: false, Data: SampleData([][]byte{{0x06, 0x05}, {0x41, 0x9A}})},
+		},
+	}
+
+	var segment bytes.Buffer
+	fragment := expected
+	fragment.Events = []Event{{Scheme: "https://aomedia.org/emsg/ID3", Timescale: 90000, Data: []byte("ID3")}}
+	WriteFragment(&segment, fragment)
+
+	frag, err := ReadFragment(segment.Bytes())
+	if err != nil {
+		t.Fatal("ReadFragment returned an error", err)
+	}
+	if !reflect.DeepEqual(frag, expected) {
+		t.Error("ReadFragment read incorrect fragment, got", frag)
+	}
+}
+
+func TestReadFragmentTruncated(t *testing.T) {
+	var segment bytes.Buffer
+	WriteFragment(&segment, Fragment{Sequence: 1, Samples: []Sample{{Duration: 3000, Data: []byte{0xAB, 0xCD}}}})
+
+	if _, err := ReadFragment(segment.Bytes()[:segment.Len()-1]); err == nil {
+		t.Error("ReadFragment did not return an error")
+	}
+}
+
+func TestNALUnits(t *testing.T) {
+	expected := [][]byte{{0x65, 0x88}, {0x06}}
+
+	nalus, err := NALUnits(SampleData(expected))
+	if err != nil {
+		t.Fatal("NALUnits returned an error", err)
+	}
+	if !reflect.DeepEqual(nalus, expected) {
+		t.Error("NALUnits returned incorrect NAL units, got", nalus)
+	}
+
+	if _, err := NALUnits([]byte{0x00, 0x00, 0x00, 0x05, 0x65}); err == nil {
+		t.Error("NALUnits did not return an error for truncated data")
+	}
+}
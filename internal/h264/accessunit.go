@@ -0,0 +1,98 @@
+package h264
+
+import (
+	"bufio"
+	"io"
+)
+
+// AccessUnit is the set of NAL units that make up a single picture.
+type AccessUnit struct {
+	NALUnits [][]byte
+}
+
+// Keyframe determines whether the access unit contains an IDR picture, which may be decoded on its own.
+func (au AccessUnit) Keyframe() bool {
+	for _, nalu := range au.NALUnits {
+		if Type(nalu) == TypeIDR {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AccessUnitReader groups the NAL units of an Annex-B byte stream into access units.
+type AccessUnitReader struct {
+	scanner *bufio.Scanner
+	pending [][]byte
+	picture bool // Whether the pending access unit contains a picture yet
+}
+
+// NewAccessUnitReader creates a reader that groups the NAL units of an Annex-B byte stream into access units.
+func NewAccessUnitReader(r io.Reader) *AccessUnitReader {
+	return &AccessUnitReader{scanner: NewScanner(r)}
+}
+
+// Read returns the next access unit in the stream.
+//
+// Returns io.EOF once the stream has ended and all of the access units have been read.
+func (rdr *AccessUnitReader) Read() (AccessUnit, error) {
+	for rdr.scanner.Scan() {
+		nalu := append([]byte{}, rdr.scanner.Bytes()...)
+
+		if rdr.picture && startsAccessUnit(nalu) {
+			au := AccessUnit{NALUnits: rdr.pending}
+			rdr.pending = [][]byte{nalu}
+			rdr.picture = isPicture(nalu)
+			return au, nil
+		}
+
+		rdr.pending = append(rdr.pending, nalu)
+		if isPicture(nalu) {
+			rdr.picture = true
+		}
+	}
+
+	if err := rdr.scanner.Err(); err != nil {
+		return AccessUnit{}, err
+	}
+
+	// Hand over whatever is left at the end of the stream
+	if rdr.picture {
+		au := AccessUnit{NALUnits: rdr.pending}
+		rdr.pending = nil
+		rdr.picture = false
+		return au, nil
+	}
+
+	return AccessUnit{}, io.EOF
+}
+
+// isPicture determines whether the NAL unit contains a slice of a picture.
+func isPicture(nalu []byte) bool {
+	nalType := Type(nalu)
+	return nalType == TypeNonIDR || nalType == TypeIDR
+}
+
+// startsAccessUnit determines whether the NAL unit marks the beginning of a new access unit when it follows a picture,
+// per ITU-T H.264 section 7.4.1.2.3.
+func startsAccessUnit(nalu []byte) bool {
+	switch nalType := Type(nalu); {
+	case nalType == TypeAUD || nalType == TypeSPS || nalType == TypePPS || nalType == TypeSEI:
+		return true
+	case nalType >= 14 && nalType <= 18:
+		return true
+	case nalType == TypeNonIDR || nalType == TypeIDR:
+		return isFirstSlice(nalu)
+	}
+
+	return false
+}
+
+// isFirstSlice determines whether the slice is the first in its picture.
+//
+// The slice header starts with the Exp-Golomb coded address of the first macroblock in the slice, and a zero address is
+// coded as a single set bit.
+func isFirstSlice(nalu []byte) bool {
+	return len(nalu) > 1 && nalu[1]&0x80 != 0
+}
@@ -0,0 +1,104 @@
+package h264
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+var (
+	fakeAUD         = []byte{0x09, 0xF0}
+	fakeSPS         = []byte{0x67, 0x64, 0x00, 0x28}
+	fakePPS         = []byte{0x68, 0xEE, 0x3C, 0x80}
+	fakeSEI         = []byte{0x06, 0x05, 0x01, 0x80}
+	fakeIDR         = []byte{0x65, 0x88, 0x84}
+	fakeNonIDR      = []byte{0x41, 0x9A, 0x02}
+	fakeSecondSlice = []byte{0x41, 0x40, 0x02}
+)
+
+// annexB builds an Annex-B byte stream out of the given NAL units.
+func annexB(nalus ...[]byte) []byte {
+	var stream []byte
+	for _, nalu := range nalus {
+		stream = append(stream, StartCode...)
+		stream = append(stream, nalu...)
+	}
+	return stream
+}
+
+func TestAccessUnitReader(t *testing.T) {
+	testCases := []struct {
+		name     string
+		stream   []byte
+		expected []AccessUnit
+	}{
+		{
+			"parameter sets with keyframe",
+			annexB(fakeSPS, fakePPS, fakeIDR, fakeNonIDR, fakeNonIDR),
+			[]AccessUnit{
+				{NALUnits: [][]byte{fakeSPS, fakePPS, fakeIDR}},
+				{NALUnits: [][]byte{fakeNonIDR}},
+				{NALUnits: [][]byte{fakeNonIDR}},
+			},
+		},
+		{
+			"access unit delimiters",
+			annexB(fakeAUD, fakeSEI, fakeIDR, fakeAUD, fakeNonIDR),
+			[]AccessUnit{
+				{NALUnits: [][]byte{fakeAUD, fakeSEI, fakeIDR}},
+				{NALUnits: [][]byte{fakeAUD, fakeNonIDR}},
+			},
+		},
+		{
+			"multiple slices",
+			annexB(fakeNonIDR, fakeSecondSlice, fakeNonIDR, fakeSecondSlice),
+			[]AccessUnit{
+				{NALUnits: [][]byte{fakeNonIDR, fakeSecondSlice}},
+				{NALUnits: [][]byte{fakeNonIDR, fakeSecondSlice}},
+			},
+		},
+		{
+			"parameter sets without picture",
+			annexB(fakeSPS, fakePPS),
+			nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			reader := NewAccessUnitReader(bytes.NewReader(tc.stream))
+
+			var units []AccessUnit
+			for {
+				au, err := reader.Read()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal("Read returned an error", err)
+				}
+				units = append(units, au)
+			}
+
+			if len(units) != len(tc.expected) {
+				t.Fatal("Read returned incorrect number of access units, got", len(units))
+			}
+
+			for i := range units {
+				if !bytes.Equal(bytes.Join(units[i].NALUnits, []byte{0xFF}), bytes.Join(tc.expected[i].NALUnits, []byte{0xFF})) {
+					t.Error("Read returned incorrect access unit", i, "got", units[i].NALUnits)
+				}
+			}
+		})
+	}
+}
+
+func TestAccessUnitKeyframe(t *testing.T) {
+	if !(AccessUnit{NALUnits: [][]byte{fakeSPS, fakePPS, fakeIDR}}).Keyframe() {
+		t.Error("Keyframe returned incorrect value for IDR picture")
+	}
+
+	if (AccessUnit{NALUnits: [][]byte{fakeAUD, fakeNonIDR}}).Keyframe() {
+		t.Error("Keyframe returned incorrect value for non-IDR picture")
+	}
+}
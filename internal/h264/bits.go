@@ -0,0 +1,90 @@
+package h264
+
+import (
+	"errors"
+)
+
+var errTruncated = errors.New("h264: truncated data")
+
+// rbsp removes the emulation prevention bytes from the NAL unit payload, giving the raw byte sequence payload.
+func rbsp(data []byte) []byte {
+	out := make([]byte, 0, len(data))
+	zeros := 0
+
+	for _, b := range data {
+		if zeros >= 2 && b == 0x03 {
+			zeros = 0
+			continue
+		}
+
+		if b == 0x00 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+
+		out = append(out, b)
+	}
+
+	return out
+}
+
+// bitReader reads the bit fields that make up H.264 syntax elements.
+//
+// Reading past the end of the data records an error and returns zeros rather than failing each read, so that parsers
+// may check for an error once at the end.
+type bitReader struct {
+	data []byte
+	pos  int // Position in bits
+	err  error
+}
+
+func newBitReader(data []byte) *bitReader {
+	return &bitReader{data: data}
+}
+
+// readBits reads an unsigned integer made up of n bits.
+func (br *bitReader) readBits(n int) uint64 {
+	var v uint64
+
+	for i := 0; i < n; i++ {
+		if br.pos >= len(br.data)*8 {
+			br.err = errTruncated
+			return 0
+		}
+
+		bit := br.data[br.pos/8] >> (7 - uint(br.pos%8)) & 1
+		v = v<<1 | uint64(bit)
+		br.pos++
+	}
+
+	return v
+}
+
+func (br *bitReader) readFlag() bool {
+	return br.readBits(1) == 1
+}
+
+// readUE reads an unsigned Exp-Golomb coded integer.
+func (br *bitReader) readUE() uint {
+	zeros := 0
+	for !br.readFlag() {
+		if br.err != nil || zeros > 31 {
+			br.err = errTruncated
+			return 0
+		}
+		zeros++
+	}
+
+	return uint(1<<uint(zeros) - 1 + br.readBits(zeros))
+}
+
+// readSE reads a signed Exp-Golomb coded integer.
+func (br *bitReader) readSE() int {
+	v := br.readUE()
+	if v%2 == 0 {
+		return -int(v / 2)
+	}
+
+	return int(v+1) / 2
+}
@@ -0,0 +1,85 @@
+package h264
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+// MaxNALUnitSize is the largest NAL unit that will be read into memory.
+const MaxNALUnitSize = 4 * 1024 * 1024
+
+// StartCode separates NAL units in an Annex-B byte stream.
+var StartCode = []byte{0x00, 0x00, 0x00, 0x01}
+
+var shortStartCode = []byte{0x00, 0x00, 0x01}
+
+// NALUnitType identifies the contents of a NAL unit.
+type NALUnitType byte
+
+// NAL unit types, per ITU-T H.264 Table 7-1.
+const (
+	TypeNonIDR NALUnitType = 1 // Coded slice of a non-IDR picture
+	TypeIDR    NALUnitType = 5 // Coded slice of an IDR picture, also known as a keyframe
+	TypeSEI    NALUnitType = 6 // Supplemental enhancement information
+	TypeSPS    NALUnitType = 7 // Sequence parameter set
+	TypePPS    NALUnitType = 8 // Picture parameter set
+	TypeAUD    NALUnitType = 9 // Access unit delimiter
+)
+
+// Type determines the type of the NAL unit.
+func Type(nalu []byte) NALUnitType {
+	if len(nalu) == 0 {
+		return 0
+	}
+
+	return NALUnitType(nalu[0] & 0x1F)
+}
+
+// NewScanner creates a scanner that splits an Annex-B byte stream into NAL units.
+//
+// The NAL units do not include their start codes.
+func NewScanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), MaxNALUnitSize)
+	scanner.Split(ScanNALUnits)
+
+	return scanner
+}
+
+// ScanNALUnits is a split function for a bufio.Scanner that returns each NAL unit of an Annex-B byte stream, without
+// its start code.
+func ScanNALUnits(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	start := bytes.Index(data, shortStartCode)
+	if start < 0 {
+		// Throw away anything that isn't part of a NAL unit, holding on to the end in case it's a partial start code
+		if atEOF {
+			return len(data), nil, nil
+		}
+		if len(data) < len(shortStartCode) {
+			return 0, nil, nil
+		}
+		return len(data) - len(shortStartCode) + 1, nil, nil
+	}
+
+	begin := start + len(shortStartCode)
+
+	end := bytes.Index(data[begin:], shortStartCode)
+	if end < 0 {
+		if !atEOF {
+			// Need more data to find the end of the NAL unit
+			return start, nil, nil
+		}
+		end = len(data)
+	} else {
+		end += begin
+	}
+
+	// Trailing zeros belong to the next start code rather than to the NAL unit
+	token = bytes.TrimRight(data[begin:end], "\x00")
+	if len(token) == 0 {
+		return end, nil, nil
+	}
+
+	return end, token, nil
+}
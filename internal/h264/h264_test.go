@@ -0,0 +1,99 @@
+package h264
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+func TestScanner(t *testing.T) {
+	testCases := []struct {
+		name     string
+		stream   []byte
+		expected [][]byte
+	}{
+		{
+			"four byte start codes",
+			[]byte{0, 0, 0, 1, 0x67, 0x64, 0x00, 0x28, 0, 0, 0, 1, 0x68, 0xEE, 0x3C, 0x80, 0, 0, 0, 1, 0x65, 0x88, 0x84},
+			[][]byte{{0x67, 0x64, 0x00, 0x28}, {0x68, 0xEE, 0x3C, 0x80}, {0x65, 0x88, 0x84}},
+		},
+		{
+			"three byte start codes",
+			[]byte{0, 0, 1, 0x67, 0x64, 0x00, 0x28, 0, 0, 1, 0x68, 0xEE, 0x3C, 0x80, 0, 0, 1, 0x41, 0x9A},
+			[][]byte{{0x67, 0x64, 0x00, 0x28}, {0x68, 0xEE, 0x3C, 0x80}, {0x41, 0x9A}},
+		},
+		{
+			"leading garbage",
+			[]byte{0xFF, 0xFE, 0, 0, 0, 1, 0x09, 0xF0},
+			[][]byte{{0x09, 0xF0}},
+		},
+		{
+			"empty NAL units",
+			[]byte{0, 0, 1, 0, 0, 1, 0x09, 0xF0, 0, 0, 0, 0, 1},
+			[][]byte{{0x09, 0xF0}},
+		},
+		{
+			"no start code",
+			[]byte{0x67, 0x64, 0x00, 0x28},
+			nil,
+		},
+		{
+			"empty",
+			[]byte{},
+			nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			readers := map[string]io.Reader{
+				"whole":    bytes.NewReader(tc.stream),
+				"one byte": iotest.OneByteReader(bytes.NewReader(tc.stream)),
+			}
+
+			for readerName, reader := range readers {
+				scanner := NewScanner(reader)
+
+				var nalus [][]byte
+				for scanner.Scan() {
+					nalus = append(nalus, append([]byte{}, scanner.Bytes()...))
+				}
+
+				if err := scanner.Err(); err != nil {
+					t.Error("Scanner returned an error for", readerName, "reader:", err)
+				}
+
+				if len(nalus) != len(tc.expected) {
+					t.Fatal("Scanner returned incorrect number of NAL units for", readerName, "reader, got", len(nalus))
+				}
+				for i := range nalus {
+					if !bytes.Equal(nalus[i], tc.expected[i]) {
+						t.Error("Scanner returned incorrect NAL unit for", readerName, "reader, got", nalus[i])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestType(t *testing.T) {
+	testCases := []struct {
+		nalu     []byte
+		expected NALUnitType
+	}{
+		{[]byte{0x67, 0x64}, TypeSPS},
+		{[]byte{0x68, 0xEE}, TypePPS},
+		{[]byte{0x65, 0x88}, TypeIDR},
+		{[]byte{0x41, 0x9A}, TypeNonIDR},
+		{[]byte{0x06, 0x05}, TypeSEI},
+		{[]byte{0x09, 0xF0}, TypeAUD},
+		{[]byte{}, 0},
+	}
+
+	for _, tc := range testCases {
+		if Type(tc.nalu) != tc.expected {
+			t.Error("Type returned incorrect value for", tc.nalu, "got", Type(tc.nalu))
+		}
+	}
+}
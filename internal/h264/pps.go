@@ -0,0 +1,35 @@
+package h264
+
+import (
+	"errors"
+)
+
+// PPS is a picture parameter set, describing how the pictures that reference it are coded.
+type PPS struct {
+	ID                  uint // Identifier referenced by slices
+	SPSID               uint // Identifier of the sequence parameter set it depends on
+	CABAC               bool // Whether pictures are entropy coded with CABAC rather than CAVLC
+	BottomFieldPicOrder bool // Whether slices carry picture order information for the bottom field
+}
+
+// ParsePPS parses the start of a picture parameter set NAL unit, per ITU-T H.264 section 7.3.2.2.
+func ParsePPS(nalu []byte) (PPS, error) {
+	if Type(nalu) != TypePPS {
+		return PPS{}, errors.New("h264: not a picture parameter set")
+	}
+
+	br := newBitReader(rbsp(nalu[1:]))
+
+	pps := PPS{
+		ID:                  br.readUE(),
+		SPSID:               br.readUE(),
+		CABAC:               br.readFlag(),
+		BottomFieldPicOrder: br.readFlag(),
+	}
+
+	if br.err != nil {
+		return PPS{}, errors.New("h264: truncated picture parameter set")
+	}
+
+	return pps, nil
+}
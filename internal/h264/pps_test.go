@@ -0,0 +1,33 @@
+package h264
+
+import (
+	"testing"
+)
+
+func TestParsePPS(t *testing.T) {
+	bw := &bitWriter{}
+	bw.writeBits(0x68, 8)
+	bw.writeUE(1)      // ID
+	bw.writeUE(0)      // SPS ID
+	bw.writeFlag(true) // CABAC
+	bw.writeFlag(false)
+
+	pps, err := ParsePPS(bw.data)
+	if err != nil {
+		t.Fatal("ParsePPS returned an error", err)
+	}
+
+	if pps != (PPS{ID: 1, SPSID: 0, CABAC: true}) {
+		t.Errorf("ParsePPS returned incorrect value, got %+v", pps)
+	}
+}
+
+func TestParsePPSInvalid(t *testing.T) {
+	if _, err := ParsePPS([]byte{0x67, 0x64}); err == nil || err.Error() != "h264: not a picture parameter set" {
+		t.Error("ParsePPS returned incorrect error for wrong NAL unit type, got", err)
+	}
+
+	if _, err := ParsePPS([]byte{0x68}); err == nil || err.Error() != "h264: truncated picture parameter set" {
+		t.Error("ParsePPS returned incorrect error for truncated NAL unit, got", err)
+	}
+}
@@ -0,0 +1,62 @@
+package h264
+
+import (
+	"errors"
+)
+
+// SEI payload types, per ITU-T H.264 Annex D.
+const (
+	SEIBufferingPeriod      = 0
+	SEIPictureTiming        = 1
+	SEIUserDataRegistered   = 4
+	SEIUserDataUnregistered = 5
+	SEIRecoveryPoint        = 6
+)
+
+// SEIMessage is a single supplemental enhancement information message.
+type SEIMessage struct {
+	Type    int
+	Payload []byte
+}
+
+// ParseSEI splits a supplemental enhancement information NAL unit into its messages, per ITU-T H.264 section 7.3.2.3.
+func ParseSEI(nalu []byte) ([]SEIMessage, error) {
+	if Type(nalu) != TypeSEI {
+		return nil, errors.New("h264: not supplemental enhancement information")
+	}
+
+	data := rbsp(nalu[1:])
+	var messages []SEIMessage
+
+	// Messages continue until the trailing stop bit
+	for len(data) > 0 && !(len(data) == 1 && data[0] == 0x80) {
+		payloadType, n := readSEIValue(data)
+		data = data[n:]
+
+		payloadSize, n := readSEIValue(data)
+		data = data[n:]
+
+		if n == 0 || payloadSize > len(data) {
+			return nil, errors.New("h264: truncated supplemental enhancement information")
+		}
+
+		messages = append(messages, SEIMessage{Type: payloadType, Payload: data[:payloadSize]})
+		data = data[payloadSize:]
+	}
+
+	return messages, nil
+}
+
+// readSEIValue reads a payload type or size, which is coded as a run of 0xFF bytes followed by the remainder.
+func readSEIValue(data []byte) (value int, n int) {
+	for n < len(data) {
+		b := data[n]
+		n++
+		value += int(b)
+		if b != 0xFF {
+			return value, n
+		}
+	}
+
+	return value, 0
+}
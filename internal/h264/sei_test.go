@@ -0,0 +1,41 @@
+package h264
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseSEI(t *testing.T) {
+	nalu := []byte{0x06}
+	nalu = append(nalu, SEIRecoveryPoint, 0x01, 0x80)
+	nalu = append(nalu, SEIUserDataUnregistered, 0xFF, 0x01)
+	nalu = append(nalu, bytes.Repeat([]byte{0xAB}, 256)...)
+	nalu = append(nalu, 0x80)
+
+	messages, err := ParseSEI(nalu)
+	if err != nil {
+		t.Fatal("ParseSEI returned an error", err)
+	}
+
+	if len(messages) != 2 {
+		t.Fatal("ParseSEI returned incorrect number of messages, got", len(messages))
+	}
+
+	if messages[0].Type != SEIRecoveryPoint || !bytes.Equal(messages[0].Payload, []byte{0x80}) {
+		t.Errorf("ParseSEI returned incorrect first message, got %+v", messages[0])
+	}
+
+	if messages[1].Type != SEIUserDataUnregistered || len(messages[1].Payload) != 256 {
+		t.Errorf("ParseSEI returned incorrect second message, got type %d size %d", messages[1].Type, len(messages[1].Payload))
+	}
+}
+
+func TestParseSEIInvalid(t *testing.T) {
+	if _, err := ParseSEI([]byte{0x67}); err == nil || err.Error() != "h264: not supplemental enhancement information" {
+		t.Error("ParseSEI returned incorrect error for wrong NAL unit type, got", err)
+	}
+
+	if _, err := ParseSEI([]byte{0x06, 0x05, 0x10, 0xAB}); err == nil {
+		t.Error("ParseSEI did not return an error for truncated NAL unit")
+	}
+}
@@ -0,0 +1,208 @@
+package h264
+
+import (
+	"errors"
+	"fmt"
+)
+
+// SPS is a sequence parameter set, describing the video as a whole.
+type SPS struct {
+	Profile        byte   // Profile indicator, like 66 for Baseline or 100 for High
+	Constraints    byte   // Constraint set flags
+	Level          byte   // Level indicator, ten times the level number
+	ID             uint   // Identifier referenced by picture parameter sets
+	ChromaFormat   uint   // Chroma format indicator, 1 for 4:2:0
+	Width          int    // Width of the video in pixels, after cropping
+	Height         int    // Height of the video in pixels, after cropping
+	CropLeft       int    // Pixels cropped from the left of the decoded picture
+	CropRight      int    // Pixels cropped from the right of the decoded picture
+	CropTop        int    // Pixels cropped from the top of the decoded picture
+	CropBottom     int    // Pixels cropped from the bottom of the decoded picture
+	NumUnitsInTick uint32 // Number of time units in a clock tick, from the VUI timing information
+	TimeScale      uint32 // Number of time units per second, from the VUI timing information
+	FixedFrameRate bool   // Whether the framerate is constant, from the VUI timing information
+}
+
+// Fps determines the framerate from the VUI timing information.
+//
+// Returns zero if the sequence parameter set doesn't include timing information.
+func (sps SPS) Fps() float64 {
+	if sps.NumUnitsInTick == 0 || sps.TimeScale == 0 {
+		return 0
+	}
+
+	// Every frame takes two clock ticks, one for each field
+	return float64(sps.TimeScale) / float64(2*sps.NumUnitsInTick)
+}
+
+// Codec describes the video in the RFC 6381 format used by the CODECS attribute of playlists and by MIME types.
+func (sps SPS) Codec() string {
+	return fmt.Sprintf("avc1.%02X%02X%02X", sps.Profile, sps.Constraints, sps.Level)
+}
+
+// ParseSPS parses a sequence parameter set NAL unit, per ITU-T H.264 section 7.3.2.1.
+func ParseSPS(nalu []byte) (SPS, error) {
+	if Type(nalu) != TypeSPS {
+		return SPS{}, errors.New("h264: not a sequence parameter set")
+	}
+
+	br := newBitReader(rbsp(nalu[1:]))
+	var sps SPS
+
+	sps.Profile = byte(br.readBits(8))
+	sps.Constraints = byte(br.readBits(8))
+	sps.Level = byte(br.readBits(8))
+	sps.ID = br.readUE()
+
+	sps.ChromaFormat = 1
+	separateColourPlane := false
+
+	switch sps.Profile {
+	case 100, 110, 122, 244, 44, 83, 86, 118, 128, 138, 139, 134, 135:
+		sps.ChromaFormat = br.readUE()
+		if sps.ChromaFormat == 3 {
+			separateColourPlane = br.readFlag()
+		}
+
+		br.readUE()   // Luma bit depth
+		br.readUE()   // Chroma bit depth
+		br.readFlag() // Transform bypass
+
+		if br.readFlag() {
+			lists := 8
+			if sps.ChromaFormat == 3 {
+				lists = 12
+			}
+
+			for i := 0; i < lists; i++ {
+				if !br.readFlag() {
+					continue
+				}
+
+				size := 16
+				if i >= 6 {
+					size = 64
+				}
+				skipScalingList(br, size)
+			}
+		}
+	}
+
+	br.readUE() // Maximum frame number
+
+	switch br.readUE() { // Picture order count type
+	case 0:
+		br.readUE() // Maximum picture order count
+	case 1:
+		br.readFlag() // Delta always zero
+		br.readSE()   // Offset for non-reference pictures
+		br.readSE()   // Offset for top to bottom field
+		cycle := br.readUE()
+		for i := uint(0); i < cycle && br.err == nil; i++ {
+			br.readSE()
+		}
+	}
+
+	br.readUE()   // Maximum reference frames
+	br.readFlag() // Gaps in frame number allowed
+
+	widthInMbs := int(br.readUE()) + 1
+	heightInMapUnits := int(br.readUE()) + 1
+
+	frameMbsOnly := br.readFlag()
+	if !frameMbsOnly {
+		br.readFlag() // Adaptive frame field
+	}
+
+	br.readFlag() // Direct 8x8 inference
+
+	var cropLeft, cropRight, cropTop, cropBottom int
+	if br.readFlag() {
+		cropLeft = int(br.readUE())
+		cropRight = int(br.readUE())
+		cropTop = int(br.readUE())
+		cropBottom = int(br.readUE())
+	}
+
+	if br.readFlag() {
+		parseVUI(br, &sps)
+	}
+
+	if br.err != nil {
+		return SPS{}, errors.New("h264: truncated sequence parameter set")
+	}
+
+	// Interlaced video codes pairs of fields, making map units two macroblocks tall
+	fieldFactor := 2
+	if frameMbsOnly {
+		fieldFactor = 1
+	}
+
+	// Cropping is in units of chroma samples
+	cropUnitX := 1
+	cropUnitY := fieldFactor
+	if !separateColourPlane && sps.ChromaFormat != 0 {
+		if sps.ChromaFormat == 1 || sps.ChromaFormat == 2 {
+			cropUnitX = 2
+		}
+		if sps.ChromaFormat == 1 {
+			cropUnitY *= 2
+		}
+	}
+
+	sps.CropLeft = cropLeft * cropUnitX
+	sps.CropRight = cropRight * cropUnitX
+	sps.CropTop = cropTop * cropUnitY
+	sps.CropBottom = cropBottom * cropUnitY
+	sps.Width = widthInMbs*16 - sps.CropLeft - sps.CropRight
+	sps.Height = fieldFactor*heightInMapUnits*16 - sps.CropTop - sps.CropBottom
+
+	return sps, nil
+}
+
+// parseVUI parses the video usability information up through the timing information, per ITU-T H.264 section E.1.1.
+func parseVUI(br *bitReader, sps *SPS) {
+	if br.readFlag() { // Aspect ratio
+		if br.readBits(8) == 255 {
+			br.readBits(16) // Sample aspect ratio width
+			br.readBits(16) // Sample aspect ratio height
+		}
+	}
+
+	if br.readFlag() { // Overscan
+		br.readFlag()
+	}
+
+	if br.readFlag() { // Video signal type
+		br.readBits(3) // Video format
+		br.readFlag()  // Full range
+		if br.readFlag() {
+			br.readBits(24) // Colour primaries, transfer characteristics, and matrix coefficients
+		}
+	}
+
+	if br.readFlag() { // Chroma location
+		br.readUE()
+		br.readUE()
+	}
+
+	if br.readFlag() { // Timing
+		sps.NumUnitsInTick = uint32(br.readBits(32))
+		sps.TimeScale = uint32(br.readBits(32))
+		sps.FixedFrameRate = br.readFlag()
+	}
+}
+
+func skipScalingList(br *bitReader, size int) {
+	last := 8
+	next := 8
+
+	for i := 0; i < size && br.err == nil; i++ {
+		if next != 0 {
+			next = (last + br.readSE() + 256) % 256
+		}
+		if next != 0 {
+			last = next
+		}
+	}
+}
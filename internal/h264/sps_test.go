@@ -0,0 +1,220 @@
+package h264
+
+import (
+	"testing"
+)
+
+// bitWriter builds H.264 syntax elements for tests.
+type bitWriter struct {
+	data []byte
+	bits int
+}
+
+func (bw *bitWriter) writeBits(v uint64, n int) {
+	for i := n - 1; i >= 0; i-- {
+		if bw.bits%8 == 0 {
+			bw.data = append(bw.data, 0)
+		}
+		bw.data[len(bw.data)-1] |= byte(v>>uint(i)&1) << (7 - uint(bw.bits%8))
+		bw.bits++
+	}
+}
+
+func (bw *bitWriter) writeFlag(f bool) {
+	if f {
+		bw.writeBits(1, 1)
+	} else {
+		bw.writeBits(0, 1)
+	}
+}
+
+func (bw *bitWriter) writeUE(v uint) {
+	n := 0
+	for (v+1)>>uint(n+1) != 0 {
+		n++
+	}
+	bw.writeBits(0, n)
+	bw.writeBits(uint64(v+1), n+1)
+}
+
+// fakeSPS1080p builds a High profile 1080p sequence parameter set, cropped from 1088 lines, running at 30fps.
+func fakeSPS1080p() []byte {
+	bw := &bitWriter{}
+	bw.writeBits(0x67, 8)
+	bw.writeBits(100, 8) // Profile
+	bw.writeBits(0, 8)   // Constraints
+	bw.writeBits(40, 8)  // Level
+	bw.writeUE(0)        // ID
+	bw.writeUE(1)        // Chroma format
+	bw.writeUE(0)        // Luma bit depth
+	bw.writeUE(0)        // Chroma bit depth
+	bw.writeFlag(false)  // Transform bypass
+	bw.writeFlag(false)  // Scaling matrix
+	bw.writeUE(0)        // Maximum frame number
+	bw.writeUE(2)        // Picture order count type
+	bw.writeUE(1)        // Maximum reference frames
+	bw.writeFlag(false)  // Gaps in frame number
+	bw.writeUE(119)      // Width in macroblocks
+	bw.writeUE(67)       // Height in map units
+	bw.writeFlag(true)   // Frame macroblocks only
+	bw.writeFlag(true)   // Direct 8x8 inference
+	bw.writeFlag(true)   // Cropping
+	bw.writeUE(0)
+	bw.writeUE(0)
+	bw.writeUE(0)
+	bw.writeUE(4)
+	bw.writeFlag(true)  // VUI
+	bw.writeFlag(false) // Aspect ratio
+	bw.writeFlag(false) // Overscan
+	bw.writeFlag(true)  // Video signal type
+	bw.writeBits(5, 3)
+	bw.writeFlag(true)
+	bw.writeFlag(true)
+	bw.writeBits(0x010101, 24)
+	bw.writeFlag(false) // Chroma location
+	bw.writeFlag(true)  // Timing
+	bw.writeBits(1000, 32)
+	bw.writeBits(60000, 32)
+	bw.writeFlag(true)
+	bw.writeFlag(true) // Stop bit
+	return emulationPrevention(bw.data)
+}
+
+// emulationPrevention escapes byte sequences in the NAL unit that would otherwise look like start codes.
+func emulationPrevention(data []byte) []byte {
+	var out []byte
+	zeros := 0
+
+	for _, b := range data {
+		if zeros >= 2 && b <= 0x03 {
+			out = append(out, 0x03)
+			zeros = 0
+		}
+
+		if b == 0x00 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+
+		out = append(out, b)
+	}
+
+	return out
+}
+
+func TestParseSPS(t *testing.T) {
+	testCases := []struct {
+		name     string
+		nalu     []byte
+		expected SPS
+		fps      float64
+		codec    string
+	}{
+		{
+			"x264 720p",
+			[]byte{
+				0x67, 0x64, 0x00, 0x1F, 0xAC, 0xD9, 0x40, 0x50, 0x05, 0xBB, 0x01, 0x10, 0x00, 0x00, 0x03, 0x00,
+				0x10, 0x00, 0x00, 0x03, 0x03, 0xC0, 0xF1, 0x83, 0x19, 0x60,
+			},
+			SPS{Profile: 100, Level: 31, ChromaFormat: 1, Width: 1280, Height: 720, NumUnitsInTick: 1, TimeScale: 60},
+			30,
+			"avc1.64001F",
+		},
+		{
+			"cropped 1080p",
+			fakeSPS1080p(),
+			SPS{
+				Profile:        100,
+				Level:          40,
+				ChromaFormat:   1,
+				Width:          1920,
+				Height:         1080,
+				CropBottom:     8,
+				NumUnitsInTick: 1000,
+				TimeScale:      60000,
+				FixedFrameRate: true,
+			},
+			30,
+			"avc1.640028",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sps, err := ParseSPS(tc.nalu)
+			if err != nil {
+				t.Fatal("ParseSPS returned an error", err)
+			}
+
+			if sps != tc.expected {
+				t.Errorf("ParseSPS returned incorrect value, got %+v", sps)
+			}
+
+			if sps.Fps() != tc.fps {
+				t.Error("Fps returned incorrect value, got", sps.Fps())
+			}
+
+			if sps.Codec() != tc.codec {
+				t.Error("Codec returned incorrect value, got", sps.Codec())
+			}
+		})
+	}
+}
+
+func TestParseSPSInvalid(t *testing.T) {
+	if _, err := ParseSPS([]byte{0x68, 0xEE}); err == nil || err.Error() != "h264: not a sequence parameter set" {
+		t.Error("ParseSPS returned incorrect error for wrong NAL unit type, got", err)
+	}
+
+	if _, err := ParseSPS([]byte{0x67, 0x64, 0x00}); err == nil || err.Error() != "h264: truncated sequence parameter set" {
+		t.Error("ParseSPS returned incorrect error for truncated NAL unit, got", err)
+	}
+}
+
+func TestSPSFpsWithoutTiming(t *testing.T) {
+	if fps := (SPS{}).Fps(); fps != 0 {
+		t.Error("Fps returned incorrect value without timing information, got", fps)
+	}
+}
+
+func TestReadExpGolomb(t *testing.T) {
+	bw := &bitWriter{}
+	for _, v := range []uint{0, 1, 2, 3, 7, 255} {
+		bw.writeUE(v)
+	}
+	bw.writeUE(3) // +2
+	bw.writeUE(4) // -2
+
+	br := newBitReader(bw.data)
+	for _, expected := range []uint{0, 1, 2, 3, 7, 255} {
+		if v := br.readUE(); v != expected {
+			t.Error("readUE returned incorrect value, got", v)
+		}
+	}
+
+	if v := br.readSE(); v != 2 {
+		t.Error("readSE returned incorrect value, got", v)
+	}
+	if v := br.readSE(); v != -2 {
+		t.Error("readSE returned incorrect value, got", v)
+	}
+
+	if br.err != nil {
+		t.Error("bitReader recorded an error", br.err)
+	}
+
+	br.readBits(16)
+	if br.err == nil {
+		t.Error("bitReader did not record an error reading past the end")
+	}
+}
+
+func TestRBSP(t *testing.T) {
+	data := rbsp([]byte{0x00, 0x00, 0x03, 0x01, 0x00, 0x00, 0x03, 0x00, 0x03})
+	expected := []byte{0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x03}
+
+	if string(data) != string(expected) {
+		t.Errorf("rbsp returned incorrect data, got % X", data)
+	}
+}
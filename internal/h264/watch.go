@@ -0,0 +1,69 @@
+package h264
+
+import (
+	"bytes"
+	"io"
+)
+
+// watcher passes an H.264 byte stream through untouched, looking for the first sequence parameter set along the way.
+type watcher struct {
+	io.ReadCloser
+	fn   func(SPS)
+	buf  []byte
+	done bool
+}
+
+// Watch passes the H.264 byte stream through untouched, calling fn with the first sequence parameter set that comes
+// through. Reads go straight through to the underlying reader once it has been found.
+func Watch(r io.ReadCloser, fn func(SPS)) io.ReadCloser {
+	return &watcher{ReadCloser: r, fn: fn}
+}
+
+func (w *watcher) Read(p []byte) (int, error) {
+	n, err := w.ReadCloser.Read(p)
+	if !w.done && n > 0 {
+		w.inspect(p[:n])
+	}
+
+	return n, err
+}
+
+func (w *watcher) inspect(data []byte) {
+	w.buf = append(w.buf, data...)
+
+	for {
+		start := bytes.Index(w.buf, shortStartCode)
+		if start < 0 {
+			// Hold on to the end in case it's a partial start code
+			if len(w.buf) > len(shortStartCode) {
+				w.buf = append([]byte{}, w.buf[len(w.buf)-len(shortStartCode)+1:]...)
+			}
+			return
+		}
+
+		begin := start + len(shortStartCode)
+		end := bytes.Index(w.buf[begin:], shortStartCode)
+		if end < 0 {
+			w.buf = w.buf[start:]
+
+			// Give up on streams that don't look like H.264
+			if len(w.buf) > MaxNALUnitSize {
+				w.done = true
+				w.buf = nil
+			}
+			return
+		}
+
+		nalu := bytes.TrimRight(w.buf[begin:begin+end], "\x00")
+		if Type(nalu) == TypeSPS {
+			if sps, err := ParseSPS(nalu); err == nil {
+				w.done = true
+				w.buf = nil
+				w.fn(sps)
+				return
+			}
+		}
+
+		w.buf = w.buf[begin+end:]
+	}
+}
@@ -0,0 +1,47 @@
+package h264
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"testing/iotest"
+)
+
+func TestWatch(t *testing.T) {
+	stream := annexB(fakeAUD, fakeSEI, fakeSPS1080p(), fakePPS, fakeIDR, fakeNonIDR)
+
+	var found []SPS
+	watched := Watch(ioutil.NopCloser(iotest.OneByteReader(bytes.NewReader(stream))), func(sps SPS) {
+		found = append(found, sps)
+	})
+
+	data, err := ioutil.ReadAll(watched)
+	if err != nil {
+		t.Fatal("Read returned an error", err)
+	}
+
+	if !bytes.Equal(data, stream) {
+		t.Error("Watch did not pass the stream through untouched")
+	}
+
+	if len(found) != 1 || found[0].Width != 1920 || found[0].Height != 1080 {
+		t.Error("Watch did not find the sequence parameter set, got", found)
+	}
+}
+
+func TestWatchNoSPS(t *testing.T) {
+	stream := annexB(fakeIDR, fakeNonIDR)
+
+	called := false
+	watched := Watch(ioutil.NopCloser(bytes.NewReader(stream)), func(sps SPS) {
+		called = true
+	})
+
+	if data, _ := ioutil.ReadAll(watched); !bytes.Equal(data, stream) {
+		t.Error("Watch did not pass the stream through untouched")
+	}
+
+	if called {
+		t.Error("Watch called function without a sequence parameter set")
+	}
+}
@@ -0,0 +1,368 @@
+package hls
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/jaredpetersen/raspilive/internal/chain"
+	"github.com/jaredpetersen/raspilive/internal/export"
+	"github.com/jaredpetersen/raspilive/internal/metadata"
+	"github.com/jaredpetersen/raspilive/internal/segment"
+	"github.com/jaredpetersen/raspilive/internal/session"
+)
+
+// Options represents ways that the native muxer may be configured to mux video to HLS.
+//
+// Defaults matching those of Ffmpeg are used if a value is not provided.
+type Options struct {
+	Fps          int    // Framerate of the output video
+	SegmentType  string // Format of the video segment
+	SegmentTime  int    // Segment length target duration in seconds
+	PlaylistSize int    // Maximum number of playlist entries
+	StorageSize  int    // Maximum number of unreferenced segments to keep in storage before removal
+	Window       int    // Duration of video to keep for viewers to rewind through in seconds, in place of PlaylistSize
+	Budget       int64  // Maximum size of all the segments in storage in bytes, dropping the oldest segments past it
+
+	ProgramDateTime bool // Tag the segments in the playlist with the wall clock time they were captured
+	TimestampNames  bool // Name the segments with the time they were captured instead of the sequence number
+
+	// EpochNumbers numbers the segments from the seconds since the Unix epoch so that they keep increasing across
+	// restarts, marking the first one as a discontinuity
+	EpochNumbers bool
+
+	// IFrames writes an I-frame playlist for scrubbing previews, listed alongside the media playlist in a master
+	// playlist that takes over the name of the media playlist. Only MPEG-TS segments are supported.
+	IFrames bool
+
+	// Metadata is timed metadata to carry in the segments as ID3 tags, presented along with the frames captured as it
+	// arrived
+	Metadata *metadata.Queue
+
+	// Subtitles renders the given fields of the metadata in a WebVTT subtitles playlist, listed alongside the media
+	// playlist in a master playlist that takes over the name of the media playlist
+	Subtitles []string
+
+	// Catalog takes note of the segments as they are written so that footage may be exported from them for as long as
+	// they are kept in storage
+	Catalog *export.Catalog
+
+	// Chain keeps a tamper-evident manifest of the segments as they are written, which must be to the chain's directory
+	Chain *chain.Chain
+
+	// Session keeps track of the session that the segments are written to, which must be to the session's directory,
+	// along with a playlist of every segment in it that is finished as video on demand once the stream ends. Segments
+	// are kept in storage for as long as the session goes on, unless Shortfall calls for cutting them back.
+	Session *session.Tracker
+
+	// Shortfall reports how many bytes need freeing up in storage, if any, cutting the segments kept back as far as the
+	// live edge to make room
+	Shortfall func() int64
+}
+
+var now = time.Now
+
+// Muxer represents the native HLS muxer.
+//
+// Video is segmented within raspilive itself rather than with Ffmpeg, saving a good deal of CPU and memory on the
+// smaller Raspberry Pi boards. The video stream must be H.264 in the Annex-B format, as produced by raspivid.
+type Muxer struct {
+	Directory string
+	Storage   segment.Storage // Where the video is kept in place of the directory, such as in memory
+	Options   Options
+	done      chan struct{}
+	err       error
+}
+
+// Mux begins muxing the video stream to the HLS format.
+func (muxer *Muxer) Mux(video io.ReadCloser) error {
+	segmentType := strings.ToLower(muxer.Options.SegmentType)
+	if segmentType != "" && segmentType != "mpegts" && segmentType != "fmp4" {
+		return errors.New("hls: invalid segment type")
+	}
+
+	if muxer.Options.IFrames && segmentType == "fmp4" {
+		return errors.New("hls: i-frame playlist requires mpegts segments")
+	}
+
+	if len(muxer.Options.Subtitles) > 0 && muxer.Options.Metadata == nil {
+		return errors.New("hls: subtitles require metadata")
+	}
+
+	if muxer.Storage == nil && muxer.Directory != "" {
+		if info, err := os.Stat(muxer.Directory); err != nil || !info.IsDir() {
+			return errors.New("hls: invalid directory")
+		}
+	}
+
+	muxer.done = make(chan struct{})
+
+	go func() {
+		defer close(muxer.done)
+		muxer.err = muxer.mux(video)
+	}()
+
+	return nil
+}
+
+// Wait blocks until the video stream is finished processing by Mux.
+func (muxer *Muxer) Wait() error {
+	if muxer.done == nil {
+		return errors.New("hls: not started")
+	}
+
+	<-muxer.done
+
+	return muxer.err
+}
+
+func (muxer *Muxer) String() string {
+	if muxer.done == nil {
+		return ""
+	}
+
+	if muxer.Storage != nil {
+		return "native hls muxer (memory)"
+	}
+
+	return fmt.Sprintf("native hls muxer (%s)", path.Join(muxer.Directory, "livestream.m3u8"))
+}
+
+func (muxer *Muxer) mux(video io.ReadCloser) error {
+	storage := muxer.Storage
+	if storage == nil {
+		storage = segment.Dir(muxer.Directory)
+	}
+
+	playlist := &Playlist{
+		Storage:         storage,
+		Name:            "livestream.m3u8",
+		ProgramDateTime: muxer.Options.ProgramDateTime,
+	}
+
+	manifests := []segment.Manifest{playlist}
+
+	// Players only find I-frame and subtitles playlists through a master playlist, so the media playlist steps aside
+	// for one
+	if muxer.Options.IFrames || len(muxer.Options.Subtitles) > 0 {
+		playlist.Name = "livestream-0.m3u8"
+		master := &masterManifest{
+			master: MasterPlaylist{Storage: storage, Name: "livestream.m3u8"},
+			media:  playlist.Name,
+		}
+
+		if muxer.Options.IFrames {
+			iframes := &IFramePlaylist{Storage: storage, Name: "livestream-iframes.m3u8"}
+			master.iframe = iframes.Name
+			manifests = append(manifests, iframes)
+		}
+
+		if len(muxer.Options.Subtitles) > 0 {
+			subtitles := &Subtitles{
+				Storage:         storage,
+				Name:            "livestream-subtitles.m3u8",
+				Pattern:         "subtitles-%d.vtt",
+				Fields:          muxer.Options.Subtitles,
+				ProgramDateTime: muxer.Options.ProgramDateTime,
+			}
+			master.master.Subtitles = subtitles.Name
+			manifests = append(manifests, subtitles)
+		}
+
+		manifests = append(manifests, master)
+	}
+
+	if muxer.Options.Chain != nil {
+		manifests = append(manifests, muxer.Options.Chain)
+	}
+
+	if muxer.Options.Catalog != nil {
+		manifests = append(manifests, muxer.Options.Catalog)
+	}
+
+	timestamped := muxer.Options.TimestampNames
+
+	var format segment.Format
+	if strings.ToLower(muxer.Options.SegmentType) == "fmp4" {
+		playlist.Map = "init.mp4"
+		format = &segment.FMP4{
+			Storage:     storage,
+			InitName:    "init.mp4",
+			Pattern:     segmentPattern(timestamped, "raspilive-%d.m4s"),
+			Timestamped: timestamped,
+		}
+		if muxer.Options.Catalog != nil {
+			muxer.Options.Catalog.SetInit("init.mp4")
+		}
+		if muxer.Options.Chain != nil {
+			muxer.Options.Chain.SetInit("init.mp4")
+		}
+	} else {
+		format = &segment.TS{
+			Pattern:     segmentPattern(timestamped, "raspilive-%03d.ts"),
+			Timestamped: timestamped,
+			Metadata:    muxer.Options.Metadata != nil,
+		}
+	}
+
+	// List every segment of the session for playing it back once it ends
+	if muxer.Options.Session != nil {
+		manifests = append(manifests, &VODPlaylist{
+			Playlist: Playlist{
+				Storage:         storage,
+				Name:            session.PlaylistName,
+				Map:             playlist.Map,
+				ProgramDateTime: muxer.Options.ProgramDateTime,
+			},
+		}, muxer.Options.Session)
+	}
+
+	options := segment.Options{
+		Fps:          muxer.Options.Fps,
+		SegmentTime:  muxer.Options.SegmentTime,
+		PlaylistSize: muxer.Options.PlaylistSize,
+		StorageSize:  muxer.Options.StorageSize,
+		Window:       muxer.Options.Window,
+		Budget:       muxer.Options.Budget,
+		Metadata:     muxer.Options.Metadata,
+		Shortfall:    muxer.Options.Shortfall,
+	}
+	if muxer.Options.EpochNumbers {
+		options.StartNumber = int(now().Unix())
+		options.Discontinuity = true
+
+		// Carry on from the playlist of the run before, as Ffmpeg does, so that its segments slide out and are removed
+		// in their turn rather than being left behind for good
+		if muxer.Storage == nil && muxer.Options.Session == nil {
+			options.Previous, playlist.carried = muxer.previous(playlist.Name)
+			if len(options.Previous) > 0 {
+				options.StartNumber = options.Previous[len(options.Previous)-1].Number + 1
+			}
+		}
+	}
+
+	// The whole session is played back from storage, so nothing is removed from it until the session itself is, short
+	// of running out of space
+	if muxer.Options.Session != nil {
+		options.StorageSize = 0
+		options.Budget = 0
+	}
+
+	seg := segment.New(storage, format, options, manifests...)
+
+	return seg.Feed(video)
+}
+
+// previous reads the segments that an earlier run left listed in the playlist in the directory, along with the number of
+// discontinuities that had slid out of it. Segments that are gone are left out, marking the one after a gap as a
+// discontinuity. The segments that had already slid out of the playlist are removed, as nothing keeps track of them
+// any longer.
+func (muxer *Muxer) previous(name string) ([]segment.Segment, int) {
+	data, err := ioutil.ReadFile(path.Join(muxer.Directory, name))
+	if err != nil {
+		return nil, 0
+	}
+
+	listed, carried := parsePlaylist(data)
+	muxer.removeUnlisted(listed)
+
+	var segments []segment.Segment
+	gap := false
+	for _, seg := range listed {
+		info, err := os.Stat(path.Join(muxer.Directory, seg.Name))
+		if err != nil {
+			if len(segments) == 0 && seg.Discontinuity {
+				carried++
+			}
+			gap = true
+			continue
+		}
+
+		seg.Size = info.Size()
+		seg.Discontinuity = seg.Discontinuity || (gap && len(segments) > 0)
+		segments = append(segments, seg)
+		gap = false
+	}
+
+	return segments, carried
+}
+
+// removeUnlisted removes the segments in the directory that are not listed.
+func (muxer *Muxer) removeUnlisted(listed []segment.Segment) {
+	names := make(map[string]bool)
+	for _, seg := range listed {
+		names[seg.Name] = true
+	}
+
+	entries, err := ioutil.ReadDir(muxer.Directory)
+	if err != nil {
+		return
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		ext := path.Ext(name)
+		if entry.IsDir() || names[name] || !strings.HasPrefix(name, "raspilive-") || (ext != ".ts" && ext != ".m4s") {
+			continue
+		}
+
+		os.Remove(path.Join(muxer.Directory, name))
+	}
+}
+
+// segmentPattern picks the pattern for the segment file names, swapping the sequence number for the capture time when
+// timestamped.
+func segmentPattern(timestamped bool, numbered string) string {
+	if timestamped {
+		return "raspilive-%s" + path.Ext(numbered)
+	}
+
+	return numbered
+}
+
+// masterManifest keeps a master playlist listing the media playlist, along with any I-frame and subtitles playlists,
+// up to date with the peak bitrate of the segments, which is only known once they have been written.
+type masterManifest struct {
+	master    MasterPlaylist
+	media     string // URI of the media playlist
+	iframe    string // URI of the I-frame playlist, if there is one
+	bandwidth int    // Peak bitrate listed so far in bits per second
+}
+
+func (mm *masterManifest) Update(segments []segment.Segment, ended bool) error {
+	if len(segments) == 0 {
+		return nil
+	}
+
+	bandwidth, iframeBandwidth := mm.bandwidth, 0
+	for _, seg := range segments {
+		if seg.Duration > 0 {
+			bandwidth = int(math.Max(float64(bandwidth), float64(seg.Size*8)/seg.Seconds()))
+			iframeBandwidth = int(math.Max(float64(iframeBandwidth), float64(seg.Keyframe*8)/seg.Seconds()))
+		}
+	}
+
+	// The playlist only needs rewriting when the peak goes up, as players only care about the highest bitrate
+	if bandwidth <= mm.bandwidth {
+		return nil
+	}
+	mm.bandwidth = bandwidth
+
+	last := segments[len(segments)-1]
+	mm.master.Variants = []Variant{
+		{URI: mm.media, Bandwidth: bandwidth, Width: last.Width, Height: last.Height, Codec: last.Codec},
+	}
+	if mm.iframe != "" {
+		mm.master.IFrames = []Variant{
+			{URI: mm.iframe, Bandwidth: iframeBandwidth, Width: last.Width, Height: last.Height, Codec: last.Codec},
+		}
+	}
+
+	return mm.master.Write()
+}
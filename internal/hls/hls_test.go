@@ -0,0 +1,951 @@
+package hls
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jaredpetersen/raspilive/internal/chain"
+	"github.com/jaredpetersen/raspilive/internal/export"
+	"github.com/jaredpetersen/raspilive/internal/h264"
+	"github.com/jaredpetersen/raspilive/internal/memfs"
+	"github.com/jaredpetersen/raspilive/internal/metadata"
+	"github.com/jaredpetersen/raspilive/internal/mpegts"
+	"github.com/jaredpetersen/raspilive/internal/segment"
+	"github.com/jaredpetersen/raspilive/internal/session"
+)
+
+var (
+	fakeSPS = []byte{
+		0x67, 0x64, 0x00, 0x1F, 0xAC, 0xD9, 0x40, 0x50, 0x05, 0xBB, 0x01, 0x10, 0x00, 0x00, 0x03, 0x00,
+		0x10, 0x00, 0x00, 0x03, 0x03, 0xC0, 0xF1, 0x83, 0x19, 0x60,
+	}
+	fakePPS    = []byte{0x68, 0xEE, 0x3C, 0x80}
+	fakeIDR    = []byte{0x65, 0x88, 0x84}
+	fakeNonIDR = []byte{0x41, 0x9A, 0x02}
+)
+
+// fakeVideo builds an Annex-B stream with a keyframe every keyframeInterval frames and parameter sets only at the
+// start, as raspivid does by default.
+func fakeVideo(frames int, keyframeInterval int) []byte {
+	var stream []byte
+	for _, nalu := range [][]byte{fakeSPS, fakePPS} {
+		stream = append(stream, h264.StartCode...)
+		stream = append(stream, nalu...)
+	}
+
+	for i := 0; i < frames; i++ {
+		nalu := fakeNonIDR
+		if i%keyframeInterval == 0 {
+			nalu = fakeIDR
+		}
+		stream = append(stream, h264.StartCode...)
+		stream = append(stream, nalu...)
+	}
+
+	return stream
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "raspilive-hls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	return dir
+}
+
+func mux(t *testing.T, muxer *Muxer, video []byte) {
+	if err := muxer.Mux(ioutil.NopCloser(bytes.NewReader(video))); err != nil {
+		t.Fatal("Mux returned an error", err)
+	}
+	if err := muxer.Wait(); err != nil {
+		t.Fatal("Wait returned an error", err)
+	}
+}
+
+func readPlaylist(t *testing.T, dir string) string {
+	playlist, err := ioutil.ReadFile(path.Join(dir, "livestream.m3u8"))
+	if err != nil {
+		t.Fatal("Failed to read playlist", err)
+	}
+	return string(playlist)
+}
+
+func TestMux(t *testing.T) {
+	dir := tempDir(t)
+
+	muxer := Muxer{
+		Directory: dir,
+		Options: Options{
+			Fps:         30,
+			SegmentTime: 1,
+		},
+	}
+
+	// Keyframes every half second, so segments should only be cut on every other keyframe
+	mux(t, &muxer, fakeVideo(90, 15))
+
+	expected := "#EXTM3U\n" +
+		"#EXT-X-VERSION:3\n" +
+		"#EXT-X-TARGETDURATION:1\n" +
+		"#EXT-X-MEDIA-SEQUENCE:0\n" +
+		"#EXTINF:1.000000,\nraspilive-000.ts\n" +
+		"#EXTINF:1.000000,\nraspilive-001.ts\n" +
+		"#EXTINF:1.000000,\nraspilive-002.ts\n" +
+		"#EXT-X-ENDLIST\n"
+	if playlist := readPlaylist(t, dir); playlist != expected {
+		t.Error("Mux wrote incorrect playlist, got\n", playlist)
+	}
+}
+
+// playlistHistory keeps every version of the media playlist written to the store.
+type playlistHistory struct {
+	*memfs.Store
+	versions []string
+}
+
+func (history *playlistHistory) Create(name string) (io.WriteCloser, error) {
+	if name != "livestream.m3u8" {
+		return history.Store.Create(name)
+	}
+
+	return &playlistVersion{history: history}, nil
+}
+
+type playlistVersion struct {
+	bytes.Buffer
+	history *playlistHistory
+}
+
+func (version *playlistVersion) Close() error {
+	version.history.versions = append(version.history.versions, version.String())
+	version.history.Store.WriteFile("livestream.m3u8", version.Bytes())
+	return nil
+}
+
+func TestMuxWindow(t *testing.T) {
+	history := &playlistHistory{Store: &memfs.Store{}}
+
+	muxer := Muxer{
+		Storage: history,
+		Options: Options{
+			Fps:         30,
+			SegmentTime: 1,
+			Window:      3,
+		},
+	}
+
+	mux(t, &muxer, fakeVideo(150, 30))
+
+	// Segments drop off the window as it slides, which an event playlist may never do
+	for _, version := range history.versions {
+		if strings.Contains(version, "#EXT-X-PLAYLIST-TYPE") {
+			t.Fatal("Mux marked the sliding playlist with a playlist type, got\n", version)
+		}
+	}
+
+	expected := "#EXTM3U\n" +
+		"#EXT-X-VERSION:3\n" +
+		"#EXT-X-TARGETDURATION:1\n" +
+		"#EXT-X-MEDIA-SEQUENCE:2\n" +
+		"#EXTINF:1.000000,\nraspilive-002.ts\n" +
+		"#EXTINF:1.000000,\nraspilive-003.ts\n" +
+		"#EXTINF:1.000000,\nraspilive-004.ts\n" +
+		"#EXT-X-ENDLIST\n"
+	if playlist := history.versions[len(history.versions)-1]; playlist != expected {
+		t.Error("Mux wrote incorrect playlist, got\n", playlist)
+	}
+}
+
+func TestMuxProgramDateTime(t *testing.T) {
+	dir := tempDir(t)
+
+	muxer := Muxer{
+		Directory: dir,
+		Options: Options{
+			Fps:             30,
+			SegmentTime:     1,
+			ProgramDateTime: true,
+			TimestampNames:  true,
+		},
+	}
+
+	mux(t, &muxer, fakeVideo(90, 30))
+
+	lines := strings.Split(readPlaylist(t, dir), "\n")[4:13]
+
+	var previous time.Time
+	for i := 0; i < len(lines); i += 3 {
+		tag, name := lines[i], lines[i+2]
+
+		if !strings.HasPrefix(tag, "#EXT-X-PROGRAM-DATE-TIME:") {
+			t.Fatal("Mux wrote playlist without program date time, got", tag)
+		}
+		captured, err := time.Parse(time.RFC3339Nano, strings.TrimPrefix(tag, "#EXT-X-PROGRAM-DATE-TIME:"))
+		if err != nil {
+			t.Fatal("Mux wrote invalid program date time", err)
+		}
+		if i > 0 && captured.Sub(previous) != time.Second {
+			t.Error("Mux wrote program date times that do not follow the segment durations, got", lines)
+		}
+		previous = captured
+
+		if expected := "raspilive-" + captured.Format(segment.TimeLayout) + ".ts"; name != expected {
+			t.Error("Mux named segment incorrectly, got", name)
+		}
+		if _, err := os.Stat(path.Join(dir, name)); err != nil {
+			t.Error("Mux did not write segment", name)
+		}
+	}
+}
+
+func TestMuxEpochNumbers(t *testing.T) {
+	now = func() time.Time { return time.Unix(1615982400, 0) }
+	defer func() { now = time.Now }()
+
+	dir := tempDir(t)
+
+	muxer := Muxer{
+		Directory: dir,
+		Options: Options{
+			Fps:          30,
+			SegmentTime:  1,
+			PlaylistSize: 2,
+			EpochNumbers: true,
+		},
+	}
+
+	mux(t, &muxer, fakeVideo(60, 30))
+
+	expected := "#EXTM3U\n" +
+		"#EXT-X-VERSION:3\n" +
+		"#EXT-X-TARGETDURATION:1\n" +
+		"#EXT-X-MEDIA-SEQUENCE:1615982400\n" +
+		"#EXT-X-DISCONTINUITY\n" +
+		"#EXTINF:1.000000,\nraspilive-1615982400.ts\n" +
+		"#EXTINF:1.000000,\nraspilive-1615982401.ts\n" +
+		"#EXT-X-ENDLIST\n"
+	if playlist := readPlaylist(t, dir); playlist != expected {
+		t.Error("Mux wrote incorrect playlist, got\n", playlist)
+	}
+
+	// Players still need to know about the discontinuity once it slides out of the playlist
+	dir = tempDir(t)
+	muxer.Directory = dir
+	mux(t, &muxer, fakeVideo(90, 30))
+
+	expected = "#EXTM3U\n" +
+		"#EXT-X-VERSION:3\n" +
+		"#EXT-X-TARGETDURATION:1\n" +
+		"#EXT-X-MEDIA-SEQUENCE:1615982401\n" +
+		"#EXT-X-DISCONTINUITY-SEQUENCE:1\n" +
+		"#EXTINF:1.000000,\nraspilive-1615982401.ts\n" +
+		"#EXTINF:1.000000,\nraspilive-1615982402.ts\n" +
+		"#EXT-X-ENDLIST\n"
+	if playlist := readPlaylist(t, dir); playlist != expected {
+		t.Error("Mux wrote incorrect playlist, got\n", playlist)
+	}
+}
+
+func TestMuxEpochNumbersCarriesOn(t *testing.T) {
+	now = func() time.Time { return time.Unix(1615982400, 0) }
+	defer func() { now = time.Now }()
+
+	dir := tempDir(t)
+
+	muxer := Muxer{
+		Directory: dir,
+		Options: Options{
+			Fps:          30,
+			SegmentTime:  1,
+			PlaylistSize: 3,
+			StorageSize:  1,
+			EpochNumbers: true,
+		},
+	}
+
+	mux(t, &muxer, fakeVideo(60, 30))
+
+	// The next run appends to the playlist of the one before, carrying on with its numbers
+	now = func() time.Time { return time.Unix(1615982500, 0) }
+	muxer = Muxer{Directory: dir, Options: muxer.Options}
+	mux(t, &muxer, fakeVideo(60, 30))
+
+	expected := "#EXTM3U\n" +
+		"#EXT-X-VERSION:3\n" +
+		"#EXT-X-TARGETDURATION:1\n" +
+		"#EXT-X-MEDIA-SEQUENCE:1615982401\n" +
+		"#EXT-X-DISCONTINUITY-SEQUENCE:1\n" +
+		"#EXTINF:1.000000,\nraspilive-1615982401.ts\n" +
+		"#EXT-X-DISCONTINUITY\n" +
+		"#EXTINF:1.000000,\nraspilive-1615982402.ts\n" +
+		"#EXTINF:1.000000,\nraspilive-1615982403.ts\n" +
+		"#EXT-X-ENDLIST\n"
+	if playlist := readPlaylist(t, dir); playlist != expected {
+		t.Error("Mux wrote incorrect playlist, got\n", playlist)
+	}
+
+	// The segments of the run before are kept in storage once they slide out, the same as any other
+	if _, err := os.Stat(path.Join(dir, "raspilive-1615982400.ts")); err != nil {
+		t.Error("Mux removed the segment kept for clients still downloading it", err)
+	}
+
+	// Segments that had slid out by the time the run before stopped are no longer kept track of, so they are removed
+	now = func() time.Time { return time.Unix(1615982600, 0) }
+	muxer = Muxer{Directory: dir, Options: muxer.Options}
+	mux(t, &muxer, fakeVideo(60, 30))
+
+	for _, name := range []string{"raspilive-1615982400.ts", "raspilive-1615982401.ts"} {
+		if _, err := os.Stat(path.Join(dir, name)); !os.IsNotExist(err) {
+			t.Error("Mux left behind the segment of an earlier run", name)
+		}
+	}
+}
+
+func TestMuxIFrames(t *testing.T) {
+	dir := tempDir(t)
+
+	muxer := Muxer{
+		Directory: dir,
+		Options: Options{
+			Fps:         30,
+			SegmentTime: 1,
+			IFrames:     true,
+		},
+	}
+
+	mux(t, &muxer, fakeVideo(60, 30))
+
+	// The keyframe fits in a single packet after the program tables
+	expected := "#EXTM3U\n" +
+		"#EXT-X-VERSION:4\n" +
+		"#EXT-X-TARGETDURATION:1\n" +
+		"#EXT-X-MEDIA-SEQUENCE:0\n" +
+		"#EXT-X-I-FRAMES-ONLY\n" +
+		"#EXTINF:1.000000,\n#EXT-X-BYTERANGE:564@0\nraspilive-000.ts\n" +
+		"#EXTINF:1.000000,\n#EXT-X-BYTERANGE:564@0\nraspilive-001.ts\n" +
+		"#EXT-X-ENDLIST\n"
+	if playlist, _ := ioutil.ReadFile(path.Join(dir, "livestream-iframes.m3u8")); string(playlist) != expected {
+		t.Error("Mux wrote incorrect I-frame playlist, got\n", string(playlist))
+	}
+
+	master := readPlaylist(t, dir)
+	for _, e := range []string{"livestream-0.m3u8\n", `,URI="livestream-iframes.m3u8"`} {
+		if !strings.Contains(master, e) {
+			t.Errorf("Mux wrote master playlist without %s, got\n%s", e, master)
+		}
+	}
+
+	if _, err := os.Stat(path.Join(dir, "livestream-0.m3u8")); err != nil {
+		t.Error("Mux did not write media playlist")
+	}
+}
+
+func TestMuxIFramesFmp4(t *testing.T) {
+	muxer := Muxer{Directory: tempDir(t), Options: Options{SegmentType: "fmp4", IFrames: true}}
+
+	if err := muxer.Mux(ioutil.NopCloser(bytes.NewReader(nil))); err == nil {
+		t.Error("Mux did not return an error for an I-frame playlist of fragmented MP4 segments")
+	}
+}
+
+func TestMuxMetadata(t *testing.T) {
+	dir := tempDir(t)
+
+	queue := &metadata.Queue{}
+	queue.Push([]byte(`{"temperature":21.5,"door":"closed"}`))
+
+	muxer := Muxer{
+		Directory: dir,
+		Options: Options{
+			Fps:         30,
+			SegmentTime: 1,
+			Metadata:    queue,
+			Subtitles:   []string{"temperature"},
+		},
+	}
+
+	mux(t, &muxer, fakeVideo(60, 30))
+
+	if ts, _ := ioutil.ReadFile(path.Join(dir, "raspilive-000.ts")); !bytes.Contains(ts, []byte(`{"temperature":21.5,"door":"closed"}`)) {
+		t.Error("Mux did not carry metadata in the segment")
+	}
+
+	// The value carries over into the next segment
+	for i := 0; i < 2; i++ {
+		expected := fmt.Sprintf("WEBVTT\n"+
+			"X-TIMESTAMP-MAP=MPEGTS:%d,LOCAL:00:00:00.000\n"+
+			"\n"+
+			"00:00:00.000 --> 00:00:01.000\n"+
+			"temperature: 21.5\n", 126000+i*90000)
+		if vtt, _ := ioutil.ReadFile(path.Join(dir, fmt.Sprintf("subtitles-%d.vtt", i))); string(vtt) != expected {
+			t.Errorf("Mux wrote incorrect subtitles segment %d, got\n%s", i, vtt)
+		}
+	}
+
+	if playlist, _ := ioutil.ReadFile(path.Join(dir, "livestream-subtitles.m3u8")); !strings.Contains(string(playlist), "subtitles-1.vtt\n") {
+		t.Error("Mux wrote incorrect subtitles playlist, got\n", string(playlist))
+	}
+
+	master := readPlaylist(t, dir)
+	for _, e := range []string{`URI="livestream-subtitles.m3u8"`, `SUBTITLES="subtitles"`, "livestream-0.m3u8\n"} {
+		if !strings.Contains(master, e) {
+			t.Errorf("Mux wrote master playlist without %s, got\n%s", e, master)
+		}
+	}
+}
+
+func TestMuxSubtitlesWithoutMetadata(t *testing.T) {
+	muxer := Muxer{Directory: tempDir(t), Options: Options{Subtitles: []string{"temperature"}}}
+
+	if err := muxer.Mux(ioutil.NopCloser(bytes.NewReader(nil))); err == nil {
+		t.Error("Mux did not return an error for subtitles without metadata")
+	}
+}
+
+func TestMuxMemory(t *testing.T) {
+	dir := tempDir(t)
+	store := &memfs.Store{}
+
+	muxer := Muxer{
+		Directory: dir,
+		Storage:   store,
+		Options: Options{
+			Fps:          30,
+			SegmentTime:  1,
+			PlaylistSize: 2,
+			StorageSize:  1,
+		},
+	}
+
+	mux(t, &muxer, fakeVideo(150, 30))
+
+	playlist, err := store.ReadFile("livestream.m3u8")
+	if err != nil {
+		t.Fatal("Mux did not write playlist to storage", err)
+	}
+	if !strings.Contains(string(playlist), "raspilive-004.ts") {
+		t.Error("Mux wrote incorrect playlist, got\n", string(playlist))
+	}
+
+	for number, expected := range []bool{false, false, true, true, true} {
+		_, err := store.ReadFile(fmt.Sprintf("raspilive-%03d.ts", number))
+		if exists := err == nil; exists != expected {
+			t.Errorf("Mux left incorrect segments in storage, raspilive-%03d.ts exists: %t", number, exists)
+		}
+	}
+
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Error("Mux wrote files to disk, got", len(files), "files")
+	}
+}
+
+func TestMuxSegments(t *testing.T) {
+	dir := tempDir(t)
+
+	muxer := Muxer{
+		Directory: dir,
+		Options: Options{
+			Fps:         30,
+			SegmentTime: 1,
+		},
+	}
+
+	mux(t, &muxer, fakeVideo(60, 30))
+
+	for i, name := range []string{"raspilive-000.ts", "raspilive-001.ts"} {
+		stream, err := ioutil.ReadFile(path.Join(dir, name))
+		if err != nil {
+			t.Fatal("Failed to read segment", err)
+		}
+		if len(stream) == 0 || len(stream)%mpegts.PacketSize != 0 {
+			t.Fatal("Mux wrote segment with incorrect size, got", len(stream))
+		}
+
+		// Each segment stands on its own, with the tables up front and parameter sets before the first keyframe
+		if pid := uint16(stream[1]&0x1F)<<8 | uint16(stream[2]); pid != mpegts.PATPID {
+			t.Error("Mux did not start segment with PAT, got PID", pid)
+		}
+
+		var video []byte
+		for j := 0; j < len(stream); j += mpegts.PacketSize {
+			pkt := stream[j : j+mpegts.PacketSize]
+			if uint16(pkt[1]&0x1F)<<8|uint16(pkt[2]) != mpegts.VideoPID {
+				continue
+			}
+			if pkt[3]&0x20 != 0 {
+				pkt = pkt[5+int(pkt[4]):]
+			} else {
+				pkt = pkt[4:]
+			}
+			video = append(video, pkt...)
+		}
+
+		expectedStart := []byte{0x00, 0x00, 0x00, 0x01, 0x09, 0xF0, 0x00, 0x00, 0x00, 0x01, 0x67}
+		if start := video[14 : 14+len(expectedStart)]; !bytes.Equal(start, expectedStart) {
+			t.Errorf("Mux wrote incorrect start of segment %d, got % X", i, start)
+		}
+	}
+}
+
+func TestMuxPlaylistSize(t *testing.T) {
+	dir := tempDir(t)
+
+	muxer := Muxer{
+		Directory: dir,
+		Options: Options{
+			Fps:          30,
+			SegmentTime:  1,
+			PlaylistSize: 2,
+			StorageSize:  1,
+		},
+	}
+
+	mux(t, &muxer, fakeVideo(150, 30))
+
+	playlist := readPlaylist(t, dir)
+	if !strings.Contains(playlist, "#EXT-X-MEDIA-SEQUENCE:3\n") {
+		t.Error("Mux wrote incorrect media sequence, got\n", playlist)
+	}
+	if !strings.Contains(playlist, "raspilive-003.ts") || !strings.Contains(playlist, "raspilive-004.ts") ||
+		strings.Contains(playlist, "raspilive-002.ts") {
+		t.Error("Mux wrote incorrect playlist entries, got\n", playlist)
+	}
+
+	// One unreferenced segment is kept on disk, the rest are removed
+	for name, expected := range map[string]bool{
+		"raspilive-000.ts": false,
+		"raspilive-001.ts": false,
+		"raspilive-002.ts": true,
+		"raspilive-003.ts": true,
+		"raspilive-004.ts": true,
+	} {
+		_, err := os.Stat(path.Join(dir, name))
+		if exists := err == nil; exists != expected {
+			t.Errorf("Mux left incorrect segments on disk, %s exists: %t", name, exists)
+		}
+	}
+}
+
+func TestMuxNoStorageSize(t *testing.T) {
+	dir := tempDir(t)
+
+	muxer := Muxer{
+		Directory: dir,
+		Options: Options{
+			Fps:          30,
+			SegmentTime:  1,
+			PlaylistSize: 1,
+		},
+	}
+
+	mux(t, &muxer, fakeVideo(90, 30))
+
+	for _, name := range []string{"raspilive-000.ts", "raspilive-001.ts", "raspilive-002.ts"} {
+		if _, err := os.Stat(path.Join(dir, name)); err != nil {
+			t.Error("Mux removed segment without a storage size", name)
+		}
+	}
+}
+
+func TestMuxDefaults(t *testing.T) {
+	dir := tempDir(t)
+
+	muxer := Muxer{Directory: dir}
+
+	mux(t, &muxer, fakeVideo(30*14, 30))
+
+	playlist := readPlaylist(t, dir)
+	if !strings.Contains(playlist, "#EXT-X-TARGETDURATION:2\n") || !strings.Contains(playlist, "#EXT-X-MEDIA-SEQUENCE:2\n") {
+		t.Error("Mux wrote playlist with incorrect defaults, got\n", playlist)
+	}
+}
+
+func TestMuxSkipsToFirstKeyframe(t *testing.T) {
+	dir := tempDir(t)
+
+	muxer := Muxer{
+		Directory: dir,
+		Options: Options{
+			Fps:         30,
+			SegmentTime: 1,
+		},
+	}
+
+	var video []byte
+	for i := 0; i < 15; i++ {
+		video = append(video, h264.StartCode...)
+		video = append(video, fakeNonIDR...)
+	}
+	video = append(video, fakeVideo(30, 30)...)
+
+	mux(t, &muxer, video)
+
+	if playlist := readPlaylist(t, dir); !strings.Contains(playlist, "#EXTINF:1.000000,\nraspilive-000.ts\n") {
+		t.Error("Mux did not skip to first keyframe, got\n", playlist)
+	}
+}
+
+func TestMuxFmp4(t *testing.T) {
+	dir := tempDir(t)
+
+	muxer := Muxer{
+		Directory: dir,
+		Options: Options{
+			Fps:         30,
+			SegmentType: "fmp4",
+			SegmentTime: 1,
+		},
+	}
+
+	mux(t, &muxer, fakeVideo(60, 30))
+
+	expected := "#EXTM3U\n" +
+		"#EXT-X-VERSION:7\n" +
+		"#EXT-X-TARGETDURATION:1\n" +
+		"#EXT-X-MEDIA-SEQUENCE:0\n" +
+		"#EXT-X-INDEPENDENT-SEGMENTS\n" +
+		"#EXT-X-MAP:URI=\"init.mp4\"\n" +
+		"#EXTINF:1.000000,\nraspilive-0.m4s\n" +
+		"#EXTINF:1.000000,\nraspilive-1.m4s\n" +
+		"#EXT-X-ENDLIST\n"
+	if playlist := readPlaylist(t, dir); playlist != expected {
+		t.Error("Mux wrote incorrect playlist, got\n", playlist)
+	}
+
+	for _, name := range []string{"init.mp4", "raspilive-0.m4s", "raspilive-1.m4s"} {
+		if _, err := os.Stat(path.Join(dir, name)); err != nil {
+			t.Error("Mux did not write", name)
+		}
+	}
+}
+
+func TestMuxCatalog(t *testing.T) {
+	testCases := []struct {
+		segmentType string
+		init        string
+		first       string
+	}{
+		{"mpegts", "", "raspilive-000.ts"},
+		{"fmp4", "init.mp4", "raspilive-0.m4s"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.segmentType, func(t *testing.T) {
+			catalog := &export.Catalog{}
+
+			muxer := Muxer{
+				Directory: tempDir(t),
+				Options: Options{
+					Fps:          30,
+					SegmentType:  tc.segmentType,
+					SegmentTime:  1,
+					PlaylistSize: 1,
+					Catalog:      catalog,
+				},
+			}
+
+			mux(t, &muxer, fakeVideo(90, 30))
+
+			if catalog.Init() != tc.init {
+				t.Error("Mux set incorrect init segment in the catalog, got", catalog.Init())
+			}
+
+			// The catalog holds on to the segments that slid out of the playlist
+			segments := catalog.Range(time.Time{}, time.Now().Add(time.Hour))
+			if len(segments) != 3 || segments[0].Name != tc.first {
+				t.Error("Mux did not catalog the segments, got", segments)
+			}
+		})
+	}
+}
+
+func TestMuxChain(t *testing.T) {
+	dir := tempDir(t)
+	publicKey, privateKey, _ := ed25519.GenerateKey(nil)
+
+	muxer := Muxer{
+		Directory: dir,
+		Options: Options{
+			Fps:          30,
+			SegmentTime:  1,
+			PlaylistSize: 1,
+			StorageSize:  1,
+			Chain:        &chain.Chain{Directory: dir, Key: privateKey},
+		},
+	}
+
+	mux(t, &muxer, fakeVideo(120, 30))
+
+	report, err := chain.Verify(dir, publicKey)
+	if err != nil {
+		t.Fatal("Mux did not write the chain", err)
+	}
+
+	// Segments removed from storage expire from the start of the chain
+	if !report.OK() || report.Entries != 4 || len(report.Expired) != 2 || len(report.Verified) != 2 {
+		t.Error("Mux did not chain the segments, got", report)
+	}
+}
+
+func TestMuxSession(t *testing.T) {
+	library := &session.Library{Directory: tempDir(t)}
+	tracker, err := library.Start()
+	if err != nil {
+		t.Fatal("Start returned an error", err)
+	}
+	dir := tracker.Directory()
+
+	muxer := Muxer{
+		Directory: dir,
+		Options: Options{
+			Fps:          30,
+			SegmentTime:  1,
+			PlaylistSize: 1,
+			StorageSize:  1,
+			Session:      tracker,
+		},
+	}
+
+	mux(t, &muxer, fakeVideo(90, 30))
+
+	// The session keeps every segment, long after they slide out of the live playlist
+	expected := "#EXTM3U\n" +
+		"#EXT-X-VERSION:3\n" +
+		"#EXT-X-TARGETDURATION:1\n" +
+		"#EXT-X-MEDIA-SEQUENCE:0\n" +
+		"#EXT-X-PLAYLIST-TYPE:VOD\n" +
+		"#EXTINF:1.000000,\nraspilive-000.ts\n" +
+		"#EXTINF:1.000000,\nraspilive-001.ts\n" +
+		"#EXTINF:1.000000,\nraspilive-002.ts\n" +
+		"#EXT-X-ENDLIST\n"
+	vod, err := ioutil.ReadFile(path.Join(dir, session.PlaylistName))
+	if err != nil {
+		t.Fatal("Mux did not write the session's playlist", err)
+	}
+	if string(vod) != expected {
+		t.Error("Mux wrote incorrect session playlist, got\n", string(vod))
+	}
+	if _, err := os.Stat(path.Join(dir, "raspilive-000.ts")); err != nil {
+		t.Error("Mux removed a segment of the session")
+	}
+
+	if playlist := readPlaylist(t, dir); strings.Count(playlist, "#EXTINF") != 1 {
+		t.Error("Mux wrote incorrect playlist, got\n", playlist)
+	}
+
+	sessions, _ := library.Sessions()
+	if len(sessions) != 1 || sessions[0].Live || sessions[0].Segments != 3 || sessions[0].Duration != 3 {
+		t.Error("Mux did not finish the session, got", sessions)
+	}
+}
+
+func TestMuxSessionShortfall(t *testing.T) {
+	library := &session.Library{Directory: tempDir(t)}
+	tracker, err := library.Start()
+	if err != nil {
+		t.Fatal("Start returned an error", err)
+	}
+	dir := tracker.Directory()
+
+	muxer := Muxer{
+		Directory: dir,
+		Options: Options{
+			Fps:          30,
+			SegmentTime:  1,
+			PlaylistSize: 1,
+			Session:      tracker,
+			Shortfall:    func() int64 { return 1 << 30 },
+		},
+	}
+
+	mux(t, &muxer, fakeVideo(150, 30))
+
+	// Running out of space cuts the session back too, leaving out the segments that are gone
+	expected := "#EXTM3U\n" +
+		"#EXT-X-VERSION:3\n" +
+		"#EXT-X-TARGETDURATION:1\n" +
+		"#EXT-X-MEDIA-SEQUENCE:3\n" +
+		"#EXT-X-PLAYLIST-TYPE:VOD\n" +
+		"#EXTINF:1.000000,\nraspilive-003.ts\n" +
+		"#EXTINF:1.000000,\nraspilive-004.ts\n" +
+		"#EXT-X-ENDLIST\n"
+	vod, err := ioutil.ReadFile(path.Join(dir, session.PlaylistName))
+	if err != nil {
+		t.Fatal("Mux did not write the session's playlist", err)
+	}
+	if string(vod) != expected {
+		t.Error("Mux wrote incorrect session playlist, got\n", string(vod))
+	}
+	if _, err := os.Stat(path.Join(dir, "raspilive-002.ts")); !os.IsNotExist(err) {
+		t.Error("Mux did not remove the oldest segments of the session")
+	}
+}
+
+func TestMuxShortfall(t *testing.T) {
+	dir := tempDir(t)
+
+	muxer := Muxer{
+		Directory: dir,
+		Options: Options{
+			Fps:          30,
+			SegmentTime:  1,
+			PlaylistSize: 5,
+			Shortfall:    func() int64 { return 1 << 30 },
+		},
+	}
+
+	mux(t, &muxer, fakeVideo(180, 30))
+
+	// Running out of space cuts the playlist back to the live edge
+	if playlist := readPlaylist(t, dir); strings.Count(playlist, "#EXTINF") != 3 {
+		t.Error("Mux wrote incorrect playlist, got\n", playlist)
+	}
+	if _, err := os.Stat(path.Join(dir, "raspilive-002.ts")); !os.IsNotExist(err) {
+		t.Error("Mux did not remove the oldest segments")
+	}
+}
+
+func TestMuxInvalidSegmentType(t *testing.T) {
+	muxer := Muxer{
+		Directory: tempDir(t),
+		Options: Options{
+			SegmentType: "webm",
+		},
+	}
+
+	if err := muxer.Mux(ioutil.NopCloser(bytes.NewReader(nil))); err == nil || err.Error() != "hls: invalid segment type" {
+		t.Error("Mux returned incorrect error, got", err)
+	}
+}
+
+func TestMuxInvalidDirectory(t *testing.T) {
+	muxer := Muxer{
+		Directory: path.Join(tempDir(t), "nonexistent"),
+	}
+
+	if err := muxer.Mux(ioutil.NopCloser(bytes.NewReader(nil))); err == nil || err.Error() != "hls: invalid directory" {
+		t.Error("Mux returned incorrect error, got", err)
+	}
+}
+
+func TestWaitNotStarted(t *testing.T) {
+	muxer := Muxer{}
+
+	err := muxer.Wait()
+	if err == nil || err.Error() != "hls: not started" {
+		t.Error("Wait returned incorrect error, got", err)
+	}
+}
+
+func TestString(t *testing.T) {
+	muxer := Muxer{Directory: "/tmp/camera"}
+
+	if muxer.String() != "" {
+		t.Error("String returned incorrect value before starting, got", muxer.String())
+	}
+
+	muxer.done = make(chan struct{})
+	if expected := "native hls muxer (/tmp/camera/livestream.m3u8)"; muxer.String() != expected {
+		t.Error("String returned incorrect value, got", muxer.String())
+	}
+}
+
+// TestMuxFfprobe validates the output against real H.264 video using Ffmpeg and Ffprobe, when they are available.
+func TestMuxFfprobe(t *testing.T) {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		t.Skip("ffmpeg is not installed")
+	}
+	if _, err := exec.LookPath("ffprobe"); err != nil {
+		t.Skip("ffprobe is not installed")
+	}
+
+	video, err := exec.Command(
+		"ffmpeg", "-v", "error",
+		"-f", "lavfi", "-i", "testsrc=size=320x240:rate=30:duration=6",
+		"-c:v", "libx264", "-g", "30", "-bsf:v", "h264_mp4toannexb",
+		"-f", "h264", "pipe:1").Output()
+	if err != nil {
+		t.Skip("ffmpeg is not able to encode H.264 video", err)
+	}
+
+	testCases := []struct {
+		segmentType string
+		segments    []string
+	}{
+		{"mpegts", []string{"raspilive-000.ts", "raspilive-001.ts", "raspilive-002.ts"}},
+		{"fmp4", nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.segmentType, func(t *testing.T) {
+			dir := tempDir(t)
+			muxer := Muxer{
+				Directory: dir,
+				Options: Options{
+					Fps:         30,
+					SegmentType: tc.segmentType,
+					SegmentTime: 2,
+				},
+			}
+			mux(t, &muxer, video)
+
+			probeStream(t, path.Join(dir, "livestream.m3u8"), 180)
+
+			for _, name := range tc.segments {
+				if err := exec.Command("ffprobe", "-v", "error", path.Join(dir, name)).Run(); err != nil {
+					t.Error("ffprobe rejected segment", name, err)
+				}
+			}
+		})
+	}
+}
+
+// probeStream checks that ffprobe reads the expected H.264 video out of the playlist.
+func probeStream(t *testing.T, playlist string, frames int) {
+	out, err := exec.Command(
+		"ffprobe", "-v", "error",
+		"-count_frames",
+		"-show_entries", "stream=codec_name,width,height,nb_read_frames",
+		"-of", "json",
+		playlist).Output()
+	if err != nil {
+		t.Fatal("ffprobe rejected the playlist", err)
+	}
+
+	var probe struct {
+		Streams []struct {
+			CodecName    string `json:"codec_name"`
+			Width        int    `json:"width"`
+			Height       int    `json:"height"`
+			NbReadFrames string `json:"nb_read_frames"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(out, &probe); err != nil {
+		t.Fatal("Failed to parse ffprobe output", err)
+	}
+
+	if len(probe.Streams) != 1 {
+		t.Fatal("ffprobe found incorrect number of streams, got", len(probe.Streams))
+	}
+
+	stream := probe.Streams[0]
+	if stream.CodecName != "h264" || stream.Width != 320 || stream.Height != 240 {
+		t.Error("ffprobe found incorrect stream, got", stream)
+	}
+	if stream.NbReadFrames != strconv.Itoa(frames) {
+		t.Error("ffprobe read incorrect number of frames, got", stream.NbReadFrames)
+	}
+}
@@ -0,0 +1,68 @@
+package hls
+
+import (
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/jaredpetersen/raspilive/internal/segment"
+)
+
+// IFramePlaylist is an HLS I-frame playlist pointing at the keyframe that begins each segment, which players use for
+// scrubbing previews and fast forward.
+//
+// Only MPEG-TS segments may be listed, as they carry the keyframe on its own right after the program tables.
+type IFramePlaylist struct {
+	Storage segment.Storage // Where to write the playlist
+	Name    string          // File name of the playlist
+}
+
+// Update rewrites the playlist with the keyframes of the segments that are currently available.
+func (pl *IFramePlaylist) Update(segments []segment.Segment, ended bool) error {
+	return segment.WriteFile(pl.Storage, pl.Name, func(w io.Writer) error {
+		return pl.encode(w, segments, ended)
+	})
+}
+
+// encode writes the playlist in the M3U8 format, marking it as complete if the stream has ended.
+func (pl *IFramePlaylist) encode(w io.Writer, segments []segment.Segment, ended bool) error {
+	targetDuration := 0.0
+	for _, seg := range segments {
+		targetDuration = math.Max(targetDuration, seg.Seconds())
+	}
+
+	sequence := 0
+	if len(segments) > 0 {
+		sequence = segments[0].Number
+	}
+
+	// I-frame playlists came along in version 4
+	_, err := fmt.Fprintf(w,
+		"#EXTM3U\n#EXT-X-VERSION:4\n#EXT-X-TARGETDURATION:%d\n#EXT-X-MEDIA-SEQUENCE:%d\n#EXT-X-I-FRAMES-ONLY\n",
+		int(math.Ceil(targetDuration)), sequence)
+	if err != nil {
+		return err
+	}
+
+	for _, seg := range segments {
+		if seg.Discontinuity {
+			if _, err := io.WriteString(w, "#EXT-X-DISCONTINUITY\n"); err != nil {
+				return err
+			}
+		}
+
+		// The keyframe is the only one listed for the segment, so it stands in for the whole duration
+		_, err := fmt.Fprintf(w, "#EXTINF:%.6f,\n#EXT-X-BYTERANGE:%d@0\n%s\n", seg.Seconds(), seg.Keyframe, seg.Name)
+		if err != nil {
+			return err
+		}
+	}
+
+	if ended {
+		if _, err := io.WriteString(w, "#EXT-X-ENDLIST\n"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,76 @@
+package hls
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/jaredpetersen/raspilive/internal/segment"
+)
+
+// Variant is one rendition of the video listed in a master playlist.
+type Variant struct {
+	URI       string // URI of the media playlist of the rendition
+	Bandwidth int    // Peak bitrate of the rendition in bits per second
+	Width     int    // Width of the video in pixels
+	Height    int    // Height of the video in pixels
+	Codec     string // RFC 6381 codec of the video
+}
+
+// MasterPlaylist is an HLS master playlist listing the renditions of the video, letting players switch between them
+// as their bandwidth allows.
+type MasterPlaylist struct {
+	Storage  segment.Storage // Where to write the playlist
+	Name     string          // File name of the playlist
+	Variants []Variant       // Renditions of the video, with the one that players should start with first
+	IFrames  []Variant       // I-frame playlists of the renditions, for scrubbing previews and fast forward
+
+	Subtitles string // URI of a subtitles playlist to offer alongside every rendition, if there is one
+}
+
+// Write writes out the master playlist.
+func (mpl *MasterPlaylist) Write() error {
+	return segment.WriteFile(mpl.Storage, mpl.Name, mpl.encode)
+}
+
+// encode writes the master playlist in the M3U8 format.
+func (mpl *MasterPlaylist) encode(w io.Writer) error {
+	// I-frame playlists came along in version 4
+	version := 3
+	if len(mpl.IFrames) > 0 {
+		version = 4
+	}
+
+	if _, err := fmt.Fprintf(w, "#EXTM3U\n#EXT-X-VERSION:%d\n", version); err != nil {
+		return err
+	}
+
+	// Renditions refer to the subtitles by the group that they belong to
+	var group string
+	if mpl.Subtitles != "" {
+		_, err := fmt.Fprintf(w,
+			"#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID=\"subtitles\",NAME=\"Metadata\",DEFAULT=YES,AUTOSELECT=YES,URI=\"%s\"\n",
+			mpl.Subtitles)
+		if err != nil {
+			return err
+		}
+		group = ",SUBTITLES=\"subtitles\""
+	}
+
+	for _, variant := range mpl.Variants {
+		_, err := fmt.Fprintf(w, "#EXT-X-STREAM-INF:BANDWIDTH=%d,RESOLUTION=%dx%d,CODECS=\"%s\"%s\n%s\n",
+			variant.Bandwidth, variant.Width, variant.Height, variant.Codec, group, variant.URI)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, iframes := range mpl.IFrames {
+		_, err := fmt.Fprintf(w, "#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=%d,RESOLUTION=%dx%d,CODECS=\"%s\",URI=\"%s\"\n",
+			iframes.Bandwidth, iframes.Width, iframes.Height, iframes.Codec, iframes.URI)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,82 @@
+package hls
+
+import (
+	"testing"
+
+	"github.com/jaredpetersen/raspilive/internal/memfs"
+)
+
+func TestMasterPlaylistWrite(t *testing.T) {
+	store := &memfs.Store{}
+
+	mpl := MasterPlaylist{
+		Storage: store,
+		Name:    "livestream.m3u8",
+		Variants: []Variant{
+			{URI: "livestream-0.m3u8", Bandwidth: 17000000, Width: 1280, Height: 720, Codec: "avc1.64001F"},
+			{URI: "livestream-1.m3u8", Bandwidth: 800000, Width: 640, Height: 360, Codec: "avc1.4D4028"},
+		},
+	}
+
+	if err := mpl.Write(); err != nil {
+		t.Fatal("Write returned an error", err)
+	}
+
+	expected := "#EXTM3U\n" +
+		"#EXT-X-VERSION:3\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=17000000,RESOLUTION=1280x720,CODECS=\"avc1.64001F\"\n" +
+		"livestream-0.m3u8\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=800000,RESOLUTION=640x360,CODECS=\"avc1.4D4028\"\n" +
+		"livestream-1.m3u8\n"
+	if playlist, _ := store.ReadFile("livestream.m3u8"); string(playlist) != expected {
+		t.Error("Write wrote incorrect playlist, got\n", string(playlist))
+	}
+}
+
+func TestMasterPlaylistWriteIFrames(t *testing.T) {
+	store := &memfs.Store{}
+
+	mpl := MasterPlaylist{
+		Storage:  store,
+		Name:     "livestream.m3u8",
+		Variants: []Variant{{URI: "livestream-0.m3u8", Bandwidth: 2000000, Width: 1280, Height: 720, Codec: "avc1.64001F"}},
+		IFrames:  []Variant{{URI: "livestream-iframes.m3u8", Bandwidth: 100000, Width: 1280, Height: 720, Codec: "avc1.64001F"}},
+	}
+
+	if err := mpl.Write(); err != nil {
+		t.Fatal("Write returned an error", err)
+	}
+
+	expected := "#EXTM3U\n" +
+		"#EXT-X-VERSION:4\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=2000000,RESOLUTION=1280x720,CODECS=\"avc1.64001F\"\n" +
+		"livestream-0.m3u8\n" +
+		"#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=100000,RESOLUTION=1280x720,CODECS=\"avc1.64001F\",URI=\"livestream-iframes.m3u8\"\n"
+	if playlist, _ := store.ReadFile("livestream.m3u8"); string(playlist) != expected {
+		t.Error("Write wrote incorrect playlist, got\n", string(playlist))
+	}
+}
+
+func TestMasterPlaylistWriteSubtitles(t *testing.T) {
+	store := &memfs.Store{}
+
+	mpl := MasterPlaylist{
+		Storage:   store,
+		Name:      "livestream.m3u8",
+		Variants:  []Variant{{URI: "livestream-0.m3u8", Bandwidth: 2000000, Width: 1280, Height: 720, Codec: "avc1.64001F"}},
+		Subtitles: "livestream-subtitles.m3u8",
+	}
+
+	if err := mpl.Write(); err != nil {
+		t.Fatal("Write returned an error", err)
+	}
+
+	expected := "#EXTM3U\n" +
+		"#EXT-X-VERSION:3\n" +
+		"#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID=\"subtitles\",NAME=\"Metadata\",DEFAULT=YES,AUTOSELECT=YES,URI=\"livestream-subtitles.m3u8\"\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=2000000,RESOLUTION=1280x720,CODECS=\"avc1.64001F\",SUBTITLES=\"subtitles\"\n" +
+		"livestream-0.m3u8\n"
+	if playlist, _ := store.ReadFile("livestream.m3u8"); string(playlist) != expected {
+		t.Error("Write wrote incorrect playlist, got\n", string(playlist))
+	}
+}
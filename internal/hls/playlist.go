@@ -0,0 +1,165 @@
+package hls
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jaredpetersen/raspilive/internal/segment"
+)
+
+// Playlist is an HLS media playlist listing the segments as they become available.
+type Playlist struct {
+	Storage segment.Storage // Where to write the playlist
+	Name    string          // File name of the playlist
+	Map     string          // URI of the initialization segment, for fragmented MP4 segments
+	VOD     bool            // Mark the playlist as video on demand once the stream has ended, and as an event until then
+
+	// ProgramDateTime tags each segment with the wall clock time it was captured
+	ProgramDateTime bool
+
+	discontinuities []int // Sequence numbers of the discontinuous segments seen so far, oldest first
+	carried         int   // Discontinuities that had slid out of the playlist of an earlier session carried on from
+}
+
+// programDateTimeLayout is the ISO 8601 layout of EXT-X-PROGRAM-DATE-TIME, to the millisecond.
+const programDateTimeLayout = "2006-01-02T15:04:05.000Z07:00"
+
+// Update rewrites the playlist with the segments that are currently available.
+func (pl *Playlist) Update(segments []segment.Segment, ended bool) error {
+	for _, seg := range segments {
+		seen := len(pl.discontinuities) > 0 && pl.discontinuities[len(pl.discontinuities)-1] >= seg.Number
+		if seg.Discontinuity && !seen {
+			pl.discontinuities = append(pl.discontinuities, seg.Number)
+		}
+	}
+
+	return segment.WriteFile(pl.Storage, pl.Name, func(w io.Writer) error {
+		return pl.encode(w, segments, ended)
+	})
+}
+
+// encode writes the playlist in the M3U8 format, marking it as complete if the stream has ended.
+func (pl *Playlist) encode(w io.Writer, segments []segment.Segment, ended bool) error {
+	targetDuration := 0.0
+	for _, seg := range segments {
+		targetDuration = math.Max(targetDuration, seg.Seconds())
+	}
+
+	// Fragmented MP4 segments need the initialization segment, which came along in version 6 for media playlists
+	version := 3
+	if pl.Map != "" {
+		version = 7
+	}
+
+	sequence := 0
+	if len(segments) > 0 {
+		sequence = segments[0].Number
+	}
+
+	_, err := fmt.Fprintf(w,
+		"#EXTM3U\n#EXT-X-VERSION:%d\n#EXT-X-TARGETDURATION:%d\n#EXT-X-MEDIA-SEQUENCE:%d\n",
+		version, int(math.Ceil(targetDuration)), sequence)
+	if err != nil {
+		return err
+	}
+
+	// Players count the discontinuities that have slid out of the playlist to line up the ones that remain
+	discontinuitySequence := pl.carried
+	for _, number := range pl.discontinuities {
+		if number < sequence {
+			discontinuitySequence++
+		}
+	}
+	if discontinuitySequence > 0 {
+		if _, err := fmt.Fprintf(w, "#EXT-X-DISCONTINUITY-SEQUENCE:%d\n", discontinuitySequence); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case pl.VOD && ended:
+		if _, err := io.WriteString(w, "#EXT-X-PLAYLIST-TYPE:VOD\n"); err != nil {
+			return err
+		}
+	case pl.VOD:
+		if _, err := io.WriteString(w, "#EXT-X-PLAYLIST-TYPE:EVENT\n"); err != nil {
+			return err
+		}
+	}
+
+	if pl.Map != "" {
+		if _, err := fmt.Fprintf(w, "#EXT-X-INDEPENDENT-SEGMENTS\n#EXT-X-MAP:URI=\"%s\"\n", pl.Map); err != nil {
+			return err
+		}
+	}
+
+	for _, seg := range segments {
+		if seg.Discontinuity {
+			if _, err := io.WriteString(w, "#EXT-X-DISCONTINUITY\n"); err != nil {
+				return err
+			}
+		}
+		if pl.ProgramDateTime && !seg.Time.IsZero() {
+			_, err := fmt.Fprintf(w, "#EXT-X-PROGRAM-DATE-TIME:%s\n", seg.Time.UTC().Format(programDateTimeLayout))
+			if err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintf(w, "#EXTINF:%.6f,\n%s\n", seg.Seconds(), seg.Name); err != nil {
+			return err
+		}
+	}
+
+	if ended {
+		if _, err := io.WriteString(w, "#EXT-X-ENDLIST\n"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// parsePlaylist reads the segments listed in a media playlist written by an earlier session, oldest first, along with
+// the number of discontinuities that had already slid out of it.
+func parsePlaylist(data []byte) ([]segment.Segment, int) {
+	var segments []segment.Segment
+	var next segment.Segment
+	number, discontinuitySequence := 0, 0
+
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+
+		switch {
+		case strings.HasPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"):
+			number, _ = strconv.Atoi(strings.TrimPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"))
+		case strings.HasPrefix(line, "#EXT-X-DISCONTINUITY-SEQUENCE:"):
+			discontinuitySequence, _ = strconv.Atoi(strings.TrimPrefix(line, "#EXT-X-DISCONTINUITY-SEQUENCE:"))
+		case line == "#EXT-X-DISCONTINUITY":
+			next.Discontinuity = true
+		case strings.HasPrefix(line, "#EXT-X-PROGRAM-DATE-TIME:"):
+			next.Time, _ = time.Parse(programDateTimeLayout, strings.TrimPrefix(line, "#EXT-X-PROGRAM-DATE-TIME:"))
+		case strings.HasPrefix(line, "#EXTINF:"):
+			duration := strings.TrimPrefix(line, "#EXTINF:")
+			if comma := strings.IndexByte(duration, ','); comma >= 0 {
+				duration = duration[:comma]
+			}
+			seconds, _ := strconv.ParseFloat(duration, 64)
+			next.Duration = int64(math.Round(seconds * segment.Timescale))
+		case line == "" || strings.HasPrefix(line, "#"):
+			continue
+		default:
+			next.Name = path.Base(line)
+			next.Number = number
+			segments = append(segments, next)
+			next = segment.Segment{}
+			number++
+		}
+	}
+
+	return segments, discontinuitySequence
+}
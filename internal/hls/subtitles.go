@@ -0,0 +1,153 @@
+package hls
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/jaredpetersen/raspilive/internal/segment"
+)
+
+// Subtitles is an HLS subtitles playlist of WebVTT segments that render fields of the timed metadata carried alongside
+// the video as text.
+//
+// A WebVTT segment is written for each video segment, covering the same stretch of the stream, and removed once the
+// video segment leaves the playlist. Each field shows its latest value until a newer one arrives.
+type Subtitles struct {
+	Storage segment.Storage // Where to write the playlist and WebVTT segments
+	Name    string          // File name of the playlist
+	Pattern string          // Format of the WebVTT segment file names, given the sequence number of the video segment
+	Fields  []string        // Top level fields of the metadata documents to render, in order
+
+	// ProgramDateTime tags each segment with the wall clock time it was captured
+	ProgramDateTime bool
+
+	playlist Playlist
+	values   map[string]string // Latest value of each field as of the end of the last WebVTT segment
+	written  []int             // Sequence numbers of the WebVTT segments in storage, oldest first
+}
+
+// Update writes the WebVTT segments of any new video segments and rewrites the playlist listing them.
+func (subs *Subtitles) Update(segments []segment.Segment, ended bool) error {
+	listed := make([]segment.Segment, len(segments))
+	for i, seg := range segments {
+		if len(subs.written) == 0 || seg.Number > subs.written[len(subs.written)-1] {
+			err := segment.WriteFile(subs.Storage, fmt.Sprintf(subs.Pattern, seg.Number), func(w io.Writer) error {
+				return subs.encode(w, seg)
+			})
+			if err != nil {
+				return err
+			}
+			subs.written = append(subs.written, seg.Number)
+		}
+
+		seg.Name = fmt.Sprintf(subs.Pattern, seg.Number)
+		listed[i] = seg
+	}
+
+	for len(subs.written) > 0 && len(segments) > 0 && subs.written[0] < segments[0].Number {
+		subs.Storage.Remove(fmt.Sprintf(subs.Pattern, subs.written[0]))
+		subs.written = subs.written[1:]
+	}
+
+	subs.playlist.Storage = subs.Storage
+	subs.playlist.Name = subs.Name
+	subs.playlist.ProgramDateTime = subs.ProgramDateTime
+
+	return subs.playlist.Update(listed, ended)
+}
+
+// encode writes the WebVTT segment covering the video segment, with a cue for each change to the rendered fields.
+func (subs *Subtitles) encode(w io.Writer, seg segment.Segment) error {
+	// Cue times count from the start of the segment, which players line up with the video's presentation timestamps,
+	// wrapping around as they do in MPEG-TS
+	_, err := fmt.Fprintf(w, "WEBVTT\nX-TIMESTAMP-MAP=MPEGTS:%d,LOCAL:00:00:00.000\n", seg.Start%(1<<33))
+	if err != nil {
+		return err
+	}
+
+	start, text := seg.Start, subs.text()
+	for _, md := range seg.Metadata {
+		if md.PTS > start {
+			if err := writeCue(w, start-seg.Start, md.PTS-seg.Start, text); err != nil {
+				return err
+			}
+			start = md.PTS
+		}
+
+		subs.apply(md.Data)
+		text = subs.text()
+	}
+
+	return writeCue(w, start-seg.Start, seg.Duration, text)
+}
+
+// apply takes on the values of the rendered fields given in the metadata document, clearing those that are null.
+func (subs *Subtitles) apply(data []byte) {
+	var document map[string]json.RawMessage
+	if err := json.Unmarshal(data, &document); err != nil {
+		return
+	}
+
+	if subs.values == nil {
+		subs.values = make(map[string]string)
+	}
+
+	for _, field := range subs.Fields {
+		raw, ok := document[field]
+		if !ok {
+			continue
+		}
+
+		var value string
+		switch {
+		case string(raw) == "null":
+			delete(subs.values, field)
+			continue
+		case json.Unmarshal(raw, &value) == nil:
+		default:
+			var compact bytes.Buffer
+			json.Compact(&compact, raw)
+			value = compact.String()
+		}
+
+		subs.values[field] = value
+	}
+}
+
+// text renders the fields that have values, a line each.
+func (subs *Subtitles) text() string {
+	var lines []string
+	for _, field := range subs.Fields {
+		if value, ok := subs.values[field]; ok {
+			lines = append(lines, escapeCueText(field+": "+value))
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// writeCue writes a cue showing the text between the times, given in Timescale ticks, skipping it if there is nothing
+// to show.
+func writeCue(w io.Writer, start int64, end int64, text string) error {
+	if text == "" || end <= start {
+		return nil
+	}
+
+	_, err := fmt.Fprintf(w, "\n%s --> %s\n%s\n", cueTime(start), cueTime(end), text)
+	return err
+}
+
+// cueTime formats Timescale ticks as a WebVTT timestamp.
+func cueTime(ticks int64) string {
+	ms := (time.Duration(ticks) * time.Second / segment.Timescale).Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+// escapeCueText keeps the text from being taken as markup or ending the cue early.
+func escapeCueText(text string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\r", " ", "\n", " ").Replace(text)
+}
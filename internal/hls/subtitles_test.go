@@ -0,0 +1,97 @@
+package hls
+
+import (
+	"testing"
+
+	"github.com/jaredpetersen/raspilive/internal/memfs"
+	"github.com/jaredpetersen/raspilive/internal/segment"
+)
+
+func TestSubtitlesUpdate(t *testing.T) {
+	store := &memfs.Store{}
+
+	subs := &Subtitles{
+		Storage: store,
+		Name:    "subtitles.m3u8",
+		Pattern: "subtitles-%d.vtt",
+		Fields:  []string{"temperature", "door", "gps"},
+	}
+
+	first := segment.Segment{
+		Name:     "raspilive-000.ts",
+		Number:   0,
+		Start:    126000,
+		Duration: 180000,
+		Metadata: []segment.Metadata{
+			{PTS: 126000 + 45000, Data: []byte(`{"temperature":21.5,"gps":{"lat":47.6, "lon":-122.3},"ignored":true}`)},
+			{PTS: 126000 + 135000, Data: []byte(`{"door":"<open> & shut"}`)},
+		},
+	}
+	second := segment.Segment{
+		Name:     "raspilive-001.ts",
+		Number:   1,
+		Start:    306000,
+		Duration: 180000,
+		Metadata: []segment.Metadata{
+			{PTS: 306000 + 90000, Data: []byte(`{"gps":null}`)},
+		},
+	}
+
+	if err := subs.Update([]segment.Segment{first}, false); err != nil {
+		t.Fatal("Update returned an error", err)
+	}
+	if err := subs.Update([]segment.Segment{first, second}, false); err != nil {
+		t.Fatal("Update returned an error", err)
+	}
+
+	expectedFirst := "WEBVTT\n" +
+		"X-TIMESTAMP-MAP=MPEGTS:126000,LOCAL:00:00:00.000\n" +
+		"\n" +
+		"00:00:00.500 --> 00:00:01.500\n" +
+		"temperature: 21.5\n" +
+		"gps: {\"lat\":47.6,\"lon\":-122.3}\n" +
+		"\n" +
+		"00:00:01.500 --> 00:00:02.000\n" +
+		"temperature: 21.5\n" +
+		"door: &lt;open&gt; &amp; shut\n" +
+		"gps: {\"lat\":47.6,\"lon\":-122.3}\n"
+	if vtt, _ := store.ReadFile("subtitles-0.vtt"); string(vtt) != expectedFirst {
+		t.Error("Update wrote incorrect first segment, got\n", string(vtt))
+	}
+
+	expectedSecond := "WEBVTT\n" +
+		"X-TIMESTAMP-MAP=MPEGTS:306000,LOCAL:00:00:00.000\n" +
+		"\n" +
+		"00:00:00.000 --> 00:00:01.000\n" +
+		"temperature: 21.5\n" +
+		"door: &lt;open&gt; &amp; shut\n" +
+		"gps: {\"lat\":47.6,\"lon\":-122.3}\n" +
+		"\n" +
+		"00:00:01.000 --> 00:00:02.000\n" +
+		"temperature: 21.5\n" +
+		"door: &lt;open&gt; &amp; shut\n"
+	if vtt, _ := store.ReadFile("subtitles-1.vtt"); string(vtt) != expectedSecond {
+		t.Error("Update wrote incorrect second segment, got\n", string(vtt))
+	}
+
+	expectedPlaylist := "#EXTM3U\n" +
+		"#EXT-X-VERSION:3\n" +
+		"#EXT-X-TARGETDURATION:2\n" +
+		"#EXT-X-MEDIA-SEQUENCE:0\n" +
+		"#EXTINF:2.000000,\nsubtitles-0.vtt\n" +
+		"#EXTINF:2.000000,\nsubtitles-1.vtt\n"
+	if playlist, _ := store.ReadFile("subtitles.m3u8"); string(playlist) != expectedPlaylist {
+		t.Error("Update wrote incorrect playlist, got\n", string(playlist))
+	}
+
+	// Segments are removed along with the video segments that they cover
+	if err := subs.Update([]segment.Segment{second}, true); err != nil {
+		t.Fatal("Update returned an error", err)
+	}
+	if _, err := store.ReadFile("subtitles-0.vtt"); err == nil {
+		t.Error("Update did not remove segment that left the playlist")
+	}
+	if _, err := store.ReadFile("subtitles-1.vtt"); err != nil {
+		t.Error("Update removed segment that is still listed")
+	}
+}
@@ -0,0 +1,45 @@
+package hls
+
+import "github.com/jaredpetersen/raspilive/internal/segment"
+
+// VODPlaylist is an HLS media playlist listing every segment of the stream from the start, rather than only those
+// within the window, so that the whole stream may be played back as video on demand once it ends.
+//
+// Segments evicted from storage to make room are dropped from the start of the playlist. It is no longer marked as an
+// event from then on, as players take an event to only ever grow, until it is marked as video on demand at the end.
+type VODPlaylist struct {
+	Playlist
+	segments []segment.Segment // Every segment seen so far that is still in storage, oldest first
+	trimmed  bool              // Whether segments have been dropped from the start
+}
+
+// Update adds the segments that are new since the last update to the playlist and rewrites it.
+func (vod *VODPlaylist) Update(segments []segment.Segment, ended bool) error {
+	for _, seg := range segments {
+		if len(vod.segments) == 0 || seg.Number > vod.segments[len(vod.segments)-1].Number {
+			vod.segments = append(vod.segments, seg)
+		}
+	}
+
+	vod.Playlist.VOD = ended || !vod.trimmed
+
+	return vod.Playlist.Update(vod.segments, ended)
+}
+
+// Evict drops the segments from the playlist.
+func (vod *VODPlaylist) Evict(segments []segment.Segment) {
+	evicted := make(map[int]bool)
+	for _, seg := range segments {
+		evicted[seg.Number] = true
+	}
+
+	kept := vod.segments[:0]
+	for _, seg := range vod.segments {
+		if evicted[seg.Number] {
+			vod.trimmed = true
+			continue
+		}
+		kept = append(kept, seg)
+	}
+	vod.segments = kept
+}
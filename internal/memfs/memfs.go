@@ -0,0 +1,268 @@
+package memfs
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Store is a bounded, concurrency-safe collection of files kept in memory.
+//
+// Video written to the store never touches the disk, sparing SD cards from wear. The store may be served with
+// server.Static as an http.FileSystem.
+//
+// Only media segments are ever evicted. Everything else, such as playlists, initialization segments and thumbnails, is
+// written once or kept up to date by the muxer and stays until it is removed.
+type Store struct {
+	Capacity int64 // Maximum total size of the files in bytes, evicting the oldest media segments past it. Unlimited if zero.
+	mu       sync.RWMutex
+	files    map[string]*file
+	size     int64
+	written  uint64 // Number of files written so far, ordering them for eviction
+}
+
+type file struct {
+	name    string
+	data    []byte
+	modTime time.Time
+	seq     uint64
+}
+
+// WriteFile stores the file, replacing any existing file of the same name all at once.
+func (store *Store) WriteFile(name string, data []byte) {
+	name = clean(name)
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if store.files == nil {
+		store.files = make(map[string]*file)
+	}
+
+	if existing, ok := store.files[name]; ok {
+		store.size -= int64(len(existing.data))
+	}
+
+	store.written++
+	store.files[name] = &file{name: name, data: data, modTime: time.Now(), seq: store.written}
+	store.size += int64(len(data))
+
+	store.evict(name)
+}
+
+// Create creates a file that is stored once it is closed, so that it is never seen partially written.
+func (store *Store) Create(name string) (io.WriteCloser, error) {
+	return &writer{store: store, name: name}, nil
+}
+
+// Remove removes the file.
+func (store *Store) Remove(name string) error {
+	name = clean(name)
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	existing, ok := store.files[name]
+	if !ok {
+		return os.ErrNotExist
+	}
+
+	store.size -= int64(len(existing.data))
+	delete(store.files, name)
+
+	return nil
+}
+
+// ReadFile returns the contents of the file.
+func (store *Store) ReadFile(name string) ([]byte, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	f, ok := store.files[clean(name)]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+
+	return f.data, nil
+}
+
+// Size returns the total size of the files in bytes.
+func (store *Store) Size() int64 {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	return store.size
+}
+
+// Open opens the file for reading, implementing http.FileSystem.
+func (store *Store) Open(name string) (http.File, error) {
+	name = clean(name)
+
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	if name == "" {
+		return store.root(), nil
+	}
+
+	f, ok := store.files[name]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+
+	// Files are replaced rather than modified, so readers may hold on to the data without copying it
+	return &openFile{Reader: bytes.NewReader(f.data), info: fileInfo{f}}, nil
+}
+
+// evict removes the oldest media segments until the store is within capacity, sparing the file that was just written.
+func (store *Store) evict(keep string) {
+	if store.Capacity <= 0 || store.size <= store.Capacity {
+		return
+	}
+
+	files := make([]*file, 0, len(store.files))
+	for _, f := range store.files {
+		if f.name != keep && isMediaSegment(f.name) {
+			files = append(files, f)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].seq < files[j].seq })
+
+	for _, f := range files {
+		if store.size <= store.Capacity {
+			return
+		}
+
+		store.size -= int64(len(f.data))
+		delete(store.files, f.name)
+	}
+}
+
+// isMediaSegment reports whether the file is a segment of video, as opposed to an initialization segment that the
+// media segments depend upon.
+func isMediaSegment(name string) bool {
+	switch path.Ext(name) {
+	case ".ts", ".m4s":
+		return !strings.HasPrefix(path.Base(name), "init")
+	default:
+		return false
+	}
+}
+
+func (store *Store) root() http.File {
+	infos := make([]os.FileInfo, 0, len(store.files))
+	for _, f := range store.files {
+		infos = append(infos, fileInfo{f})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+
+	return &openDir{entries: infos}
+}
+
+// clean normalizes the file name so that "/livestream.m3u8" and "livestream.m3u8" refer to the same file.
+func clean(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// writer buffers a file until it is closed.
+type writer struct {
+	bytes.Buffer
+	store  *Store
+	name   string
+	closed bool
+}
+
+func (w *writer) Close() error {
+	if w.closed {
+		return os.ErrClosed
+	}
+	w.closed = true
+
+	w.store.WriteFile(w.name, w.Bytes())
+
+	return nil
+}
+
+type fileInfo struct {
+	f *file
+}
+
+func (info fileInfo) Name() string       { return path.Base(info.f.name) }
+func (info fileInfo) Size() int64        { return int64(len(info.f.data)) }
+func (info fileInfo) Mode() os.FileMode  { return 0444 }
+func (info fileInfo) ModTime() time.Time { return info.f.modTime }
+func (info fileInfo) IsDir() bool        { return false }
+func (info fileInfo) Sys() interface{}   { return nil }
+
+type openFile struct {
+	*bytes.Reader
+	info fileInfo
+}
+
+func (f *openFile) Close() error {
+	return nil
+}
+
+func (f *openFile) Readdir(count int) ([]os.FileInfo, error) {
+	return nil, os.ErrInvalid
+}
+
+func (f *openFile) Stat() (os.FileInfo, error) {
+	return f.info, nil
+}
+
+type dirInfo struct{}
+
+func (info dirInfo) Name() string       { return "/" }
+func (info dirInfo) Size() int64        { return 0 }
+func (info dirInfo) Mode() os.FileMode  { return os.ModeDir | 0555 }
+func (info dirInfo) ModTime() time.Time { return time.Time{} }
+func (info dirInfo) IsDir() bool        { return true }
+func (info dirInfo) Sys() interface{}   { return nil }
+
+type openDir struct {
+	entries []os.FileInfo
+	pos     int
+}
+
+func (d *openDir) Close() error {
+	return nil
+}
+
+func (d *openDir) Read(p []byte) (int, error) {
+	return 0, os.ErrInvalid
+}
+
+func (d *openDir) Seek(offset int64, whence int) (int64, error) {
+	return 0, os.ErrInvalid
+}
+
+func (d *openDir) Readdir(count int) ([]os.FileInfo, error) {
+	remaining := d.entries[d.pos:]
+
+	if count <= 0 {
+		d.pos = len(d.entries)
+		return remaining, nil
+	}
+
+	if len(remaining) == 0 {
+		return nil, io.EOF
+	}
+
+	if count > len(remaining) {
+		count = len(remaining)
+	}
+	d.pos += count
+
+	return remaining[:count], nil
+}
+
+func (d *openDir) Stat() (os.FileInfo, error) {
+	return dirInfo{}, nil
+}
@@ -0,0 +1,194 @@
+package memfs
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestCreate(t *testing.T) {
+	store := &Store{}
+
+	file, err := store.Create("raspilive-1.m4s")
+	if err != nil {
+		t.Fatal("Create returned an error", err)
+	}
+	io.WriteString(file, "segment")
+
+	if _, err := store.ReadFile("raspilive-1.m4s"); !os.IsNotExist(err) {
+		t.Error("Store made file visible before it was closed")
+	}
+
+	if err := file.Close(); err != nil {
+		t.Fatal("Close returned an error", err)
+	}
+
+	if data, _ := store.ReadFile("/raspilive-1.m4s"); string(data) != "segment" {
+		t.Error("Store wrote incorrect contents, got", string(data))
+	}
+
+	if size := store.Size(); size != 7 {
+		t.Error("Store reported incorrect size, got", size)
+	}
+}
+
+func TestWriteFileReplaces(t *testing.T) {
+	store := &Store{}
+	store.WriteFile("livestream.m3u8", []byte("#EXTM3U\n"))
+	store.WriteFile("livestream.m3u8", []byte("#EXTM3U\n#EXT-X-VERSION:3\n"))
+
+	if data, _ := store.ReadFile("livestream.m3u8"); string(data) != "#EXTM3U\n#EXT-X-VERSION:3\n" {
+		t.Error("Store did not replace file, got", string(data))
+	}
+
+	if size := store.Size(); size != 25 {
+		t.Error("Store reported incorrect size, got", size)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	store := &Store{}
+	store.WriteFile("raspilive-1.m4s", []byte("segment"))
+
+	if err := store.Remove("raspilive-1.m4s"); err != nil {
+		t.Fatal("Remove returned an error", err)
+	}
+
+	if _, err := store.ReadFile("raspilive-1.m4s"); !os.IsNotExist(err) {
+		t.Error("Store did not remove file")
+	}
+
+	if size := store.Size(); size != 0 {
+		t.Error("Store reported incorrect size, got", size)
+	}
+
+	if err := store.Remove("raspilive-1.m4s"); !os.IsNotExist(err) {
+		t.Error("Remove did not return an error for a missing file", err)
+	}
+}
+
+func TestEvictsOldestFiles(t *testing.T) {
+	store := &Store{Capacity: 20}
+	store.WriteFile("raspilive-1.m4s", make([]byte, 8))
+	store.WriteFile("raspilive-2.m4s", make([]byte, 8))
+	store.WriteFile("raspilive-3.m4s", make([]byte, 8))
+
+	if _, err := store.ReadFile("raspilive-1.m4s"); !os.IsNotExist(err) {
+		t.Error("Store did not evict oldest file")
+	}
+
+	for _, name := range []string{"raspilive-2.m4s", "raspilive-3.m4s"} {
+		if _, err := store.ReadFile(name); err != nil {
+			t.Error("Store evicted", name)
+		}
+	}
+
+	if size := store.Size(); size != 16 {
+		t.Error("Store reported incorrect size, got", size)
+	}
+}
+
+func TestEvictsOnlyMediaSegments(t *testing.T) {
+	store := &Store{Capacity: 20}
+	store.WriteFile("init.m4s", make([]byte, 4))
+	store.WriteFile("master.m3u8", make([]byte, 4))
+	store.WriteFile("thumbnails-1.jpg", make([]byte, 4))
+	store.WriteFile("raspilive-1.m4s", make([]byte, 8))
+	store.WriteFile("raspilive-2.m4s", make([]byte, 8))
+
+	if _, err := store.ReadFile("raspilive-1.m4s"); !os.IsNotExist(err) {
+		t.Error("Store did not evict oldest media segment")
+	}
+
+	for _, name := range []string{"init.m4s", "master.m3u8", "thumbnails-1.jpg", "raspilive-2.m4s"} {
+		if _, err := store.ReadFile(name); err != nil {
+			t.Error("Store evicted", name)
+		}
+	}
+}
+
+func TestKeepsFileLargerThanCapacity(t *testing.T) {
+	store := &Store{Capacity: 4}
+	store.WriteFile("raspilive-1.m4s", make([]byte, 8))
+
+	if _, err := store.ReadFile("raspilive-1.m4s"); err != nil {
+		t.Error("Store evicted the file that was just written")
+	}
+}
+
+func TestServesFiles(t *testing.T) {
+	store := &Store{}
+	store.WriteFile("livestream.m3u8", []byte("#EXTM3U\n"))
+
+	srv := httptest.NewServer(http.FileServer(store))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/livestream.m3u8")
+	if err != nil {
+		t.Fatal("Request to server failed:", err)
+	}
+	if resp.StatusCode != 200 {
+		t.Error("Request to server failed with status code", resp.StatusCode)
+	}
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	if string(body) != "#EXTM3U\n" {
+		t.Error("Response body did not match, given:", string(body))
+	}
+
+	resp, err = http.Get(srv.URL + "/raspilive-1.ts")
+	if err != nil {
+		t.Fatal("Request to server failed:", err)
+	}
+	if resp.StatusCode != 404 {
+		t.Error("Request to server for missing file returned status code", resp.StatusCode)
+	}
+}
+
+func TestListsFiles(t *testing.T) {
+	store := &Store{}
+	store.WriteFile("raspilive-2.ts", []byte("segment"))
+	store.WriteFile("raspilive-1.ts", []byte("segment"))
+
+	dir, err := store.Open("/")
+	if err != nil {
+		t.Fatal("Open returned an error", err)
+	}
+
+	infos, err := dir.Readdir(-1)
+	if err != nil {
+		t.Fatal("Readdir returned an error", err)
+	}
+
+	if len(infos) != 2 || infos[0].Name() != "raspilive-1.ts" || infos[1].Name() != "raspilive-2.ts" {
+		t.Error("Readdir listed incorrect files, got", infos)
+	}
+}
+
+func TestConcurrentAccess(t *testing.T) {
+	store := &Store{Capacity: 64}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				store.WriteFile("livestream.m3u8", make([]byte, 16))
+				if file, err := store.Open("livestream.m3u8"); err == nil {
+					ioutil.ReadAll(file)
+					file.Close()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if size := store.Size(); size != 16 {
+		t.Error("Store reported incorrect size, got", size)
+	}
+}
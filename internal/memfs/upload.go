@@ -0,0 +1,78 @@
+package memfs
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+)
+
+// UploadServer accepts files into the store over HTTP, as Ffmpeg does when given an HTTP URL as its output.
+//
+// Files are uploaded with PUT and removed with DELETE. The server only listens on the loopback interface so that
+// nobody else may tamper with the video.
+type UploadServer struct {
+	Store    *Store
+	listener net.Listener
+	server   *http.Server
+}
+
+// Listen begins listening on an available loopback port, returning the base URL that files may be uploaded to.
+func (us *UploadServer) Listen() (string, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+
+	us.listener = listener
+	us.server = &http.Server{Handler: us}
+
+	return "http://" + listener.Addr().String(), nil
+}
+
+// Serve accepts uploads until the server is closed, which may happen before it is served.
+func (us *UploadServer) Serve() error {
+	if us.server == nil {
+		return errors.New("memfs: not listening")
+	}
+
+	err := us.server.Serve(us.listener)
+	if err != http.ErrServerClosed {
+		return err
+	}
+
+	return nil
+}
+
+// Close stops accepting uploads.
+func (us *UploadServer) Close() error {
+	if us.server == nil {
+		return errors.New("memfs: not listening")
+	}
+
+	return us.server.Close()
+}
+
+func (us *UploadServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPut, http.MethodPost:
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		us.Store.WriteFile(r.URL.Path, data)
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		if err := us.Store.Remove(r.URL.Path); os.IsNotExist(err) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
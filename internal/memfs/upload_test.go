@@ -0,0 +1,84 @@
+package memfs
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func request(t *testing.T, method string, url string, body string) int {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("Request to server failed:", err)
+	}
+	resp.Body.Close()
+
+	return resp.StatusCode
+}
+
+func TestUploadServer(t *testing.T) {
+	store := &Store{}
+	uploads := &UploadServer{Store: store}
+
+	url, err := uploads.Listen()
+	if err != nil {
+		t.Fatal("Listen returned an error", err)
+	}
+	if !strings.HasPrefix(url, "http://127.0.0.1:") {
+		t.Error("Listen returned incorrect URL, got", url)
+	}
+
+	go uploads.Serve()
+	defer uploads.Close()
+
+	if status := request(t, http.MethodPut, url+"/livestream.m3u8", "#EXTM3U\n"); status != http.StatusNoContent {
+		t.Error("Upload failed with status code", status)
+	}
+
+	if data, _ := store.ReadFile("livestream.m3u8"); string(data) != "#EXTM3U\n" {
+		t.Error("UploadServer stored incorrect contents, got", string(data))
+	}
+
+	if status := request(t, http.MethodDelete, url+"/livestream.m3u8", ""); status != http.StatusNoContent {
+		t.Error("Delete failed with status code", status)
+	}
+
+	if _, err := store.ReadFile("livestream.m3u8"); err == nil {
+		t.Error("UploadServer did not remove file")
+	}
+
+	if status := request(t, http.MethodDelete, url+"/livestream.m3u8", ""); status != http.StatusNotFound {
+		t.Error("Delete of missing file returned status code", status)
+	}
+
+	if status := request(t, http.MethodGet, url+"/livestream.m3u8", ""); status != http.StatusMethodNotAllowed {
+		t.Error("Get returned status code", status)
+	}
+}
+
+func TestUploadServerClosedBeforeServing(t *testing.T) {
+	uploads := &UploadServer{Store: &Store{}}
+	if _, err := uploads.Listen(); err != nil {
+		t.Fatal("Listen returned an error", err)
+	}
+
+	uploads.Close()
+
+	served := make(chan error, 1)
+	go func() { served <- uploads.Serve() }()
+
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Error("Serve returned an error", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Serve did not return after the server was closed")
+	}
+}
@@ -0,0 +1,36 @@
+package metadata
+
+// Description is the description of the user defined text frame that carries the JSON document in ID3 tags, which
+// players hand over along with the document.
+const Description = "raspilive"
+
+// ID3 wraps the JSON document in an ID3v2.4 tag as a user defined text frame, the form of timed metadata that HLS and
+// DASH players understand.
+func ID3(data []byte) []byte {
+	// Text encoding, description, and the document, all in UTF-8
+	body := make([]byte, 0, 1+len(Description)+1+len(data))
+	body = append(body, 0x03)
+	body = append(body, Description...)
+	body = append(body, 0x00)
+	body = append(body, data...)
+
+	frame := make([]byte, 0, 10+len(body))
+	frame = append(frame, "TXXX"...)
+	frame = append(frame, synchsafe(len(body))...)
+	frame = append(frame, 0x00, 0x00) // Flags
+	frame = append(frame, body...)
+
+	tag := make([]byte, 0, 10+len(frame))
+	tag = append(tag, "ID3"...)
+	tag = append(tag, 0x04, 0x00) // Version 2.4.0
+	tag = append(tag, 0x00)       // Flags
+	tag = append(tag, synchsafe(len(frame))...)
+	tag = append(tag, frame...)
+
+	return tag
+}
+
+// synchsafe encodes the size with seven bits to each byte, as ID3 does so that sizes never look like MPEG sync words.
+func synchsafe(size int) []byte {
+	return []byte{byte(size>>21) & 0x7F, byte(size>>14) & 0x7F, byte(size>>7) & 0x7F, byte(size) & 0x7F}
+}
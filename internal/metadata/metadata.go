@@ -0,0 +1,69 @@
+package metadata
+
+import (
+	"sync"
+	"time"
+)
+
+// SchemeID3 identifies event messages in fragmented MP4 that carry ID3 tags, which players treat the same as the ID3
+// tags carried in MPEG-TS.
+const SchemeID3 = "https://aomedia.org/emsg/ID3"
+
+// defaultCapacity is the number of documents held by a queue if it is not given a capacity.
+const defaultCapacity = 64
+
+var now = time.Now
+
+// Queue holds timed metadata, such as telemetry from sensors, until the video captured at the same time catches up
+// with it.
+//
+// Documents are stamped with the wall clock time that they arrive at. Queues are safe for concurrent use.
+type Queue struct {
+	Capacity int // Maximum number of documents to hold, dropping the oldest past it
+	mu       sync.Mutex
+	pending  []document
+}
+
+// document is a single piece of metadata waiting for the video.
+type document struct {
+	arrived time.Time
+	data    []byte
+}
+
+// Push adds the JSON document to the queue.
+func (queue *Queue) Push(data []byte) {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	capacity := queue.Capacity
+	if capacity <= 0 {
+		capacity = defaultCapacity
+	}
+
+	queue.pending = append(queue.pending, document{arrived: now(), data: data})
+	if len(queue.pending) > capacity {
+		queue.pending = append([]document{}, queue.pending[len(queue.pending)-capacity:]...)
+	}
+}
+
+// Take removes the documents that arrived by the given time from the queue, returning them oldest first.
+func (queue *Queue) Take(until time.Time) [][]byte {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	n := 0
+	for n < len(queue.pending) && !queue.pending[n].arrived.After(until) {
+		n++
+	}
+	if n == 0 {
+		return nil
+	}
+
+	taken := make([][]byte, n)
+	for i := range taken {
+		taken[i] = queue.pending[i].data
+	}
+	queue.pending = queue.pending[n:]
+
+	return taken
+}
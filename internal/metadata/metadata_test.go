@@ -0,0 +1,71 @@
+package metadata
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestQueue(t *testing.T) {
+	clock := time.Date(2021, 3, 17, 12, 0, 0, 0, time.UTC)
+	now = func() time.Time { return clock }
+	defer func() { now = time.Now }()
+
+	queue := &Queue{}
+	queue.Push([]byte(`{"temperature":21.5}`))
+	clock = clock.Add(time.Second)
+	queue.Push([]byte(`{"door":"open"}`))
+
+	if taken := queue.Take(clock.Add(-time.Second - time.Millisecond)); taken != nil {
+		t.Error("Take returned metadata that arrived later, got", taken)
+	}
+
+	taken := queue.Take(clock.Add(-time.Millisecond))
+	if len(taken) != 1 || string(taken[0]) != `{"temperature":21.5}` {
+		t.Error("Take returned incorrect metadata, got", taken)
+	}
+
+	taken = queue.Take(clock)
+	if len(taken) != 1 || string(taken[0]) != `{"door":"open"}` {
+		t.Error("Take returned incorrect metadata, got", taken)
+	}
+
+	if taken := queue.Take(clock.Add(time.Hour)); taken != nil {
+		t.Error("Take returned metadata that was already taken, got", taken)
+	}
+}
+
+func TestQueueCapacity(t *testing.T) {
+	queue := &Queue{Capacity: 2}
+	queue.Push([]byte("1"))
+	queue.Push([]byte("2"))
+	queue.Push([]byte("3"))
+
+	taken := queue.Take(time.Now().Add(time.Hour))
+	if len(taken) != 2 || string(taken[0]) != "2" || string(taken[1]) != "3" {
+		t.Error("Take did not drop the oldest metadata past the capacity, got", taken)
+	}
+}
+
+func TestID3(t *testing.T) {
+	tag := ID3([]byte(`{"a":1}`))
+
+	expected := []byte{
+		'I', 'D', '3', 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0x1C,
+		'T', 'X', 'X', 'X', 0x00, 0x00, 0x00, 0x12, 0x00, 0x00,
+		0x03, 'r', 'a', 's', 'p', 'i', 'l', 'i', 'v', 'e', 0x00,
+		'{', '"', 'a', '"', ':', '1', '}',
+	}
+	if !bytes.Equal(tag, expected) {
+		t.Errorf("ID3 returned incorrect tag, got % X", tag)
+	}
+}
+
+func TestID3Synchsafe(t *testing.T) {
+	tag := ID3(bytes.Repeat([]byte("x"), 200))
+
+	// 200 bytes of document, 11 bytes of encoding and description, and 10 bytes of frame header
+	if size := tag[6:10]; !bytes.Equal(size, []byte{0x00, 0x00, 0x01, 0x5D}) {
+		t.Errorf("ID3 returned incorrect tag size, got % X", size)
+	}
+}
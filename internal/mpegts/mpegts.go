@@ -0,0 +1,300 @@
+package mpegts
+
+import (
+	"errors"
+	"io"
+)
+
+// PacketSize is the size of a single transport stream packet.
+const PacketSize = 188
+
+// Packet identifiers used by the transport stream.
+const (
+	PATPID   uint16 = 0x0000 // Program association table
+	PMTPID   uint16 = 0x1000 // Program map table
+	VideoPID uint16 = 0x0100 // H.264 video, also carrying the program clock reference
+	ID3PID   uint16 = 0x0102 // Timed metadata in ID3 tags
+)
+
+const (
+	syncByte       = 0x47
+	streamTypeH264 = 0x1B
+	streamTypeID3  = 0x15 // Metadata carried in PES packets
+	streamIDVideo  = 0xE0
+	streamIDID3    = 0xBD // Private stream 1
+	headerSize     = 4
+	payloadSize    = PacketSize - headerSize
+)
+
+// Writer writes H.264 video to an MPEG transport stream.
+//
+// Timestamps are expressed in the 90kHz units used throughout MPEG-TS.
+type Writer struct {
+	Metadata   bool // Whether the program carries a stream of timed ID3 metadata alongside the video
+	w          io.Writer
+	continuity map[uint16]byte
+}
+
+// NewWriter creates a writer that writes the transport stream to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w, continuity: make(map[uint16]byte)}
+}
+
+// Reset switches the output of the transport stream to w.
+//
+// Continuity counters carry over so that consecutive segments may be played back to back.
+func (tsw *Writer) Reset(w io.Writer) {
+	tsw.w = w
+}
+
+// WriteTables writes the program association and program map tables, which must come before any video in order for
+// players to make sense of the stream.
+func (tsw *Writer) WriteTables() error {
+	pat := []byte{
+		0x00,       // Table ID
+		0xB0, 0x0D, // Section syntax indicator, section length
+		0x00, 0x01, // Transport stream ID
+		0xC1,       // Version 0, current
+		0x00, 0x00, // Section number, last section number
+		0x00, 0x01, // Program number
+		0xE0 | byte(PMTPID>>8), byte(PMTPID & 0xFF),
+	}
+	if err := tsw.writeSection(PATPID, pat); err != nil {
+		return err
+	}
+
+	if tsw.Metadata {
+		return tsw.writeSection(PMTPID, metadataPMT())
+	}
+
+	pmt := []byte{
+		0x02,       // Table ID
+		0xB0, 0x12, // Section syntax indicator, section length
+		0x00, 0x01, // Program number
+		0xC1,       // Version 0, current
+		0x00, 0x00, // Section number, last section number
+		0xE0 | byte(VideoPID>>8), byte(VideoPID & 0xFF), // PCR PID
+		0xF0, 0x00, // Program info length
+		streamTypeH264, 0xE0 | byte(VideoPID>>8), byte(VideoPID & 0xFF), 0xF0, 0x00,
+	}
+	return tsw.writeSection(PMTPID, pmt)
+}
+
+// metadataPMT builds the program map table for video accompanied by timed ID3 metadata, described the way that Apple
+// lays out in its timed metadata specification for HTTP Live Streaming.
+func metadataPMT() []byte {
+	return []byte{
+		0x02,       // Table ID
+		0xB0, 0x37, // Section syntax indicator, section length
+		0x00, 0x01, // Program number
+		0xC1,       // Version 0, current
+		0x00, 0x00, // Section number, last section number
+		0xE0 | byte(VideoPID>>8), byte(VideoPID & 0xFF), // PCR PID
+		0xF0, 0x11, // Program info length
+		0x25, 0x0F, // Metadata pointer descriptor
+		0xFF, 0xFF, 'I', 'D', '3', ' ', // Application format
+		0xFF, 'I', 'D', '3', ' ', // Metadata format
+		0x00,       // Metadata service ID
+		0x1F,       // No locator record, carried in the same transport stream
+		0x00, 0x01, // Program number
+		streamTypeH264, 0xE0 | byte(VideoPID>>8), byte(VideoPID & 0xFF), 0xF0, 0x00,
+		streamTypeID3, 0xE0 | byte(ID3PID>>8), byte(ID3PID & 0xFF), 0xF0, 0x0F,
+		0x26, 0x0D, // Metadata descriptor
+		0xFF, 0xFF, 'I', 'D', '3', ' ', // Application format
+		0xFF, 'I', 'D', '3', ' ', // Metadata format
+		0x00, // Metadata service ID
+		0x0F, // No decoder config or DSM-CC
+	}
+}
+
+// WriteVideo writes an H.264 access unit in Annex-B format, to be presented at the provided timestamp.
+//
+// Keyframes are marked as random access points so that players know where they may begin decoding.
+func (tsw *Writer) WriteVideo(pts int64, data []byte, keyframe bool) error {
+	pes := make([]byte, 0, 14+len(data))
+	pes = append(pes,
+		0x00, 0x00, 0x01, streamIDVideo,
+		0x00, 0x00, // Unbounded packet length, permitted for video
+		0x84, // Data alignment indicator
+		0x80, // PTS only
+		0x05, // Header data length
+	)
+	pes = append(pes, encodeTimestamp(0x20, pts)...)
+	pes = append(pes, data...)
+
+	// Give the decoder some time to buffer the frame before presentation
+	return tsw.writePES(VideoPID, pes, pts-pcrDelay, keyframe)
+}
+
+// WriteMetadata writes an ID3 tag of timed metadata, to be presented along with the frame at the provided timestamp.
+//
+// The program must have been set up to carry metadata before writing the tables.
+func (tsw *Writer) WriteMetadata(pts int64, tag []byte) error {
+	// Unlike video, the packet length of metadata must always be given
+	length := 3 + 5 + len(tag)
+	if length > 0xFFFF {
+		return errors.New("mpegts: metadata too large")
+	}
+
+	pes := make([]byte, 0, 6+length)
+	pes = append(pes,
+		0x00, 0x00, 0x01, streamIDID3,
+		byte(length>>8), byte(length),
+		0x84, // Data alignment indicator
+		0x80, // PTS only
+		0x05, // Header data length
+	)
+	pes = append(pes, encodeTimestamp(0x20, pts)...)
+	pes = append(pes, tag...)
+
+	return tsw.writePES(ID3PID, pes, 0, false)
+}
+
+// pcrDelay is the amount of time, in 90kHz units, that the program clock runs behind the presentation timestamps.
+const pcrDelay = 63000
+
+// writeSection writes a program specific information section to a single packet, filling the rest of it with padding.
+func (tsw *Writer) writeSection(pid uint16, section []byte) error {
+	var packet [PacketSize]byte
+	tsw.writeHeader(packet[:], pid, true, false)
+
+	payload := packet[headerSize:]
+	payload[0] = 0x00 // Pointer field
+	n := 1 + copy(payload[1:], section)
+
+	crc := crc32(section)
+	payload[n] = byte(crc >> 24)
+	payload[n+1] = byte(crc >> 16)
+	payload[n+2] = byte(crc >> 8)
+	payload[n+3] = byte(crc)
+
+	for i := n + 4; i < len(payload); i++ {
+		payload[i] = 0xFF
+	}
+
+	_, err := tsw.w.Write(packet[:])
+	return err
+}
+
+// writePES splits the packetized elementary stream into transport stream packets.
+//
+// The first packet of the video carries the program clock reference, and the last packet is stuffed to fill it.
+func (tsw *Writer) writePES(pid uint16, pes []byte, pcr int64, randomAccess bool) error {
+	first := true
+
+	for len(pes) > 0 {
+		var packet [PacketSize]byte
+
+		// Adaptation field contents, not including the length
+		var adaptation []byte
+		if first && pid == VideoPID {
+			flags := byte(0x10) // PCR
+			if randomAccess {
+				flags |= 0x40
+			}
+			adaptation = append([]byte{flags}, encodePCR(pcr)...)
+		}
+
+		minAdaptationSize := 0
+		if adaptation != nil {
+			minAdaptationSize = 1 + len(adaptation)
+		}
+
+		n := len(pes)
+		if n > payloadSize-minAdaptationSize {
+			n = payloadSize - minAdaptationSize
+		}
+		adaptationSize := payloadSize - n
+
+		tsw.writeHeader(packet[:], pid, first, adaptationSize > 0)
+
+		if adaptationSize > 0 {
+			packet[headerSize] = byte(adaptationSize - 1)
+
+			if adaptationSize > 1 {
+				if adaptation == nil {
+					adaptation = []byte{0x00}
+				}
+				copy(packet[headerSize+1:], adaptation)
+
+				// Stuffing
+				for i := headerSize + 1 + len(adaptation); i < headerSize+adaptationSize; i++ {
+					packet[i] = 0xFF
+				}
+			}
+		}
+
+		copy(packet[headerSize+adaptationSize:], pes[:n])
+
+		if _, err := tsw.w.Write(packet[:]); err != nil {
+			return err
+		}
+
+		pes = pes[n:]
+		first = false
+	}
+
+	return nil
+}
+
+// writeHeader writes the transport stream packet header, advancing the continuity counter for the PID.
+func (tsw *Writer) writeHeader(packet []byte, pid uint16, payloadStart bool, adaptation bool) {
+	packet[0] = syncByte
+
+	packet[1] = byte(pid>>8) & 0x1F
+	if payloadStart {
+		packet[1] |= 0x40
+	}
+
+	packet[2] = byte(pid)
+
+	control := byte(0x10) // Payload only
+	if adaptation {
+		control = 0x30
+	}
+
+	cc := tsw.continuity[pid]
+	packet[3] = control | cc
+	tsw.continuity[pid] = (cc + 1) & 0x0F
+}
+
+// encodeTimestamp encodes a 33-bit presentation or decode timestamp, prefixed with the given marker.
+func encodeTimestamp(prefix byte, ts int64) []byte {
+	return []byte{
+		prefix | byte(ts>>29)&0x0E | 0x01,
+		byte(ts >> 22),
+		byte(ts>>14)&0xFE | 0x01,
+		byte(ts >> 7),
+		byte(ts<<1)&0xFE | 0x01,
+	}
+}
+
+// encodePCR encodes a program clock reference, leaving the 27MHz extension at zero.
+func encodePCR(pcr int64) []byte {
+	return []byte{
+		byte(pcr >> 25),
+		byte(pcr >> 17),
+		byte(pcr >> 9),
+		byte(pcr >> 1),
+		byte(pcr<<7)&0x80 | 0x7E,
+		0x00,
+	}
+}
+
+// crc32 calculates the MPEG-2 flavor of CRC-32 used to protect program specific information.
+func crc32(data []byte) uint32 {
+	crc := uint32(0xFFFFFFFF)
+
+	for _, b := range data {
+		crc ^= uint32(b) << 24
+		for i := 0; i < 8; i++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04C11DB7
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+
+	return crc
+}
@@ -0,0 +1,223 @@
+package mpegts
+
+import (
+	"bytes"
+	"testing"
+)
+
+// packets splits the transport stream into packets, failing the test if it is malformed.
+func packets(t *testing.T, stream []byte) [][]byte {
+	if len(stream)%PacketSize != 0 {
+		t.Fatal("Transport stream is not a multiple of the packet size, got", len(stream))
+	}
+
+	var pkts [][]byte
+	for i := 0; i < len(stream); i += PacketSize {
+		pkt := stream[i : i+PacketSize]
+		if pkt[0] != syncByte {
+			t.Fatal("Transport stream packet is missing the sync byte", i/PacketSize)
+		}
+		pkts = append(pkts, pkt)
+	}
+
+	return pkts
+}
+
+// payload returns the payload of the transport stream packet, skipping the adaptation field.
+func payload(pkt []byte) []byte {
+	if pkt[3]&0x20 != 0 {
+		return pkt[headerSize+1+int(pkt[headerSize]):]
+	}
+	return pkt[headerSize:]
+}
+
+func pid(pkt []byte) uint16 {
+	return uint16(pkt[1]&0x1F)<<8 | uint16(pkt[2])
+}
+
+func TestWriteTables(t *testing.T) {
+	var stream bytes.Buffer
+	tsw := NewWriter(&stream)
+
+	if err := tsw.WriteTables(); err != nil {
+		t.Fatal("WriteTables returned an error", err)
+	}
+
+	pkts := packets(t, stream.Bytes())
+	if len(pkts) != 2 {
+		t.Fatal("WriteTables wrote incorrect number of packets, got", len(pkts))
+	}
+
+	expectedPAT := []byte{
+		0x47, 0x40, 0x00, 0x10, 0x00,
+		0x00, 0xB0, 0x0D, 0x00, 0x01, 0xC1, 0x00, 0x00, 0x00, 0x01, 0xF0, 0x00,
+		0x2A, 0xB1, 0x04, 0xB2,
+	}
+	if !bytes.Equal(pkts[0][:len(expectedPAT)], expectedPAT) {
+		t.Errorf("WriteTables wrote incorrect PAT, got % X", pkts[0][:len(expectedPAT)])
+	}
+	if pid(pkts[1]) != PMTPID {
+		t.Error("WriteTables wrote PMT to incorrect PID, got", pid(pkts[1]))
+	}
+
+	for _, pkt := range pkts {
+		section := payload(pkt)[1:]
+		length := int(section[1]&0x0F)<<8 | int(section[2])
+		if crc32(section[:3+length]) != 0 {
+			t.Errorf("WriteTables wrote section with invalid CRC, got % X", section[:3+length])
+		}
+	}
+}
+
+func TestWriteTablesMetadata(t *testing.T) {
+	var stream bytes.Buffer
+	tsw := NewWriter(&stream)
+	tsw.Metadata = true
+
+	if err := tsw.WriteTables(); err != nil {
+		t.Fatal("WriteTables returned an error", err)
+	}
+
+	pkts := packets(t, stream.Bytes())
+	if len(pkts) != 2 {
+		t.Fatal("WriteTables wrote incorrect number of packets, got", len(pkts))
+	}
+
+	section := payload(pkts[1])[1:]
+	length := int(section[1]&0x0F)<<8 | int(section[2])
+	if crc32(section[:3+length]) != 0 {
+		t.Errorf("WriteTables wrote PMT with invalid CRC, got % X", section[:3+length])
+	}
+
+	// Walk the elementary streams past the program info
+	programInfoLength := int(section[10]&0x0F)<<8 | int(section[11])
+	streams := map[uint16]byte{}
+	for i := 12 + programInfoLength; i < 3+length-4; {
+		streams[uint16(section[i+1]&0x1F)<<8|uint16(section[i+2])] = section[i]
+		i += 5 + (int(section[i+3]&0x0F)<<8 | int(section[i+4]))
+	}
+
+	if streams[VideoPID] != streamTypeH264 || streams[ID3PID] != streamTypeID3 || len(streams) != 2 {
+		t.Errorf("WriteTables wrote incorrect streams, got %v", streams)
+	}
+	if !bytes.Contains(section[12:12+programInfoLength], []byte("ID3 ")) {
+		t.Error("WriteTables did not write the metadata pointer descriptor")
+	}
+}
+
+func TestWriteMetadata(t *testing.T) {
+	var stream bytes.Buffer
+	tsw := NewWriter(&stream)
+
+	tag := bytes.Repeat([]byte{0xCD}, 300)
+	if err := tsw.WriteMetadata(126000, tag); err != nil {
+		t.Fatal("WriteMetadata returned an error", err)
+	}
+
+	var pes []byte
+	for i, pkt := range packets(t, stream.Bytes()) {
+		if pid(pkt) != ID3PID {
+			t.Fatal("WriteMetadata wrote to incorrect PID, got", pid(pkt))
+		}
+		if i == 0 && pkt[3]&0x20 != 0 {
+			t.Error("WriteMetadata wrote an adaptation field carrying the program clock reference")
+		}
+		pes = append(pes, payload(pkt)...)
+	}
+
+	expectedHeader := []byte{0x00, 0x00, 0x01, 0xBD, 0x01, 0x34, 0x84, 0x80, 0x05, 0x21, 0x00, 0x07, 0xD8, 0x61}
+	if !bytes.Equal(pes[:len(expectedHeader)], expectedHeader) {
+		t.Errorf("WriteMetadata wrote incorrect PES header, got % X", pes[:len(expectedHeader)])
+	}
+	if !bytes.Equal(pes[len(expectedHeader):], tag) {
+		t.Error("WriteMetadata wrote incorrect tag")
+	}
+}
+
+func TestWriteVideo(t *testing.T) {
+	// Exercise the stuffing around packet boundaries
+	sizes := []int{1, 150, 155, 156, 157, 183, 184, 500, 10000}
+
+	for _, size := range sizes {
+		var stream bytes.Buffer
+		tsw := NewWriter(&stream)
+
+		data := bytes.Repeat([]byte{0xAB}, size)
+		if err := tsw.WriteVideo(126000, data, true); err != nil {
+			t.Fatal("WriteVideo returned an error", err)
+		}
+
+		var pes []byte
+		for i, pkt := range packets(t, stream.Bytes()) {
+			if pid(pkt) != VideoPID {
+				t.Fatal("WriteVideo wrote to incorrect PID, got", pid(pkt))
+			}
+			if int(pkt[3]&0x0F) != i%16 {
+				t.Error("WriteVideo wrote incorrect continuity counter, got", pkt[3]&0x0F)
+			}
+			if (pkt[1]&0x40 != 0) != (i == 0) {
+				t.Error("WriteVideo wrote incorrect payload unit start indicator for packet", i)
+			}
+			pes = append(pes, payload(pkt)...)
+		}
+
+		expectedHeader := []byte{0x00, 0x00, 0x01, 0xE0, 0x00, 0x00, 0x84, 0x80, 0x05, 0x21, 0x00, 0x07, 0xD8, 0x61}
+		if !bytes.Equal(pes[:len(expectedHeader)], expectedHeader) {
+			t.Errorf("WriteVideo wrote incorrect PES header, got % X", pes[:len(expectedHeader)])
+		}
+		if !bytes.Equal(pes[len(expectedHeader):], data) {
+			t.Error("WriteVideo wrote incorrect data for size", size)
+		}
+	}
+}
+
+func TestWriteVideoAdaptationField(t *testing.T) {
+	testCases := []struct {
+		name     string
+		keyframe bool
+		flags    byte
+	}{
+		{"keyframe", true, 0x50},
+		{"non-keyframe", false, 0x10},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var stream bytes.Buffer
+			tsw := NewWriter(&stream)
+
+			if err := tsw.WriteVideo(126000, bytes.Repeat([]byte{0xAB}, 1000), tc.keyframe); err != nil {
+				t.Fatal("WriteVideo returned an error", err)
+			}
+
+			pkt := packets(t, stream.Bytes())[0]
+			if pkt[3]&0x20 == 0 {
+				t.Fatal("WriteVideo did not write an adaptation field")
+			}
+			if pkt[5] != tc.flags {
+				t.Errorf("WriteVideo wrote incorrect adaptation field flags, got %X", pkt[5])
+			}
+
+			pcr := int64(pkt[6])<<25 | int64(pkt[7])<<17 | int64(pkt[8])<<9 | int64(pkt[9])<<1 | int64(pkt[10])>>7
+			if pcr != 126000-pcrDelay {
+				t.Error("WriteVideo wrote incorrect PCR, got", pcr)
+			}
+		})
+	}
+}
+
+func TestReset(t *testing.T) {
+	var first, second bytes.Buffer
+	tsw := NewWriter(&first)
+
+	tsw.WriteVideo(126000, []byte{0xAB}, true)
+	tsw.Reset(&second)
+	tsw.WriteVideo(129000, []byte{0xAB}, false)
+
+	if first.Len() != PacketSize || second.Len() != PacketSize {
+		t.Fatal("Reset did not switch output")
+	}
+	if second.Bytes()[3]&0x0F != 1 {
+		t.Error("Reset did not carry over continuity counter, got", second.Bytes()[3]&0x0F)
+	}
+}
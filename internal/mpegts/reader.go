@@ -0,0 +1,88 @@
+package mpegts
+
+import (
+	"errors"
+	"io"
+)
+
+// ReadVideo reads the transport stream and hands over each H.264 access unit carried on the video PID, in Annex-B
+// format, along with its presentation timestamp. Everything else in the stream is skipped.
+func ReadVideo(r io.Reader, fn func(pts int64, data []byte) error) error {
+	var packet [PacketSize]byte
+	var pes []byte // PES packet being put back together, nil until the start of one shows up
+
+	flush := func() error {
+		if pes == nil {
+			return nil
+		}
+
+		pts, data, err := parsePES(pes)
+		pes = nil
+		if err != nil {
+			return err
+		}
+
+		return fn(pts, data)
+	}
+
+	for {
+		if _, err := io.ReadFull(r, packet[:]); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		if packet[0] != syncByte {
+			return errors.New("mpegts: lost sync")
+		}
+
+		pid := uint16(packet[1]&0x1F)<<8 | uint16(packet[2])
+		if pid != VideoPID {
+			continue
+		}
+
+		control := packet[3] >> 4 & 0x03
+		payload := packet[headerSize:]
+		if control&0x02 != 0 {
+			adaptationSize := 1 + int(payload[0])
+			if adaptationSize > len(payload) {
+				return errors.New("mpegts: invalid adaptation field")
+			}
+			payload = payload[adaptationSize:]
+		}
+		if control&0x01 == 0 {
+			continue
+		}
+
+		if packet[1]&0x40 != 0 {
+			if err := flush(); err != nil {
+				return err
+			}
+			pes = []byte{}
+		}
+		if pes != nil {
+			pes = append(pes, payload...)
+		}
+	}
+
+	return flush()
+}
+
+// parsePES pulls the presentation timestamp and data out of a PES packet.
+func parsePES(pes []byte) (int64, []byte, error) {
+	if len(pes) < 9 || pes[0] != 0x00 || pes[1] != 0x00 || pes[2] != 0x01 {
+		return 0, nil, errors.New("mpegts: invalid PES packet")
+	}
+
+	headerLength := int(pes[8])
+	if pes[7]&0x80 == 0 || headerLength < 5 || len(pes) < 9+headerLength {
+		return 0, nil, errors.New("mpegts: missing presentation timestamp")
+	}
+
+	return decodeTimestamp(pes[9:14]), pes[9+headerLength:], nil
+}
+
+// decodeTimestamp decodes a 33-bit presentation or decode timestamp.
+func decodeTimestamp(b []byte) int64 {
+	return int64(b[0]>>1&0x07)<<30 | int64(b[1])<<22 | int64(b[2]>>1)<<15 | int64(b[3])<<7 | int64(b[4]>>1)
+}
@@ -0,0 +1,63 @@
+package mpegts
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestReadVideo(t *testing.T) {
+	var stream bytes.Buffer
+	tsw := NewWriter(&stream)
+	tsw.Metadata = true
+	tsw.WriteTables()
+
+	frames := [][]byte{
+		bytes.Repeat([]byte{0xAB}, 10000),
+		bytes.Repeat([]byte{0xCD}, 150),
+		bytes.Repeat([]byte{0xEF}, 1),
+	}
+	for i, frame := range frames {
+		pts := int64(126000 + i*3000)
+		tsw.WriteVideo(pts, frame, i == 0)
+		tsw.WriteMetadata(pts, []byte("ID3"))
+	}
+
+	// Timestamps wrap around after 33 bits
+	tsw.WriteVideo(1<<33-1, frames[2], false)
+
+	var read [][]byte
+	var timestamps []int64
+	err := ReadVideo(&stream, func(pts int64, data []byte) error {
+		read = append(read, data)
+		timestamps = append(timestamps, pts)
+		return nil
+	})
+	if err != nil {
+		t.Fatal("ReadVideo returned an error", err)
+	}
+
+	if len(read) != 4 {
+		t.Fatal("ReadVideo read incorrect number of access units, got", len(read))
+	}
+	for i, frame := range frames {
+		if !bytes.Equal(read[i], frame) {
+			t.Errorf("ReadVideo read incorrect data for access unit %d", i)
+		}
+	}
+
+	expectedTimestamps := []int64{126000, 129000, 132000, 1<<33 - 1}
+	for i, pts := range timestamps {
+		if pts != expectedTimestamps[i] {
+			t.Errorf("ReadVideo read incorrect timestamp for access unit %d, got %d", i, pts)
+		}
+	}
+}
+
+func TestReadVideoLostSync(t *testing.T) {
+	stream := bytes.Repeat([]byte{0x00}, PacketSize)
+
+	err := ReadVideo(bytes.NewReader(stream), func(pts int64, data []byte) error { return nil })
+	if err == nil {
+		t.Error("ReadVideo did not return an error")
+	}
+}
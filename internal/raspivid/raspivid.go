@@ -18,6 +18,8 @@ type Options struct {
 	Fps            int  // Framerate of the video
 	HorizontalFlip bool // Flip the video horizontally
 	VerticalFlip   bool // Flip the video vertically
+	InlineHeaders  bool // Insert SPS/PPS headers before every keyframe so that the video may be joined mid-stream
+	IntraPeriod    int  // Number of frames between keyframes
 }
 
 // Stream represents a Raspberry Pi camera video streamer.
@@ -50,6 +52,14 @@ func NewStream(options Options) (*Stream, error) {
 		args = append(args, "--vflip")
 	}
 
+	if options.InlineHeaders {
+		args = append(args, "--inline")
+	}
+
+	if options.IntraPeriod != 0 {
+		args = append(args, "--intra", strconv.Itoa(options.IntraPeriod))
+	}
+
 	cmd := execCommand("raspivid", args...)
 	video, err := cmd.StdoutPipe()
 
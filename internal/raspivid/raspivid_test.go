@@ -81,7 +81,25 @@ func TestNewStream(t *testing.T) {
 			},
 		},
 		{
-			Options{Width: 1280, Height: 720, Fps: 30, HorizontalFlip: true, VerticalFlip: true},
+			Options{InlineHeaders: true},
+			[]string{
+				"raspivid",
+				"-o", "-",
+				"-t", "0",
+				"--inline",
+			},
+		},
+		{
+			Options{IntraPeriod: 30},
+			[]string{
+				"raspivid",
+				"-o", "-",
+				"-t", "0",
+				"--intra", "30",
+			},
+		},
+		{
+			Options{Width: 1280, Height: 720, Fps: 30, HorizontalFlip: true, VerticalFlip: true, InlineHeaders: true},
 			[]string{
 				"raspivid",
 				"-o", "-",
@@ -90,6 +108,7 @@ func TestNewStream(t *testing.T) {
 				"--height", "720",
 				"--framerate", "30",
 				"--hflip", "--vflip",
+				"--inline",
 			},
 		},
 	}
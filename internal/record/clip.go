@@ -0,0 +1,264 @@
+package record
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"filippo.io/age"
+	"github.com/jaredpetersen/raspilive/internal/chain"
+	"github.com/jaredpetersen/raspilive/internal/h264"
+	"github.com/jaredpetersen/raspilive/internal/segment"
+)
+
+// maxClipLength is the longest a clip may run in seconds before it is split in two, for triggers that keep on coming.
+const maxClipLength = 60 * 60
+
+// ClipOptions represents ways that the clipper may be configured.
+type ClipOptions struct {
+	Fps      int           // Framerate of the video
+	PreRoll  time.Duration // Video to keep from before the trigger
+	PostRoll time.Duration // Video to keep from after the trigger, extended by any triggers that come in meanwhile
+	MaxAge   time.Duration // Remove clips that finished longer ago than this, keeping them regardless if zero
+	MaxSize  int64         // Maximum size of all the clips in bytes, removing the oldest past it if not zero
+
+	// Chain keeps a tamper-evident manifest of the clips as they are finished, which must be in the clipper's directory
+	Chain *chain.Chain
+
+	// Recipient encrypts the clips as they are written, named with EncryptedExt on the end, so that only the holder of
+	// the recipient's private key may play them
+	Recipient age.Recipient
+}
+
+// Clipper keeps the last few seconds of video in memory and saves a clip of it to disk whenever it is triggered, so
+// that what just happened is kept without recording all the time.
+//
+// Clips are written the same way as recordings, filed in a directory for each day they were captured on and listed in
+// an index alongside them. They begin on the keyframe that covers the pre-roll and run on through the post-roll after
+// the last trigger. The video stream must be H.264 in the Annex-B format, as produced by raspivid.
+type Clipper struct {
+	Directory string
+	Options   ClipOptions
+	index     *Index
+	mu        sync.Mutex
+	until     time.Time // Wall clock time that the clip runs until, in the past if it has not been triggered
+	gops      []gop     // Groups of pictures kept for the pre-roll, oldest first
+	sps       []byte
+	pps       []byte
+	clip      *segment.Segmenter
+	done      chan struct{}
+	err       error
+}
+
+// gop is a group of pictures, beginning with a keyframe.
+type gop struct {
+	captured time.Time // Wall clock time that the keyframe was captured
+	units    []h264.AccessUnit
+}
+
+// Mux begins buffering the video stream, ready to save clips of it.
+func (clipper *Clipper) Mux(video io.ReadCloser) error {
+	if info, err := os.Stat(clipper.Directory); err != nil || !info.IsDir() {
+		return errors.New("record: invalid directory")
+	}
+
+	clipper.index = &Index{
+		Directory: clipper.Directory,
+		MaxAge:    clipper.Options.MaxAge,
+		MaxSize:   clipper.Options.MaxSize,
+	}
+	if err := clipper.index.Load(); err != nil {
+		return fmt.Errorf("record: failed to load index: %w", err)
+	}
+
+	clipper.done = make(chan struct{})
+
+	go func() {
+		defer close(clipper.done)
+		clipper.err = clipper.mux(video)
+	}()
+
+	return nil
+}
+
+// Wait blocks until the video stream is finished processing by Mux.
+func (clipper *Clipper) Wait() error {
+	if clipper.done == nil {
+		return errors.New("record: not started")
+	}
+
+	<-clipper.done
+
+	return clipper.err
+}
+
+func (clipper *Clipper) String() string {
+	if clipper.done == nil {
+		return ""
+	}
+
+	return fmt.Sprintf("native mp4 clipper (%s)", clipper.Directory)
+}
+
+// Trigger saves a clip of the video from the pre-roll before now through the post-roll after it, extending the clip
+// in progress if there is one. Safe to call from any goroutine.
+func (clipper *Clipper) Trigger() {
+	clipper.mu.Lock()
+	defer clipper.mu.Unlock()
+
+	until := now().Add(clipper.Options.PostRoll)
+	if until.After(clipper.until) {
+		clipper.until = until
+	}
+}
+
+// Clips returns the finished clips, oldest first.
+func (clipper *Clipper) Clips() []Recording {
+	if clipper.index == nil {
+		return nil
+	}
+
+	return clipper.index.Recordings()
+}
+
+// PruneOldest removes the oldest clip to free up space, reporting whether there was one to remove.
+func (clipper *Clipper) PruneOldest() (bool, error) {
+	if clipper.index == nil {
+		return false, nil
+	}
+
+	return clipper.index.PruneOldest()
+}
+
+func (clipper *Clipper) mux(video io.Reader) error {
+	units := h264.NewAccessUnitReader(video)
+
+	for {
+		au, err := units.Read()
+		if err == io.EOF || errors.Is(err, os.ErrClosed) {
+			break
+		}
+		if err != nil {
+			clipper.end()
+			return err
+		}
+
+		if err := clipper.write(au); err != nil {
+			clipper.end()
+			return err
+		}
+	}
+
+	return clipper.end()
+}
+
+// write holds on to the access unit for the pre-roll, and adds it to the clip if one has been triggered.
+func (clipper *Clipper) write(au h264.AccessUnit) error {
+	captured := now()
+
+	for _, nalu := range au.NALUnits {
+		switch h264.Type(nalu) {
+		case h264.TypeSPS:
+			clipper.sps = nalu
+		case h264.TypePPS:
+			clipper.pps = nalu
+		}
+	}
+
+	if au.Keyframe() {
+		clipper.gops = append(clipper.gops, gop{captured: captured})
+	}
+	if len(clipper.gops) == 0 {
+		// Nothing can be decoded until the first keyframe shows up
+		return nil
+	}
+
+	latest := &clipper.gops[len(clipper.gops)-1]
+	latest.units = append(latest.units, au)
+
+	// Keep the group of pictures that the pre-roll starts partway through, since clips have to begin on a keyframe
+	start := captured.Add(-clipper.Options.PreRoll)
+	for len(clipper.gops) > 1 && !clipper.gops[1].captured.After(start) {
+		clipper.gops = clipper.gops[1:]
+	}
+
+	clipper.mu.Lock()
+	until := clipper.until
+	clipper.mu.Unlock()
+
+	if clipper.clip == nil {
+		if !captured.Before(until) {
+			return nil
+		}
+		return clipper.begin()
+	}
+
+	if captured.After(until) {
+		return clipper.end()
+	}
+
+	return clipper.clip.Write(au)
+}
+
+// begin starts a new clip with the video held on to for the pre-roll.
+func (clipper *Clipper) begin() error {
+	if clipper.sps == nil || clipper.pps == nil {
+		return nil
+	}
+
+	format := &segment.MP4{
+		Pattern:     Pattern,
+		Timestamped: true,
+		Daily:       true,
+	}
+
+	var storage segment.Storage = segment.SyncedDir(clipper.Directory)
+	if clipper.Options.Recipient != nil {
+		format.Pattern += EncryptedExt
+		storage = encryptedStorage{Storage: storage, recipient: clipper.Options.Recipient}
+	}
+
+	options := segment.Options{
+		Fps:          clipper.Options.Fps,
+		SegmentTime:  maxClipLength,
+		PlaylistSize: 1,
+		Epoch:        clipper.gops[0].captured,
+	}
+
+	manifests := []segment.Manifest{clipper.index}
+	if clipper.Options.Chain != nil {
+		manifests = append(manifests, clipper.Options.Chain)
+	}
+
+	clipper.clip = segment.New(storage, format, options, manifests...)
+
+	for i, gop := range clipper.gops {
+		for j, au := range gop.units {
+			// The parameter sets only come along at the start of the stream, so the clip needs to be given them
+			if i == 0 && j == 0 {
+				au = h264.AccessUnit{NALUnits: append([][]byte{clipper.sps, clipper.pps}, au.NALUnits...)}
+			}
+
+			if err := clipper.clip.Write(au); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// end finishes the clip in progress, if there is one.
+func (clipper *Clipper) end() error {
+	if clipper.clip == nil {
+		return nil
+	}
+
+	clip := clipper.clip
+	clipper.clip = nil
+
+	return clip.Close()
+}
@@ -0,0 +1,108 @@
+package record
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/jaredpetersen/raspilive/internal/h264"
+)
+
+func TestClipper(t *testing.T) {
+	dir := tempDir(t)
+	epoch := time.Date(2021, 3, 17, 12, 0, 0, 0, time.UTC)
+
+	var clock time.Time
+	now = func() time.Time { return clock }
+	defer func() { now = time.Now }()
+
+	clipper := &Clipper{
+		Directory: dir,
+		Options:   ClipOptions{Fps: 30, PreRoll: 2 * time.Second, PostRoll: 3 * time.Second},
+	}
+
+	video, input := io.Pipe()
+	if err := clipper.Mux(video); err != nil {
+		t.Fatal("Mux returned an error", err)
+	}
+
+	// Frames are captured as they are read, so the clock only moves on once each one has been taken in
+	units := h264.NewAccessUnitReader(bytes.NewReader(fakeVideo(330, 30)))
+	for i := 0; ; i++ {
+		au, err := units.Read()
+		if err != nil {
+			break
+		}
+
+		clock = epoch.Add(time.Duration(i) * time.Second / 30)
+		switch i {
+		case 150, 180, 300:
+			clipper.Trigger()
+		}
+		clipper.write(au)
+	}
+	input.Close()
+
+	if err := clipper.Wait(); err != nil {
+		t.Fatal("Wait returned an error", err)
+	}
+
+	// The first clip begins on the keyframe two seconds before the first trigger and runs until three seconds after
+	// the second, while the next one picks up the pre-roll again
+	clips := clipper.Clips()
+	if len(clips) != 2 {
+		t.Fatal("Clipper saved incorrect number of clips, got", len(clips))
+	}
+
+	expected := []struct {
+		captured time.Time
+		duration float64
+	}{
+		{epoch.Add(3 * time.Second), 181.0 / 30},
+		{epoch.Add(8 * time.Second), 3},
+	}
+	for i, clip := range clips {
+		if !clip.Time.Equal(expected[i].captured) || clip.Duration != expected[i].duration {
+			t.Errorf("Clipper saved incorrect clip %d, got %s lasting %v", i, clip.Time, clip.Duration)
+		}
+
+		data, err := ioutil.ReadFile(path.Join(dir, clip.Name))
+		if err != nil {
+			t.Fatal("Clipper did not write the clip", err)
+		}
+		if !bytes.Contains(data, fakeSPS) || !bytes.Contains(data, fakePPS) {
+			t.Errorf("Clipper did not give clip %d the parameter sets", i)
+		}
+	}
+}
+
+func TestClipperNotTriggered(t *testing.T) {
+	dir := tempDir(t)
+
+	clipper := &Clipper{Directory: dir, Options: ClipOptions{PreRoll: time.Second, PostRoll: time.Second}}
+	if err := clipper.Mux(ioutil.NopCloser(bytes.NewReader(fakeVideo(90, 30)))); err != nil {
+		t.Fatal("Mux returned an error", err)
+	}
+	if err := clipper.Wait(); err != nil {
+		t.Fatal("Wait returned an error", err)
+	}
+
+	if clips := clipper.Clips(); len(clips) != 0 {
+		t.Error("Clipper saved clips without being triggered, got", clips)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 || files[0].Name() != IndexName {
+		t.Error("Clipper wrote files without being triggered")
+	}
+}
+
+func TestClipperInvalidDirectory(t *testing.T) {
+	clipper := &Clipper{Directory: path.Join(os.TempDir(), "raspilive-missing")}
+
+	if err := clipper.Mux(ioutil.NopCloser(bytes.NewReader(nil))); err == nil {
+		t.Error("Mux did not return an error")
+	}
+}
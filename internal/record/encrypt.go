@@ -0,0 +1,124 @@
+package record
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+
+	"filippo.io/age"
+	"github.com/jaredpetersen/raspilive/internal/segment"
+)
+
+// EncryptedExt is the extension added to the names of encrypted recordings.
+const EncryptedExt = ".age"
+
+// ErrTruncated indicates that an encrypted recording was cut short, such as by a crash, or damaged, so only the video up
+// to its last complete fragment before that could be restored.
+var ErrTruncated = errors.New("record: encrypted recording was cut short or damaged")
+
+// encryptedStorage encrypts the files it creates to the recipient as they are written, so that the video never touches
+// the disk in the clear.
+type encryptedStorage struct {
+	segment.Storage
+	recipient age.Recipient
+}
+
+func (storage encryptedStorage) Create(name string) (io.WriteCloser, error) {
+	file, err := storage.Storage.Create(name)
+	if err != nil {
+		return nil, err
+	}
+
+	encrypted, err := age.Encrypt(file, storage.recipient)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &encryptedFile{WriteCloser: encrypted, file: file}, nil
+}
+
+// encryptedFile finishes the encryption before closing the file underneath it.
+type encryptedFile struct {
+	io.WriteCloser
+	file io.WriteCloser
+}
+
+// Sync flushes the file underneath to disk, which holds everything but the chunk that is still being encrypted.
+func (encrypted *encryptedFile) Sync() error {
+	if file, ok := encrypted.file.(interface{ Sync() error }); ok {
+		return file.Sync()
+	}
+
+	return nil
+}
+
+func (encrypted *encryptedFile) Close() error {
+	if err := encrypted.WriteCloser.Close(); err != nil {
+		encrypted.file.Close()
+		return err
+	}
+
+	return encrypted.file.Close()
+}
+
+// Decrypt restores the encrypted recording to a playable MP4 file at the destination, using whichever of the identities
+// it was encrypted to.
+//
+// Recordings that were cut short by a crash are restored up to their last complete fragment, returning ErrTruncated. A
+// chunk cut short can't be told apart from one that was damaged, so the same goes for recordings that were altered.
+func Decrypt(src string, dst string, identities ...age.Identity) error {
+	file, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	decrypted, err := age.Decrypt(file, identities...)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	tmpName := filepath.Join(filepath.Dir(dst), "."+filepath.Base(dst)+".tmp")
+	out, err := os.Create(tmpName)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpName)
+
+	_, copyErr := io.Copy(out, decrypted)
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	// Every chunk up to the one that failed was authenticated, so the video in them can be trusted
+	truncated := false
+	if copyErr != nil {
+		end, err := complete(tmpName)
+		if err != nil {
+			return err
+		}
+		if end == 0 {
+			return ErrTruncated
+		}
+		if err := os.Truncate(tmpName, end); err != nil {
+			return err
+		}
+		truncated = true
+	}
+
+	if err := os.Rename(tmpName, dst); err != nil {
+		return err
+	}
+
+	if truncated {
+		return ErrTruncated
+	}
+
+	return nil
+}
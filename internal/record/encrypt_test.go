@@ -0,0 +1,170 @@
+package record
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/jaredpetersen/raspilive/internal/segment"
+)
+
+func newIdentity(t *testing.T) *age.X25519Identity {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return identity
+}
+
+// recordEncrypted records the fake video to the directory, encrypted to the identity.
+func recordEncrypted(t *testing.T, dir string, identity *age.X25519Identity) []Recording {
+	recorder := Recorder{
+		Directory: dir,
+		Options:   Options{Fps: 30, Length: 2, Recipient: identity.Recipient()},
+	}
+
+	if err := recorder.Mux(ioutil.NopCloser(bytes.NewReader(fakeVideo(150, 30)))); err != nil {
+		t.Fatal("Mux returned an error", err)
+	}
+	if err := recorder.Wait(); err != nil {
+		t.Fatal("Wait returned an error", err)
+	}
+
+	return recorder.Recordings()
+}
+
+func TestMuxEncrypted(t *testing.T) {
+	dir := tempDir(t)
+	identity := newIdentity(t)
+
+	recordings := recordEncrypted(t, dir, identity)
+	if len(recordings) != 3 {
+		t.Fatal("Recorder wrote incorrect number of recordings, got", len(recordings))
+	}
+
+	for _, recording := range recordings {
+		if !strings.HasSuffix(recording.Name, ".mp4"+EncryptedExt) {
+			t.Error("Recorder did not name the encrypted recording, got", recording.Name)
+		}
+
+		data, err := ioutil.ReadFile(path.Join(dir, recording.Name))
+		if err != nil {
+			t.Fatal("Recorder did not write the recording", err)
+		}
+		if bytes.Contains(data, []byte("moov")) || !bytes.HasPrefix(data, []byte("age-encryption.org/v1\n")) {
+			t.Error("Recorder did not encrypt the recording")
+		}
+
+		decrypted := path.Join(tempDir(t), "recording.mp4")
+		if err := Decrypt(path.Join(dir, recording.Name), decrypted, identity); err != nil {
+			t.Fatal("Decrypt returned an error", err)
+		}
+
+		if data = []byte(readFile(t, decrypted)); int64(len(data)) != recording.Size || !bytes.Contains(data, []byte("moov")) {
+			t.Error("Decrypt did not restore the recording")
+		}
+	}
+}
+
+func TestDecryptWrongIdentity(t *testing.T) {
+	dir := tempDir(t)
+	recordings := recordEncrypted(t, dir, newIdentity(t))
+
+	decrypted := path.Join(tempDir(t), "recording.mp4")
+	if err := Decrypt(path.Join(dir, recordings[0].Name), decrypted, newIdentity(t)); err == nil {
+		t.Error("Decrypt did not return an error")
+	}
+
+	if _, err := os.Stat(decrypted); !os.IsNotExist(err) {
+		t.Error("Decrypt wrote a file anyway")
+	}
+}
+
+func TestDecryptTruncated(t *testing.T) {
+	dir := tempDir(t)
+	identity := newIdentity(t)
+	recordings := recordEncrypted(t, dir, identity)
+
+	// Lose the end of the last chunk, the way a power cut would
+	name := path.Join(dir, recordings[0].Name)
+	info, _ := os.Stat(name)
+	os.Truncate(name, info.Size()-100)
+
+	decrypted := path.Join(tempDir(t), "recording.mp4")
+	if err := Decrypt(name, decrypted, identity); err != ErrTruncated {
+		t.Fatal("Decrypt returned incorrect error, got", err)
+	}
+
+	// The recordings are small enough to fit in a single chunk, so there is nothing complete to restore
+	if _, err := os.Stat(decrypted); !os.IsNotExist(err) {
+		t.Error("Decrypt restored video that it could not authenticate")
+	}
+}
+
+func box(boxType string, size int) []byte {
+	data := make([]byte, size)
+	binary.BigEndian.PutUint32(data, uint32(size))
+	copy(data[4:], boxType)
+
+	return data
+}
+
+func TestDecryptTruncatedRestoresCompleteFragments(t *testing.T) {
+	dir := tempDir(t)
+	identity := newIdentity(t)
+
+	// Fragments large enough to span several chunks
+	var recording []byte
+	recording = append(recording, box("ftyp", 24)...)
+	recording = append(recording, box("moov", 1000)...)
+	for i := 0; i < 4; i++ {
+		recording = append(recording, box("moof", 100)...)
+		recording = append(recording, box("mdat", 50000)...)
+	}
+
+	file, err := encryptedStorage{Storage: segment.Dir(dir), recipient: identity.Recipient()}.Create("recording.mp4.age")
+	if err != nil {
+		t.Fatal("Create returned an error", err)
+	}
+	file.Write(recording)
+	file.Close()
+
+	// Lose the last chunk and then some
+	name := path.Join(dir, "recording.mp4.age")
+	info, _ := os.Stat(name)
+	os.Truncate(name, info.Size()-80000)
+
+	decrypted := path.Join(tempDir(t), "recording.mp4")
+	if err := Decrypt(name, decrypted, identity); err != ErrTruncated {
+		t.Fatal("Decrypt returned incorrect error, got", err)
+	}
+
+	// The first chunk covers the first fragment whole
+	expected := recording[:24+1000+100+50000]
+	if data := readFile(t, decrypted); data != string(expected) {
+		t.Error("Decrypt restored incorrect video, got", len(data), "bytes")
+	}
+}
+
+func TestIndexRecoverEncrypted(t *testing.T) {
+	dir := tempDir(t)
+
+	name := "2021/03/17/raspilive-20210317T120000.000Z.mp4" + EncryptedExt
+	writeFile(t, path.Join(dir, "2021/03/17/.raspilive-20210317T120000.000Z.mp4"+EncryptedExt+".tmp"), make([]byte, 100))
+
+	index := &Index{Directory: dir}
+	if err := index.Load(); err != nil {
+		t.Fatal("Load returned an error", err)
+	}
+
+	recordings := index.Recordings()
+	if len(recordings) != 1 || recordings[0].Name != name || recordings[0].Size != 100 {
+		t.Error("Load did not recover the encrypted recording, got", recordings)
+	}
+}
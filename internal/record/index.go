@@ -0,0 +1,292 @@
+package record
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jaredpetersen/raspilive/internal/segment"
+)
+
+// IndexName is the name of the index of recordings kept in the directory.
+const IndexName = "recordings.json"
+
+// Recording is a single MP4 file listed in the index.
+type Recording struct {
+	Name     string    `json:"name"`     // Path of the file relative to the directory
+	Time     time.Time `json:"time"`     // Wall clock time that the first frame was captured
+	Duration float64   `json:"duration"` // Duration in seconds
+	Size     int64     `json:"size"`     // Size in bytes
+}
+
+// End returns the wall clock time that the last frame of the recording finished.
+func (recording Recording) End() time.Time {
+	return recording.Time.Add(time.Duration(recording.Duration * float64(time.Second)))
+}
+
+// Index keeps track of the recordings in a directory, removing the oldest ones past the retention limits as new ones
+// finish.
+//
+// The index is kept in the directory as JSON so that it lasts across restarts and may be read by other tools. Indexes
+// are safe for concurrent use.
+type Index struct {
+	Directory  string
+	MaxAge     time.Duration // Remove recordings that finished longer ago than this, keeping them regardless if zero
+	MaxSize    int64         // Maximum size of all the recordings in bytes, removing the oldest past it if not zero
+	mu         sync.Mutex
+	recordings []Recording
+}
+
+// Load reads the index from the directory, recovering any recordings that were cut short by a crash and forgetting
+// any that were removed by hand, then applies the retention limits.
+func (index *Index) Load() error {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	index.recordings = nil
+
+	data, err := ioutil.ReadFile(path.Join(index.Directory, IndexName))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &index.recordings); err != nil {
+			return err
+		}
+	}
+
+	var recordings []Recording
+	for _, recording := range index.recordings {
+		if _, err := os.Stat(path.Join(index.Directory, recording.Name)); err == nil {
+			recordings = append(recordings, recording)
+		}
+	}
+	index.recordings = recordings
+
+	if err := index.recover(); err != nil {
+		return err
+	}
+
+	index.prune()
+
+	return index.write()
+}
+
+// Update adds the newest segment to the index as a finished recording, then applies the retention limits.
+func (index *Index) Update(segments []segment.Segment, ended bool) error {
+	if len(segments) == 0 {
+		return nil
+	}
+
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	newest := segments[len(segments)-1]
+	if len(index.recordings) > 0 && index.recordings[len(index.recordings)-1].Name == newest.Name {
+		return nil
+	}
+
+	index.recordings = append(index.recordings, Recording{
+		Name:     newest.Name,
+		Time:     newest.Time,
+		Duration: newest.Seconds(),
+		Size:     newest.Size,
+	})
+
+	index.prune()
+
+	return index.write()
+}
+
+// PruneOldest removes the oldest recording to free up space, reporting whether there was one to remove. The newest
+// recording is always kept.
+func (index *Index) PruneOldest() (bool, error) {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	if len(index.recordings) <= 1 {
+		return false, nil
+	}
+
+	index.remove(index.recordings[0].Name)
+	index.recordings = index.recordings[1:]
+
+	return true, index.write()
+}
+
+// Recordings returns the recordings in the index, oldest first.
+func (index *Index) Recordings() []Recording {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	return append([]Recording{}, index.recordings...)
+}
+
+// recover takes in the recordings that were still being written when the recorder last stopped, trimming off the
+// fragment that was cut short and giving them their proper names.
+//
+// Recordings without a single complete fragment are removed since there is nothing in them to play.
+func (index *Index) recover() error {
+	var recovered []Recording
+
+	err := filepath.Walk(index.Directory, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		base := filepath.Base(name)
+		encrypted := strings.HasSuffix(base, ".mp4"+EncryptedExt+".tmp")
+		if info.IsDir() || !strings.HasPrefix(base, ".") || !(strings.HasSuffix(base, ".mp4.tmp") || encrypted) {
+			return nil
+		}
+
+		// Encrypted recordings can't be read back without the private key, so they are trimmed when decrypted instead
+		end := info.Size()
+		if !encrypted {
+			if end, err = complete(name); err != nil {
+				return err
+			}
+		}
+		if end == 0 {
+			return os.Remove(name)
+		}
+		if err := os.Truncate(name, end); err != nil {
+			return err
+		}
+
+		recoveredName := filepath.Join(filepath.Dir(name), strings.TrimSuffix(strings.TrimPrefix(base, "."), ".tmp"))
+		if err := os.Rename(name, recoveredName); err != nil {
+			return err
+		}
+		if err := segment.SyncDir(filepath.Dir(recoveredName)); err != nil {
+			return err
+		}
+
+		relative, err := filepath.Rel(index.Directory, recoveredName)
+		if err != nil {
+			return err
+		}
+
+		// The recording ran up until the file was last written to
+		recording := Recording{Name: filepath.ToSlash(relative), Time: info.ModTime().UTC(), Size: end}
+		if captured, ok := captureTime(recoveredName); ok && captured.Before(info.ModTime()) {
+			recording.Time = captured
+			recording.Duration = info.ModTime().Sub(captured).Seconds()
+		}
+		recovered = append(recovered, recording)
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Recordings are named by capture time, and the walk goes through them in lexical order
+	index.recordings = append(index.recordings, recovered...)
+
+	return nil
+}
+
+// prune removes the oldest recordings until the rest are within the retention limits, always keeping the newest.
+func (index *Index) prune() {
+	var total int64
+	for _, recording := range index.recordings {
+		total += recording.Size
+	}
+
+	cutoff := now().Add(-index.MaxAge)
+	for len(index.recordings) > 1 {
+		oldest := index.recordings[0]
+		expired := index.MaxAge > 0 && oldest.End().Before(cutoff)
+		overSize := index.MaxSize > 0 && total > index.MaxSize
+		if !expired && !overSize {
+			break
+		}
+
+		index.remove(oldest.Name)
+		index.recordings = index.recordings[1:]
+		total -= oldest.Size
+	}
+}
+
+// remove removes the recording from the directory, along with the directories it leaves empty.
+func (index *Index) remove(name string) {
+	os.Remove(path.Join(index.Directory, name))
+
+	for dir := path.Dir(name); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		if err := os.Remove(path.Join(index.Directory, dir)); err != nil {
+			break
+		}
+	}
+}
+
+func (index *Index) write() error {
+	return segment.WriteFile(segment.SyncedDir(index.Directory), IndexName, func(w io.Writer) error {
+		recordings := index.recordings
+		if recordings == nil {
+			recordings = []Recording{}
+		}
+
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(recordings)
+	})
+}
+
+// complete returns the length of the MP4 file through the end of its last complete fragment, or zero if it does not
+// have one.
+func complete(name string) (int64, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	var end, offset int64
+	header := make([]byte, 8)
+	for offset+8 <= info.Size() {
+		if _, err := file.ReadAt(header, offset); err != nil {
+			return 0, err
+		}
+
+		size := int64(binary.BigEndian.Uint32(header))
+		if size < 8 || offset+size > info.Size() {
+			break
+		}
+
+		offset += size
+
+		// Fragments end with their media data
+		if string(header[4:]) == "mdat" {
+			end = offset
+		}
+	}
+
+	return end, nil
+}
+
+// captureTime parses the capture time out of the name of a recording.
+func captureTime(name string) (time.Time, bool) {
+	prefix := strings.Split(Pattern, "%s")[0]
+	suffix := strings.Split(Pattern, "%s")[1]
+
+	base := strings.TrimSuffix(filepath.Base(name), EncryptedExt)
+	captured, err := time.Parse(segment.TimeLayout, strings.TrimSuffix(strings.TrimPrefix(base, prefix), suffix))
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return captured, true
+}
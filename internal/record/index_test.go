@@ -0,0 +1,247 @@
+package record
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/jaredpetersen/raspilive/internal/segment"
+)
+
+func stubNow(t *testing.T, stub time.Time) {
+	now = func() time.Time { return stub }
+	t.Cleanup(func() { now = time.Now })
+}
+
+func writeFile(t *testing.T, name string, data []byte) {
+	if err := os.MkdirAll(path.Dir(name), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(name, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func recordedSegment(name string, captured time.Time, size int64) segment.Segment {
+	return segment.Segment{Name: name, Time: captured, Duration: 600 * segment.Timescale, Size: size}
+}
+
+func TestIndexUpdate(t *testing.T) {
+	dir := tempDir(t)
+	captured := time.Date(2021, 3, 17, 12, 0, 0, 0, time.UTC)
+	stubNow(t, captured)
+
+	index := &Index{Directory: dir}
+	if err := index.Load(); err != nil {
+		t.Fatal("Load returned an error", err)
+	}
+
+	name := "2021/03/17/raspilive-20210317T120000.000Z.mp4"
+	writeFile(t, path.Join(dir, name), make([]byte, 100))
+	if err := index.Update([]segment.Segment{recordedSegment(name, captured, 100)}, false); err != nil {
+		t.Fatal("Update returned an error", err)
+	}
+
+	var listed []Recording
+	if err := json.Unmarshal([]byte(readFile(t, path.Join(dir, IndexName))), &listed); err != nil {
+		t.Fatal("Index is not valid JSON", err)
+	}
+
+	expected := Recording{Name: name, Time: captured, Duration: 600, Size: 100}
+	if len(listed) != 1 || listed[0] != expected {
+		t.Error("Index listed incorrect recordings, got", listed)
+	}
+
+	// The index carries over to the next run
+	reloaded := &Index{Directory: dir}
+	if err := reloaded.Load(); err != nil {
+		t.Fatal("Load returned an error", err)
+	}
+	if recordings := reloaded.Recordings(); len(recordings) != 1 || recordings[0] != expected {
+		t.Error("Load read incorrect recordings, got", recordings)
+	}
+}
+
+func TestIndexMaxAge(t *testing.T) {
+	dir := tempDir(t)
+	start := time.Date(2021, 3, 17, 12, 0, 0, 0, time.UTC)
+	stubNow(t, start.Add(35*time.Minute))
+
+	index := &Index{Directory: dir, MaxAge: 20 * time.Minute}
+	index.Load()
+
+	var segments []segment.Segment
+	for i := 0; i < 3; i++ {
+		captured := start.Add(time.Duration(i) * 10 * time.Minute)
+		name := path.Join(captured.Format("2006/01/02"), "raspilive-"+captured.Format(segment.TimeLayout)+".mp4")
+		writeFile(t, path.Join(dir, name), make([]byte, 100))
+		segments = append(segments, recordedSegment(name, captured, 100))
+	}
+	for i := range segments {
+		index.Update(segments[:i+1], false)
+	}
+
+	// The first recording finished 25 minutes ago, the second 15
+	recordings := index.Recordings()
+	if len(recordings) != 2 || recordings[0].Name != segments[1].Name {
+		t.Error("Index kept incorrect recordings, got", recordings)
+	}
+	if _, err := os.Stat(path.Join(dir, segments[0].Name)); !os.IsNotExist(err) {
+		t.Error("Index did not remove expired recording")
+	}
+}
+
+func TestIndexMaxSize(t *testing.T) {
+	dir := tempDir(t)
+	start := time.Date(2021, 3, 17, 23, 50, 0, 0, time.UTC)
+	stubNow(t, start)
+
+	index := &Index{Directory: dir, MaxSize: 250}
+	index.Load()
+
+	var segments []segment.Segment
+	for i := 0; i < 3; i++ {
+		captured := start.Add(time.Duration(i) * 10 * time.Minute)
+		name := path.Join(captured.Format("2006/01/02"), "raspilive-"+captured.Format(segment.TimeLayout)+".mp4")
+		writeFile(t, path.Join(dir, name), make([]byte, 100))
+		segments = append(segments, recordedSegment(name, captured, 100))
+		index.Update(segments, false)
+	}
+
+	recordings := index.Recordings()
+	if len(recordings) != 2 || recordings[0].Name != segments[1].Name {
+		t.Error("Index kept incorrect recordings, got", recordings)
+	}
+
+	// Days are cleaned up once their recordings are gone
+	if _, err := os.Stat(path.Join(dir, "2021/03/17")); !os.IsNotExist(err) {
+		t.Error("Index did not remove empty directory")
+	}
+	if _, err := os.Stat(path.Join(dir, "2021/03/18")); err != nil {
+		t.Error("Index removed directory that still has recordings")
+	}
+}
+
+func TestIndexPruneOldest(t *testing.T) {
+	dir := tempDir(t)
+	start := time.Date(2021, 3, 17, 12, 0, 0, 0, time.UTC)
+	stubNow(t, start)
+
+	index := &Index{Directory: dir}
+	index.Load()
+
+	var segments []segment.Segment
+	for i := 0; i < 2; i++ {
+		captured := start.Add(time.Duration(i) * 10 * time.Minute)
+		name := "raspilive-" + captured.Format(segment.TimeLayout) + ".mp4"
+		writeFile(t, path.Join(dir, name), make([]byte, 100))
+		segments = append(segments, recordedSegment(name, captured, 100))
+		index.Update(segments, false)
+	}
+
+	if pruned, err := index.PruneOldest(); !pruned || err != nil {
+		t.Fatal("PruneOldest did not remove the oldest recording", err)
+	}
+	if _, err := os.Stat(path.Join(dir, segments[0].Name)); !os.IsNotExist(err) {
+		t.Error("PruneOldest did not remove the oldest recording from disk")
+	}
+
+	// The newest recording is always kept
+	if pruned, _ := index.PruneOldest(); pruned {
+		t.Error("PruneOldest removed the newest recording")
+	}
+	if recordings := index.Recordings(); len(recordings) != 1 || recordings[0].Name != segments[1].Name {
+		t.Error("Index kept incorrect recordings, got", recordings)
+	}
+}
+
+func TestIndexKeepsNewest(t *testing.T) {
+	dir := tempDir(t)
+	stubNow(t, time.Date(2021, 3, 17, 12, 0, 0, 0, time.UTC))
+
+	index := &Index{Directory: dir, MaxSize: 50}
+	index.Load()
+
+	writeFile(t, path.Join(dir, "raspilive-1.mp4"), make([]byte, 100))
+	index.Update([]segment.Segment{recordedSegment("raspilive-1.mp4", now(), 100)}, false)
+
+	if recordings := index.Recordings(); len(recordings) != 1 {
+		t.Error("Index removed the newest recording, got", recordings)
+	}
+}
+
+func TestIndexLoadForgetsRemovedRecordings(t *testing.T) {
+	dir := tempDir(t)
+	stubNow(t, time.Date(2021, 3, 17, 12, 0, 0, 0, time.UTC))
+
+	index := &Index{Directory: dir}
+	index.Load()
+	for _, name := range []string{"raspilive-1.mp4", "raspilive-2.mp4"} {
+		writeFile(t, path.Join(dir, name), make([]byte, 100))
+		index.Update([]segment.Segment{recordedSegment(name, now(), 100)}, false)
+	}
+
+	os.Remove(path.Join(dir, "raspilive-1.mp4"))
+
+	reloaded := &Index{Directory: dir}
+	if err := reloaded.Load(); err != nil {
+		t.Fatal("Load returned an error", err)
+	}
+	if recordings := reloaded.Recordings(); len(recordings) != 1 || recordings[0].Name != "raspilive-2.mp4" {
+		t.Error("Load kept incorrect recordings, got", recordings)
+	}
+}
+
+func TestIndexLoadRecovers(t *testing.T) {
+	dir := tempDir(t)
+	stubNow(t, time.Date(2021, 3, 17, 12, 0, 0, 0, time.UTC))
+
+	// Record a few seconds, then cut the recording short partway through a fragment as a crash would
+	var recording bytes.Buffer
+	format := &segment.MP4{}
+	format.Begin(&recording, 0, 0)
+	for i := 0; i < 3; i++ {
+		format.Write(int64(i)*segment.Timescale, segment.Timescale, [][]byte{fakeSPS, fakePPS, fakeIDR}, true)
+	}
+	format.End()
+	data := recording.Bytes()
+	complete := int64(len(data))
+	data = append(data, data[len(data)-40:len(data)-10]...)
+
+	captured := time.Date(2021, 3, 17, 11, 50, 0, 0, time.UTC)
+	tmpName := path.Join(dir, "2021/03/17/.raspilive-20210317T115000.000Z.mp4.tmp")
+	writeFile(t, tmpName, data)
+	os.Chtimes(tmpName, captured.Add(time.Minute), captured.Add(time.Minute))
+
+	// Nothing can be played from a recording that never got through its first fragment
+	emptyName := path.Join(dir, "2021/03/17/.raspilive-20210317T115500.000Z.mp4.tmp")
+	writeFile(t, emptyName, recording.Bytes()[:100])
+
+	index := &Index{Directory: dir}
+	if err := index.Load(); err != nil {
+		t.Fatal("Load returned an error", err)
+	}
+
+	expected := Recording{Name: "2021/03/17/raspilive-20210317T115000.000Z.mp4", Time: captured, Duration: 60, Size: complete}
+	if recordings := index.Recordings(); len(recordings) != 1 || recordings[0] != expected {
+		t.Error("Load recovered incorrect recordings, got", recordings)
+	}
+
+	recovered, err := ioutil.ReadFile(path.Join(dir, expected.Name))
+	if err != nil {
+		t.Fatal("Load did not rename the recovered recording", err)
+	}
+	if !bytes.Equal(recovered, recording.Bytes()) {
+		t.Error("Load did not trim the incomplete fragment from the recovered recording")
+	}
+
+	for _, name := range []string{tmpName, emptyName} {
+		if _, err := os.Stat(name); !os.IsNotExist(err) {
+			t.Error("Load left temporary file behind", name)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package record
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"filippo.io/age"
+	"github.com/jaredpetersen/raspilive/internal/chain"
+	"github.com/jaredpetersen/raspilive/internal/segment"
+)
+
+// Pattern is the format of the recording file names, given the capture time in segment.TimeLayout.
+const Pattern = "raspilive-%s.mp4"
+
+// Defaults used if a value is not provided.
+const (
+	defaultFps    = 30
+	defaultLength = 600
+)
+
+var now = time.Now
+
+// Options represents ways that the recorder may be configured.
+type Options struct {
+	Fps     int           // Framerate of the video
+	Length  int           // Target length of each recording in seconds, cut on the first keyframe past it
+	MaxAge  time.Duration // Remove recordings that finished longer ago than this, keeping them regardless if zero
+	MaxSize int64         // Maximum size of all the recordings in bytes, removing the oldest past it if not zero
+
+	// Chain keeps a tamper-evident manifest of the recordings as they are finished, which must be in the recorder's
+	// directory
+	Chain *chain.Chain
+
+	// Recipient encrypts the recordings as they are written, named with EncryptedExt on the end, so that only the holder
+	// of the recipient's private key may play them
+	Recipient age.Recipient
+}
+
+// Recorder writes video into rolling MP4 recordings of a fixed length, filed in a directory for each day they were
+// captured on and listed in an index alongside them.
+//
+// Recordings are fragmented MP4, with each fragment flushed to disk as it is written, so that a crash or power loss
+// costs no more than the last few seconds of video; the recording that was cut short is recovered the next time the
+// recorder starts. The video stream must be H.264 in the
+// Annex-B format, as produced by raspivid, and is copied into the recordings as is.
+type Recorder struct {
+	Directory string
+	Options   Options
+	index     *Index
+	done      chan struct{}
+	err       error
+}
+
+// Mux begins recording the video stream.
+func (recorder *Recorder) Mux(video io.ReadCloser) error {
+	if info, err := os.Stat(recorder.Directory); err != nil || !info.IsDir() {
+		return errors.New("record: invalid directory")
+	}
+
+	recorder.index = &Index{
+		Directory: recorder.Directory,
+		MaxAge:    recorder.Options.MaxAge,
+		MaxSize:   recorder.Options.MaxSize,
+	}
+	if err := recorder.index.Load(); err != nil {
+		return fmt.Errorf("record: failed to load index: %w", err)
+	}
+
+	recorder.done = make(chan struct{})
+
+	go func() {
+		defer close(recorder.done)
+		recorder.err = recorder.mux(video)
+	}()
+
+	return nil
+}
+
+// Wait blocks until the video stream is finished processing by Mux.
+func (recorder *Recorder) Wait() error {
+	if recorder.done == nil {
+		return errors.New("record: not started")
+	}
+
+	<-recorder.done
+
+	return recorder.err
+}
+
+func (recorder *Recorder) String() string {
+	if recorder.done == nil {
+		return ""
+	}
+
+	return fmt.Sprintf("native mp4 recorder (%s)", recorder.Directory)
+}
+
+// Recordings returns the finished recordings, oldest first.
+func (recorder *Recorder) Recordings() []Recording {
+	if recorder.index == nil {
+		return nil
+	}
+
+	return recorder.index.Recordings()
+}
+
+// PruneOldest removes the oldest recording to free up space, reporting whether there was one to remove.
+func (recorder *Recorder) PruneOldest() (bool, error) {
+	if recorder.index == nil {
+		return false, nil
+	}
+
+	return recorder.index.PruneOldest()
+}
+
+func (recorder *Recorder) mux(video io.ReadCloser) error {
+	fps := recorder.Options.Fps
+	if fps <= 0 {
+		fps = defaultFps
+	}
+
+	length := recorder.Options.Length
+	if length <= 0 {
+		length = defaultLength
+	}
+
+	format := &segment.MP4{
+		Pattern:     Pattern,
+		Timestamped: true,
+		Daily:       true,
+	}
+
+	var storage segment.Storage = segment.SyncedDir(recorder.Directory)
+	if recorder.Options.Recipient != nil {
+		format.Pattern += EncryptedExt
+		storage = encryptedStorage{Storage: storage, recipient: recorder.Options.Recipient}
+	}
+
+	// The index takes care of retention, since it knows about the recordings from earlier runs too
+	options := segment.Options{
+		Fps:          fps,
+		SegmentTime:  length,
+		PlaylistSize: 1,
+	}
+
+	manifests := []segment.Manifest{recorder.index}
+	if recorder.Options.Chain != nil {
+		manifests = append(manifests, recorder.Options.Chain)
+	}
+
+	seg := segment.New(storage, format, options, manifests...)
+
+	return seg.Feed(video)
+}
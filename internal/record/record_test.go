@@ -0,0 +1,153 @@
+package record
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/jaredpetersen/raspilive/internal/chain"
+	"github.com/jaredpetersen/raspilive/internal/h264"
+)
+
+var (
+	fakeSPS = []byte{
+		0x67, 0x64, 0x00, 0x1F, 0xAC, 0xD9, 0x40, 0x50, 0x05, 0xBB, 0x01, 0x10, 0x00, 0x00, 0x03, 0x00,
+		0x10, 0x00, 0x00, 0x03, 0x03, 0xC0, 0xF1, 0x83, 0x19, 0x60,
+	}
+	fakePPS    = []byte{0x68, 0xEE, 0x3C, 0x80}
+	fakeIDR    = []byte{0x65, 0x88, 0x84}
+	fakeNonIDR = []byte{0x41, 0x9A, 0x02}
+)
+
+// fakeVideo builds an Annex-B stream with a keyframe every keyframeInterval frames.
+func fakeVideo(frames int, keyframeInterval int) []byte {
+	var stream []byte
+	for _, nalu := range [][]byte{fakeSPS, fakePPS} {
+		stream = append(stream, h264.StartCode...)
+		stream = append(stream, nalu...)
+	}
+
+	for i := 0; i < frames; i++ {
+		nalu := fakeNonIDR
+		if i%keyframeInterval == 0 {
+			nalu = fakeIDR
+		}
+		stream = append(stream, h264.StartCode...)
+		stream = append(stream, nalu...)
+	}
+
+	return stream
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "raspilive-record")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	return dir
+}
+
+func TestMux(t *testing.T) {
+	dir := tempDir(t)
+
+	recorder := Recorder{
+		Directory: dir,
+		Options:   Options{Fps: 30, Length: 2},
+	}
+
+	if err := recorder.Mux(ioutil.NopCloser(bytes.NewReader(fakeVideo(150, 30)))); err != nil {
+		t.Fatal("Mux returned an error", err)
+	}
+	if err := recorder.Wait(); err != nil {
+		t.Fatal("Wait returned an error", err)
+	}
+
+	recordings := recorder.Recordings()
+	if len(recordings) != 3 {
+		t.Fatal("Recorder wrote incorrect number of recordings, got", len(recordings))
+	}
+
+	for i, recording := range recordings {
+		if !strings.HasPrefix(recording.Name, recording.Time.Format("2006/01/02")+"/raspilive-") {
+			t.Error("Recorder did not file the recording by day, got", recording.Name)
+		}
+
+		expectedDuration := 2.0
+		if i == 2 {
+			expectedDuration = 1
+		}
+		if recording.Duration != expectedDuration {
+			t.Errorf("Recorder listed incorrect duration for recording %d, got %v", i, recording.Duration)
+		}
+
+		data, err := ioutil.ReadFile(path.Join(dir, recording.Name))
+		if err != nil {
+			t.Fatal("Recorder did not write the recording", err)
+		}
+		if int64(len(data)) != recording.Size {
+			t.Errorf("Recorder listed incorrect size for recording %d, got %d", i, recording.Size)
+		}
+		if !bytes.Contains(data, []byte("moov")) || bytes.Count(data, []byte("moof")) != int(expectedDuration) {
+			t.Errorf("Recorder did not write a fragment per keyframe to recording %d", i)
+		}
+	}
+
+	if !strings.Contains(readFile(t, path.Join(dir, IndexName)), recordings[2].Name) {
+		t.Error("Recorder did not write the index")
+	}
+}
+
+func TestMuxChain(t *testing.T) {
+	dir := tempDir(t)
+	publicKey, privateKey, _ := ed25519.GenerateKey(nil)
+
+	recorder := Recorder{
+		Directory: dir,
+		Options:   Options{Fps: 30, Length: 2, Chain: &chain.Chain{Directory: dir, Key: privateKey}},
+	}
+
+	if err := recorder.Mux(ioutil.NopCloser(bytes.NewReader(fakeVideo(150, 30)))); err != nil {
+		t.Fatal("Mux returned an error", err)
+	}
+	if err := recorder.Wait(); err != nil {
+		t.Fatal("Wait returned an error", err)
+	}
+
+	report, err := chain.Verify(dir, publicKey)
+	if err != nil {
+		t.Fatal("Recorder did not write the chain", err)
+	}
+	if !report.OK() || len(report.Verified) != 3 || len(report.Unlisted) != 0 {
+		t.Error("Recorder did not chain the recordings, got", report)
+	}
+}
+
+func TestMuxInvalidDirectory(t *testing.T) {
+	recorder := Recorder{Directory: path.Join(tempDir(t), "missing")}
+
+	if err := recorder.Mux(ioutil.NopCloser(bytes.NewReader(nil))); err == nil {
+		t.Error("Mux did not return an error")
+	}
+}
+
+func TestWaitNotStarted(t *testing.T) {
+	recorder := Recorder{}
+
+	if err := recorder.Wait(); err == nil {
+		t.Error("Wait did not return an error")
+	}
+}
+
+func readFile(t *testing.T, name string) string {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal("Failed to read file", err)
+	}
+	return string(data)
+}
@@ -0,0 +1,305 @@
+package s3
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MinPartSize is the smallest size that S3 accepts for every part of a multipart upload except the last.
+const MinPartSize = 5 * 1024 * 1024
+
+// defaultTimeout is the amount of time a request has to finish if the client is not given an HTTP client.
+const defaultTimeout = 5 * time.Minute
+
+var now = time.Now
+
+// Client is a minimal client for S3-compatible object storage, such as Amazon S3 or MinIO, covering just what is needed
+// to upload files.
+//
+// Requests are signed with AWS Signature Version 4 and address the bucket in the path rather than the host name, which
+// every S3-compatible service supports.
+type Client struct {
+	Endpoint   string       // Base URL of the service, such as https://s3.us-east-1.amazonaws.com or http://localhost:9000
+	Region     string       // Region the bucket is in, which MinIO defaults to us-east-1
+	Bucket     string       // Bucket to upload objects to
+	AccessKey  string       // Access key ID to sign requests with
+	SecretKey  string       // Secret access key to sign requests with
+	HTTPClient *http.Client // Client used to make requests. Uses one with a generous timeout if not provided.
+}
+
+// Error is an error response from the service.
+type Error struct {
+	StatusCode int
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+}
+
+func (err *Error) Error() string {
+	if err.Code == "" {
+		return fmt.Sprintf("s3: %d %s", err.StatusCode, http.StatusText(err.StatusCode))
+	}
+
+	return fmt.Sprintf("s3: %d %s: %s", err.StatusCode, err.Code, err.Message)
+}
+
+// Temporary reports whether the request may succeed if it is tried again.
+func (err *Error) Temporary() bool {
+	return err.StatusCode >= 500 || err.StatusCode == http.StatusTooManyRequests || err.Code == "RequestTimeout" ||
+		err.Code == "SlowDown"
+}
+
+// PutObject uploads the object in a single request.
+func (client *Client) PutObject(key string, body []byte, header http.Header) error {
+	_, err := client.do(http.MethodPut, key, nil, header, body)
+	return err
+}
+
+// CreateMultipartUpload begins a multipart upload of the object, returning the ID of the upload.
+func (client *Client) CreateMultipartUpload(key string, header http.Header) (string, error) {
+	resp, err := client.do(http.MethodPost, key, url.Values{"uploads": {""}}, header, nil)
+	if err != nil {
+		return "", err
+	}
+
+	var result struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := xml.Unmarshal(resp, &result); err != nil || result.UploadID == "" {
+		return "", fmt.Errorf("s3: invalid response to create multipart upload")
+	}
+
+	return result.UploadID, nil
+}
+
+// UploadPart uploads a part of a multipart upload, numbered from one, returning the part's entity tag.
+func (client *Client) UploadPart(key string, uploadID string, number int, body []byte) (string, error) {
+	query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {uploadID}}
+
+	var etag string
+	_, err := client.request(http.MethodPut, key, query, nil, body, func(resp *http.Response) {
+		etag = resp.Header.Get("ETag")
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return etag, nil
+}
+
+// CompleteMultipartUpload finishes a multipart upload, putting the object together from the parts with the given
+// entity tags in order.
+func (client *Client) CompleteMultipartUpload(key string, uploadID string, etags []string) error {
+	type part struct {
+		PartNumber int
+		ETag       string
+	}
+	complete := struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []part   `xml:"Part"`
+	}{}
+	for i, etag := range etags {
+		complete.Parts = append(complete.Parts, part{PartNumber: i + 1, ETag: etag})
+	}
+
+	body, err := xml.Marshal(complete)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.do(http.MethodPost, key, url.Values{"uploadId": {uploadID}}, nil, body)
+	if err != nil {
+		return err
+	}
+
+	// The service may run into an error after it has already responded with a success status
+	if s3Err := parseError(http.StatusOK, resp); s3Err != nil {
+		return s3Err
+	}
+
+	return nil
+}
+
+// AbortMultipartUpload abandons a multipart upload, freeing up the parts uploaded so far.
+func (client *Client) AbortMultipartUpload(key string, uploadID string) error {
+	_, err := client.do(http.MethodDelete, key, url.Values{"uploadId": {uploadID}}, nil, nil)
+	return err
+}
+
+func (client *Client) do(method string, key string, query url.Values, header http.Header, body []byte) ([]byte, error) {
+	return client.request(method, key, query, header, body, nil)
+}
+
+// request makes a signed request for the object, returning the response body if it succeeds.
+func (client *Client) request(method string, key string, query url.Values, header http.Header, body []byte, fn func(*http.Response)) ([]byte, error) {
+	endpoint, err := url.Parse(client.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("s3: invalid endpoint: %w", err)
+	}
+
+	path := strings.TrimSuffix(endpoint.Path, "/") + "/" + uriEncode(client.Bucket, false) + "/" + uriEncode(key, true)
+	target := endpoint.Scheme + "://" + endpoint.Host + path
+	if len(query) > 0 {
+		target += "?" + canonicalQuery(query)
+	}
+
+	req, err := http.NewRequest(method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	client.sign(req, path, query, body)
+
+	httpClient := client.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: defaultTimeout}
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		if s3Err := parseError(resp.StatusCode, data); s3Err != nil {
+			return nil, s3Err
+		}
+		return nil, &Error{StatusCode: resp.StatusCode}
+	}
+
+	if fn != nil {
+		fn(resp)
+	}
+
+	return data, nil
+}
+
+// sign signs the request with AWS Signature Version 4, covering every header set on it.
+func (client *Client) sign(req *http.Request, path string, query url.Values, body []byte) {
+	signed := now().UTC()
+	date := signed.Format("20060102")
+	scope := date + "/" + client.Region + "/s3/aws4_request"
+
+	payloadHash := sha256.Sum256(body)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+	req.Header.Set("X-Amz-Date", signed.Format("20060102T150405Z"))
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		canonicalQuery(query),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+	canonicalHash := sha256.Sum256([]byte(canonicalRequest))
+
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		signed.Format("20060102T150405Z"),
+		scope,
+		hex.EncodeToString(canonicalHash[:]),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+client.SecretKey), date)
+	key = hmacSHA256(key, client.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		client.AccessKey, scope, signedHeaders, signature))
+}
+
+// parseError reads the error out of a response body, if there is one.
+func parseError(statusCode int, data []byte) *Error {
+	s3Err := &Error{}
+	if err := xml.Unmarshal(data, s3Err); err != nil || s3Err.Code == "" {
+		return nil
+	}
+
+	// Errors that come with a success status are to be tried again
+	if statusCode < 300 {
+		statusCode = http.StatusInternalServerError
+	}
+	s3Err.StatusCode = statusCode
+
+	return s3Err
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// canonicalQuery encodes the query parameters sorted by name, as they are signed.
+func canonicalQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var params []string
+	for _, name := range names {
+		for _, value := range query[name] {
+			params = append(params, uriEncode(name, false)+"="+uriEncode(value, false))
+		}
+	}
+
+	return strings.Join(params, "&")
+}
+
+// uriEncode percent-encodes everything but the unreserved characters, as signatures require, optionally leaving
+// slashes alone for object keys.
+func uriEncode(s string, keepSlash bool) string {
+	var encoded strings.Builder
+	for _, b := range []byte(s) {
+		unreserved := (b >= 'A' && b <= 'Z') || (b >= 'a' && b <= 'z') || (b >= '0' && b <= '9') ||
+			b == '-' || b == '_' || b == '.' || b == '~'
+		if unreserved || (keepSlash && b == '/') {
+			encoded.WriteByte(b)
+		} else {
+			fmt.Fprintf(&encoded, "%%%02X", b)
+		}
+	}
+
+	return encoded.String()
+}
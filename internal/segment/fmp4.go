@@ -0,0 +1,125 @@
+package segment
+
+import (
+	"io"
+	"time"
+
+	"github.com/jaredpetersen/raspilive/internal/fmp4"
+	"github.com/jaredpetersen/raspilive/internal/h264"
+	"github.com/jaredpetersen/raspilive/internal/metadata"
+)
+
+// FMP4 packages video into fragmented MP4 segments suitable for both HLS and DASH, each made up of a single fragment.
+//
+// The initialization segment is written alongside the segments once the first keyframe shows up.
+type FMP4 struct {
+	Storage     Storage // Where to write the initialization segment
+	InitName    string  // File name of the initialization segment
+	Pattern     string  // Format of the segment file names, given the sequence number
+	Timestamped bool    // Give Pattern the capture time in TimeLayout instead of the sequence number
+	w           io.Writer
+	fragment    fmp4.Fragment
+	sps         []byte
+	pps         []byte
+	events      uint32 // Number of events written so far
+}
+
+// Name returns the file name of the segment with the given sequence number, or capture time if timestamped.
+func (format *FMP4) Name(number int, captured time.Time) string {
+	return name(format.Pattern, format.Timestamped, number, captured)
+}
+
+// Begin starts a new segment.
+func (format *FMP4) Begin(w io.Writer, number int, pts int64) error {
+	format.w = w
+	format.fragment = fmp4.Fragment{
+		Sequence:            uint32(number + 1),
+		BaseMediaDecodeTime: uint64(pts),
+	}
+
+	return nil
+}
+
+// Write adds a frame to the current segment.
+//
+// The parameter sets are kept out of the frame and in the initialization segment, which is rewritten if they change.
+func (format *FMP4) Write(pts int64, duration int64, nalus [][]byte, keyframe bool) error {
+	var sps, pps []byte
+	var frame [][]byte
+
+	for _, nalu := range nalus {
+		switch h264.Type(nalu) {
+		case h264.TypeSPS:
+			sps = nalu
+		case h264.TypePPS:
+			pps = nalu
+		default:
+			frame = append(frame, nalu)
+		}
+	}
+
+	if sps != nil && pps != nil && (string(sps) != string(format.sps) || string(pps) != string(format.pps)) {
+		if err := format.writeInit(sps, pps); err != nil {
+			return err
+		}
+	}
+
+	format.fragment.Samples = append(format.fragment.Samples, fmp4.Sample{
+		Duration: uint32(duration),
+		Keyframe: keyframe,
+		Data:     fmp4.SampleData(frame),
+	})
+
+	return nil
+}
+
+// WriteMetadata adds the JSON document to the current segment in an ID3 tag, carried by an event message ahead of the
+// fragment.
+func (format *FMP4) WriteMetadata(pts int64, data []byte) error {
+	format.fragment.Events = append(format.fragment.Events, fmp4.Event{
+		Scheme:           metadata.SchemeID3,
+		Timescale:        Timescale,
+		PresentationTime: uint64(pts),
+		ID:               format.events,
+		Data:             metadata.ID3(data),
+	})
+	format.events++
+
+	return nil
+}
+
+// End finishes the current segment, writing out the fragment.
+func (format *FMP4) End() error {
+	err := fmp4.WriteFragment(format.w, format.fragment)
+	format.fragment.Samples = nil
+	format.fragment.Events = nil
+
+	return err
+}
+
+func (format *FMP4) writeInit(sps []byte, pps []byte) error {
+	params, err := h264.ParseSPS(sps)
+	if err != nil {
+		return err
+	}
+
+	track := fmp4.Track{
+		Width:     params.Width,
+		Height:    params.Height,
+		Timescale: Timescale,
+		SPS:       sps,
+		PPS:       pps,
+	}
+
+	err = WriteFile(format.Storage, format.InitName, func(w io.Writer) error {
+		return fmp4.WriteInit(w, track)
+	})
+	if err != nil {
+		return err
+	}
+
+	format.sps = append([]byte{}, sps...)
+	format.pps = append([]byte{}, pps...)
+
+	return nil
+}
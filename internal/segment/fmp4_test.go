@@ -0,0 +1,92 @@
+package segment
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestFMP4(t *testing.T) {
+	dir := tempDir(t)
+
+	format := &FMP4{Storage: Dir(dir), InitName: "init.m4s", Pattern: "raspilive-%d.m4s"}
+
+	if name := format.Name(3, time.Time{}); name != "raspilive-3.m4s" {
+		t.Error("Name returned incorrect value, got", name)
+	}
+
+	var segment bytes.Buffer
+	format.Begin(&segment, 0, ptsOffset)
+	format.Write(ptsOffset, 3000, [][]byte{fakeSPS, fakePPS, fakeIDR}, true)
+	format.Write(ptsOffset+3000, 3000, [][]byte{fakeNonIDR}, false)
+	if err := format.End(); err != nil {
+		t.Fatal("End returned an error", err)
+	}
+
+	init, err := ioutil.ReadFile(path.Join(dir, "init.m4s"))
+	if err != nil {
+		t.Fatal("FMP4 did not write initialization segment", err)
+	}
+	if !bytes.Contains(init, fakeSPS) || !bytes.Contains(init, fakePPS) {
+		t.Error("FMP4 did not include parameter sets in initialization segment")
+	}
+
+	// Parameter sets live in the initialization segment rather than the media data
+	data := segment.Bytes()
+	mdat := bytes.Index(data, []byte("mdat"))
+	if mdat < 0 {
+		t.Fatal("FMP4 did not write media data")
+	}
+
+	expected := []byte{0x00, 0x00, 0x00, 0x03}
+	expected = append(expected, fakeIDR...)
+	expected = append(expected, 0x00, 0x00, 0x00, 0x03)
+	expected = append(expected, fakeNonIDR...)
+	if !bytes.Equal(data[mdat+4:], expected) {
+		t.Errorf("FMP4 wrote incorrect media data, got % X", data[mdat+4:])
+	}
+
+	tfdt := bytes.Index(data, []byte("tfdt"))
+	if decodeTime := binary.BigEndian.Uint64(data[tfdt+8:]); decodeTime != ptsOffset {
+		t.Error("FMP4 wrote incorrect base media decode time, got", decodeTime)
+	}
+}
+
+func TestFMP4Metadata(t *testing.T) {
+	format := &FMP4{Storage: Dir(tempDir(t)), InitName: "init.m4s", Pattern: "raspilive-%d.m4s"}
+
+	var first, second bytes.Buffer
+	format.Begin(&first, 0, ptsOffset)
+	format.WriteMetadata(ptsOffset, []byte(`{"door":"open"}`))
+	format.Write(ptsOffset, 3000, [][]byte{fakeSPS, fakePPS, fakeIDR}, true)
+	format.End()
+	format.Begin(&second, 1, ptsOffset+3000)
+	format.WriteMetadata(ptsOffset+3000, []byte(`{"door":"closed"}`))
+	format.Write(ptsOffset+3000, 3000, [][]byte{fakeNonIDR}, false)
+	format.End()
+
+	for i, segment := range [][]byte{first.Bytes(), second.Bytes()} {
+		// Event messages come ahead of the movie fragment
+		emsg := bytes.Index(segment, []byte("emsg"))
+		if emsg < 0 || emsg > bytes.Index(segment, []byte("moof")) {
+			t.Fatal("FMP4 did not write an event message ahead of the fragment")
+		}
+		if bytes.Count(segment, []byte("https://aomedia.org/emsg/ID3")) != 1 {
+			t.Error("FMP4 carried event messages over from an earlier segment")
+		}
+
+		body := segment[emsg+8:]
+		if presentationTime := binary.BigEndian.Uint64(body[4:]); presentationTime != uint64(ptsOffset+3000*i) {
+			t.Error("FMP4 wrote incorrect presentation time, got", presentationTime)
+		}
+		if id := binary.BigEndian.Uint32(body[16:]); id != uint32(i) {
+			t.Error("FMP4 wrote incorrect event ID, got", id)
+		}
+		if !bytes.Contains(body, []byte("https://aomedia.org/emsg/ID3\x00\x00ID3")) {
+			t.Error("FMP4 did not write the metadata as an ID3 event message")
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package segment
+
+import (
+	"io"
+	"path"
+	"time"
+
+	"github.com/jaredpetersen/raspilive/internal/fmp4"
+	"github.com/jaredpetersen/raspilive/internal/h264"
+)
+
+// MP4 packages video into standalone fragmented MP4 files for recording, each starting with its own initialization
+// segment and timestamps so that it plays on its own.
+//
+// Every keyframe begins a new fragment that is written out as soon as the next keyframe shows up, and flushed to disk if
+// the file can be synced, so a file cut short by a crash or power loss still plays up to the last complete group of
+// pictures.
+type MP4 struct {
+	Pattern     string // Format of the file names, given the sequence number
+	Timestamped bool   // Give Pattern the capture time in TimeLayout instead of the sequence number
+	Daily       bool   // File each recording in a directory for the day it was captured on, named YYYY/MM/DD in UTC
+	w           io.Writer
+	start       int64 // Presentation timestamp of the first frame in the file
+	fragment    fmp4.Fragment
+	initialized bool
+}
+
+// Name returns the file name of the recording with the given sequence number, or capture time if timestamped.
+func (format *MP4) Name(number int, captured time.Time) string {
+	name := name(format.Pattern, format.Timestamped, number, captured)
+	if format.Daily {
+		name = path.Join(captured.UTC().Format("2006/01/02"), name)
+	}
+
+	return name
+}
+
+// Begin starts a new recording.
+func (format *MP4) Begin(w io.Writer, number int, pts int64) error {
+	format.w = w
+	format.start = pts
+	format.fragment = fmp4.Fragment{}
+	format.initialized = false
+
+	return nil
+}
+
+// Write adds a frame to the current recording, writing out the fragment before it if the frame is a keyframe.
+//
+// The first frame of a recording is always a keyframe, whose parameter sets make up the initialization segment.
+func (format *MP4) Write(pts int64, duration int64, nalus [][]byte, keyframe bool) error {
+	var sps, pps []byte
+	var frame [][]byte
+
+	for _, nalu := range nalus {
+		switch h264.Type(nalu) {
+		case h264.TypeSPS:
+			sps = nalu
+		case h264.TypePPS:
+			pps = nalu
+		default:
+			frame = append(frame, nalu)
+		}
+	}
+
+	if !format.initialized {
+		if sps == nil || pps == nil {
+			return nil
+		}
+		if err := format.writeInit(sps, pps); err != nil {
+			return err
+		}
+	}
+
+	if keyframe {
+		if err := format.flush(); err != nil {
+			return err
+		}
+		format.fragment.BaseMediaDecodeTime = uint64(pts - format.start)
+	}
+
+	format.fragment.Samples = append(format.fragment.Samples, fmp4.Sample{
+		Duration: uint32(duration),
+		Keyframe: keyframe,
+		Data:     fmp4.SampleData(frame),
+	})
+
+	return nil
+}
+
+// End finishes the current recording, writing out the last fragment.
+func (format *MP4) End() error {
+	return format.flush()
+}
+
+// flush writes out the fragment that is in progress, if it has any frames.
+func (format *MP4) flush() error {
+	if len(format.fragment.Samples) == 0 {
+		return nil
+	}
+
+	format.fragment.Sequence++
+	err := fmp4.WriteFragment(format.w, format.fragment)
+	format.fragment.Samples = nil
+	if err != nil {
+		return err
+	}
+
+	if file, ok := format.w.(syncer); ok {
+		return file.Sync()
+	}
+
+	return nil
+}
+
+// syncer is a file that may be flushed to disk.
+type syncer interface {
+	Sync() error
+}
+
+func (format *MP4) writeInit(sps []byte, pps []byte) error {
+	params, err := h264.ParseSPS(sps)
+	if err != nil {
+		return err
+	}
+
+	track := fmp4.Track{
+		Width:     params.Width,
+		Height:    params.Height,
+		Timescale: Timescale,
+		SPS:       sps,
+		PPS:       pps,
+	}
+
+	if err := fmp4.WriteInit(format.w, track); err != nil {
+		return err
+	}
+
+	format.initialized = true
+
+	return nil
+}
@@ -0,0 +1,103 @@
+package segment
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func TestMP4Name(t *testing.T) {
+	captured := time.Date(2021, 3, 17, 23, 30, 0, 0, time.FixedZone("PDT", -7*60*60))
+
+	format := &MP4{Pattern: "raspilive-%s.mp4", Timestamped: true, Daily: true}
+	if name := format.Name(3, captured); name != "2021/03/18/raspilive-20210318T063000.000Z.mp4" {
+		t.Error("Name returned incorrect value, got", name)
+	}
+
+	format = &MP4{Pattern: "raspilive-%d.mp4"}
+	if name := format.Name(3, captured); name != "raspilive-3.mp4" {
+		t.Error("Name returned incorrect value, got", name)
+	}
+}
+
+func TestMP4(t *testing.T) {
+	format := &MP4{Pattern: "raspilive-%d.mp4"}
+
+	var recording bytes.Buffer
+	format.Begin(&recording, 0, ptsOffset+9000)
+	format.Write(ptsOffset+9000, 3000, [][]byte{fakeSPS, fakePPS, fakeIDR}, true)
+	format.Write(ptsOffset+12000, 3000, [][]byte{fakeNonIDR}, false)
+
+	// The first group of pictures is written out as soon as the next one begins
+	format.Write(ptsOffset+15000, 3000, [][]byte{fakeSPS, fakePPS, fakeIDR}, true)
+	if count := bytes.Count(recording.Bytes(), []byte("moof")); count != 1 {
+		t.Fatal("MP4 did not write out the fragment when the next keyframe arrived, got", count, "fragments")
+	}
+
+	if err := format.End(); err != nil {
+		t.Fatal("End returned an error", err)
+	}
+
+	data := recording.Bytes()
+	if !bytes.HasPrefix(data[4:], []byte("ftyp")) {
+		t.Fatal("MP4 did not begin the recording with an initialization segment")
+	}
+	if bytes.Count(data, []byte("moov")) != 1 || !bytes.Contains(data, fakeSPS) || !bytes.Contains(data, fakePPS) {
+		t.Error("MP4 did not write parameter sets to the initialization segment once")
+	}
+	if count := bytes.Count(data, []byte("moof")); count != 2 {
+		t.Error("MP4 wrote incorrect number of fragments, got", count)
+	}
+
+	// Timestamps start over from zero in every recording
+	var decodeTimes []uint64
+	for offset := 0; ; {
+		tfdt := bytes.Index(data[offset:], []byte("tfdt"))
+		if tfdt < 0 {
+			break
+		}
+		offset += tfdt + 4
+		decodeTimes = append(decodeTimes, binary.BigEndian.Uint64(data[offset+4:]))
+	}
+	if len(decodeTimes) != 2 || decodeTimes[0] != 0 || decodeTimes[1] != 6000 {
+		t.Error("MP4 wrote incorrect base media decode times, got", decodeTimes)
+	}
+
+	// Each recording stands on its own
+	var next bytes.Buffer
+	format.Begin(&next, 1, ptsOffset+18000)
+	format.Write(ptsOffset+18000, 3000, [][]byte{fakeSPS, fakePPS, fakeIDR}, true)
+	format.End()
+	if !bytes.Contains(next.Bytes(), []byte("moov")) || !bytes.Contains(next.Bytes(), []byte("moof")) {
+		t.Error("MP4 did not write a complete recording after the first")
+	}
+}
+
+// syncedBuffer counts the number of times it was flushed to disk.
+type syncedBuffer struct {
+	bytes.Buffer
+	syncs int
+}
+
+func (buf *syncedBuffer) Sync() error {
+	buf.syncs++
+	return nil
+}
+
+func TestMP4SyncsFragments(t *testing.T) {
+	format := &MP4{Pattern: "raspilive-%d.mp4"}
+
+	var recording syncedBuffer
+	format.Begin(&recording, 0, ptsOffset+9000)
+	format.Write(ptsOffset+9000, 3000, [][]byte{fakeSPS, fakePPS, fakeIDR}, true)
+	format.Write(ptsOffset+12000, 3000, [][]byte{fakeSPS, fakePPS, fakeIDR}, true)
+	if recording.syncs != 1 {
+		t.Error("MP4 did not flush the fragment to disk, got", recording.syncs, "syncs")
+	}
+
+	format.End()
+	if recording.syncs != 2 {
+		t.Error("MP4 did not flush the last fragment to disk, got", recording.syncs, "syncs")
+	}
+}
@@ -0,0 +1,523 @@
+package segment
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/jaredpetersen/raspilive/internal/h264"
+	"github.com/jaredpetersen/raspilive/internal/metadata"
+)
+
+// Timescale is the number of timestamp ticks per second used for all segment formats.
+const Timescale = 90000
+
+// Defaults that match the ones Ffmpeg uses when a value is not provided.
+const (
+	defaultFps          = 30
+	defaultSegmentTime  = 2
+	defaultPlaylistSize = 5
+)
+
+// TimeLayout is the layout of the capture time in timestamped segment names, which sort in the order they were
+// captured.
+const TimeLayout = "20060102T150405.000Z"
+
+var now = time.Now
+
+// ptsOffset delays the first presentation timestamp so that formats with clocks that run behind it, like the program
+// clock reference of MPEG-TS, do not start out negative.
+const ptsOffset = 126000
+
+// liveEdge is the number of segments that the manifests hold on to when storage runs out of space, which is as few as
+// players need to keep streaming.
+const liveEdge = 3
+
+// Segment is a single media segment.
+type Segment struct {
+	Name     string    // File name of the segment
+	Number   int       // Sequence number of the segment
+	Start    int64     // Presentation timestamp of the first frame in Timescale ticks
+	Duration int64     // Duration in Timescale ticks
+	Size     int64     // Size in bytes
+	Codec    string    // RFC 6381 codec of the video in the segment
+	Width    int       // Width of the video in pixels
+	Height   int       // Height of the video in pixels
+	Time     time.Time // Wall clock time that the first frame was captured
+
+	// Discontinuity marks that the segment does not follow on from the one before it, such as after a restart
+	Discontinuity bool
+
+	// Keyframe is the number of bytes from the start of the segment through the end of its first frame, which is
+	// always a keyframe, or zero if the format holds on to the frames until the segment ends
+	Keyframe int64
+
+	Metadata []Metadata // Timed metadata carried alongside the video, in order
+}
+
+// Metadata is a JSON document of timed metadata, such as telemetry from sensors, presented along with a frame.
+type Metadata struct {
+	PTS  int64  // Presentation timestamp of the frame in Timescale ticks
+	Data []byte // JSON document
+}
+
+// Seconds returns the duration of the segment in seconds.
+func (seg Segment) Seconds() float64 {
+	return float64(seg.Duration) / Timescale
+}
+
+// Format packages video into segment files.
+type Format interface {
+	// Name returns the file name of the segment with the given sequence number and capture time.
+	Name(number int, captured time.Time) string
+
+	// Begin starts a new segment, written to w.
+	Begin(w io.Writer, number int, pts int64) error
+
+	// Write adds a frame to the current segment. Keyframes always begin with the sequence and picture parameter sets.
+	Write(pts int64, duration int64, nalus [][]byte, keyframe bool) error
+
+	// End finishes the current segment.
+	End() error
+}
+
+// MetadataFormat is a Format that carries timed metadata in the segments alongside the video.
+type MetadataFormat interface {
+	// WriteMetadata adds the JSON document to the current segment, to be presented at the given timestamp.
+	WriteMetadata(pts int64, data []byte) error
+}
+
+// Manifest lists the segments for players, such as an HLS playlist or DASH manifest.
+type Manifest interface {
+	// Update rewrites the manifest with the segments that are currently available, oldest first, marking it as
+	// complete if the stream has ended.
+	Update(segments []Segment, ended bool) error
+}
+
+// Archive is a manifest that goes on listing segments after they leave the other manifests, such as a playlist of the
+// whole stream, which must stop listing them once they are evicted from storage to make room.
+type Archive interface {
+	Manifest
+
+	// Evict stops listing the segments, which are about to be removed from storage, ahead of the next update.
+	Evict(segments []Segment)
+}
+
+// Options represents ways that the segmenter may be configured.
+//
+// Defaults matching those of Ffmpeg are used if a value is not provided.
+type Options struct {
+	Fps          int   // Framerate of the video
+	SegmentTime  int   // Segment length target duration in seconds
+	PlaylistSize int   // Maximum number of manifest entries
+	StorageSize  int   // Maximum number of unreferenced segments to keep in storage before removal
+	StartNumber  int   // Sequence number of the first segment
+	Window       int   // Duration of video to keep in the manifests in seconds, in place of PlaylistSize
+	Budget       int64 // Maximum size of all the segments in storage in bytes, dropping the oldest segments past it
+
+	// Discontinuity marks the first segment as a discontinuity, for when it follows on from an earlier session
+	Discontinuity bool
+
+	// Previous is the segments still listed by the manifests of an earlier session, oldest first, which the manifests
+	// carry on from until they slide out and are removed like any other
+	Previous []Segment
+
+	// Metadata is timed metadata to carry alongside the video, presented along with the first frame captured after it
+	// arrived
+	Metadata *metadata.Queue
+
+	// Epoch is the wall clock time that the first frame was captured, for video that was held on to before being
+	// written. The time that the first frame is written at is used if not provided.
+	Epoch time.Time
+
+	// Shortfall reports how many bytes need freeing up in storage, if any. Running out of space takes precedence over
+	// the rest of the retention options, removing the oldest segments down to the live edge to make room.
+	Shortfall func() int64
+}
+
+// Segmenter cuts H.264 video into segments on keyframes and keeps the manifests referencing them up to date.
+type Segmenter struct {
+	storage      Storage
+	format       Format
+	manifests    []Manifest
+	fps          int64
+	segmentTime  int64
+	playlistSize int
+	storageSize  int
+	window       int64 // Duration of the window in Timescale ticks
+	budget       int64
+	metadata     *metadata.Queue
+	restarted    bool      // Whether the next segment follows on from an earlier session
+	number       int       // Sequence number of the next segment
+	frames       int64     // Number of frames written so far
+	listed       []Segment // Segments referenced by the manifests, oldest first
+	unreferenced []Segment // Segments that have slid out of the manifests but are still in storage, oldest first
+	current      Segment
+	file         io.WriteCloser
+	buf          *bufio.Writer
+	out          *countingWriter
+	sps          []byte
+	pps          []byte
+	params       h264.SPS  // Parsed sequence parameter set
+	epoch        time.Time // Wall clock time that the first frame was captured
+	shortfall    func() int64
+}
+
+// New creates a segmenter that writes segments in the given format to the storage.
+func New(storage Storage, format Format, options Options, manifests ...Manifest) *Segmenter {
+	fps := options.Fps
+	if fps <= 0 {
+		fps = defaultFps
+	}
+
+	segmentTime := options.SegmentTime
+	if segmentTime <= 0 {
+		segmentTime = defaultSegmentTime
+	}
+
+	playlistSize := options.PlaylistSize
+	if playlistSize <= 0 {
+		playlistSize = defaultPlaylistSize
+	}
+
+	return &Segmenter{
+		storage:      storage,
+		format:       format,
+		manifests:    manifests,
+		fps:          int64(fps),
+		segmentTime:  int64(segmentTime),
+		playlistSize: playlistSize,
+		storageSize:  options.StorageSize,
+		window:       int64(options.Window) * Timescale,
+		budget:       options.Budget,
+		restarted:    options.Discontinuity,
+		listed:       append([]Segment{}, options.Previous...),
+		metadata:     options.Metadata,
+		number:       options.StartNumber,
+		epoch:        options.Epoch.UTC(),
+		shortfall:    options.Shortfall,
+	}
+}
+
+// pts calculates the presentation timestamp of the frame.
+func (seg *Segmenter) pts(frame int64) int64 {
+	return ptsOffset + frame*Timescale/seg.fps
+}
+
+// Write writes the access unit to the current segment, cutting a new segment first if it's time.
+func (seg *Segmenter) Write(au h264.AccessUnit) error {
+	for _, nalu := range au.NALUnits {
+		switch h264.Type(nalu) {
+		case h264.TypeSPS:
+			if params, err := h264.ParseSPS(nalu); err == nil {
+				seg.sps = nalu
+				seg.params = params
+			}
+		case h264.TypePPS:
+			seg.pps = nalu
+		}
+	}
+
+	keyframe := au.Keyframe()
+	pts := seg.pts(seg.frames)
+
+	if seg.file == nil && (!keyframe || seg.sps == nil || seg.pps == nil) {
+		// Nothing can be decoded until the first keyframe shows up
+		return nil
+	}
+
+	if keyframe && (seg.file == nil || pts-seg.current.Start >= seg.segmentTime*Timescale) {
+		if seg.epoch.IsZero() {
+			seg.epoch = now().UTC()
+		}
+		if err := seg.cut(pts); err != nil {
+			return err
+		}
+	}
+
+	if seg.metadata != nil {
+		if err := seg.writeMetadata(pts); err != nil {
+			return err
+		}
+	}
+
+	duration := seg.pts(seg.frames+1) - pts
+	if err := seg.format.Write(pts, duration, seg.nalUnits(au, keyframe), keyframe); err != nil {
+		return err
+	}
+	if keyframe && seg.current.Keyframe == 0 {
+		seg.current.Keyframe = seg.out.n
+	}
+
+	seg.frames++
+
+	return nil
+}
+
+// Feed reads the H.264 video stream and segments it, finishing up once the video stream ends.
+func (seg *Segmenter) Feed(video io.Reader) error {
+	units := h264.NewAccessUnitReader(video)
+
+	for {
+		au, err := units.Read()
+		if err == io.EOF || errors.Is(err, os.ErrClosed) {
+			break
+		}
+		if err != nil {
+			seg.Close()
+			return err
+		}
+
+		if err := seg.Write(au); err != nil {
+			seg.Close()
+			return err
+		}
+	}
+
+	return seg.Close()
+}
+
+// Close finishes the last segment and marks the manifests as complete.
+func (seg *Segmenter) Close() error {
+	return seg.finish(seg.pts(seg.frames), true)
+}
+
+// writeMetadata adds the metadata that arrived by the time the frame was captured to the current segment, presented
+// along with the frame.
+func (seg *Segmenter) writeMetadata(pts int64) error {
+	captured := seg.epoch.Add(elapsed(pts - ptsOffset))
+
+	for _, data := range seg.metadata.Take(captured) {
+		if format, ok := seg.format.(MetadataFormat); ok {
+			if err := format.WriteMetadata(pts, data); err != nil {
+				return err
+			}
+		}
+		seg.current.Metadata = append(seg.current.Metadata, Metadata{PTS: pts, Data: data})
+	}
+
+	return nil
+}
+
+// nalUnits prepares the NAL units of the access unit for the format, leaving out access unit delimiters.
+//
+// Keyframes begin with the parameter sets so that every segment may be decoded on its own.
+func (seg *Segmenter) nalUnits(au h264.AccessUnit, keyframe bool) [][]byte {
+	var nalus [][]byte
+	if keyframe {
+		nalus = append(nalus, seg.sps, seg.pps)
+	}
+
+	for _, nalu := range au.NALUnits {
+		switch h264.Type(nalu) {
+		case h264.TypeAUD:
+			continue
+		case h264.TypeSPS, h264.TypePPS:
+			if keyframe {
+				continue
+			}
+		}
+		nalus = append(nalus, nalu)
+	}
+
+	return nalus
+}
+
+// cut finishes the current segment, if there is one, and begins a new one starting at the provided timestamp.
+func (seg *Segmenter) cut(pts int64) error {
+	if err := seg.finish(pts, false); err != nil {
+		return err
+	}
+
+	// Capture times follow the presentation timestamps from the first frame on so that they line up with the
+	// segment durations rather than drifting with however long the camera took to deliver each frame
+	captured := seg.epoch.Add(elapsed(pts - ptsOffset))
+	name := seg.format.Name(seg.number, captured)
+
+	file, err := seg.storage.Create(name)
+	if err != nil {
+		return err
+	}
+
+	seg.file = file
+	seg.buf = bufio.NewWriterSize(file, 64*1024)
+	seg.out = &countingWriter{w: seg.buf, file: file}
+	seg.current = Segment{
+		Name:   name,
+		Number: seg.number,
+		Start:  pts,
+		Codec:  seg.params.Codec(),
+		Width:  seg.params.Width,
+		Height: seg.params.Height,
+		Time:   captured,
+
+		Discontinuity: seg.restarted,
+	}
+	seg.number++
+	seg.restarted = false
+
+	return seg.format.Begin(seg.out, seg.current.Number, pts)
+}
+
+// finish closes out the current segment, ending at the provided timestamp, and adds it to the manifests.
+func (seg *Segmenter) finish(end int64, ended bool) error {
+	if seg.file == nil {
+		return nil
+	}
+
+	file := seg.file
+	seg.file = nil
+
+	if err := seg.format.End(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := seg.buf.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	seg.current.Duration = end - seg.current.Start
+	seg.current.Size = seg.out.n
+
+	seg.listed = append(seg.listed, seg.current)
+	var removed []Segment
+	for len(seg.listed) > 1 && seg.overflowing() {
+		removed = append(removed, seg.listed[0])
+		seg.listed = seg.listed[1:]
+	}
+	evicted := seg.evict()
+	if len(removed) > 0 || len(evicted) > 0 {
+		seg.listed = append([]Segment{}, seg.listed...)
+	}
+
+	for _, manifest := range seg.manifests {
+		if archive, ok := manifest.(Archive); ok && len(evicted) > 0 {
+			archive.Evict(evicted)
+		}
+		if err := manifest.Update(seg.listed, ended); err != nil {
+			return err
+		}
+	}
+
+	for _, old := range evicted {
+		seg.storage.Remove(old.Name)
+	}
+
+	// Segments stick around for a bit after leaving the manifests for the sake of clients that are still downloading
+	// them, but are kept forever if there is no limit other than the space available
+	if seg.storageSize > 0 || seg.budget > 0 || seg.shortfall != nil {
+		seg.unreferenced = append(seg.unreferenced, removed...)
+	}
+	for len(seg.unreferenced) > 0 {
+		overStorageSize := seg.storageSize > 0 && len(seg.unreferenced) > seg.storageSize
+		overBudget := seg.budget > 0 && size(seg.listed)+size(seg.unreferenced) > seg.budget
+		if !overStorageSize && !overBudget {
+			break
+		}
+
+		seg.storage.Remove(seg.unreferenced[0].Name)
+		seg.unreferenced = seg.unreferenced[1:]
+	}
+
+	return nil
+}
+
+// evict picks out the oldest segments to remove to make up for the shortfall in space, starting with those that are no
+// longer referenced and then cutting the manifests back as far as the live edge.
+func (seg *Segmenter) evict() []Segment {
+	if seg.shortfall == nil {
+		return nil
+	}
+
+	shortfall := seg.shortfall()
+
+	var evicted []Segment
+	for shortfall > 0 && len(seg.unreferenced) > 0 {
+		evicted = append(evicted, seg.unreferenced[0])
+		shortfall -= seg.unreferenced[0].Size
+		seg.unreferenced = seg.unreferenced[1:]
+	}
+	for shortfall > 0 && len(seg.listed) > liveEdge {
+		evicted = append(evicted, seg.listed[0])
+		shortfall -= seg.listed[0].Size
+		seg.listed = seg.listed[1:]
+	}
+
+	return evicted
+}
+
+// overflowing reports whether the manifests list more segments than they should, going by the window if there is one
+// and the playlist size otherwise.
+func (seg *Segmenter) overflowing() bool {
+	if seg.budget > 0 && size(seg.listed) > seg.budget {
+		return true
+	}
+
+	if seg.window > 0 {
+		var duration int64
+		for _, listed := range seg.listed {
+			duration += listed.Duration
+		}
+		return duration > seg.window
+	}
+
+	return len(seg.listed) > seg.playlistSize
+}
+
+// name formats the file name of a segment with the pattern, given either the sequence number or the capture time.
+func name(pattern string, timestamped bool, number int, captured time.Time) string {
+	if timestamped {
+		return fmt.Sprintf(pattern, captured.UTC().Format(TimeLayout))
+	}
+
+	return fmt.Sprintf(pattern, number)
+}
+
+// elapsed converts Timescale ticks into a duration, taking the whole seconds first so that long streams do not
+// overflow.
+func elapsed(ticks int64) time.Duration {
+	return time.Duration(ticks/Timescale)*time.Second + time.Duration(ticks%Timescale)*time.Second/Timescale
+}
+
+// size adds up the size of the segments in bytes.
+func size(segments []Segment) int64 {
+	var total int64
+	for _, segment := range segments {
+		total += segment.Size
+	}
+
+	return total
+}
+
+// countingWriter keeps track of the number of bytes written to the buffered file.
+type countingWriter struct {
+	w    *bufio.Writer
+	file io.Writer
+	n    int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// Sync writes out what has been buffered so far and flushes it to disk, if the file can be.
+func (cw *countingWriter) Sync() error {
+	if err := cw.w.Flush(); err != nil {
+		return err
+	}
+
+	if file, ok := cw.file.(syncer); ok {
+		return file.Sync()
+	}
+
+	return nil
+}
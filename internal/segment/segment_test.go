@@ -0,0 +1,339 @@
+package segment
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jaredpetersen/raspilive/internal/h264"
+	"github.com/jaredpetersen/raspilive/internal/metadata"
+)
+
+var (
+	fakeAUD = []byte{0x09, 0xF0}
+	fakeSPS = []byte{
+		0x67, 0x64, 0x00, 0x1F, 0xAC, 0xD9, 0x40, 0x50, 0x05, 0xBB, 0x01, 0x10, 0x00, 0x00, 0x03, 0x00,
+		0x10, 0x00, 0x00, 0x03, 0x03, 0xC0, 0xF1, 0x83, 0x19, 0x60,
+	}
+	fakePPS    = []byte{0x68, 0xEE, 0x3C, 0x80}
+	fakeIDR    = []byte{0x65, 0x88, 0x84}
+	fakeNonIDR = []byte{0x41, 0x9A, 0x02}
+)
+
+type frame struct {
+	pts      int64
+	duration int64
+	nalus    [][]byte
+	keyframe bool
+}
+
+// fakeFormat writes a byte per frame and records the frames and metadata it was given.
+type fakeFormat struct {
+	w        io.Writer
+	begins   []int
+	frames   []frame
+	metadata []Metadata
+}
+
+func (format *fakeFormat) Name(number int, captured time.Time) string {
+	return fmt.Sprintf("segment-%d", number)
+}
+
+func (format *fakeFormat) Begin(w io.Writer, number int, pts int64) error {
+	format.w = w
+	format.begins = append(format.begins, number)
+	return nil
+}
+
+func (format *fakeFormat) Write(pts int64, duration int64, nalus [][]byte, keyframe bool) error {
+	format.frames = append(format.frames, frame{pts, duration, nalus, keyframe})
+	_, err := format.w.Write([]byte{0xAB})
+	return err
+}
+
+func (format *fakeFormat) WriteMetadata(pts int64, data []byte) error {
+	format.metadata = append(format.metadata, Metadata{PTS: pts, Data: data})
+	return nil
+}
+
+func (format *fakeFormat) End() error {
+	return nil
+}
+
+// fakeManifest records the updates it was given.
+type fakeManifest struct {
+	segments []Segment
+	ended    bool
+	updates  int
+}
+
+func (manifest *fakeManifest) Update(segments []Segment, ended bool) error {
+	manifest.segments = append([]Segment{}, segments...)
+	manifest.ended = ended
+	manifest.updates++
+	return nil
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "raspilive-segment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	return dir
+}
+
+// writeVideo writes the frames to the segmenter, with a keyframe every keyframeInterval frames.
+func writeVideo(t *testing.T, seg *Segmenter, frames int, keyframeInterval int) {
+	for i := 0; i < frames; i++ {
+		au := h264.AccessUnit{NALUnits: [][]byte{fakeAUD, fakeNonIDR}}
+		if i%keyframeInterval == 0 {
+			au = h264.AccessUnit{NALUnits: [][]byte{fakeAUD, fakeIDR}}
+		}
+		if i == 0 {
+			au = h264.AccessUnit{NALUnits: [][]byte{fakeAUD, fakeSPS, fakePPS, fakeIDR}}
+		}
+
+		if err := seg.Write(au); err != nil {
+			t.Fatal("Write returned an error", err)
+		}
+	}
+
+	if err := seg.Close(); err != nil {
+		t.Fatal("Close returned an error", err)
+	}
+}
+
+func TestSegmenter(t *testing.T) {
+	epoch := time.Date(2021, 3, 17, 12, 0, 0, 0, time.UTC)
+	now = func() time.Time { return epoch }
+	defer func() { now = time.Now }()
+
+	dir := tempDir(t)
+	format := &fakeFormat{}
+	manifest := &fakeManifest{}
+
+	seg := New(Dir(dir), format, Options{Fps: 30, SegmentTime: 1, StartNumber: 1}, manifest)
+	writeVideo(t, seg, 90, 30)
+
+	if !manifest.ended || manifest.updates != 3 {
+		t.Error("Segmenter updated manifest incorrectly, got", manifest.updates, manifest.ended)
+	}
+
+	if len(manifest.segments) != 3 {
+		t.Fatal("Segmenter listed incorrect number of segments, got", len(manifest.segments))
+	}
+
+	for i, segment := range manifest.segments {
+		expected := Segment{
+			Name:     fmt.Sprintf("segment-%d", i+1),
+			Number:   i + 1,
+			Start:    ptsOffset + int64(i)*Timescale,
+			Duration: Timescale,
+			Size:     30,
+			Codec:    "avc1.64001F",
+			Width:    1280,
+			Height:   720,
+			Time:     epoch.Add(time.Duration(i) * time.Second),
+			Keyframe: 1,
+		}
+		if !reflect.DeepEqual(segment, expected) {
+			t.Error("Segmenter listed incorrect segment, got", segment)
+		}
+
+		if _, err := os.Stat(path.Join(dir, segment.Name)); err != nil {
+			t.Error("Segmenter did not write segment", segment.Name)
+		}
+	}
+
+	if len(format.frames) != 90 || format.frames[1].duration != 3000 || format.frames[1].pts != ptsOffset+3000 {
+		t.Error("Segmenter wrote incorrect frames")
+	}
+}
+
+func TestSegmenterParameterSets(t *testing.T) {
+	format := &fakeFormat{}
+
+	seg := New(Dir(tempDir(t)), format, Options{Fps: 30, SegmentTime: 1})
+	writeVideo(t, seg, 31, 30)
+
+	expected := [][][]byte{
+		{fakeSPS, fakePPS, fakeIDR},
+		{fakeNonIDR},
+		{fakeSPS, fakePPS, fakeIDR},
+	}
+	for i, index := range []int{0, 1, 30} {
+		if !bytes.Equal(bytes.Join(format.frames[index].nalus, nil), bytes.Join(expected[i], nil)) {
+			t.Errorf("Segmenter wrote incorrect NAL units for frame %d, got % X", index, format.frames[index].nalus)
+		}
+	}
+}
+
+func TestSegmenterRetention(t *testing.T) {
+	dir := tempDir(t)
+	manifest := &fakeManifest{}
+
+	seg := New(Dir(dir), &fakeFormat{}, Options{Fps: 30, SegmentTime: 1, PlaylistSize: 2, StorageSize: 1}, manifest)
+	writeVideo(t, seg, 150, 30)
+
+	if len(manifest.segments) != 2 || manifest.segments[0].Number != 3 {
+		t.Error("Segmenter listed incorrect segments, got", manifest.segments)
+	}
+
+	for number, expected := range []bool{false, false, true, true, true} {
+		_, err := os.Stat(path.Join(dir, fmt.Sprintf("segment-%d", number)))
+		if exists := err == nil; exists != expected {
+			t.Errorf("Segmenter left incorrect segments on disk, segment-%d exists: %t", number, exists)
+		}
+	}
+}
+
+func TestSegmenterWindow(t *testing.T) {
+	manifest := &fakeManifest{}
+
+	// The window takes the place of the playlist size
+	seg := New(Dir(tempDir(t)), &fakeFormat{}, Options{Fps: 30, SegmentTime: 1, PlaylistSize: 2, Window: 4}, manifest)
+	writeVideo(t, seg, 300, 30)
+
+	if len(manifest.segments) != 4 || manifest.segments[0].Number != 6 {
+		t.Error("Segmenter listed incorrect segments, got", manifest.segments)
+	}
+}
+
+func TestSegmenterBudget(t *testing.T) {
+	dir := tempDir(t)
+	manifest := &fakeManifest{}
+
+	// Each segment is 30 bytes, so only three fit in the budget between the manifests and storage
+	seg := New(Dir(dir), &fakeFormat{}, Options{Fps: 30, SegmentTime: 1, Window: 60, StorageSize: 5, Budget: 90}, manifest)
+	writeVideo(t, seg, 300, 30)
+
+	if len(manifest.segments) != 3 || manifest.segments[0].Number != 7 {
+		t.Error("Segmenter listed incorrect segments, got", manifest.segments)
+	}
+
+	if files, _ := ioutil.ReadDir(dir); len(files) != 3 {
+		t.Error("Segmenter kept incorrect number of segments in storage, got", len(files))
+	}
+}
+
+func TestSegmenterShortfall(t *testing.T) {
+	dir := tempDir(t)
+	manifest := &fakeManifest{}
+
+	// Each segment is 30 bytes, so only four fit in the space available
+	shortfall := func() int64 {
+		var used int64
+		files, _ := ioutil.ReadDir(dir)
+		for _, file := range files {
+			used += file.Size()
+		}
+		if used > 120 {
+			return used - 120
+		}
+		return 0
+	}
+
+	seg := New(Dir(dir), &fakeFormat{}, Options{Fps: 30, SegmentTime: 1, Window: 60, Shortfall: shortfall}, manifest)
+	writeVideo(t, seg, 300, 30)
+
+	if len(manifest.segments) != 4 || manifest.segments[0].Number != 6 {
+		t.Error("Segmenter listed incorrect segments, got", manifest.segments)
+	}
+
+	if files, _ := ioutil.ReadDir(dir); len(files) != 4 {
+		t.Error("Segmenter kept incorrect number of segments in storage, got", len(files))
+	}
+}
+
+func TestSegmenterShortfallKeepsLiveEdge(t *testing.T) {
+	dir := tempDir(t)
+	manifest := &fakeManifest{}
+
+	shortfall := func() int64 { return 1000 }
+
+	seg := New(Dir(dir), &fakeFormat{}, Options{Fps: 30, SegmentTime: 1, PlaylistSize: 5, StorageSize: 5, Shortfall: shortfall}, manifest)
+	writeVideo(t, seg, 300, 30)
+
+	if len(manifest.segments) != 3 || manifest.segments[0].Number != 7 {
+		t.Error("Segmenter listed incorrect segments, got", manifest.segments)
+	}
+
+	if files, _ := ioutil.ReadDir(dir); len(files) != 3 {
+		t.Error("Segmenter kept incorrect number of segments in storage, got", len(files))
+	}
+}
+
+func TestSegmenterDiscontinuity(t *testing.T) {
+	manifest := &fakeManifest{}
+
+	seg := New(Dir(tempDir(t)), &fakeFormat{}, Options{Fps: 30, SegmentTime: 1, Discontinuity: true}, manifest)
+	writeVideo(t, seg, 90, 30)
+
+	for i, segment := range manifest.segments {
+		if segment.Discontinuity != (i == 0) {
+			t.Errorf("Segmenter marked segment %d incorrectly, discontinuity: %t", i, segment.Discontinuity)
+		}
+	}
+}
+
+func TestSegmenterEpoch(t *testing.T) {
+	epoch := time.Date(2021, 3, 17, 12, 0, 0, 0, time.FixedZone("PDT", -7*60*60))
+	manifest := &fakeManifest{}
+
+	seg := New(Dir(tempDir(t)), &fakeFormat{}, Options{Fps: 30, SegmentTime: 1, Epoch: epoch}, manifest)
+	writeVideo(t, seg, 60, 30)
+
+	for i, segment := range manifest.segments {
+		if expected := epoch.UTC().Add(time.Duration(i) * time.Second); segment.Time != expected {
+			t.Errorf("Segmenter gave segment %d incorrect capture time, got %s", i, segment.Time)
+		}
+	}
+}
+
+func TestSegmenterMetadata(t *testing.T) {
+	queue := &metadata.Queue{}
+	arrived := time.Now()
+	queue.Push([]byte(`{"door":"open"}`))
+
+	// Start the video a little under a second and a half before the metadata arrived
+	now = func() time.Time { return arrived.Add(-1480 * time.Millisecond) }
+	defer func() { now = time.Now }()
+
+	format := &fakeFormat{}
+	manifest := &fakeManifest{}
+
+	seg := New(Dir(tempDir(t)), format, Options{Fps: 30, SegmentTime: 1, Metadata: queue}, manifest)
+	writeVideo(t, seg, 90, 30)
+
+	expected := []Metadata{{PTS: ptsOffset + 45*3000, Data: []byte(`{"door":"open"}`)}}
+	if !reflect.DeepEqual(format.metadata, expected) {
+		t.Error("Segmenter wrote incorrect metadata, got", format.metadata)
+	}
+
+	for i, segment := range manifest.segments {
+		if i == 1 && !reflect.DeepEqual(segment.Metadata, expected) || i != 1 && segment.Metadata != nil {
+			t.Errorf("Segmenter listed incorrect metadata for segment %d, got %v", i, segment.Metadata)
+		}
+	}
+}
+
+func TestSegmenterSkipsToFirstKeyframe(t *testing.T) {
+	format := &fakeFormat{}
+
+	seg := New(Dir(tempDir(t)), format, Options{})
+	seg.Write(h264.AccessUnit{NALUnits: [][]byte{fakeNonIDR}})
+	seg.Write(h264.AccessUnit{NALUnits: [][]byte{fakeIDR}}) // No parameter sets yet
+	writeVideo(t, seg, 2, 30)
+
+	if len(format.frames) != 2 || format.frames[0].pts != ptsOffset {
+		t.Error("Segmenter did not skip to first keyframe, got", len(format.frames), "frames")
+	}
+}
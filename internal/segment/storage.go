@@ -0,0 +1,132 @@
+package segment
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path"
+)
+
+// Storage keeps the segments and manifests where the server can get at them.
+type Storage interface {
+	// Create creates the named file, which shows up all at once when it is closed so that clients never see a partial
+	// file.
+	Create(name string) (io.WriteCloser, error)
+
+	// Remove removes the named file.
+	Remove(name string) error
+}
+
+// Dir stores files in a directory on disk.
+type Dir string
+
+// Create creates the file by way of a temporary file that is renamed into place when closed, along with any
+// directories in the name that do not exist yet.
+func (dir Dir) Create(name string) (io.WriteCloser, error) {
+	name = path.Join(string(dir), name)
+	tmpName := path.Join(path.Dir(name), "."+path.Base(name)+".tmp")
+
+	if err := os.MkdirAll(path.Dir(name), 0755); err != nil {
+		return nil, err
+	}
+
+	file, err := os.Create(tmpName)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dirFile{File: file, name: name}, nil
+}
+
+// Remove removes the file from the directory.
+func (dir Dir) Remove(name string) error {
+	return os.Remove(path.Join(string(dir), name))
+}
+
+// SyncedDir stores files in a directory on disk like Dir, except that files are flushed to the disk before they take
+// the place of the named file, and the directory after, so that they survive a power loss. Writers may call Sync to
+// flush what has been written so far.
+type SyncedDir string
+
+// Create creates the file, which is flushed to disk when closed.
+func (dir SyncedDir) Create(name string) (io.WriteCloser, error) {
+	file, err := Dir(dir).Create(name)
+	if err != nil {
+		return nil, err
+	}
+	file.(*dirFile).synced = true
+
+	return file, nil
+}
+
+// Remove removes the file from the directory.
+func (dir SyncedDir) Remove(name string) error {
+	return Dir(dir).Remove(name)
+}
+
+// SyncDir flushes the directory's entries to disk, so that files renamed into it are still there after a power loss.
+func SyncDir(name string) error {
+	dir, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+
+	err = dir.Sync()
+	if closeErr := dir.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// dirFile is a temporary file that takes the place of the named file when closed.
+type dirFile struct {
+	*os.File
+	name   string
+	synced bool
+}
+
+func (file *dirFile) Close() error {
+	if file.synced {
+		if err := file.File.Sync(); err != nil {
+			file.File.Close()
+			os.Remove(file.File.Name())
+			return err
+		}
+	}
+
+	if err := file.File.Close(); err != nil {
+		os.Remove(file.File.Name())
+		return err
+	}
+
+	if err := os.Rename(file.File.Name(), file.name); err != nil {
+		return err
+	}
+
+	if file.synced {
+		return SyncDir(path.Dir(file.name))
+	}
+
+	return nil
+}
+
+// WriteFile writes the file to the storage all at once, leaving any existing file alone if writing fails.
+func WriteFile(storage Storage, name string, write func(w io.Writer) error) error {
+	var buf bytes.Buffer
+	if err := write(&buf); err != nil {
+		return err
+	}
+
+	file, err := storage.Create(name)
+	if err != nil {
+		return err
+	}
+
+	if _, err := buf.WriteTo(file); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
@@ -0,0 +1,128 @@
+package segment
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestDir(t *testing.T) {
+	dir := tempDir(t)
+	storage := Dir(dir)
+
+	file, err := storage.Create("raspilive-1.m4s")
+	if err != nil {
+		t.Fatal("Create returned an error", err)
+	}
+	io.WriteString(file, "segment")
+
+	if _, err := os.Stat(path.Join(dir, "raspilive-1.m4s")); err == nil {
+		t.Error("Dir made file visible before it was closed")
+	}
+
+	if err := file.Close(); err != nil {
+		t.Fatal("Close returned an error", err)
+	}
+
+	if data, _ := ioutil.ReadFile(path.Join(dir, "raspilive-1.m4s")); string(data) != "segment" {
+		t.Error("Dir wrote incorrect contents, got", string(data))
+	}
+
+	if err := storage.Remove("raspilive-1.m4s"); err != nil {
+		t.Fatal("Remove returned an error", err)
+	}
+
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Error("Dir left files behind, got", len(files), "files")
+	}
+}
+
+func TestSyncedDir(t *testing.T) {
+	dir := tempDir(t)
+	storage := SyncedDir(dir)
+
+	file, err := storage.Create("2021/03/17/raspilive-1.mp4")
+	if err != nil {
+		t.Fatal("Create returned an error", err)
+	}
+	io.WriteString(file, "recording")
+
+	// What has been written so far makes it to disk before the file is finished
+	out := &countingWriter{w: bufio.NewWriter(file), file: file}
+	io.WriteString(out, "fragment")
+	if err := out.Sync(); err != nil {
+		t.Fatal("Sync returned an error", err)
+	}
+	if data, _ := ioutil.ReadFile(path.Join(dir, "2021/03/17/.raspilive-1.mp4.tmp")); string(data) != "recordingfragment" {
+		t.Error("Sync did not write out the buffered data, got", string(data))
+	}
+
+	if err := file.Close(); err != nil {
+		t.Fatal("Close returned an error", err)
+	}
+
+	if data, _ := ioutil.ReadFile(path.Join(dir, "2021/03/17/raspilive-1.mp4")); string(data) != "recordingfragment" {
+		t.Error("SyncedDir wrote incorrect contents, got", string(data))
+	}
+
+	if err := storage.Remove("2021/03/17/raspilive-1.mp4"); err != nil {
+		t.Fatal("Remove returned an error", err)
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	dir := tempDir(t)
+
+	err := WriteFile(Dir(dir), "livestream.m3u8", func(w io.Writer) error {
+		_, err := io.WriteString(w, "#EXTM3U\n")
+		return err
+	})
+	if err != nil {
+		t.Fatal("WriteFile returned an error", err)
+	}
+
+	if data, _ := ioutil.ReadFile(path.Join(dir, "livestream.m3u8")); string(data) != "#EXTM3U\n" {
+		t.Error("WriteFile wrote incorrect contents, got", string(data))
+	}
+
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Error("WriteFile left temporary files behind, got", len(files), "files")
+	}
+}
+
+func TestWriteFileKeepsExistingFileOnError(t *testing.T) {
+	dir := tempDir(t)
+	ioutil.WriteFile(path.Join(dir, "livestream.m3u8"), []byte("#EXTM3U\n"), 0644)
+
+	err := WriteFile(Dir(dir), "livestream.m3u8", func(w io.Writer) error {
+		io.WriteString(w, "#EXT")
+		return errors.New("failed")
+	})
+	if err == nil {
+		t.Fatal("WriteFile did not return an error")
+	}
+
+	if data, _ := ioutil.ReadFile(path.Join(dir, "livestream.m3u8")); string(data) != "#EXTM3U\n" {
+		t.Error("WriteFile replaced existing file, got", string(data))
+	}
+}
+
+func TestDirCreatesDirectories(t *testing.T) {
+	dir := tempDir(t)
+
+	file, err := Dir(dir).Create("2021/03/17/raspilive-1.mp4")
+	if err != nil {
+		t.Fatal("Create returned an error", err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal("Close returned an error", err)
+	}
+
+	if _, err := os.Stat(path.Join(dir, "2021/03/17/raspilive-1.mp4")); err != nil {
+		t.Error("Dir did not create file in nested directories", err)
+	}
+}
@@ -0,0 +1,58 @@
+package segment
+
+import (
+	"io"
+	"time"
+
+	"github.com/jaredpetersen/raspilive/internal/h264"
+	"github.com/jaredpetersen/raspilive/internal/metadata"
+	"github.com/jaredpetersen/raspilive/internal/mpegts"
+)
+
+// TS packages video into MPEG-TS segments.
+type TS struct {
+	Pattern     string // Format of the segment file names, given the sequence number
+	Timestamped bool   // Give Pattern the capture time in TimeLayout instead of the sequence number
+	Metadata    bool   // Carry a stream of timed metadata in ID3 tags, which must be set for WriteMetadata
+	ts          *mpegts.Writer
+}
+
+// Name returns the file name of the segment with the given sequence number, or capture time if timestamped.
+func (format *TS) Name(number int, captured time.Time) string {
+	return name(format.Pattern, format.Timestamped, number, captured)
+}
+
+// Begin starts a new segment with the program tables so that it may be played on its own.
+func (format *TS) Begin(w io.Writer, number int, pts int64) error {
+	if format.ts == nil {
+		format.ts = mpegts.NewWriter(w)
+		format.ts.Metadata = format.Metadata
+	} else {
+		format.ts.Reset(w)
+	}
+
+	return format.ts.WriteTables()
+}
+
+// Write adds a frame to the current segment, starting with an access unit delimiter as required by MPEG-TS.
+func (format *TS) Write(pts int64, duration int64, nalus [][]byte, keyframe bool) error {
+	data := append([]byte{}, h264.StartCode...)
+	data = append(data, byte(h264.TypeAUD), 0xF0)
+
+	for _, nalu := range nalus {
+		data = append(data, h264.StartCode...)
+		data = append(data, nalu...)
+	}
+
+	return format.ts.WriteVideo(pts, data, keyframe)
+}
+
+// WriteMetadata adds the JSON document to the current segment in an ID3 tag.
+func (format *TS) WriteMetadata(pts int64, data []byte) error {
+	return format.ts.WriteMetadata(pts, metadata.ID3(data))
+}
+
+// End finishes the current segment.
+func (format *TS) End() error {
+	return nil
+}
@@ -0,0 +1,79 @@
+package segment
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/jaredpetersen/raspilive/internal/mpegts"
+)
+
+func TestTS(t *testing.T) {
+	format := &TS{Pattern: "raspilive-%03d.ts"}
+
+	if name := format.Name(3, time.Time{}); name != "raspilive-003.ts" {
+		t.Error("Name returned incorrect value, got", name)
+	}
+
+	var first, second bytes.Buffer
+	format.Begin(&first, 0, ptsOffset)
+	format.Write(ptsOffset, 3000, [][]byte{fakeSPS, fakePPS, fakeIDR}, true)
+	format.End()
+	format.Begin(&second, 1, ptsOffset+3000)
+	format.Write(ptsOffset+3000, 3000, [][]byte{fakeNonIDR}, false)
+	format.End()
+
+	for _, segment := range [][]byte{first.Bytes(), second.Bytes()} {
+		if len(segment) != 3*mpegts.PacketSize {
+			t.Fatal("TS wrote incorrect number of packets, got", len(segment)/mpegts.PacketSize)
+		}
+
+		// Every segment begins with the program tables
+		if segment[1]&0x1F != 0 || segment[2] != 0 {
+			t.Error("TS did not begin segment with the PAT")
+		}
+
+		// Frames begin with an access unit delimiter
+		video := segment[2*mpegts.PacketSize:]
+		if !bytes.Contains(video, []byte{0x00, 0x00, 0x00, 0x01, 0x09, 0xF0, 0x00, 0x00, 0x00, 0x01}) {
+			t.Error("TS did not begin frame with an access unit delimiter")
+		}
+	}
+}
+
+func TestTSTimestamped(t *testing.T) {
+	format := &TS{Pattern: "raspilive-%s.ts", Timestamped: true}
+
+	captured := time.Date(2021, 3, 17, 5, 0, 1, 250000000, time.FixedZone("PDT", -7*60*60))
+	if name := format.Name(3, captured); name != "raspilive-20210317T120001.250Z.ts" {
+		t.Error("Name returned incorrect value, got", name)
+	}
+}
+
+func TestTSMetadata(t *testing.T) {
+	format := &TS{Pattern: "raspilive-%03d.ts", Metadata: true}
+
+	var segment bytes.Buffer
+	format.Begin(&segment, 0, ptsOffset)
+	format.WriteMetadata(ptsOffset, []byte(`{"door":"open"}`))
+	format.Write(ptsOffset, 3000, [][]byte{fakeSPS, fakePPS, fakeIDR}, true)
+	format.End()
+
+	if len(segment.Bytes()) != 4*mpegts.PacketSize {
+		t.Fatal("TS wrote incorrect number of packets, got", len(segment.Bytes())/mpegts.PacketSize)
+	}
+
+	// The program map table lists the metadata stream ahead of the tag itself
+	pmt := segment.Bytes()[mpegts.PacketSize : 2*mpegts.PacketSize]
+	if !bytes.Contains(pmt, []byte{0x15, 0xE1, 0x02}) {
+		t.Error("TS did not list the metadata stream in the PMT")
+	}
+
+	tag := segment.Bytes()[2*mpegts.PacketSize : 3*mpegts.PacketSize]
+	if pid := uint16(tag[1]&0x1F)<<8 | uint16(tag[2]); pid != mpegts.ID3PID {
+		t.Fatal("TS wrote metadata to incorrect PID, got", pid)
+	}
+	if !bytes.Contains(tag, []byte(`ID3`)) || !bytes.Contains(tag, []byte(`{"door":"open"}`)) {
+		t.Error("TS did not write the metadata in an ID3 tag")
+	}
+}
@@ -0,0 +1,17 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"time"
+)
+
+var now = time.Now
+
+// Clock serves the current time in UTC as an xs:dateTime, to the millisecond, so that DASH players may sync their
+// clocks with the camera and know which segments are available.
+func Clock(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	io.WriteString(w, now().UTC().Format("2006-01-02T15:04:05.000Z"))
+}
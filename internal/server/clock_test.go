@@ -0,0 +1,23 @@
+package server
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClock(t *testing.T) {
+	now = func() time.Time { return time.Date(2021, 3, 17, 5, 0, 1, 250000000, time.FixedZone("PDT", -7*60*60)) }
+	defer func() { now = time.Now }()
+
+	recorder := httptest.NewRecorder()
+	Clock(recorder, httptest.NewRequest("GET", "/camera/time", nil))
+
+	if body := recorder.Body.String(); body != "2021-03-17T12:00:01.250Z" {
+		t.Error("Clock served incorrect time, got", body)
+	}
+
+	if cacheControl := recorder.Header().Get("Cache-Control"); cacheControl != "no-store" {
+		t.Error("Clock allowed the time to be cached, got", cacheControl)
+	}
+}
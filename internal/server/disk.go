@@ -0,0 +1,30 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/jaredpetersen/raspilive/internal/disk"
+)
+
+// Disk serves what the disk space guards last saw of the space on their filesystems as JSON, for monitoring.
+type Disk struct {
+	Guards []*disk.Guard
+}
+
+func (handler *Disk) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	statuses := []disk.Status{}
+	for _, guard := range handler.Guards {
+		statuses = append(statuses, guard.Status())
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(statuses)
+}
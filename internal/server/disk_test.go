@@ -0,0 +1,51 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/jaredpetersen/raspilive/internal/disk"
+)
+
+func TestDisk(t *testing.T) {
+	dir, err := ioutil.TempDir("", "raspilive-server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	guard := &disk.Guard{Directory: dir, Options: disk.Options{MinFree: 1}}
+	if err := guard.Check(); err != nil {
+		t.Fatal("Check returned an error", err)
+	}
+
+	recorder := httptest.NewRecorder()
+	handler := &Disk{Guards: []*disk.Guard{guard}}
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/camera/disk", nil))
+
+	if contentType := recorder.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Error("Disk responded with incorrect content type, got", contentType)
+	}
+
+	var statuses []disk.Status
+	if err := json.Unmarshal(recorder.Body.Bytes(), &statuses); err != nil {
+		t.Fatal("Disk served invalid JSON", err)
+	}
+	if len(statuses) != 1 || statuses[0].Directory != dir || statuses[0].Total <= 0 || statuses[0].MinFree != 1 {
+		t.Error("Disk served incorrect status, got", statuses)
+	}
+}
+
+func TestDiskRejectsPost(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	handler := &Disk{}
+	handler.ServeHTTP(recorder, httptest.NewRequest("POST", "/camera/disk", nil))
+
+	if recorder.Code != http.StatusMethodNotAllowed {
+		t.Error("Disk responded with incorrect status, got", recorder.Code)
+	}
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/jaredpetersen/raspilive/internal/export"
+	"github.com/jaredpetersen/raspilive/internal/segment"
+	"github.com/rs/zerolog/hlog"
+)
+
+// Export serves the footage captured between the wall clock times given by the `start` and `end` query parameters,
+// formatted per RFC 3339, as a downloadable MP4 file.
+//
+// The file is remuxed from whole segments that are still kept in storage, so it may begin a little before the start
+// and end a little after the end.
+type Export struct {
+	Catalog    *export.Catalog // Segments to export footage from
+	FileSystem http.FileSystem // Where the segments are kept
+	Auth       string          // Credentials required to export footage, formatted as username:password
+}
+
+func (handler *Export) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if handler.Auth != "" {
+		basicAuth(handler.Auth, http.HandlerFunc(handler.export)).ServeHTTP(w, r)
+		return
+	}
+
+	handler.export(w, r)
+}
+
+// export serves the footage in the requested range as a downloadable MP4 file.
+func (handler *Export) export(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	start, err := time.Parse(time.RFC3339Nano, r.URL.Query().Get("start"))
+	if err != nil {
+		http.Error(w, "Invalid start", http.StatusBadRequest)
+		return
+	}
+
+	end, err := time.Parse(time.RFC3339Nano, r.URL.Query().Get("end"))
+	if err != nil || !end.After(start) {
+		http.Error(w, "Invalid end", http.StatusBadRequest)
+		return
+	}
+
+	segments := handler.Catalog.Range(start, end)
+	if len(segments) == 0 {
+		http.Error(w, "No video in range", http.StatusNotFound)
+		return
+	}
+
+	// Nothing goes out until the first frame does, so errors up until then may still be reported properly
+	out := &lazyWriter{w: w, name: fmt.Sprintf("raspilive-%s.mp4", start.UTC().Format(segment.TimeLayout))}
+
+	err = export.Write(out, handler.FileSystem, handler.Catalog.Init(), segments)
+	switch {
+	case err == export.ErrNoVideo:
+		http.Error(w, "No video in range", http.StatusNotFound)
+	case err != nil && !out.started:
+		hlog.FromRequest(r).Debug().Err(err).Msg("Encountered an error exporting video")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	case err != nil:
+		// The response is already underway, so all that can be done is to cut it short
+		hlog.FromRequest(r).Debug().Err(err).Msg("Encountered an error exporting video")
+	}
+}
+
+// lazyWriter sends the headers for the downloadable file along with the first write.
+type lazyWriter struct {
+	w       http.ResponseWriter
+	name    string
+	started bool
+}
+
+func (lw *lazyWriter) Write(p []byte) (int, error) {
+	if !lw.started {
+		lw.w.Header().Set("Content-Type", "video/mp4")
+		lw.w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", lw.name))
+		lw.w.Header().Set("Cache-Control", "no-store")
+		lw.started = true
+	}
+
+	return lw.w.Write(p)
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jaredpetersen/raspilive/internal/export"
+	"github.com/jaredpetersen/raspilive/internal/h264"
+	"github.com/jaredpetersen/raspilive/internal/memfs"
+	"github.com/jaredpetersen/raspilive/internal/segment"
+)
+
+// exportCatalog segments a few seconds of video into the store, starting at the given time.
+func exportCatalog(t *testing.T, store *memfs.Store, start time.Time) *export.Catalog {
+	catalog := &export.Catalog{}
+
+	format := &segment.TS{Pattern: "raspilive-%d.ts"}
+	seg := segment.New(store, format, segment.Options{Fps: 30, SegmentTime: 1, Epoch: start}, catalog)
+
+	sps := []byte{
+		0x67, 0x64, 0x00, 0x1F, 0xAC, 0xD9, 0x40, 0x50, 0x05, 0xBB, 0x01, 0x10, 0x00, 0x00, 0x03, 0x00,
+		0x10, 0x00, 0x00, 0x03, 0x03, 0xC0, 0xF1, 0x83, 0x19, 0x60,
+	}
+	pps := []byte{0x68, 0xEE, 0x3C, 0x80}
+	for i := 0; i < 90; i++ {
+		au := h264.AccessUnit{NALUnits: [][]byte{{0x41, 0x9A, 0x02}}}
+		if i%30 == 0 {
+			au = h264.AccessUnit{NALUnits: [][]byte{sps, pps, {0x65, 0x88, 0x84}}}
+		}
+		if err := seg.Write(au); err != nil {
+			t.Fatal("Write returned an error", err)
+		}
+	}
+	seg.Close()
+
+	return catalog
+}
+
+func TestExport(t *testing.T) {
+	start := time.Date(2021, 3, 17, 12, 0, 0, 0, time.UTC)
+	store := &memfs.Store{}
+	handler := &Export{Catalog: exportCatalog(t, store, start), FileSystem: store}
+
+	request := httptest.NewRequest("GET", "/camera/export?start=2021-03-17T12:00:01.5Z&end=2021-03-17T05:00:02.5-07:00", nil)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+
+	if recorder.Code != http.StatusOK {
+		t.Fatal("Export responded with incorrect status, got", recorder.Code)
+	}
+	if contentType := recorder.Header().Get("Content-Type"); contentType != "video/mp4" {
+		t.Error("Export responded with incorrect content type, got", contentType)
+	}
+	if disposition := recorder.Header().Get("Content-Disposition"); disposition != `attachment; filename="raspilive-20210317T120001.500Z.mp4"` {
+		t.Error("Export responded with incorrect content disposition, got", disposition)
+	}
+
+	// The range covers the second and third segments
+	if count := bytes.Count(recorder.Body.Bytes(), []byte("moof")); count != 2 {
+		t.Error("Export responded with incorrect number of fragments, got", count)
+	}
+}
+
+func TestExportRejectsRequests(t *testing.T) {
+	testCases := []struct {
+		name   string
+		method string
+		query  string
+		status int
+	}{
+		{"post", "POST", "start=2021-03-17T12:00:00Z&end=2021-03-17T12:00:01Z", http.StatusMethodNotAllowed},
+		{"missing start", "GET", "end=2021-03-17T12:00:01Z", http.StatusBadRequest},
+		{"invalid end", "GET", "start=2021-03-17T12:00:00Z&end=noon", http.StatusBadRequest},
+		{"end before start", "GET", "start=2021-03-17T12:00:01Z&end=2021-03-17T12:00:00Z", http.StatusBadRequest},
+		{"no video", "GET", "start=2021-03-17T13:00:00Z&end=2021-03-17T14:00:00Z", http.StatusNotFound},
+	}
+
+	start := time.Date(2021, 3, 17, 12, 0, 0, 0, time.UTC)
+	store := &memfs.Store{}
+	handler := &Export{Catalog: exportCatalog(t, store, start), FileSystem: store}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(tc.method, "/camera/export?"+tc.query, nil))
+
+			if recorder.Code != tc.status {
+				t.Error("Export responded with incorrect status, got", recorder.Code)
+			}
+		})
+	}
+}
+
+func TestExportRemovedSegments(t *testing.T) {
+	start := time.Date(2021, 3, 17, 12, 0, 0, 0, time.UTC)
+	store := &memfs.Store{}
+	catalog := exportCatalog(t, store, start)
+	for _, seg := range catalog.Range(start, start.Add(time.Hour)) {
+		store.Remove(seg.Name)
+	}
+
+	handler := &Export{Catalog: catalog, FileSystem: store}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/camera/export?start=2021-03-17T12:00:00Z&end=2021-03-17T12:00:03Z", nil))
+
+	if recorder.Code != http.StatusNotFound {
+		t.Error("Export responded with incorrect status, got", recorder.Code)
+	}
+	if disposition := recorder.Header().Get("Content-Disposition"); disposition != "" {
+		t.Error("Export offered a download without any video")
+	}
+}
+
+func TestExportAuth(t *testing.T) {
+	start := time.Date(2021, 3, 17, 12, 0, 0, 0, time.UTC)
+	store := &memfs.Store{}
+	handler := &Export{Catalog: exportCatalog(t, store, start), FileSystem: store, Auth: "viewer:secret"}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/camera/export?start=2021-03-17T12:00:00Z&end=2021-03-17T12:00:01Z", nil))
+	if recorder.Code != http.StatusUnauthorized {
+		t.Error("Export served footage without credentials, got", recorder.Code)
+	}
+
+	request := httptest.NewRequest("GET", "/camera/export?start=2021-03-17T12:00:00Z&end=2021-03-17T12:00:01Z", nil)
+	request.SetBasicAuth("viewer", "secret")
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusOK {
+		t.Error("Export refused footage with credentials, got", recorder.Code)
+	}
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/jaredpetersen/raspilive/internal/metadata"
+)
+
+// maxMetadataSize is the largest metadata document accepted, keeping the tags small enough to ride along with the
+// video without holding it up.
+const maxMetadataSize = 16 * 1024
+
+// Metadata accepts timed metadata, such as telemetry from sensors, as JSON documents via HTTP POST and queues them up
+// to be carried alongside the video captured as they arrive.
+type Metadata struct {
+	Queue *metadata.Queue // Where to queue up the documents
+	Auth  string          // Credentials required to post metadata, formatted as username:password
+}
+
+func (handler *Metadata) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if handler.Auth != "" {
+		basicAuth(handler.Auth, http.HandlerFunc(handler.accept)).ServeHTTP(w, r)
+		return
+	}
+
+	handler.accept(w, r)
+}
+
+// accept queues up the JSON document in the body of the request.
+func (handler *Metadata) accept(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxMetadataSize))
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	// Documents are kept on a single line so that they come through the same however they were formatted
+	var document bytes.Buffer
+	if err := json.Compact(&document, body); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	handler.Queue.Push(document.Bytes())
+
+	w.WriteHeader(http.StatusNoContent)
+}
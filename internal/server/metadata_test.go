@@ -0,0 +1,78 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jaredpetersen/raspilive/internal/metadata"
+)
+
+func TestMetadata(t *testing.T) {
+	queue := &metadata.Queue{}
+	handler := &Metadata{Queue: queue}
+
+	request := httptest.NewRequest("POST", "/camera/metadata", strings.NewReader("{\n  \"temperature\": 21.5\n}"))
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+
+	if recorder.Code != http.StatusNoContent {
+		t.Error("Metadata responded with incorrect status, got", recorder.Code)
+	}
+
+	taken := queue.Take(time.Now())
+	if len(taken) != 1 || string(taken[0]) != `{"temperature":21.5}` {
+		t.Error("Metadata queued incorrect document, got", taken)
+	}
+}
+
+func TestMetadataRejectsRequests(t *testing.T) {
+	testCases := []struct {
+		name   string
+		method string
+		body   string
+		status int
+	}{
+		{"get", "GET", "", http.StatusMethodNotAllowed},
+		{"invalid json", "POST", `{"temperature":`, http.StatusBadRequest},
+		{"too large", "POST", `"` + strings.Repeat("x", maxMetadataSize) + `"`, http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			queue := &metadata.Queue{}
+			handler := &Metadata{Queue: queue}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(tc.method, "/camera/metadata", strings.NewReader(tc.body)))
+
+			if recorder.Code != tc.status {
+				t.Error("Metadata responded with incorrect status, got", recorder.Code)
+			}
+			if taken := queue.Take(time.Now()); taken != nil {
+				t.Error("Metadata queued a rejected document, got", taken)
+			}
+		})
+	}
+}
+
+func TestMetadataAuth(t *testing.T) {
+	queue := &metadata.Queue{}
+	handler := &Metadata{Queue: queue, Auth: "sensor:secret"}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("POST", "/camera/metadata", strings.NewReader(`{"door":"open"}`)))
+	if recorder.Code != http.StatusUnauthorized {
+		t.Error("Metadata accepted a document without credentials, got", recorder.Code)
+	}
+
+	request := httptest.NewRequest("POST", "/camera/metadata", strings.NewReader(`{"door":"open"}`))
+	request.SetBasicAuth("sensor", "secret")
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusNoContent {
+		t.Error("Metadata rejected a document with credentials, got", recorder.Code)
+	}
+}
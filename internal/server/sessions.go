@@ -0,0 +1,119 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/jaredpetersen/raspilive/internal/session"
+	"github.com/rs/zerolog/hlog"
+)
+
+// Sessions serves the sessions in the library, listed as JSON from `index.json` along with a page at the root of the
+// route to browse and play them back.
+type Sessions struct {
+	Library *session.Library
+}
+
+func (handler *Sessions) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/index.json"):
+		handler.serveIndex(w, r)
+	case strings.HasSuffix(r.URL.Path, "/"):
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		io.WriteString(w, sessionsPage)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (handler *Sessions) serveIndex(w http.ResponseWriter, r *http.Request) {
+	sessions, err := handler.Library.Sessions()
+	if err != nil {
+		hlog.FromRequest(r).Debug().Err(err).Msg("Encountered an error listing sessions")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if sessions == nil {
+		sessions = []session.Session{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(sessions)
+}
+
+// sessionsPage lists the sessions newest first, playing them back natively where HLS is supported and with hls.js
+// elsewhere.
+const sessionsPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>raspilive sessions</title>
+<style>
+body { font-family: sans-serif; margin: 1em auto; max-width: 960px; padding: 0 1em; }
+video { background: #000; width: 100%; }
+table { border-collapse: collapse; width: 100%; }
+td, th { padding: 0.4em; text-align: left; }
+tr:nth-child(even) { background: #eee; }
+</style>
+<script src="https://cdn.jsdelivr.net/npm/hls.js@1"></script>
+</head>
+<body>
+<h1>Sessions</h1>
+<video id="player" controls></video>
+<table>
+<thead><tr><th>Started</th><th>Duration</th><th>Size</th><th></th></tr></thead>
+<tbody id="sessions"></tbody>
+</table>
+<script>
+var player = document.getElementById("player");
+var hls;
+
+function play(playlist) {
+  if (hls) {
+    hls.destroy();
+    hls = null;
+  }
+  if (!player.canPlayType("application/vnd.apple.mpegurl") && window.Hls && Hls.isSupported()) {
+    hls = new Hls();
+    hls.loadSource(playlist);
+    hls.attachMedia(player);
+  } else {
+    player.src = playlist;
+  }
+  player.play();
+}
+
+function duration(seconds) {
+  var minutes = Math.floor(seconds / 60);
+  return Math.floor(minutes / 60) + "h " + (minutes % 60) + "m " + Math.floor(seconds % 60) + "s";
+}
+
+fetch("index.json").then(function (response) {
+  return response.json();
+}).then(function (sessions) {
+  var list = document.getElementById("sessions");
+  sessions.reverse().forEach(function (session) {
+    var row = list.insertRow();
+    row.insertCell().textContent = new Date(session.start).toLocaleString();
+    row.insertCell().textContent = duration(session.duration);
+    row.insertCell().textContent = (session.size / 1024 / 1024).toFixed(1) + " MB";
+    var button = document.createElement("button");
+    button.textContent = session.live ? "Watch (live)" : "Play";
+    button.onclick = function () { play("../" + session.playlist); };
+    row.insertCell().appendChild(button);
+  });
+});
+</script>
+</body>
+</html>
+`
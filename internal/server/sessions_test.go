@@ -0,0 +1,92 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/jaredpetersen/raspilive/internal/session"
+)
+
+func TestSessionsIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "raspilive-server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	library := &session.Library{Directory: dir}
+	tracker, err := library.Start()
+	if err != nil {
+		t.Fatal("Start returned an error", err)
+	}
+
+	recorder := httptest.NewRecorder()
+	handler := &Sessions{Library: library}
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/camera/sessions/index.json", nil))
+
+	if contentType := recorder.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Error("Sessions responded with incorrect content type, got", contentType)
+	}
+
+	var sessions []session.Session
+	if err := json.Unmarshal(recorder.Body.Bytes(), &sessions); err != nil {
+		t.Fatal("Sessions served invalid JSON", err)
+	}
+	if len(sessions) != 1 || sessions[0].Name != tracker.Name() || !sessions[0].Live {
+		t.Error("Sessions served incorrect sessions, got", sessions)
+	}
+}
+
+func TestSessionsIndexEmpty(t *testing.T) {
+	dir, err := ioutil.TempDir("", "raspilive-server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	recorder := httptest.NewRecorder()
+	handler := &Sessions{Library: &session.Library{Directory: dir}}
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/camera/sessions/index.json", nil))
+
+	if body := strings.TrimSpace(recorder.Body.String()); body != "[]" {
+		t.Error("Sessions served incorrect sessions, got", body)
+	}
+}
+
+func TestSessionsPage(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	handler := &Sessions{Library: &session.Library{}}
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/camera/sessions/", nil))
+
+	if contentType := recorder.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/html") {
+		t.Error("Sessions responded with incorrect content type, got", contentType)
+	}
+	if !strings.Contains(recorder.Body.String(), `fetch("index.json")`) {
+		t.Error("Sessions served incorrect page")
+	}
+}
+
+func TestSessionsNotFound(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	handler := &Sessions{Library: &session.Library{}}
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/camera/sessions/other", nil))
+
+	if recorder.Code != http.StatusNotFound {
+		t.Error("Sessions responded with incorrect status, got", recorder.Code)
+	}
+}
+
+func TestSessionsRejectsPost(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	handler := &Sessions{}
+	handler.ServeHTTP(recorder, httptest.NewRequest("POST", "/camera/sessions/", nil))
+
+	if recorder.Code != http.StatusMethodNotAllowed {
+		t.Error("Sessions responded with incorrect status, got", recorder.Code)
+	}
+}
@@ -2,7 +2,9 @@ package server
 
 import (
 	"context"
+	"crypto/subtle"
 	"errors"
+	"mime"
 	"net"
 	"net/http"
 	"os"
@@ -17,30 +19,61 @@ import (
 // ErrInvalidDirectory indicates that the provided directory does not exist
 var ErrInvalidDirectory = errors.New("directory does not exist")
 
+func init() {
+	// Not every system knows about session descriptions, so make sure players are told what they're getting
+	mime.AddExtensionType(".sdp", "application/sdp")
+}
+
 // Static is a static file server.
 //
 // Files may be accessed via the route `/camera`.
 type Static struct {
-	Port      int    // Port the server runs on. Uses the next available port if one is not provided.
-	Cert      string // Location of a certificate file for TLS
-	Key       string // Location of a key file for TLS
-	Directory string // Directory the files should be served from
-	listener  net.Listener
-	server    http.Server
+	Port       int             // Port the server runs on. Uses the next available port if one is not provided.
+	Cert       string          // Location of a certificate file for TLS
+	Key        string          // Location of a key file for TLS
+	Directory  string          // Directory the files should be served from
+	FileSystem http.FileSystem // Files to serve in place of the directory, such as video kept in memory
+	KeyAuth    string          // Credentials required to fetch keys, formatted as username:password
+	handlers   map[string]http.Handler
+	keys       http.Handler
+	listener   net.Listener
+	server     http.Server
 }
 
-// ListenAndServe begins listening on the configured port and serving static files.
-func (stcsrv *Static) ListenAndServe() error {
-	var dir string
-	if stcsrv.Directory == "" {
-		dir = "."
-	} else {
-		dir = stcsrv.Directory
+// Handle registers an additional handler for the given path under the route `/camera`.
+//
+// Handlers take precedence over files in the directory and must be registered before calling ListenAndServe.
+func (stcsrv *Static) Handle(path string, handler http.Handler) {
+	if stcsrv.handlers == nil {
+		stcsrv.handlers = make(map[string]http.Handler)
 	}
 
-	_, err := os.Stat(dir)
-	if os.IsNotExist(err) {
-		return ErrInvalidDirectory
+	stcsrv.handlers[path] = handler
+}
+
+// HandleKeys registers the handler that serves encryption keys via the route `/keys`.
+//
+// Keys are kept apart from the files under `/camera` so that they may require credentials while the video remains
+// cacheable. The handler must be registered before calling ListenAndServe.
+func (stcsrv *Static) HandleKeys(handler http.Handler) {
+	stcsrv.keys = handler
+}
+
+// ListenAndServe begins listening on the configured port and serving static files.
+func (stcsrv *Static) ListenAndServe() error {
+	// There is nothing on disk to check for when serving from a file system
+	if stcsrv.FileSystem == nil {
+		var dir string
+		if stcsrv.Directory == "" {
+			dir = "."
+		} else {
+			dir = stcsrv.Directory
+		}
+
+		_, err := os.Stat(dir)
+		if os.IsNotExist(err) {
+			return ErrInvalidDirectory
+		}
 	}
 
 	if err := stcsrv.listen(); err != nil {
@@ -80,8 +113,23 @@ func (stcsrv *Static) serve() error {
 	middlewareChain = middlewareChain.Append(hlog.UserAgentHandler("user_agent"))
 	middlewareChain = middlewareChain.Append(hlog.RefererHandler("referer"))
 
+	fileSystem := stcsrv.FileSystem
+	if fileSystem == nil {
+		fileSystem = http.Dir(stcsrv.Directory)
+	}
+
 	router := http.NewServeMux()
-	router.Handle("/camera/", middlewareChain.Then(http.StripPrefix("/camera", http.FileServer(http.Dir(stcsrv.Directory)))))
+	router.Handle("/camera/", middlewareChain.Then(http.StripPrefix("/camera", http.FileServer(fileSystem))))
+	for path, handler := range stcsrv.handlers {
+		router.Handle("/camera"+path, middlewareChain.Then(handler))
+	}
+	if stcsrv.keys != nil {
+		keys := stcsrv.keys
+		if stcsrv.KeyAuth != "" {
+			keys = basicAuth(stcsrv.KeyAuth, keys)
+		}
+		router.Handle("/keys/", middlewareChain.Then(keys))
+	}
 
 	stcsrv.server = http.Server{Handler: router}
 
@@ -101,6 +149,21 @@ func (stcsrv *Static) serve() error {
 	return nil
 }
 
+// basicAuth requires requests to provide the credentials, formatted as username:password, via HTTP basic authentication.
+func basicAuth(credentials string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		given := username + ":" + password
+		if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(credentials)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="raspilive"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 func logMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Our middleware logic goes here...
@@ -10,12 +10,14 @@ import (
 	"net/http"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 )
 
 // Generated cert and key via the crypto/tls package:
-//   go run generate_cert.go  --rsa-bits 1024 --host 127.0.0.1,::1,example.com --ca --start-date "Jan 1 00:00:00 1970" --duration=1000000h
+//
+//	go run generate_cert.go  --rsa-bits 1024 --host 127.0.0.1,::1,example.com --ca --start-date "Jan 1 00:00:00 1970" --duration=1000000h
 var tlsCert = []byte(`-----BEGIN CERTIFICATE-----
 MIICNTCCAZ6gAwIBAgIRAPc89REgYR2GEXgKW7Ebd/QwDQYJKoZIhvcNAQELBQAw
 EjEQMA4GA1UEChMHQWNtZSBDbzAgFw03MDAxMDEwMDAwMDBaGA8yMDg0MDEyOTE2
@@ -141,6 +143,163 @@ func TestListenAndServeServesFileWithTLS(t *testing.T) {
 	}
 }
 
+func TestListenAndServeServesFileSystem(t *testing.T) {
+	tempDir := t.TempDir()
+	srv := Static{
+		Directory:  "totallybaddirectory",
+		FileSystem: http.Dir(tempDir),
+	}
+
+	fileContent := []byte("get in the robot! 🤖")
+	ioutil.WriteFile(filepath.Join(tempDir, "instructions.txt"), fileContent, 0644)
+
+	go srv.ListenAndServe()
+	defer srv.Shutdown(0)
+	time.Sleep(100 * time.Millisecond)
+
+	resp, err := http.Get("http://localhost:" + strconv.Itoa(srv.Port) + "/camera/instructions.txt")
+	if err != nil {
+		t.Fatal("Request to server failed:", err)
+	}
+	if resp.StatusCode != 200 {
+		t.Error("Request to server failed with status code", resp.StatusCode)
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	if bytes.Compare(fileContent, body) != 0 {
+		t.Error("Response body did not match, given:", body)
+	}
+}
+
+func TestListenAndServeServesSessionDescription(t *testing.T) {
+	tempDir := t.TempDir()
+	srv := Static{
+		Directory: tempDir,
+	}
+
+	go srv.ListenAndServe()
+	defer srv.Shutdown(0)
+	time.Sleep(100 * time.Millisecond)
+
+	ioutil.WriteFile(filepath.Join(tempDir, "livestream.sdp"), []byte("v=0\r\n"), 0644)
+
+	resp, err := http.Get("http://localhost:" + strconv.Itoa(srv.Port) + "/camera/livestream.sdp")
+	if err != nil {
+		t.Fatal("Request to server failed:", err)
+	}
+	if resp.StatusCode != 200 {
+		t.Error("Request to server failed with status code", resp.StatusCode)
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	if contentType != "application/sdp" {
+		t.Error("Response has incorrect content type:", contentType)
+	}
+}
+
+func TestListenAndServeServesHandler(t *testing.T) {
+	tempDir := t.TempDir()
+	srv := Static{
+		Directory: tempDir,
+	}
+
+	// Set up a file that the handler should take precedence over
+	ioutil.WriteFile(filepath.Join(tempDir, "instructions.txt"), []byte("get in the robot! 🤖"), 0644)
+
+	handlerContent := []byte("beep boop")
+	srv.Handle("/instructions.txt", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(handlerContent)
+	}))
+
+	go srv.ListenAndServe()
+	defer srv.Shutdown(0)
+	time.Sleep(100 * time.Millisecond)
+
+	resp, err := http.Get("http://localhost:" + strconv.Itoa(srv.Port) + "/camera/instructions.txt")
+	if err != nil {
+		t.Fatal("Request to server failed:", err)
+	}
+	if resp.StatusCode != 200 {
+		t.Error("Request to server failed with status code", resp.StatusCode)
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	if bytes.Compare(handlerContent, body) != 0 {
+		t.Error("Response body did not match, given:", body)
+	}
+}
+
+func TestListenAndServeServesKeys(t *testing.T) {
+	srv := Static{}
+
+	key := []byte("0123456789abcdef")
+	srv.HandleKeys(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(key)
+	}))
+
+	go srv.ListenAndServe()
+	defer srv.Shutdown(0)
+	time.Sleep(100 * time.Millisecond)
+
+	resp, err := http.Get("http://localhost:" + strconv.Itoa(srv.Port) + "/keys/raspilive-0.key")
+	if err != nil {
+		t.Fatal("Request to server failed:", err)
+	}
+	if resp.StatusCode != 200 {
+		t.Error("Request to server failed with status code", resp.StatusCode)
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	if bytes.Compare(key, body) != 0 {
+		t.Error("Response body did not match, given:", body)
+	}
+}
+
+func TestListenAndServeServesKeysWithAuth(t *testing.T) {
+	srv := Static{KeyAuth: "robot:beepboop"}
+
+	srv.HandleKeys(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("0123456789abcdef"))
+	}))
+
+	go srv.ListenAndServe()
+	defer srv.Shutdown(0)
+	time.Sleep(100 * time.Millisecond)
+
+	url := "http://localhost:" + strconv.Itoa(srv.Port) + "/keys/raspilive-0.key"
+
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal("Request to server failed:", err)
+	}
+	if resp.StatusCode != 401 {
+		t.Error("Request to server without credentials returned status code", resp.StatusCode)
+	}
+
+	for credentials, expectedStatus := range map[string]int{"robot:beepboop": 200, "robot:wrong": 401} {
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		parts := strings.SplitN(credentials, ":", 2)
+		req.SetBasicAuth(parts[0], parts[1])
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal("Request to server failed:", err)
+		}
+		if resp.StatusCode != expectedStatus {
+			t.Error("Request to server with credentials", credentials, "returned status code", resp.StatusCode)
+		}
+	}
+
+	// The video itself remains open to everyone
+	resp, err = http.Get("http://localhost:" + strconv.Itoa(srv.Port) + "/camera")
+	if err != nil {
+		t.Fatal("Request to server failed:", err)
+	}
+	if resp.StatusCode != 200 {
+		t.Error("Request to server failed with status code", resp.StatusCode)
+	}
+}
+
 func TestListenAndServeReturns404(t *testing.T) {
 	srv := Static{}
 
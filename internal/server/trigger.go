@@ -0,0 +1,33 @@
+package server
+
+import (
+	"net/http"
+)
+
+// Trigger kicks off an action via HTTP POST, such as saving a clip when a motion sensor goes off.
+type Trigger struct {
+	Action func() // What to do when triggered, which must not block
+	Auth   string // Credentials required to trigger the action, formatted as username:password
+}
+
+func (handler *Trigger) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if handler.Auth != "" {
+		basicAuth(handler.Auth, http.HandlerFunc(handler.trigger)).ServeHTTP(w, r)
+		return
+	}
+
+	handler.trigger(w, r)
+}
+
+// trigger kicks off the action, which carries on after the response.
+func (handler *Trigger) trigger(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	handler.Action()
+
+	w.WriteHeader(http.StatusAccepted)
+}
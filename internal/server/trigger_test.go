@@ -0,0 +1,53 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTrigger(t *testing.T) {
+	var triggered int
+	handler := &Trigger{Action: func() { triggered++ }}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("POST", "/camera/clip", nil))
+
+	if recorder.Code != http.StatusAccepted {
+		t.Error("Trigger responded with incorrect status, got", recorder.Code)
+	}
+	if triggered != 1 {
+		t.Error("Trigger did not kick off the action")
+	}
+}
+
+func TestTriggerRejectsRequests(t *testing.T) {
+	testCases := []struct {
+		name     string
+		method   string
+		username string
+		status   int
+	}{
+		{"get", "GET", "camera", http.StatusMethodNotAllowed},
+		{"unauthorized", "POST", "intruder", http.StatusUnauthorized},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var triggered int
+			handler := &Trigger{Action: func() { triggered++ }, Auth: "camera:hunter2"}
+
+			request := httptest.NewRequest(tc.method, "/camera/clip", nil)
+			request.SetBasicAuth(tc.username, "hunter2")
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+
+			if recorder.Code != tc.status {
+				t.Error("Trigger responded with incorrect status, got", recorder.Code)
+			}
+			if triggered != 0 {
+				t.Error("Trigger kicked off the action for a rejected request")
+			}
+		})
+	}
+}
@@ -0,0 +1,240 @@
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jaredpetersen/raspilive/internal/segment"
+)
+
+// InfoName is the name of the file in each session's directory that describes it.
+const InfoName = "session.json"
+
+// PlaylistName is the name of the HLS playlist in each session's directory that lists every segment from the start of
+// the session.
+const PlaylistName = "vod.m3u8"
+
+var now = time.Now
+
+// Session is a single run of a stream, kept in a directory of its own within the library.
+type Session struct {
+	Name     string    `json:"name"`     // Directory the session is kept in, relative to the library
+	Start    time.Time `json:"start"`    // Wall clock time that the session started
+	Duration float64   `json:"duration"` // Duration of the video so far in seconds
+	Size     int64     `json:"size"`     // Size of the segments so far in bytes
+	Segments int       `json:"segments"` // Number of segments so far
+	Live     bool      `json:"live"`     // Whether the session is still being streamed
+	Playlist string    `json:"playlist"` // Path of the playlist of the whole session, relative to the library
+}
+
+// Library keeps every run of a stream in a directory of its own, named with the time it started, removing the oldest
+// sessions past the retention limit as new ones start.
+//
+// Libraries are safe for concurrent use.
+type Library struct {
+	Directory   string
+	MaxSessions int // Maximum number of sessions to keep, removing the oldest past it if not zero
+	mu          sync.Mutex
+	current     string // Name of the session in progress, which is never removed
+}
+
+// Start starts a new session, creating its directory, then applies the retention limit.
+//
+// Sessions that were still live when the stream last stopped, such as after a crash, are finished off first so that
+// they may be played back.
+func (library *Library) Start() (*Tracker, error) {
+	library.mu.Lock()
+	defer library.mu.Unlock()
+
+	if info, err := os.Stat(library.Directory); err != nil || !info.IsDir() {
+		return nil, errors.New("session: invalid directory")
+	}
+
+	sessions, err := library.sessions()
+	if err != nil {
+		return nil, err
+	}
+	for _, session := range sessions {
+		if session.Live {
+			if err := library.recover(session); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	started := now().UTC()
+	session := Session{
+		Name:  started.Format(segment.TimeLayout),
+		Start: started,
+		Live:  true,
+	}
+	session.Playlist = session.Name + "/" + PlaylistName
+
+	if err := os.Mkdir(path.Join(library.Directory, session.Name), 0755); err != nil {
+		return nil, err
+	}
+
+	tracker := &Tracker{library: library, session: session}
+	if err := library.write(session); err != nil {
+		return nil, err
+	}
+	library.current = session.Name
+
+	sessions = append(sessions, session)
+	for library.MaxSessions > 0 && len(sessions) > library.MaxSessions {
+		if err := library.remove(sessions[0].Name); err != nil {
+			return nil, err
+		}
+		sessions = sessions[1:]
+	}
+
+	return tracker, nil
+}
+
+// Sessions returns the sessions in the library, oldest first.
+func (library *Library) Sessions() ([]Session, error) {
+	library.mu.Lock()
+	defer library.mu.Unlock()
+
+	return library.sessions()
+}
+
+// PruneOldest removes the oldest session to free up space, reporting whether there was one to remove. The session in
+// progress is always kept.
+func (library *Library) PruneOldest() (bool, error) {
+	library.mu.Lock()
+	defer library.mu.Unlock()
+
+	sessions, err := library.sessions()
+	if err != nil {
+		return false, err
+	}
+
+	for _, session := range sessions {
+		if session.Name != library.current {
+			return true, library.remove(session.Name)
+		}
+	}
+
+	return false, nil
+}
+
+// sessions reads the descriptions of the sessions in the directory, skipping any directories without one.
+func (library *Library) sessions() ([]Session, error) {
+	entries, err := ioutil.ReadDir(library.Directory)
+	if err != nil {
+		return nil, err
+	}
+
+	var sessions []Session
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		data, err := ioutil.ReadFile(path.Join(library.Directory, entry.Name(), InfoName))
+		if err != nil {
+			continue
+		}
+
+		var session Session
+		if err := json.Unmarshal(data, &session); err != nil || session.Name != entry.Name() {
+			continue
+		}
+		sessions = append(sessions, session)
+	}
+
+	// Sessions are named by the time they started
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].Name < sessions[j].Name })
+
+	return sessions, nil
+}
+
+// recover finishes off a session that was cut short, ending its playlist where it left off.
+func (library *Library) recover(session Session) error {
+	name := path.Join(library.Directory, session.Playlist)
+
+	playlist, err := ioutil.ReadFile(name)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil && !strings.HasSuffix(string(playlist), "#EXT-X-ENDLIST\n") {
+		file, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(file, "#EXT-X-ENDLIST\n")
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	session.Live = false
+
+	return library.write(session)
+}
+
+// remove removes the session along with all of its video.
+func (library *Library) remove(name string) error {
+	return os.RemoveAll(path.Join(library.Directory, name))
+}
+
+func (library *Library) write(session Session) error {
+	return segment.WriteFile(segment.Dir(library.Directory), session.Name+"/"+InfoName, func(w io.Writer) error {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(session)
+	})
+}
+
+// Tracker keeps the description of the session in progress up to date with the segments written to it, marking it as
+// finished once the stream ends.
+type Tracker struct {
+	library *Library
+	session Session
+	last    int // Sequence number of the newest segment counted so far
+}
+
+// Name returns the name of the session's directory within the library.
+func (tracker *Tracker) Name() string {
+	return tracker.session.Name
+}
+
+// Directory returns the directory that the session's video is written to.
+func (tracker *Tracker) Directory() string {
+	return path.Join(tracker.library.Directory, tracker.session.Name)
+}
+
+// Update counts the segments that are new since the last update towards the session.
+func (tracker *Tracker) Update(segments []segment.Segment, ended bool) error {
+	for _, seg := range segments {
+		if tracker.session.Segments > 0 && seg.Number <= tracker.last {
+			continue
+		}
+
+		tracker.session.Duration += seg.Seconds()
+		tracker.session.Size += seg.Size
+		tracker.session.Segments++
+		tracker.last = seg.Number
+	}
+
+	if ended {
+		tracker.session.Live = false
+	}
+
+	tracker.library.mu.Lock()
+	defer tracker.library.mu.Unlock()
+
+	return tracker.library.write(tracker.session)
+}
@@ -0,0 +1,229 @@
+package session
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/jaredpetersen/raspilive/internal/segment"
+)
+
+func stubNow(t *testing.T, stub time.Time) {
+	now = func() time.Time { return stub }
+	t.Cleanup(func() { now = time.Now })
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "raspilive-session")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	return dir
+}
+
+func readFile(t *testing.T, name string) string {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal("Failed to read file", err)
+	}
+
+	return string(data)
+}
+
+// startAt starts a session in the library as though it were the given time.
+func startAt(t *testing.T, library *Library, started time.Time) *Tracker {
+	stubNow(t, started)
+
+	tracker, err := library.Start()
+	if err != nil {
+		t.Fatal("Start returned an error", err)
+	}
+
+	return tracker
+}
+
+func names(sessions []Session) []string {
+	var names []string
+	for _, session := range sessions {
+		names = append(names, session.Name)
+	}
+
+	return names
+}
+
+func TestStart(t *testing.T) {
+	dir := tempDir(t)
+	started := time.Date(2021, 3, 17, 12, 0, 0, 0, time.UTC)
+
+	library := &Library{Directory: dir}
+	tracker := startAt(t, library, started)
+
+	if tracker.Name() != "20210317T120000.000Z" || tracker.Directory() != path.Join(dir, "20210317T120000.000Z") {
+		t.Error("Start named the session incorrectly, got", tracker.Name(), tracker.Directory())
+	}
+	if info, err := os.Stat(tracker.Directory()); err != nil || !info.IsDir() {
+		t.Fatal("Start did not create the session's directory")
+	}
+
+	sessions, err := library.Sessions()
+	if err != nil {
+		t.Fatal("Sessions returned an error", err)
+	}
+
+	expected := Session{
+		Name:     "20210317T120000.000Z",
+		Start:    started,
+		Live:     true,
+		Playlist: "20210317T120000.000Z/vod.m3u8",
+	}
+	if len(sessions) != 1 || sessions[0] != expected {
+		t.Error("Sessions listed incorrect sessions, got", sessions)
+	}
+}
+
+func TestStartInvalidDirectory(t *testing.T) {
+	library := &Library{Directory: "/nonexistent"}
+	if _, err := library.Start(); err == nil {
+		t.Error("Start did not return an error")
+	}
+}
+
+func TestTrackerUpdate(t *testing.T) {
+	dir := tempDir(t)
+
+	library := &Library{Directory: dir}
+	tracker := startAt(t, library, time.Date(2021, 3, 17, 12, 0, 0, 0, time.UTC))
+
+	segments := []segment.Segment{
+		{Name: "raspilive-000.ts", Number: 0, Duration: 2 * segment.Timescale, Size: 100},
+		{Name: "raspilive-001.ts", Number: 1, Duration: 2 * segment.Timescale, Size: 200},
+	}
+	if err := tracker.Update(segments[:1], false); err != nil {
+		t.Fatal("Update returned an error", err)
+	}
+	if err := tracker.Update(segments, false); err != nil {
+		t.Fatal("Update returned an error", err)
+	}
+
+	// Segments sliding out of the window are still counted
+	segments = append(segments[1:], segment.Segment{Name: "raspilive-002.ts", Number: 2, Duration: segment.Timescale, Size: 50})
+	if err := tracker.Update(segments, true); err != nil {
+		t.Fatal("Update returned an error", err)
+	}
+
+	var session Session
+	if err := json.Unmarshal([]byte(readFile(t, path.Join(tracker.Directory(), InfoName))), &session); err != nil {
+		t.Fatal("Session description is not valid JSON", err)
+	}
+
+	if session.Duration != 5 || session.Size != 350 || session.Segments != 3 || session.Live {
+		t.Error("Update described the session incorrectly, got", session)
+	}
+}
+
+func TestStartMaxSessions(t *testing.T) {
+	dir := tempDir(t)
+	started := time.Date(2021, 3, 17, 12, 0, 0, 0, time.UTC)
+
+	library := &Library{Directory: dir, MaxSessions: 2}
+	for i := 0; i < 3; i++ {
+		tracker := startAt(t, library, started.Add(time.Duration(i)*time.Hour))
+		if err := tracker.Update(nil, true); err != nil {
+			t.Fatal("Update returned an error", err)
+		}
+	}
+
+	sessions, err := library.Sessions()
+	if err != nil {
+		t.Fatal("Sessions returned an error", err)
+	}
+	if len(sessions) != 2 || sessions[0].Name != "20210317T130000.000Z" || sessions[1].Name != "20210317T140000.000Z" {
+		t.Error("Start did not remove the oldest session, got", names(sessions))
+	}
+	if _, err := os.Stat(path.Join(dir, "20210317T120000.000Z")); !os.IsNotExist(err) {
+		t.Error("Start did not remove the oldest session's directory")
+	}
+}
+
+func TestStartRecovers(t *testing.T) {
+	dir := tempDir(t)
+	started := time.Date(2021, 3, 17, 12, 0, 0, 0, time.UTC)
+
+	library := &Library{Directory: dir}
+	crashed := startAt(t, library, started)
+
+	// The playlist of the session is left listing an event when it is cut short
+	playlist := "#EXTM3U\n#EXT-X-PLAYLIST-TYPE:EVENT\n#EXTINF:2.000000,\nraspilive-000.ts\n"
+	if err := ioutil.WriteFile(path.Join(crashed.Directory(), PlaylistName), []byte(playlist), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted := &Library{Directory: dir}
+	startAt(t, restarted, started.Add(time.Hour))
+
+	sessions, err := restarted.Sessions()
+	if err != nil {
+		t.Fatal("Sessions returned an error", err)
+	}
+	if len(sessions) != 2 || sessions[0].Live || !sessions[1].Live {
+		t.Error("Start did not finish the session that was cut short, got", sessions)
+	}
+
+	if recovered := readFile(t, path.Join(crashed.Directory(), PlaylistName)); recovered != playlist+"#EXT-X-ENDLIST\n" {
+		t.Error("Start did not end the playlist of the session that was cut short, got\n", recovered)
+	}
+}
+
+func TestPruneOldest(t *testing.T) {
+	dir := tempDir(t)
+	started := time.Date(2021, 3, 17, 12, 0, 0, 0, time.UTC)
+
+	library := &Library{Directory: dir}
+	startAt(t, library, started)
+	startAt(t, library, started.Add(time.Hour))
+
+	pruned, err := library.PruneOldest()
+	if err != nil {
+		t.Fatal("PruneOldest returned an error", err)
+	}
+	if !pruned {
+		t.Error("PruneOldest did not remove the oldest session")
+	}
+
+	// The session in progress is always kept
+	pruned, err = library.PruneOldest()
+	if err != nil {
+		t.Fatal("PruneOldest returned an error", err)
+	}
+	if pruned {
+		t.Error("PruneOldest removed the session in progress")
+	}
+
+	sessions, _ := library.Sessions()
+	if len(sessions) != 1 || sessions[0].Name != "20210317T130000.000Z" {
+		t.Error("PruneOldest removed incorrect session, got", names(sessions))
+	}
+}
+
+func TestSessionsSkipsOtherDirectories(t *testing.T) {
+	dir := tempDir(t)
+
+	library := &Library{Directory: dir}
+	startAt(t, library, time.Date(2021, 3, 17, 12, 0, 0, 0, time.UTC))
+
+	os.Mkdir(path.Join(dir, "recordings"), 0755)
+	ioutil.WriteFile(path.Join(dir, "livestream.m3u8"), nil, 0644)
+
+	sessions, err := library.Sessions()
+	if err != nil {
+		t.Fatal("Sessions returned an error", err)
+	}
+	if len(sessions) != 1 {
+		t.Error("Sessions listed incorrect sessions, got", names(sessions))
+	}
+}
@@ -0,0 +1,212 @@
+package tcp
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jaredpetersen/raspilive/internal/h264"
+	"github.com/rs/zerolog/log"
+)
+
+// clientQueueSize is the number of NAL units that may be waiting to be sent to a client before it is considered slow.
+const clientQueueSize = 256
+
+// writeTimeout is the amount of time a client has to accept video before it is disconnected.
+const writeTimeout = 10 * time.Second
+
+// Server serves the raw H.264 video stream to TCP clients.
+//
+// Every client begins receiving video from a keyframe, preceded by the sequence and picture parameter sets, so that
+// the video may be decoded right away. Clients that fall behind skip ahead to the next keyframe rather than hold up the
+// video stream. Use Feed to supply the H.264 video stream.
+type Server struct {
+	Port     int // Port the server runs on. Uses the next available port if one is not provided.
+	listener net.Listener
+	mu       sync.Mutex
+	sps      []byte
+	pps      []byte
+	clients  map[*client]struct{}
+	closed   bool
+}
+
+type client struct {
+	send    chan []byte
+	waiting bool // Whether the client is waiting for a keyframe
+}
+
+// enqueue queues the NAL unit for sending, waiting for the next keyframe if the client has fallen behind.
+func (c *client) enqueue(data []byte) {
+	select {
+	case c.send <- data:
+	default:
+		c.waiting = true
+	}
+}
+
+// ListenAndServe begins listening on the configured port and accepting clients.
+func (srv *Server) ListenAndServe() error {
+	listener, err := net.Listen("tcp", ":"+strconv.Itoa(srv.Port))
+	if err != nil {
+		return err
+	}
+
+	srv.mu.Lock()
+	srv.listener = listener
+	srv.Port = listener.Addr().(*net.TCPAddr).Port
+	srv.mu.Unlock()
+
+	log.Info().Int("port", srv.Port).Msg("Server started")
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			srv.mu.Lock()
+			closed := srv.closed
+			srv.mu.Unlock()
+
+			if closed {
+				return nil
+			}
+			return err
+		}
+
+		go srv.serve(conn)
+	}
+}
+
+// Feed reads the H.264 video stream and distributes it to the connected clients.
+//
+// Blocks until the video stream ends.
+func (srv *Server) Feed(video io.Reader) error {
+	scanner := h264.NewScanner(video)
+
+	for scanner.Scan() {
+		srv.broadcast(scanner.Bytes())
+	}
+
+	err := scanner.Err()
+	if errors.Is(err, os.ErrClosed) {
+		return nil
+	}
+
+	return err
+}
+
+// Close stops accepting new clients and disconnects all of the existing ones.
+func (srv *Server) Close() error {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	srv.closed = true
+
+	for c := range srv.clients {
+		close(c.send)
+		delete(srv.clients, c)
+	}
+
+	if srv.listener == nil {
+		return nil
+	}
+
+	return srv.listener.Close()
+}
+
+func (srv *Server) serve(conn net.Conn) {
+	defer conn.Close()
+
+	start := time.Now()
+	ip, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	size := 0
+
+	c := srv.subscribe()
+	defer srv.unsubscribe(c)
+
+	for data := range c.send {
+		conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+
+		n, err := conn.Write(data)
+		size += n
+		if err != nil {
+			break
+		}
+	}
+
+	log.Info().
+		Str("ip", ip).
+		Int("size", size).
+		Dur("duration", time.Since(start)).
+		Msg("Access")
+}
+
+func (srv *Server) subscribe() *client {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	if srv.clients == nil {
+		srv.clients = make(map[*client]struct{})
+	}
+
+	c := &client{send: make(chan []byte, clientQueueSize), waiting: true}
+
+	// Don't bother with clients that show up while shutting down
+	if srv.closed {
+		close(c.send)
+		return c
+	}
+
+	srv.clients[c] = struct{}{}
+
+	return c
+}
+
+func (srv *Server) unsubscribe(c *client) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	delete(srv.clients, c)
+}
+
+// broadcast sends the NAL unit to all of the clients, getting waiting clients started if it is a keyframe.
+func (srv *Server) broadcast(nalu []byte) {
+	data := make([]byte, 0, len(h264.StartCode)+len(nalu))
+	data = append(data, h264.StartCode...)
+	data = append(data, nalu...)
+
+	nalType := h264.Type(nalu)
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	switch nalType {
+	case h264.TypeSPS:
+		srv.sps = data
+	case h264.TypePPS:
+		srv.pps = data
+	}
+
+	for c := range srv.clients {
+		if !c.waiting {
+			c.enqueue(data)
+			continue
+		}
+
+		if nalType != h264.TypeIDR || srv.sps == nil || srv.pps == nil {
+			continue
+		}
+
+		// Only get started if there's room for the parameter sets and the keyframe
+		if cap(c.send)-len(c.send) < 3 {
+			continue
+		}
+
+		c.send <- srv.sps
+		c.send <- srv.pps
+		c.send <- data
+		c.waiting = false
+	}
+}
@@ -0,0 +1,162 @@
+package tcp
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+var (
+	fakeSPS    = []byte{0x67, 0x64, 0x00, 0x28}
+	fakePPS    = []byte{0x68, 0xEE, 0x3C, 0x80}
+	fakeIDR    = []byte{0x65, 0x88, 0x84}
+	fakeNonIDR = []byte{0x41, 0x9A}
+)
+
+// annexB builds an Annex-B byte stream out of the given NAL units.
+func annexB(nalus ...[]byte) []byte {
+	var stream []byte
+	for _, nalu := range nalus {
+		stream = append(stream, 0, 0, 0, 1)
+		stream = append(stream, nalu...)
+	}
+	return stream
+}
+
+func TestListenAndServeStarts(t *testing.T) {
+	srv := Server{}
+
+	go srv.ListenAndServe()
+	defer srv.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	srv.mu.Lock()
+	port := srv.Port
+	srv.mu.Unlock()
+
+	if port == 0 {
+		t.Fatal("ListenAndServe did not update port")
+	}
+
+	conn, err := net.Dial("tcp", "localhost:"+strconv.Itoa(port))
+	if err != nil {
+		t.Fatal("Connection to server failed:", err)
+	}
+	conn.Close()
+}
+
+func TestFeedStartsClientsAtKeyframe(t *testing.T) {
+	srv := Server{}
+
+	go srv.ListenAndServe()
+	defer srv.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	srv.mu.Lock()
+	port := srv.Port
+	srv.mu.Unlock()
+
+	// Start the video stream before anyone is connected
+	video, videoWriter := io.Pipe()
+	go srv.Feed(video)
+	videoWriter.Write(annexB(fakeSPS, fakePPS, fakeIDR, fakeNonIDR))
+
+	conn, err := net.Dial("tcp", "localhost:"+strconv.Itoa(port))
+	if err != nil {
+		t.Fatal("Connection to server failed:", err)
+	}
+	defer conn.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	// Parameter sets are only sent out inline with the first keyframe
+	videoWriter.Write(annexB(fakeNonIDR, fakeIDR, fakeNonIDR, fakeSPS))
+
+	expected := annexB(fakeSPS, fakePPS, fakeIDR, fakeNonIDR)
+	received := make([]byte, len(expected))
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := io.ReadFull(conn, received); err != nil {
+		t.Fatal("Failed to read video:", err)
+	}
+
+	if !bytes.Equal(received, expected) {
+		t.Error("Client received incorrect video:", received)
+	}
+
+	videoWriter.Close()
+}
+
+func TestFeedReturnsAtEndOfStream(t *testing.T) {
+	srv := Server{}
+
+	err := srv.Feed(bytes.NewReader(annexB(fakeSPS, fakePPS, fakeIDR)))
+	if err != nil {
+		t.Error("Feed returned an error", err)
+	}
+}
+
+func TestBroadcastSkipsToNextKeyframeWhenBehind(t *testing.T) {
+	srv := Server{}
+	c := srv.subscribe()
+
+	srv.broadcast(fakeSPS)
+	srv.broadcast(fakePPS)
+	srv.broadcast(fakeIDR)
+
+	// Fill up the client's queue so that it falls behind
+	for len(c.send) < cap(c.send) {
+		srv.broadcast(fakeNonIDR)
+	}
+	srv.broadcast(fakeNonIDR)
+
+	if !c.waiting {
+		t.Fatal("Client did not fall behind")
+	}
+
+	// Let the client catch up
+	for len(c.send) > 0 {
+		<-c.send
+	}
+
+	srv.broadcast(fakeNonIDR)
+	if len(c.send) != 0 {
+		t.Error("Client did not wait for the next keyframe")
+	}
+
+	srv.broadcast(fakeIDR)
+	if len(c.send) != 3 || c.waiting {
+		t.Error("Client did not start back up at the next keyframe")
+	}
+}
+
+func TestCloseDisconnectsClients(t *testing.T) {
+	srv := Server{}
+
+	go srv.ListenAndServe()
+	time.Sleep(100 * time.Millisecond)
+
+	srv.mu.Lock()
+	port := srv.Port
+	srv.mu.Unlock()
+
+	conn, err := net.Dial("tcp", "localhost:"+strconv.Itoa(port))
+	if err != nil {
+		t.Fatal("Connection to server failed:", err)
+	}
+	defer conn.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	srv.Close()
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Error("Client was not disconnected, got", err)
+	}
+
+	if _, err := net.Dial("tcp", "localhost:"+strconv.Itoa(port)); err == nil {
+		t.Error("Server is still accepting connections")
+	}
+}
@@ -0,0 +1,81 @@
+package thumbnail
+
+import (
+	"errors"
+	"io"
+	"os"
+	"time"
+
+	"github.com/jaredpetersen/raspilive/internal/h264"
+)
+
+// Sample reads the H.264 video stream and hands over a keyframe at most once every interval along with the time since
+// the stream began, going by the framerate. Blocks until the video stream ends.
+//
+// Each keyframe is an Annex-B byte stream of its own, starting with the parameter sets so that it may be decoded by
+// itself and ending with an access unit delimiter so that decoders do not wait on the next frame to finish it up.
+// Like the segmenter, the stream is taken to begin at the first keyframe.
+func Sample(video io.Reader, fps int, interval time.Duration, fn func(at time.Duration, keyframe []byte)) error {
+	units := h264.NewAccessUnitReader(video)
+
+	if fps <= 0 {
+		fps = 30
+	}
+	if interval <= 0 {
+		interval = defaultInterval * time.Second
+	}
+
+	var sps, pps []byte
+	var frames int64
+	var next time.Duration
+
+	for {
+		au, err := units.Read()
+		if err == io.EOF || errors.Is(err, os.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, nalu := range au.NALUnits {
+			switch h264.Type(nalu) {
+			case h264.TypeSPS:
+				sps = nalu
+			case h264.TypePPS:
+				pps = nalu
+			}
+		}
+
+		keyframe := au.Keyframe()
+		if frames == 0 && (!keyframe || sps == nil || pps == nil) {
+			continue
+		}
+
+		at := time.Duration(frames) * time.Second / time.Duration(fps)
+		frames++
+
+		// Take the first keyframe that is closer to the next slot than the one before it, in case the keyframes do
+		// not line up with the interval
+		if !keyframe || at < next {
+			continue
+		}
+		slot := (at + interval/2) / interval
+		next = (slot+1)*interval - interval/2
+
+		data := append(append(append([]byte{}, h264.StartCode...), sps...), h264.StartCode...)
+		data = append(data, pps...)
+		for _, nalu := range au.NALUnits {
+			switch h264.Type(nalu) {
+			case h264.TypeAUD, h264.TypeSPS, h264.TypePPS:
+				continue
+			}
+			data = append(data, h264.StartCode...)
+			data = append(data, nalu...)
+		}
+		data = append(data, h264.StartCode...)
+		data = append(data, byte(h264.TypeAUD), 0xF0)
+
+		fn(at, data)
+	}
+}
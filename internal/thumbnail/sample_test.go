@@ -0,0 +1,75 @@
+package thumbnail
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/jaredpetersen/raspilive/internal/h264"
+)
+
+var (
+	fakeSPS = []byte{
+		0x67, 0x64, 0x00, 0x1F, 0xAC, 0xD9, 0x40, 0x50, 0x05, 0xBB, 0x01, 0x10, 0x00, 0x00, 0x03, 0x00,
+		0x10, 0x00, 0x00, 0x03, 0x03, 0xC0, 0xF1, 0x83, 0x19, 0x60,
+	}
+	fakePPS    = []byte{0x68, 0xEE, 0x3C, 0x80}
+	fakeIDR    = []byte{0x65, 0x88, 0x84}
+	fakeNonIDR = []byte{0x41, 0x9A, 0x02}
+)
+
+// fakeVideo builds an Annex-B stream with a keyframe every keyframeInterval frames and parameter sets only at the
+// start, as raspivid does by default.
+func fakeVideo(frames int, keyframeInterval int) []byte {
+	var stream []byte
+	for _, nalu := range [][]byte{fakeSPS, fakePPS} {
+		stream = append(stream, h264.StartCode...)
+		stream = append(stream, nalu...)
+	}
+
+	for i := 0; i < frames; i++ {
+		nalu := fakeNonIDR
+		if i%keyframeInterval == 0 {
+			nalu = fakeIDR
+		}
+		stream = append(stream, h264.StartCode...)
+		stream = append(stream, nalu...)
+	}
+
+	return stream
+}
+
+func TestSample(t *testing.T) {
+	var times []time.Duration
+	var keyframes [][]byte
+
+	// Keyframes every one and a half seconds are picked out for the slots every two seconds that they are closest to
+	err := Sample(bytes.NewReader(fakeVideo(200, 45)), 30, 2*time.Second, func(at time.Duration, keyframe []byte) {
+		times = append(times, at)
+		keyframes = append(keyframes, keyframe)
+	})
+	if err != nil {
+		t.Fatal("Sample returned an error", err)
+	}
+
+	expected := []time.Duration{0, 1500 * time.Millisecond, 3 * time.Second, 6 * time.Second}
+	if len(times) != len(expected) {
+		t.Fatal("Sample picked incorrect keyframes, got", times)
+	}
+	for i := range expected {
+		if times[i] != expected[i] {
+			t.Fatal("Sample picked incorrect keyframes, got", times)
+		}
+	}
+
+	var expectedKeyframe []byte
+	for _, nalu := range [][]byte{fakeSPS, fakePPS, fakeIDR, {0x09, 0xF0}} {
+		expectedKeyframe = append(expectedKeyframe, h264.StartCode...)
+		expectedKeyframe = append(expectedKeyframe, nalu...)
+	}
+	for _, keyframe := range keyframes {
+		if !bytes.Equal(keyframe, expectedKeyframe) {
+			t.Errorf("Sample handed over incorrect keyframe, got % X", keyframe)
+		}
+	}
+}
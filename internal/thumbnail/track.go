@@ -0,0 +1,172 @@
+package thumbnail
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"io"
+	"time"
+
+	"github.com/jaredpetersen/raspilive/internal/segment"
+)
+
+// Defaults for the sprite sheets when a value is not provided.
+const (
+	defaultInterval = 5
+	defaultColumns  = 5
+	defaultRows     = 5
+	defaultQuality  = 75
+)
+
+// Options represents ways that the thumbnail track may be configured.
+type Options struct {
+	Interval  int           // Seconds between thumbnails
+	Columns   int           // Number of thumbnails across each sprite sheet
+	Rows      int           // Number of thumbnails down each sprite sheet
+	Quality   int           // JPEG quality of the sprite sheets, from 1 (worst) to 100 (best)
+	Retention time.Duration // How far back to keep thumbnails, keeping all of them if not provided
+}
+
+// Track is a scrubbing preview track made up of thumbnails tiled into JPEG sprite sheets, with a WebVTT file pointing
+// each stretch of the stream at its thumbnail.
+//
+// Each sprite sheet covers a fixed stretch of the stream, numbered from one, and thumbnails are placed by the time they
+// were taken so that players may also find them by number alone, as DASH does.
+type Track struct {
+	Storage segment.Storage // Where to write the sprite sheets and WebVTT file
+	Name    string          // File name of the WebVTT file
+	Pattern string          // Format of the sprite sheet file names, given the sheet number
+	Options Options
+	sheet   *image.RGBA // Sprite sheet currently being filled in
+	number  int         // Number of the sprite sheet currently being filled in
+	oldest  int         // Number of the oldest sprite sheet still in storage
+	tile    image.Rectangle
+	cues    []cue // Thumbnails that are currently listed, oldest first
+}
+
+// cue is a single thumbnail listed in the WebVTT file.
+type cue struct {
+	start  time.Duration
+	sheet  int
+	bounds image.Rectangle
+}
+
+// Add places the JPEG thumbnail taken at the given time since the stream began into its sprite sheet, then rewrites
+// the sheet and the WebVTT file.
+//
+// Thumbnails are all expected to be the size of the first, with any others cropped or padded to fit.
+func (track *Track) Add(at time.Duration, thumbnail []byte) error {
+	img, err := jpeg.Decode(bytes.NewReader(thumbnail))
+	if err != nil {
+		return err
+	}
+
+	interval, columns, rows := track.layout()
+	if track.tile.Empty() {
+		track.tile = image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy())
+	}
+
+	// Thumbnails land on the nearest slot, skipping any that would take the place of one that is already listed
+	index := int((at + interval/2) / interval)
+	start := time.Duration(index) * interval
+	if len(track.cues) > 0 && start <= track.cues[len(track.cues)-1].start {
+		return nil
+	}
+	number := index/(columns*rows) + 1
+
+	if number != track.number || track.sheet == nil {
+		track.number = number
+		track.sheet = image.NewRGBA(image.Rect(0, 0, columns*track.tile.Dx(), rows*track.tile.Dy()))
+		if track.oldest == 0 {
+			track.oldest = number
+		}
+	}
+
+	position := index % (columns * rows)
+	bounds := track.tile.Add(image.Pt(position%columns*track.tile.Dx(), position/columns*track.tile.Dy()))
+	draw.Draw(track.sheet, bounds, img, img.Bounds().Min, draw.Src)
+
+	track.cues = append(track.cues, cue{start: start, sheet: number, bounds: bounds})
+	track.trim(at)
+
+	err = segment.WriteFile(track.Storage, track.SheetName(number), func(w io.Writer) error {
+		return jpeg.Encode(w, track.sheet, &jpeg.Options{Quality: track.quality()})
+	})
+	if err != nil {
+		return err
+	}
+
+	return segment.WriteFile(track.Storage, track.Name, track.encode)
+}
+
+// SheetName returns the file name of the sprite sheet with the given number.
+func (track *Track) SheetName(number int) string {
+	return fmt.Sprintf(track.Pattern, number)
+}
+
+// trim drops the thumbnails that have fallen out of the retention period, removing any sprite sheets that no longer
+// hold a listed thumbnail.
+func (track *Track) trim(now time.Duration) {
+	if track.Options.Retention <= 0 {
+		return
+	}
+
+	for len(track.cues) > 1 && track.cues[0].start < now-track.Options.Retention {
+		track.cues = track.cues[1:]
+	}
+
+	for ; track.oldest < track.cues[0].sheet; track.oldest++ {
+		track.Storage.Remove(track.SheetName(track.oldest))
+	}
+}
+
+// encode writes the WebVTT file, with a cue for each thumbnail lasting until the next one is due.
+func (track *Track) encode(w io.Writer) error {
+	if _, err := io.WriteString(w, "WEBVTT\n"); err != nil {
+		return err
+	}
+
+	interval, _, _ := track.layout()
+	for _, c := range track.cues {
+		_, err := fmt.Fprintf(w, "\n%s --> %s\n%s#xywh=%d,%d,%d,%d\n",
+			timestamp(c.start), timestamp(c.start+interval), track.SheetName(c.sheet),
+			c.bounds.Min.X, c.bounds.Min.Y, c.bounds.Dx(), c.bounds.Dy())
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// layout returns the interval between thumbnails and the number of columns and rows in each sprite sheet.
+func (track *Track) layout() (time.Duration, int, int) {
+	interval, columns, rows := track.Options.Interval, track.Options.Columns, track.Options.Rows
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+	if columns <= 0 {
+		columns = defaultColumns
+	}
+	if rows <= 0 {
+		rows = defaultRows
+	}
+
+	return time.Duration(interval) * time.Second, columns, rows
+}
+
+func (track *Track) quality() int {
+	if track.Options.Quality <= 0 {
+		return defaultQuality
+	}
+
+	return track.Options.Quality
+}
+
+// timestamp formats the duration as a WebVTT timestamp.
+func timestamp(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
@@ -0,0 +1,102 @@
+package thumbnail
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"testing"
+	"time"
+
+	"github.com/jaredpetersen/raspilive/internal/memfs"
+)
+
+// fakeThumbnail encodes a solid gray JPEG of the given size.
+func fakeThumbnail(t *testing.T, width int, height int) []byte {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for i := range img.Pix {
+		img.Pix[i] = 0x80
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func newTrack(store *memfs.Store, retention time.Duration) *Track {
+	return &Track{
+		Storage: store,
+		Name:    "thumbnails.vtt",
+		Pattern: "thumbnails-%d.jpg",
+		Options: Options{Interval: 5, Columns: 2, Rows: 2, Retention: retention},
+	}
+}
+
+func TestTrackAdd(t *testing.T) {
+	store := &memfs.Store{}
+	track := newTrack(store, 0)
+
+	// Thumbnails land on the nearest slot, skipping any that would land on a slot that is already taken
+	for _, at := range []time.Duration{0, 6 * time.Second, 7 * time.Second, 14 * time.Second, 20 * time.Second} {
+		if err := track.Add(at, fakeThumbnail(t, 16, 10)); err != nil {
+			t.Fatal("Add returned an error", err)
+		}
+	}
+
+	expected := "WEBVTT\n" +
+		"\n00:00:00.000 --> 00:00:05.000\nthumbnails-1.jpg#xywh=0,0,16,10\n" +
+		"\n00:00:05.000 --> 00:00:10.000\nthumbnails-1.jpg#xywh=16,0,16,10\n" +
+		"\n00:00:15.000 --> 00:00:20.000\nthumbnails-1.jpg#xywh=16,10,16,10\n" +
+		"\n00:00:20.000 --> 00:00:25.000\nthumbnails-2.jpg#xywh=0,0,16,10\n"
+	if vtt, _ := store.ReadFile("thumbnails.vtt"); string(vtt) != expected {
+		t.Error("Add wrote incorrect track, got\n", string(vtt))
+	}
+
+	data, err := store.ReadFile("thumbnails-1.jpg")
+	if err != nil {
+		t.Fatal("Add did not write sprite sheet", err)
+	}
+	sheet, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal("Add wrote invalid sprite sheet", err)
+	}
+	if size := sheet.Bounds().Size(); size != image.Pt(32, 20) {
+		t.Error("Add wrote sprite sheet with incorrect size, got", size)
+	}
+
+	// Empty slots are left black
+	if r, _, _, _ := sheet.At(4, 14).RGBA(); r>>8 > 0x10 {
+		t.Error("Add filled in an empty slot")
+	}
+	if r, _, _, _ := sheet.At(20, 4).RGBA(); r>>8 < 0x70 {
+		t.Error("Add did not place thumbnail in its slot")
+	}
+}
+
+func TestTrackRetention(t *testing.T) {
+	store := &memfs.Store{}
+	track := newTrack(store, 12*time.Second)
+
+	for i := 0; i < 8; i++ {
+		if err := track.Add(time.Duration(i)*5*time.Second, fakeThumbnail(t, 16, 10)); err != nil {
+			t.Fatal("Add returned an error", err)
+		}
+	}
+
+	expected := "WEBVTT\n" +
+		"\n00:00:25.000 --> 00:00:30.000\nthumbnails-2.jpg#xywh=16,0,16,10\n" +
+		"\n00:00:30.000 --> 00:00:35.000\nthumbnails-2.jpg#xywh=0,10,16,10\n" +
+		"\n00:00:35.000 --> 00:00:40.000\nthumbnails-2.jpg#xywh=16,10,16,10\n"
+	if vtt, _ := store.ReadFile("thumbnails.vtt"); string(vtt) != expected {
+		t.Error("Add wrote incorrect track, got\n", string(vtt))
+	}
+
+	if _, err := store.ReadFile("thumbnails-1.jpg"); err == nil {
+		t.Error("Add did not remove sprite sheet that fell out of the retention period")
+	}
+	if _, err := store.ReadFile("thumbnails-2.jpg"); err != nil {
+		t.Error("Add removed sprite sheet that is still listed")
+	}
+}
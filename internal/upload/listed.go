@@ -0,0 +1,163 @@
+package upload
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// deletable reports whether the file may be removed once it is uploaded. Only media segments and recordings are,
+// leaving alone the initialization segments that every media segment after them depends upon.
+func deletable(name string) bool {
+	switch path.Ext(name) {
+	case ".ts", ".m4s", ".mp4", ".age":
+		return !strings.HasPrefix(path.Base(name), "init")
+	default:
+		return false
+	}
+}
+
+// listed returns the names of the files listed by the playlists and manifests in the directory, which players may
+// still ask for.
+func listed(dir string) map[string]bool {
+	names := make(map[string]bool)
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return names
+	}
+
+	for _, entry := range entries {
+		ext := path.Ext(entry.Name())
+		if entry.IsDir() || (ext != ".m3u8" && ext != ".mpd") {
+			continue
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			// Files come and go as the muxers write them
+			continue
+		}
+
+		if ext == ".m3u8" {
+			listPlaylist(data, names)
+		} else {
+			listManifest(data, names)
+		}
+	}
+
+	return names
+}
+
+// uriAttribute matches the URI attribute of a playlist tag, such as that of the initialization segment.
+var uriAttribute = regexp.MustCompile(`URI="([^"]*)"`)
+
+// listPlaylist adds the files listed in the HLS playlist.
+func listPlaylist(data []byte, names map[string]bool) {
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+
+		if strings.HasPrefix(line, "#") {
+			for _, match := range uriAttribute.FindAllStringSubmatch(line, -1) {
+				names[path.Base(match[1])] = true
+			}
+			continue
+		}
+
+		if line != "" {
+			names[path.Base(line)] = true
+		}
+	}
+}
+
+// mpdTemplate is a segment template in a DASH manifest.
+type mpdTemplate struct {
+	Media          string `xml:"media,attr"`
+	Initialization string `xml:"initialization,attr"`
+	StartNumber    *int   `xml:"startNumber,attr"`
+	Timeline       []struct {
+		Repeat int `xml:"r,attr"`
+	} `xml:"SegmentTimeline>S"`
+}
+
+// mpdManifest is the part of a DASH manifest that lists the segments.
+type mpdManifest struct {
+	Periods []struct {
+		AdaptationSets []struct {
+			Template        *mpdTemplate `xml:"SegmentTemplate"`
+			Representations []struct {
+				ID       string       `xml:"id,attr"`
+				Template *mpdTemplate `xml:"SegmentTemplate"`
+			} `xml:"Representation"`
+		} `xml:"AdaptationSet"`
+	} `xml:"Period"`
+}
+
+// numberIdentifier matches the segment number in a template, which may be formatted with a width.
+var numberIdentifier = regexp.MustCompile(`\$Number(%0(\d+)d)?\$`)
+
+// listManifest adds the files listed in the DASH manifest. Only segment templates with a timeline list their segments;
+// the rest cannot be told apart from segments that have fallen out of the manifest.
+func listManifest(data []byte, names map[string]bool) {
+	var manifest mpdManifest
+	if err := xml.Unmarshal(data, &manifest); err != nil {
+		return
+	}
+
+	for _, period := range manifest.Periods {
+		for _, set := range period.AdaptationSets {
+			for _, representation := range set.Representations {
+				template := representation.Template
+				if template == nil {
+					template = set.Template
+				}
+				if template == nil {
+					continue
+				}
+
+				id := strings.NewReplacer("$RepresentationID$", representation.ID)
+				if template.Initialization != "" {
+					names[path.Base(id.Replace(template.Initialization))] = true
+				}
+
+				number := 1
+				if template.StartNumber != nil {
+					number = *template.StartNumber
+				}
+				for _, s := range template.Timeline {
+					for i := 0; i <= s.Repeat; i++ {
+						names[path.Base(id.Replace(segmentName(template.Media, number)))] = true
+						number++
+					}
+				}
+			}
+		}
+	}
+}
+
+// segmentName fills in the segment number in the template.
+func segmentName(media string, number int) string {
+	return numberIdentifier.ReplaceAllStringFunc(media, func(identifier string) string {
+		width, _ := strconv.Atoi(numberIdentifier.FindStringSubmatch(identifier)[2])
+		return fmt.Sprintf("%0*d", width, number)
+	})
+}
+
+// remove removes the uploaded file, unless players may still ask for it.
+func remove(dir string, name string) (bool, error) {
+	if listed(filepath.Dir(filepath.Join(dir, filepath.FromSlash(name))))[path.Base(name)] {
+		return false, nil
+	}
+
+	if err := os.Remove(filepath.Join(dir, filepath.FromSlash(name))); err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+
+	return true, nil
+}
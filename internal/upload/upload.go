@@ -0,0 +1,447 @@
+package upload
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jaredpetersen/raspilive/internal/s3"
+	"github.com/rs/zerolog/log"
+)
+
+// Defaults used if a value is not provided.
+const (
+	defaultInterval  = time.Second
+	defaultQueueSize = 100
+	defaultRetries   = 5
+	defaultPartSize  = 16 * 1024 * 1024
+)
+
+// maxBackoff is the longest to wait before trying a failed upload again.
+const maxBackoff = 30 * time.Second
+
+// initialBackoff is how long to wait before trying a failed upload again the first time, doubling with each try.
+var initialBackoff = time.Second
+
+// contentTypes are the types of the files that raspilive writes, which not every system knows about.
+var contentTypes = map[string]string{
+	".m3u8":  "application/vnd.apple.mpegurl",
+	".mpd":   "application/dash+xml",
+	".ts":    "video/mp2t",
+	".m4s":   "video/iso.segment",
+	".mp4":   "video/mp4",
+	".vtt":   "text/vtt",
+	".jpg":   "image/jpeg",
+	".json":  "application/json",
+	".jsonl": "application/x-ndjson",
+}
+
+// Options represents ways that the uploader may be configured.
+type Options struct {
+	Prefix    string        // Prefix for the object keys, such as a directory in the bucket
+	Interval  time.Duration // How often to look for new and changed files
+	QueueSize int           // Maximum number of files waiting to be uploaded, dropping the oldest past it
+	Retries   int           // Number of times to try a failed upload again before giving up on it
+	PartSize  int64         // Files larger than this are uploaded in parts of this size
+	Delete    bool          // Remove segments and recordings once they are uploaded and no longer listed
+}
+
+// Uploader ships the files in a directory to S3-compatible object storage as they are finished, so that a bucket may
+// act as the origin for a CDN or keep an archive off of the device.
+//
+// The directory is scanned for files that are new or have changed, which are uploaded once they have stopped changing
+// between scans. Hidden files are skipped, as that is where the native muxers write files that are still in progress.
+// Playlists, manifests and indexes are uploaded again every time they change, after the segments that they list, and
+// are never deleted since they are rewritten in place. Neither are initialization segments, and segments are only
+// deleted once they have fallen out of the playlists and manifests that players may still read.
+type Uploader struct {
+	Directory string
+	Client    *s3.Client
+	Options   Options
+	mu        sync.Mutex
+	queue     []string
+	queued    map[string]bool
+	held      map[string]bool // Uploaded files waiting to fall out of the playlists before they are deleted
+	ready     chan struct{}
+	scanned   map[string]fileState // State of the files as of the last scan
+	uploaded  map[string]fileState // State of the files when they were last uploaded
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+// fileState is what the uploader knows about a file to tell when it has changed.
+type fileState struct {
+	size    int64
+	modTime time.Time
+}
+
+// Start begins uploading the files in the directory.
+func (uploader *Uploader) Start() error {
+	if info, err := os.Stat(uploader.Directory); err != nil || !info.IsDir() {
+		return errors.New("upload: invalid directory")
+	}
+
+	uploader.queued = make(map[string]bool)
+	uploader.held = make(map[string]bool)
+	uploader.ready = make(chan struct{}, 1)
+	uploader.scanned = make(map[string]fileState)
+	uploader.uploaded = make(map[string]fileState)
+	uploader.stop = make(chan struct{})
+	uploader.done = make(chan struct{})
+
+	scanning := make(chan struct{})
+
+	go func() {
+		defer close(scanning)
+		uploader.scan(false)
+
+		interval := uploader.Options.Interval
+		if interval <= 0 {
+			interval = defaultInterval
+		}
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				uploader.scan(false)
+			case <-uploader.stop:
+				// Whatever was writing to the directory has finished by the time the uploader is stopped, so there's no
+				// waiting for the last files to settle
+				uploader.scan(true)
+				return
+			}
+		}
+	}()
+
+	go func() {
+		defer close(uploader.done)
+		uploader.work(scanning)
+	}()
+
+	return nil
+}
+
+// Stop uploads the files that are still waiting to go and stops uploading. Failed uploads are only tried once more.
+//
+// Files are uploaded as they are when stopping, so whatever writes to the directory must have finished first.
+func (uploader *Uploader) Stop() error {
+	if uploader.done == nil {
+		return errors.New("upload: not started")
+	}
+
+	select {
+	case <-uploader.stop:
+	default:
+		close(uploader.stop)
+	}
+
+	<-uploader.done
+
+	return nil
+}
+
+func (uploader *Uploader) String() string {
+	if uploader.done == nil {
+		return ""
+	}
+
+	return "s3 uploader (" + uploader.Client.Endpoint + "/" + path.Join(uploader.Client.Bucket, uploader.Options.Prefix) + ")"
+}
+
+// scan queues the files that have changed since they were last uploaded, once they have settled.
+func (uploader *Uploader) scan(final bool) {
+	uploader.release()
+
+	var media, manifests []string
+	found := make(map[string]fileState)
+
+	filepath.Walk(uploader.Directory, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			// Files come and go as the muxers write them
+			return nil
+		}
+
+		if strings.HasPrefix(info.Name(), ".") && name != uploader.Directory {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		relative, err := filepath.Rel(uploader.Directory, name)
+		if err != nil {
+			return nil
+		}
+		relative = filepath.ToSlash(relative)
+
+		state := fileState{size: info.Size(), modTime: info.ModTime()}
+		found[relative] = state
+
+		settled := final || uploader.scanned[relative] == state
+		if !settled || uploader.uploaded[relative] == state {
+			return nil
+		}
+
+		if rewritten(relative) {
+			manifests = append(manifests, relative)
+		} else {
+			media = append(media, relative)
+		}
+
+		return nil
+	})
+
+	uploader.scanned = found
+	for name := range uploader.uploaded {
+		if _, ok := found[name]; !ok {
+			delete(uploader.uploaded, name)
+		}
+	}
+
+	sort.Strings(media)
+	sort.Strings(manifests)
+	for _, name := range append(media, manifests...) {
+		uploader.enqueue(name)
+		uploader.uploaded[name] = found[name]
+	}
+}
+
+// enqueue adds the file to the queue, dropping the oldest file waiting to go if the queue is full.
+func (uploader *Uploader) enqueue(name string) {
+	uploader.mu.Lock()
+	defer uploader.mu.Unlock()
+
+	// The file is read when its turn comes up, so it goes out as it is by then
+	if uploader.queued[name] {
+		return
+	}
+
+	queueSize := uploader.Options.QueueSize
+	if queueSize <= 0 {
+		queueSize = defaultQueueSize
+	}
+	if len(uploader.queue) >= queueSize {
+		dropped := uploader.queue[0]
+		uploader.queue = uploader.queue[1:]
+		delete(uploader.queued, dropped)
+		log.Warn().Str("file", dropped).Msg("Upload queue is full, skipping upload")
+	}
+
+	uploader.queue = append(uploader.queue, name)
+	uploader.queued[name] = true
+
+	select {
+	case uploader.ready <- struct{}{}:
+	default:
+	}
+}
+
+// dequeue takes the next file off of the queue, if there is one.
+func (uploader *Uploader) dequeue() (string, bool) {
+	uploader.mu.Lock()
+	defer uploader.mu.Unlock()
+
+	if len(uploader.queue) == 0 {
+		return "", false
+	}
+
+	name := uploader.queue[0]
+	uploader.queue = uploader.queue[1:]
+	delete(uploader.queued, name)
+
+	return name, true
+}
+
+// work uploads the queued files one at a time so that they go out in order, until scanning stops and the queue is
+// empty.
+func (uploader *Uploader) work(scanning chan struct{}) {
+	for {
+		name, ok := uploader.dequeue()
+		if !ok {
+			select {
+			case <-uploader.ready:
+				continue
+			case <-scanning:
+				if name, ok = uploader.dequeue(); !ok {
+					return
+				}
+			}
+		}
+
+		if err := uploader.retry(name); err != nil {
+			log.Debug().Err(err).Str("file", name).Msg("Encountered an error uploading file")
+			log.Warn().Str("file", name).Msg("Encountered an error uploading file, skipping upload")
+			continue
+		}
+
+		if uploader.Options.Delete && deletable(name) {
+			uploader.mu.Lock()
+			uploader.held[name] = true
+			uploader.mu.Unlock()
+			uploader.release()
+		}
+	}
+}
+
+// release deletes the uploaded files that are no longer listed by any playlist or manifest.
+func (uploader *Uploader) release() {
+	uploader.mu.Lock()
+	defer uploader.mu.Unlock()
+
+	for name := range uploader.held {
+		removed, err := remove(uploader.Directory, name)
+		if err != nil {
+			log.Debug().Err(err).Str("file", name).Msg("Encountered an error deleting uploaded file")
+			log.Warn().Str("file", name).Msg("Encountered an error deleting uploaded file, keeping it")
+		}
+		if removed || err != nil {
+			delete(uploader.held, name)
+		}
+	}
+}
+
+// retry uploads the file, trying again with exponential backoff if it fails for a reason that may pass.
+func (uploader *Uploader) retry(name string) error {
+	retries := uploader.Options.Retries
+	if retries <= 0 {
+		retries = defaultRetries
+	}
+
+	backoff := initialBackoff
+	for attempt := 0; ; attempt++ {
+		err := uploader.upload(name)
+		if err == nil || os.IsNotExist(err) || !temporary(err) || attempt >= retries {
+			if os.IsNotExist(err) {
+				// The file was removed before its turn came up, such as a segment that fell out of the playlist
+				return nil
+			}
+			return err
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-uploader.stop:
+			// Give the remaining files a chance to go rather than hold up stopping
+			return err
+		}
+
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// upload uploads the file, in parts if it is large.
+func (uploader *Uploader) upload(name string) error {
+	file, err := os.Open(filepath.Join(uploader.Directory, filepath.FromSlash(name)))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	key := path.Join(uploader.Options.Prefix, name)
+
+	header := http.Header{"Content-Type": {contentType(name)}}
+	if rewritten(name) {
+		// Caches need to check back for the latest version
+		header.Set("Cache-Control", "no-cache")
+	}
+
+	partSize := uploader.Options.PartSize
+	if partSize <= 0 {
+		partSize = defaultPartSize
+	}
+	if partSize < s3.MinPartSize {
+		partSize = s3.MinPartSize
+	}
+
+	if info.Size() <= partSize {
+		data := make([]byte, info.Size())
+		if _, err := io.ReadFull(file, data); err != nil {
+			return err
+		}
+		return uploader.Client.PutObject(key, data, header)
+	}
+
+	uploadID, err := uploader.Client.CreateMultipartUpload(key, header)
+	if err != nil {
+		return err
+	}
+
+	var etags []string
+	part := make([]byte, partSize)
+	for number := 1; ; number++ {
+		n, err := io.ReadFull(file, part)
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			uploader.Client.AbortMultipartUpload(key, uploadID)
+			return err
+		}
+
+		etag, err := uploader.Client.UploadPart(key, uploadID, number, part[:n])
+		if err != nil {
+			uploader.Client.AbortMultipartUpload(key, uploadID)
+			return err
+		}
+		etags = append(etags, etag)
+	}
+
+	if err := uploader.Client.CompleteMultipartUpload(key, uploadID, etags); err != nil {
+		uploader.Client.AbortMultipartUpload(key, uploadID)
+		return err
+	}
+
+	return nil
+}
+
+// rewritten reports whether the file is rewritten in place as the video goes on, like a playlist, rather than written
+// once.
+func rewritten(name string) bool {
+	switch path.Ext(name) {
+	case ".m3u8", ".mpd", ".json", ".jsonl", ".vtt":
+		return true
+	default:
+		return false
+	}
+}
+
+func contentType(name string) string {
+	if contentType, ok := contentTypes[path.Ext(name)]; ok {
+		return contentType
+	}
+
+	return "application/octet-stream"
+}
+
+// temporary reports whether the upload failed for a reason that may pass, such as the network dropping out.
+func temporary(err error) bool {
+	var s3Err *s3.Error
+	if errors.As(err, &s3Err) {
+		return s3Err.Temporary()
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF)
+}
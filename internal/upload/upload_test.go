@@ -0,0 +1,358 @@
+package upload
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jaredpetersen/raspilive/internal/s3"
+)
+
+// fakeS3 is a bucket kept in memory that takes uploads the way S3 does.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	headers map[string]http.Header
+	order   []string // Keys in the order that they were uploaded
+	parts   [][]byte
+	fail    int // Number of requests to fail with a server error before succeeding
+}
+
+func (fake *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	if fake.fail > 0 {
+		fake.fail--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	body, _ := ioutil.ReadAll(r.Body)
+	key := strings.TrimPrefix(r.URL.Path, "/bucket/")
+	query := r.URL.Query()
+
+	switch {
+	case r.Method == "PUT" && query.Get("uploadId") != "":
+		fake.parts = append(fake.parts, body)
+		w.Header().Set("ETag", `"etag"`)
+	case r.Method == "PUT":
+		fake.objects[key] = body
+		fake.headers[key] = r.Header
+		fake.order = append(fake.order, key)
+	case r.Method == "POST" && query["uploads"] != nil:
+		fake.parts = nil
+		fake.headers[key] = r.Header
+		w.Write([]byte("<InitiateMultipartUploadResult><UploadId>upload-1</UploadId></InitiateMultipartUploadResult>"))
+	case r.Method == "POST":
+		fake.objects[key] = bytes.Join(fake.parts, nil)
+		fake.order = append(fake.order, key)
+		w.Write([]byte("<CompleteMultipartUploadResult></CompleteMultipartUploadResult>"))
+	}
+}
+
+func (fake *fakeS3) object(key string) ([]byte, bool) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	object, ok := fake.objects[key]
+	return object, ok
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, *s3.Client) {
+	fake := &fakeS3{
+		objects: make(map[string][]byte),
+		headers: make(map[string]http.Header),
+	}
+
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+
+	client := &s3.Client{
+		Endpoint:  srv.URL,
+		Region:    "us-east-1",
+		Bucket:    "bucket",
+		AccessKey: "minioadmin",
+		SecretKey: "minioadmin",
+	}
+
+	return fake, client
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "raspilive-upload")
+	if err != nil {
+		t.Fatal("Failed to create temporary directory", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	return dir
+}
+
+func writeFile(t *testing.T, dir string, name string, data string) {
+	name = filepath.Join(dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		t.Fatal("Failed to create directory", err)
+	}
+	if err := ioutil.WriteFile(name, []byte(data), 0644); err != nil {
+		t.Fatal("Failed to write file", err)
+	}
+}
+
+func TestUpload(t *testing.T) {
+	fake, client := newFakeS3(t)
+	dir := tempDir(t)
+
+	uploader := &Uploader{
+		Directory: dir,
+		Client:    client,
+		Options:   Options{Prefix: "camera", Interval: 10 * time.Millisecond},
+	}
+	if err := uploader.Start(); err != nil {
+		t.Fatal("Start returned an error", err)
+	}
+
+	writeFile(t, dir, "raspilive-000.ts", "segment")
+	writeFile(t, dir, "livestream.m3u8", "playlist")
+	writeFile(t, dir, ".raspilive-001.ts.tmp", "in progress")
+
+	// Files go out once they have settled, without waiting to stop
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, ok := fake.object("camera/livestream.m3u8"); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Uploader did not upload the playlist while running")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := uploader.Stop(); err != nil {
+		t.Fatal("Stop returned an error", err)
+	}
+
+	if object, _ := fake.object("camera/raspilive-000.ts"); string(object) != "segment" {
+		t.Error("Uploader uploaded incorrect segment, got", string(object))
+	}
+	if contentType := fake.headers["camera/raspilive-000.ts"].Get("Content-Type"); contentType != "video/mp2t" {
+		t.Error("Uploader uploaded segment with incorrect content type, got", contentType)
+	}
+	if cacheControl := fake.headers["camera/livestream.m3u8"].Get("Cache-Control"); cacheControl != "no-cache" {
+		t.Error("Uploader uploaded playlist with incorrect cache control, got", cacheControl)
+	}
+	if _, ok := fake.object("camera/.raspilive-001.ts.tmp"); ok {
+		t.Error("Uploader uploaded a file that was still being written")
+	}
+}
+
+func TestUploadChanges(t *testing.T) {
+	fake, client := newFakeS3(t)
+	dir := tempDir(t)
+
+	writeFile(t, dir, "livestream.m3u8", "first")
+
+	uploader := &Uploader{Directory: dir, Client: client, Options: Options{Interval: 10 * time.Millisecond}}
+	uploader.Start()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if object, _ := fake.object("livestream.m3u8"); string(object) == "first" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Uploader did not upload the playlist")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	writeFile(t, dir, "livestream.m3u8", "second")
+	uploader.Stop()
+
+	if object, _ := fake.object("livestream.m3u8"); string(object) != "second" {
+		t.Error("Uploader did not upload the changed playlist, got", string(object))
+	}
+}
+
+func TestUploadOrder(t *testing.T) {
+	fake, client := newFakeS3(t)
+	dir := tempDir(t)
+
+	writeFile(t, dir, "livestream.mpd", "manifest")
+	writeFile(t, dir, "raspilive-2.m4s", "segment")
+	writeFile(t, dir, "init.m4s", "init")
+	writeFile(t, dir, "2021/03/17/raspilive-20210317T120000.000Z.mp4", "recording")
+
+	uploader := &Uploader{Directory: dir, Client: client, Options: Options{Interval: time.Hour}}
+	uploader.Start()
+	uploader.Stop()
+
+	// Manifests go after the segments that they list
+	expected := []string{"2021/03/17/raspilive-20210317T120000.000Z.mp4", "init.m4s", "raspilive-2.m4s", "livestream.mpd"}
+	if strings.Join(fake.order, " ") != strings.Join(expected, " ") {
+		t.Error("Uploader uploaded in incorrect order, got", fake.order)
+	}
+}
+
+func TestUploadDelete(t *testing.T) {
+	fake, client := newFakeS3(t)
+	dir := tempDir(t)
+
+	writeFile(t, dir, "raspilive-000.ts", "segment")
+	writeFile(t, dir, "livestream.m3u8", "playlist")
+
+	uploader := &Uploader{Directory: dir, Client: client, Options: Options{Interval: time.Hour, Delete: true}}
+	uploader.Start()
+	uploader.Stop()
+
+	if _, ok := fake.object("raspilive-000.ts"); !ok {
+		t.Fatal("Uploader did not upload the segment")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "raspilive-000.ts")); !os.IsNotExist(err) {
+		t.Error("Uploader did not delete the uploaded segment")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "livestream.m3u8")); err != nil {
+		t.Error("Uploader deleted the playlist")
+	}
+}
+
+func TestUploadDeleteKeepsListedSegments(t *testing.T) {
+	_, client := newFakeS3(t)
+	dir := tempDir(t)
+
+	writeFile(t, dir, "init.mp4", "init")
+	writeFile(t, dir, "raspilive-000.m4s", "segment")
+	writeFile(t, dir, "raspilive-001.m4s", "segment")
+	writeFile(t, dir, "livestream.m3u8", "#EXTM3U\n#EXT-X-MAP:URI=\"init.mp4\"\n#EXTINF:2.000,\nraspilive-001.m4s\n")
+
+	uploader := &Uploader{Directory: dir, Client: client, Options: Options{Interval: time.Hour, Delete: true}}
+	uploader.Start()
+	uploader.Stop()
+
+	if _, err := os.Stat(filepath.Join(dir, "init.mp4")); err != nil {
+		t.Error("Uploader deleted the initialization segment")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "raspilive-001.m4s")); err != nil {
+		t.Error("Uploader deleted a segment still listed in the playlist")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "raspilive-000.m4s")); !os.IsNotExist(err) {
+		t.Error("Uploader did not delete a segment no longer listed in the playlist")
+	}
+}
+
+func TestListedManifest(t *testing.T) {
+	dir := tempDir(t)
+
+	writeFile(t, dir, "livestream.mpd", `<?xml version="1.0" encoding="UTF-8"?>
+<MPD xmlns="urn:mpeg:dash:schema:mpd:2011">
+  <Period id="0">
+    <AdaptationSet contentType="video">
+      <Representation id="0">
+        <SegmentTemplate timescale="90000" initialization="init-$RepresentationID$.m4s" media="chunk-$RepresentationID$-$Number%05d$.m4s" startNumber="4">
+          <SegmentTimeline>
+            <S t="0" d="180000" r="1" />
+            <S d="90000" />
+          </SegmentTimeline>
+        </SegmentTemplate>
+      </Representation>
+    </AdaptationSet>
+  </Period>
+</MPD>
+`)
+
+	names := listed(dir)
+	for _, name := range []string{"init-0.m4s", "chunk-0-00004.m4s", "chunk-0-00005.m4s", "chunk-0-00006.m4s"} {
+		if !names[name] {
+			t.Error("Manifest does not list", name)
+		}
+	}
+	if names["chunk-0-00003.m4s"] || names["chunk-0-00007.m4s"] {
+		t.Error("Manifest lists segments outside of its timeline")
+	}
+}
+
+func TestUploadMultipart(t *testing.T) {
+	fake, client := newFakeS3(t)
+	dir := tempDir(t)
+
+	recording := strings.Repeat("r", s3.MinPartSize+1024)
+	writeFile(t, dir, "raspilive.mp4", recording)
+
+	uploader := &Uploader{Directory: dir, Client: client, Options: Options{Interval: time.Hour, PartSize: s3.MinPartSize}}
+	uploader.Start()
+	uploader.Stop()
+
+	if len(fake.parts) != 2 {
+		t.Error("Uploader uploaded incorrect number of parts, got", len(fake.parts))
+	}
+	if object, _ := fake.object("raspilive.mp4"); string(object) != recording {
+		t.Error("Uploader put together incorrect recording")
+	}
+}
+
+func TestUploadRetries(t *testing.T) {
+	original := initialBackoff
+	initialBackoff = time.Millisecond
+	t.Cleanup(func() { initialBackoff = original })
+
+	fake, client := newFakeS3(t)
+	fake.fail = 2
+	dir := tempDir(t)
+
+	writeFile(t, dir, "raspilive-000.ts", "segment")
+
+	uploader := &Uploader{Directory: dir, Client: client, Options: Options{Interval: 10 * time.Millisecond}}
+	uploader.Start()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, ok := fake.object("raspilive-000.ts"); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Uploader did not retry the upload")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	uploader.Stop()
+}
+
+func TestEnqueueFull(t *testing.T) {
+	uploader := &Uploader{
+		Options: Options{QueueSize: 2},
+		queued:  make(map[string]bool),
+		ready:   make(chan struct{}, 1),
+	}
+
+	for _, name := range []string{"raspilive-000.ts", "raspilive-001.ts", "raspilive-001.ts", "raspilive-002.ts"} {
+		uploader.enqueue(name)
+	}
+
+	if strings.Join(uploader.queue, " ") != "raspilive-001.ts raspilive-002.ts" {
+		t.Error("enqueue kept incorrect files, got", uploader.queue)
+	}
+}
+
+func TestStartInvalidDirectory(t *testing.T) {
+	uploader := &Uploader{Directory: filepath.Join(tempDir(t), "nonexistent")}
+
+	if err := uploader.Start(); err == nil || err.Error() != "upload: invalid directory" {
+		t.Error("Start returned incorrect error, got", err)
+	}
+}
+
+func TestStopNotStarted(t *testing.T) {
+	uploader := &Uploader{}
+
+	if err := uploader.Stop(); err == nil || err.Error() != "upload: not started" {
+		t.Error("Stop returned incorrect error, got", err)
+	}
+}